@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/badal-io/devpod-provider-gcloud/pkg/options"
+	"github.com/loft-sh/devpod/pkg/log"
+	"github.com/spf13/cobra"
+)
+
+// BatchCmd holds the cmd flags
+type BatchCmd struct {
+	Concurrency int
+}
+
+// NewBatchCmd defines a command
+func NewBatchCmd() *cobra.Command {
+	cmd := &BatchCmd{}
+	batchCmd := &cobra.Command{
+		Use:   "batch [start|stop] MACHINE_ID...",
+		Short: "Start or stop multiple instances concurrently",
+		Args:  cobra.MinimumNArgs(2),
+		RunE: func(cobraCmd *cobra.Command, args []string) error {
+			options, err := options.FromEnv(false, false)
+			if err != nil {
+				return err
+			}
+
+			return runWithTimeout(cobraCmd.Context(), options, func(ctx context.Context) error {
+				return cmd.Run(ctx, options, args[0], args[1:], log.Default)
+			})
+		},
+	}
+
+	batchCmd.Flags().IntVar(&cmd.Concurrency, "concurrency", 4, "The maximum number of operations running at the same time")
+	return batchCmd
+}
+
+// Run runs the command logic
+func (cmd *BatchCmd) Run(ctx context.Context, options *options.Options, action string, machineIDs []string, log log.Logger) error {
+	names := []string{}
+	for _, machineID := range machineIDs {
+		name, err := options.InstanceName(machineID)
+		if err != nil {
+			return err
+		}
+
+		names = append(names, name)
+	}
+
+	client, err := sharedClient(ctx, options)
+	if err != nil {
+		return err
+	}
+
+	switch action {
+	case "start":
+		log.Infof("Starting %d instances...", len(names))
+		return client.StartMany(ctx, names, cmd.Concurrency)
+	case "stop":
+		log.Infof("Stopping %d instances...", len(names))
+		return client.StopMany(ctx, names, cmd.Concurrency, options.DiscardLocalSSD)
+	}
+
+	return fmt.Errorf("unsupported action %s, must be start or stop", action)
+}
@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"mime/multipart"
+	"net/textproto"
+	"os"
+	"strings"
+
+	"github.com/badal-io/devpod-provider-gcloud/pkg/options"
+)
+
+const cloudConfigHeader = "#cloud-config"
+
+// cloudInitUserConfig creates the devpod user with cloud-init for IAP, replacing the
+// user creation of the startup script. The list merger appends the user to the users
+// of the user supplied cloud config instead of replacing them.
+const cloudInitUserConfig = `#cloud-config
+merge_how:
+  - name: list
+    settings: [append]
+  - name: dict
+    settings: [no_replace, recurse_list]
+users:
+  - name: devpod
+    shell: /bin/bash
+    groups: sudo
+    sudo: ALL=(ALL) NOPASSWD:ALL
+    ssh_authorized_keys:
+      - %s
+`
+
+// buildCloudInit returns the user-data of the instance from CLOUD_INIT, which is either an
+// inline cloud config or the path to one. An empty string is returned if it isn't configured.
+func buildCloudInit(options *options.Options, publicKey string) (string, error) {
+	if options.CloudInit == "" {
+		return "", nil
+	}
+
+	cloudConfig := options.CloudInit
+	if !strings.HasPrefix(cloudConfig, cloudConfigHeader) {
+		content, err := os.ReadFile(cloudConfig)
+		if err != nil {
+			return "", fmt.Errorf("read CLOUD_INIT file: %w", err)
+		}
+
+		cloudConfig = string(content)
+	}
+	if !strings.HasPrefix(cloudConfig, cloudConfigHeader) {
+		return "", fmt.Errorf("CLOUD_INIT must be a cloud config starting with %s", cloudConfigHeader)
+	}
+
+	if options.PublicIP {
+		return cloudConfig, nil
+	}
+
+	return buildMultipartCloudInit(cloudConfig, fmt.Sprintf(cloudInitUserConfig, strings.TrimSpace(publicKey)))
+}
+
+// buildMultipartCloudInit combines the cloud configs into a multipart mime message, which cloud-init merges in order
+func buildMultipartCloudInit(cloudConfigs ...string) (string, error) {
+	buf := &bytes.Buffer{}
+	writer := multipart.NewWriter(buf)
+	fmt.Fprintf(buf, "Content-Type: multipart/mixed; boundary=\"%s\"\nMIME-Version: 1.0\n\n", writer.Boundary())
+	for _, cloudConfig := range cloudConfigs {
+		part, err := writer.CreatePart(textproto.MIMEHeader{
+			"Content-Type": []string{`text/cloud-config; charset="utf-8"`},
+		})
+		if err != nil {
+			return "", err
+		}
+
+		_, err = part.Write([]byte(cloudConfig))
+		if err != nil {
+			return "", err
+		}
+	}
+
+	err := writer.Close()
+	if err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
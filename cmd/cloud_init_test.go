@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/badal-io/devpod-provider-gcloud/pkg/options"
+)
+
+func TestBuildCloudInit(t *testing.T) {
+	cloudConfig := "#cloud-config\npackages:\n  - git\n"
+	file := filepath.Join(t.TempDir(), "cloud-init.yaml")
+	if err := os.WriteFile(file, []byte(cloudConfig), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	shellScript := filepath.Join(t.TempDir(), "init.sh")
+	if err := os.WriteFile(shellScript, []byte("#!/bin/sh\necho hello\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	userConfig := fmt.Sprintf(cloudInitUserConfig, "ssh-ed25519 AAAA devpod")
+	tests := []struct {
+		name      string
+		cloudInit string
+		publicIP  bool
+		want      string
+		wantParts []string
+		wantErr   string
+	}{
+		{name: "not configured"},
+		{name: "inline with public ip", cloudInit: cloudConfig, publicIP: true, want: cloudConfig},
+		{name: "file with public ip", cloudInit: file, publicIP: true, want: cloudConfig},
+		{name: "inline with IAP", cloudInit: cloudConfig, wantParts: []string{cloudConfig, userConfig}},
+		{name: "file with IAP", cloudInit: file, wantParts: []string{cloudConfig, userConfig}},
+		{name: "missing file", cloudInit: filepath.Join(t.TempDir(), "missing.yaml"), wantErr: "read CLOUD_INIT file"},
+		{name: "not a cloud config", cloudInit: shellScript, wantErr: "CLOUD_INIT must be a cloud config starting with #cloud-config"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			userData, err := buildCloudInit(&options.Options{CloudInit: test.cloudInit, PublicIP: test.publicIP}, "ssh-ed25519 AAAA devpod\n")
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Errorf("buildCloudInit() error = %v, want %q", err, test.wantErr)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("buildCloudInit() error = %v", err)
+			}
+
+			if test.wantParts == nil {
+				if userData != test.want {
+					t.Errorf("buildCloudInit() = %q, want %q", userData, test.want)
+				}
+				return
+			}
+
+			if parts := cloudConfigParts(t, userData); !reflect.DeepEqual(parts, test.wantParts) {
+				t.Errorf("buildCloudInit() parts = %q, want %q", parts, test.wantParts)
+			}
+		})
+	}
+}
+
+// cloudConfigParts parses the multipart user-data like cloud-init and returns its cloud configs
+func cloudConfigParts(t *testing.T, userData string) []string {
+	t.Helper()
+
+	message, err := mail.ReadMessage(strings.NewReader(userData))
+	if err != nil {
+		t.Fatalf("parse user-data: %v", err)
+	}
+	mediaType, params, err := mime.ParseMediaType(message.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/mixed" {
+		t.Fatalf("user-data content type = %q, want multipart/mixed", message.Header.Get("Content-Type"))
+	}
+
+	parts := []string{}
+	reader := multipart.NewReader(message.Body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return parts
+		} else if err != nil {
+			t.Fatalf("read part: %v", err)
+		}
+
+		if contentType := part.Header.Get("Content-Type"); !strings.HasPrefix(contentType, "text/cloud-config") {
+			t.Errorf("part content type = %q, want text/cloud-config", contentType)
+		}
+		content, err := io.ReadAll(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts = append(parts, string(content))
+	}
+}
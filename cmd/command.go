@@ -3,6 +3,7 @@ package cmd
 import (
 	"context"
 	"fmt"
+	"io"
 	"net"
 	"os"
 	"os/exec"
@@ -10,7 +11,7 @@ import (
 	"strconv"
 	"time"
 
-	"github.com/badal-io/devpod-provider-gcloud/pkg/gcloud"
+	"cloud.google.com/go/compute/apiv1/computepb"
 	"github.com/badal-io/devpod-provider-gcloud/pkg/options"
 	"github.com/loft-sh/devpod/pkg/log"
 	"github.com/loft-sh/devpod/pkg/ssh"
@@ -27,13 +28,13 @@ func NewCommandCmd() *cobra.Command {
 	commandCmd := &cobra.Command{
 		Use:   "command",
 		Short: "Run a command on the instance",
-		RunE: func(_ *cobra.Command, args []string) error {
+		RunE: func(cobraCmd *cobra.Command, args []string) error {
 			options, err := options.FromEnv(true, true)
 			if err != nil {
 				return err
 			}
 
-			return cmd.Run(context.Background(), options, log.Default)
+			return cmd.Run(cobraCmd.Context(), options, log.Default)
 		},
 	}
 
@@ -48,35 +49,41 @@ func (cmd *CommandCmd) Run(ctx context.Context, options *options.Options, log lo
 	}
 
 	// get private key
-	privateKey, err := ssh.GetPrivateKeyRawBase(options.MachineFolder)
+	privateKey, err := getPrivateKey(options)
 	if err != nil {
 		return fmt.Errorf("load private key: %w", err)
 	}
 
 	// create gcloud client
-	client, err := gcloud.NewClient(ctx, options.Project, options.Zone)
+	client, err := sharedClient(ctx, options)
 	if err != nil {
 		return err
 	}
-	defer client.Close()
 
-	// get instance
-	instance, err := client.Get(ctx, options.MachineID)
+	// get instance, the timeout only applies to the api call and not to the command itself
+	getCtx, cancel := context.WithTimeout(ctx, options.OperationTimeout)
+	defer cancel()
+	instance, err := client.Get(getCtx, options.MachineID)
 	if err != nil {
 		return err
 	} else if instance == nil {
 		return fmt.Errorf("instance %s doesn't exist", options.MachineID)
 	}
 
-	// get external ip
-	if options.PublicIP && (len(instance.NetworkInterfaces) == 0 || len(instance.NetworkInterfaces[0].AccessConfigs) == 0 || instance.NetworkInterfaces[0].AccessConfigs[0].NatIP == nil) {
-		return fmt.Errorf("instance %s doesn't have an external nat ip", options.MachineID)
+	return runOnInstance(ctx, options, instance, privateKey, command, os.Stdin, os.Stdout, os.Stderr, log)
+}
+
+// runOnInstance runs the command on the instance with ssh, through the IAP ssh config if the instance has no public ip
+func runOnInstance(ctx context.Context, options *options.Options, instance *computepb.Instance, privateKey []byte, command string, stdin io.Reader, stdout, stderr io.Writer, log log.Logger) error {
+	target, err := directSSHTarget(options, instance)
+	if err != nil {
+		return err
 	}
 
 	// Use SSH with ProxyCommand for IAP when no public IP
-	if !options.PublicIP {
+	if target == "" {
 		// Path to SSH config file created during machine setup
-		sshConfigPath := filepath.Join(options.MachineFolder, "ssh_config")
+		sshConfigPath := options.SSHConfigFile()
 
 		// Use system ssh command with our config file
 		// This leverages the ProxyCommand configured during create
@@ -86,24 +93,33 @@ func (cmd *CommandCmd) Run(ctx context.Context, options *options.Options, log lo
 
 		for attempt := 0; attempt < maxRetries; attempt++ {
 			sshArgs := []string{
-				"-F", sshConfigPath,  // Use our SSH config with ProxyCommand
-				"-o", "ConnectionAttempts=3",  // Multiple connection attempts per try
-				options.MachineID,    // Host (configured in ssh_config)
-				command,              // Command to execute
+				"-F", sshConfigPath, // Use our SSH config with ProxyCommand
+				"-o", "ConnectionAttempts=3", // Multiple connection attempts per try
+				options.MachineID, // Host (configured in ssh_config)
+				command,           // Command to execute
 			}
 
 			sshCmd := exec.CommandContext(ctx, "ssh", sshArgs...)
-			sshCmd.Stdin = os.Stdin
-			sshCmd.Stdout = os.Stdout
-			sshCmd.Stderr = os.Stderr
+			sshCmd.Stdin = stdin
+			sshCmd.Stdout = stdout
+			sshCmd.Stderr = stderr
 
 			if err := sshCmd.Run(); err != nil {
+				// ssh exits with 255 if the connection fails, other exit codes are
+				// returned by the command and retrying would run it again
+				var exitErr *exec.ExitError
+				if errors.As(err, &exitErr) && exitErr.ExitCode() != 255 {
+					return err
+				}
+
 				lastErr = err
 				if attempt < maxRetries-1 {
 					// Retry with exponential backoff
 					backoffDuration := time.Duration((attempt+1)*2) * time.Second
 					log.Debugf("SSH command failed (attempt %d/%d), retrying in %v: %v", attempt+1, maxRetries, backoffDuration, err)
-					time.Sleep(backoffDuration)
+					if err := sleepContext(ctx, backoffDuration); err != nil {
+						return err
+					}
 					continue
 				}
 			} else {
@@ -115,18 +131,90 @@ func (cmd *CommandCmd) Run(ctx context.Context, options *options.Options, log lo
 		return fmt.Errorf("ssh via IAP ProxyCommand failed after %d attempts: %w", maxRetries, lastErr)
 	}
 
-	// For instances with public IP, use standard SSH
-	target := *instance.NetworkInterfaces[0].AccessConfigs[0].NatIP
-	port := "22"
+	// For instances with public IP or USE_INTERNAL_IP, use standard SSH
+	port := strconv.Itoa(options.SSHPort)
+
+	if options.SSHControlMaster {
+		return runWithControlMaster(ctx, options, target, port, command, stdin, stdout, stderr)
+	}
 
-	sshClient, err := ssh.NewSSHClient("devpod", target+":"+port, privateKey)
+	sshClient, err := newSSHClient(options, target+":"+port, privateKey)
 	if err != nil {
 		return errors.Wrap(err, "create ssh client")
 	}
 	defer sshClient.Close()
 
 	// run command
-	return ssh.Run(ctx, sshClient, command, os.Stdin, os.Stdout, os.Stderr)
+	return ssh.Run(ctx, sshClient, command, stdin, stdout, stderr)
+}
+
+// directSSHTarget returns the ip that ssh connects to directly, which is the public ip or the
+// internal ip with USE_INTERNAL_IP. An empty ip is returned if the connection goes through the
+// generated ssh config instead.
+func directSSHTarget(options *options.Options, instance *computepb.Instance) (string, error) {
+	if options.PublicIP {
+		if len(instance.NetworkInterfaces) == 0 || len(instance.NetworkInterfaces[0].AccessConfigs) == 0 || instance.NetworkInterfaces[0].AccessConfigs[0].NatIP == nil {
+			return "", fmt.Errorf("instance %s doesn't have an external nat ip", options.MachineID)
+		}
+
+		return instance.NetworkInterfaces[0].AccessConfigs[0].GetNatIP(), nil
+	}
+
+	if options.UseInternalIP {
+		if len(instance.NetworkInterfaces) == 0 || instance.NetworkInterfaces[0].GetNetworkIP() == "" {
+			return "", fmt.Errorf("instance %s doesn't have an internal ip", options.MachineID)
+		}
+
+		return instance.NetworkInterfaces[0].GetNetworkIP(), nil
+	}
+
+	return "", nil
+}
+
+// runWithControlMaster runs the command with the system ssh, which shares a single connection
+// to the public ip of the instance between the commands through the control master socket
+func runWithControlMaster(ctx context.Context, options *options.Options, target, port, command string, stdin io.Reader, stdout, stderr io.Writer) error {
+	sshArgs := []string{
+		"-i", sshKeyFile(options),
+		"-p", port,
+		"-o", "ControlMaster=auto",
+		"-o", "ControlPath=" + controlPath(options),
+		"-o", "ControlPersist=" + controlPersist,
+	}
+	sshArgs = append(sshArgs, hostKeyArgs(options)...)
+	if options.SSHProxy != "" {
+		proxyCommand, err := proxyCommandConfig(options)
+		if err != nil {
+			return err
+		}
+
+		sshArgs = append(sshArgs, "-o", proxyCommand)
+	}
+
+	sshCmd := exec.CommandContext(ctx, "ssh", append(sshArgs, "devpod@"+target, command)...)
+	sshCmd.Stdin = stdin
+	sshCmd.Stdout = stdout
+	sshCmd.Stderr = stderr
+	return sshCmd.Run()
+}
+
+// controlPersist is how long the control master keeps the connection open after the last command
+const controlPersist = "10m"
+
+// controlPath returns the path of the control master socket in the machine folder. ssh
+// replaces %C with a hash of the connection, which keeps the path below the socket path limit.
+func controlPath(options *options.Options) string {
+	return filepath.Join(options.MachineFolder, "cm-%C")
+}
+
+// controlMasterConfig returns the ssh config lines that share a connection between the
+// commands with SSH_CONTROL_MASTER and an empty string otherwise
+func controlMasterConfig(options *options.Options) string {
+	if !options.SSHControlMaster {
+		return ""
+	}
+
+	return fmt.Sprintf("    ControlMaster auto\n    ControlPath %s\n    ControlPersist %s\n", controlPath(options), controlPersist)
 }
 
 func findAvailablePort() (string, error) {
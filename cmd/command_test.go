@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"testing"
+
+	"cloud.google.com/go/compute/apiv1/computepb"
+	"github.com/badal-io/devpod-provider-gcloud/pkg/options"
+	"github.com/badal-io/devpod-provider-gcloud/pkg/ptr"
+)
+
+func TestDirectSSHTarget(t *testing.T) {
+	instance := &computepb.Instance{
+		NetworkInterfaces: []*computepb.NetworkInterface{
+			{
+				NetworkIP:     ptr.Ptr("10.0.0.2"),
+				AccessConfigs: []*computepb.AccessConfig{{NatIP: ptr.Ptr("203.0.113.10")}},
+			},
+		},
+	}
+	private := &computepb.Instance{
+		NetworkInterfaces: []*computepb.NetworkInterface{{NetworkIP: ptr.Ptr("10.0.0.2")}},
+	}
+
+	tests := []struct {
+		name     string
+		options  options.Options
+		instance *computepb.Instance
+		want     string
+		wantErr  string
+	}{
+		{name: "public ip", options: options.Options{PublicIP: true}, instance: instance, want: "203.0.113.10"},
+		{name: "public ip takes precedence", options: options.Options{PublicIP: true, UseInternalIP: true}, instance: instance, want: "203.0.113.10"},
+		{name: "missing public ip", options: options.Options{MachineID: "devpod-test", PublicIP: true}, instance: private, wantErr: "instance devpod-test doesn't have an external nat ip"},
+		{name: "internal ip", options: options.Options{UseInternalIP: true}, instance: private, want: "10.0.0.2"},
+		{name: "missing internal ip", options: options.Options{MachineID: "devpod-test", UseInternalIP: true}, instance: &computepb.Instance{}, wantErr: "instance devpod-test doesn't have an internal ip"},
+		{name: "iap", instance: instance},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := directSSHTarget(&test.options, test.instance)
+			if test.wantErr != "" {
+				if err == nil || err.Error() != test.wantErr {
+					t.Fatalf("directSSHTarget() error = %v, want %q", err, test.wantErr)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("directSSHTarget() error = %v", err)
+			}
+
+			if got != test.want {
+				t.Errorf("directSSHTarget() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/badal-io/devpod-provider-gcloud/pkg/gcloud"
+	"github.com/badal-io/devpod-provider-gcloud/pkg/options"
+	"github.com/loft-sh/devpod/pkg/log"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/api/option"
+)
+
+// fakeCompute serves the compute api requests of a test from handlers registered by method and
+// path relative to the zone or the project of the test, e.g. "GET /instances/devpod-test".
+// Requests without a handler are answered with 404 and operations are reported as done.
+type fakeCompute struct {
+	mutex    sync.Mutex
+	handlers map[string]http.HandlerFunc
+	requests []string
+
+	// the paths of the project and the zone of the test, e.g. /compute/v1/projects/p/zones/z
+	projectPrefix string
+	zonePrefix    string
+
+	// responded is called by the client once it received the response of a request, before
+	// the response is returned to the caller, e.g. to interrupt a command at a specific point
+	responded func(request string)
+}
+
+var fakeProjects int32
+
+// newFakeCompute starts a fake compute api and registers it as the shared client of the
+// returned options, each test gets its own project so the shared clients don't overlap
+func newFakeCompute(t *testing.T) (*fakeCompute, *options.Options) {
+	t.Helper()
+
+	project := fmt.Sprintf("test-project-%d", atomic.AddInt32(&fakeProjects, 1))
+	zone := "europe-west1-b"
+	fake := &fakeCompute{
+		handlers:      map[string]http.HandlerFunc{},
+		projectPrefix: "/compute/v1/projects/" + project,
+		zonePrefix:    fmt.Sprintf("/compute/v1/projects/%s/zones/%s", project, zone),
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(fake.serve))
+	t.Cleanup(server.Close)
+
+	httpClient := &http.Client{Transport: &fakeTransport{fake: fake}}
+	_, err := gcloud.SharedClient(context.Background(), project, zone, option.WithEndpoint(server.URL), option.WithHTTPClient(httpClient))
+	if err != nil {
+		t.Fatalf("create client: %v", err)
+	}
+
+	return fake, &options.Options{
+		MachineID:        "devpod-test",
+		MachineFolder:    t.TempDir(),
+		Project:          project,
+		Zone:             zone,
+		SSHPort:          22,
+		OperationTimeout: time.Minute,
+	}
+}
+
+// handle registers the handler of the request, the path is relative to the zone of the test
+func (f *fakeCompute) handle(method, path string, handler http.HandlerFunc) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.handlers[method+" "+f.zonePrefix+path] = handler
+}
+
+// handleProject registers the handler of the request, the path is relative to the project of the test
+func (f *fakeCompute) handleProject(method, path string, handler http.HandlerFunc) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.handlers[method+" "+f.projectPrefix+path] = handler
+}
+
+// requested returns the requests served so far as "METHOD path" relative to the zone or the project
+func (f *fakeCompute) requested() []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return append([]string{}, f.requests...)
+}
+
+// relative returns the request as "METHOD path" relative to the zone or the project
+func (f *fakeCompute) relative(r *http.Request) string {
+	return r.Method + " " + strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, f.zonePrefix), f.projectPrefix)
+}
+
+func (f *fakeCompute) serve(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	f.requests = append(f.requests, f.relative(r))
+	handler, ok := f.handlers[r.Method+" "+r.URL.Path]
+	f.mutex.Unlock()
+
+	switch {
+	case ok:
+		handler(w, r)
+	case r.Method == http.MethodGet && strings.Contains(r.URL.Path, "/operations/"):
+		writeJSON(w, map[string]string{"name": r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:], "status": "DONE"})
+	case r.Method == http.MethodPost && strings.Contains(r.URL.Path, "/operations/") && strings.HasSuffix(r.URL.Path, "/wait"):
+		name := strings.TrimSuffix(r.URL.Path, "/wait")
+		writeJSON(w, map[string]string{"name": name[strings.LastIndex(name, "/")+1:], "status": "DONE"})
+	default:
+		writeError(w, http.StatusNotFound, "notFound")
+	}
+}
+
+// fakeTransport sends the requests of the client to the fake compute api and calls its
+// responded hook with the response already read, so the hook can't interfere with reading it
+type fakeTransport struct {
+	fake *fakeCompute
+}
+
+func (t *fakeTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	resp, err := http.DefaultTransport.RoundTrip(r)
+	if err != nil {
+		return nil, err
+	}
+
+	t.fake.mutex.Lock()
+	responded := t.fake.responded
+	t.fake.mutex.Unlock()
+	if responded == nil {
+		return resp, nil
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	responded(t.fake.relative(r))
+	return resp, nil
+}
+
+// writeJSON writes the resource as the response
+func writeJSON(w http.ResponseWriter, resource interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resource)
+}
+
+// writeOperation responds with a running zone operation of the given name
+func writeOperation(w http.ResponseWriter, name string) {
+	writeJSON(w, map[string]string{"name": name, "status": "RUNNING", "zone": "europe-west1-b"})
+}
+
+// writeError responds with an api error of the given code and reason
+func writeError(w http.ResponseWriter, code int, reason string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{
+			"code":    code,
+			"message": reason,
+			"errors":  []map[string]string{{"reason": reason, "message": reason}},
+		},
+	})
+}
+
+// discardLogger returns a logger that drops all messages
+func discardLogger() log.Logger {
+	return log.NewStreamLogger(io.Discard, io.Discard, logrus.DebugLevel)
+}
@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"context"
+	"strings"
+
+	"cloud.google.com/go/compute/apiv1/computepb"
+	"github.com/badal-io/devpod-provider-gcloud/pkg/gcloud"
+	"github.com/badal-io/devpod-provider-gcloud/pkg/options"
+	"github.com/loft-sh/devpod/pkg/log"
+)
+
+// familyPrice is the on-demand price per hour of a vCPU and a GB of memory of a machine family
+type familyPrice struct {
+	vCPU     float64
+	memoryGB float64
+}
+
+// familyPrices are the approximate on-demand prices in us-central1 in USD, other regions
+// are usually up to 20% more expensive
+var familyPrices = map[string]familyPrice{
+	"e2":  {vCPU: 0.021811, memoryGB: 0.002923},
+	"n1":  {vCPU: 0.031611, memoryGB: 0.004237},
+	"n2":  {vCPU: 0.031611, memoryGB: 0.004237},
+	"n2d": {vCPU: 0.027502, memoryGB: 0.003686},
+	"t2d": {vCPU: 0.027502, memoryGB: 0.003686},
+	"c2":  {vCPU: 0.033982, memoryGB: 0.004555},
+	"c2d": {vCPU: 0.029563, memoryGB: 0.003959},
+	"c3":  {vCPU: 0.034650, memoryGB: 0.004645},
+}
+
+// diskPricesPerGBMonth are the monthly prices of a GB of capacity of the disk types in
+// us-central1 in USD, the provisioned iops of pd-extreme are not included
+var diskPricesPerGBMonth = map[string]float64{
+	"pd-standard": 0.04,
+	"pd-balanced": 0.10,
+	"pd-ssd":      0.17,
+	"pd-extreme":  0.125,
+}
+
+// hoursPerMonth converts the monthly disk prices to hourly ones
+const hoursPerMonth = 730
+
+// estimateHourlyCost returns the approximate on-demand price per hour of the machine type
+// and the boot disk, false is returned for machine families and disk types without a known price
+func estimateHourlyCost(machineType *computepb.MachineType, diskType string, diskSizeGB int) (float64, bool) {
+	family, _, _ := strings.Cut(machineType.GetName(), "-")
+	price, ok := familyPrices[family]
+	if !ok {
+		return 0, false
+	}
+	diskPrice, ok := diskPricesPerGBMonth[diskType]
+	if !ok {
+		return 0, false
+	}
+
+	memoryGB := float64(machineType.GetMemoryMb()) / 1024
+	cost := float64(machineType.GetGuestCpus())*price.vCPU + memoryGB*price.memoryGB
+	return cost + float64(diskSizeGB)*diskPrice/hoursPerMonth, true
+}
+
+// logCostEstimate logs the estimated hourly cost of the instance with SHOW_COST
+func logCostEstimate(ctx context.Context, client *gcloud.Client, options *options.Options, diskSizeGB int, log log.Logger) {
+	machineType, err := client.GetMachineType(ctx, options.MachineType)
+	if err != nil || machineType == nil {
+		log.Debugf("Skipping the cost estimate, machine type %s not found: %v", options.MachineType, err)
+		return
+	}
+
+	cost, ok := estimateHourlyCost(machineType, options.DiskType, diskSizeGB)
+	if !ok {
+		log.Infof("No cost estimate available for machine type %s with disk type %s", options.MachineType, options.DiskType)
+		return
+	}
+
+	// spot instances aren't created by the provider, so there's no spot discount to apply
+	log.Infof("Estimated cost: ~$%.2f/hour, an estimate based on the us-central1 on-demand prices without GPUs, network and discounts", cost)
+}
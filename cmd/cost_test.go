@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"math"
+	"testing"
+
+	"cloud.google.com/go/compute/apiv1/computepb"
+	"github.com/badal-io/devpod-provider-gcloud/pkg/ptr"
+)
+
+func TestEstimateHourlyCost(t *testing.T) {
+	tests := []struct {
+		name        string
+		machineType string
+		guestCpus   int32
+		memoryMb    int32
+		diskType    string
+		diskSizeGB  int
+		want        float64
+		wantOK      bool
+	}{
+		{
+			name:        "e2 with pd-balanced",
+			machineType: "e2-standard-4",
+			guestCpus:   4,
+			memoryMb:    16384,
+			diskType:    "pd-balanced",
+			diskSizeGB:  73,
+			want:        4*0.021811 + 16*0.002923 + 73*0.10/730,
+			wantOK:      true,
+		},
+		{
+			name:        "n2 with pd-ssd",
+			machineType: "n2-highmem-2",
+			guestCpus:   2,
+			memoryMb:    16384,
+			diskType:    "pd-ssd",
+			diskSizeGB:  100,
+			want:        2*0.031611 + 16*0.004237 + 100*0.17/730,
+			wantOK:      true,
+		},
+		{
+			name:        "pd-standard costs less than pd-balanced",
+			machineType: "e2-standard-4",
+			guestCpus:   4,
+			memoryMb:    16384,
+			diskType:    "pd-standard",
+			diskSizeGB:  73,
+			want:        4*0.021811 + 16*0.002923 + 73*0.04/730,
+			wantOK:      true,
+		},
+		{
+			name:        "without disk",
+			machineType: "c3-standard-4",
+			guestCpus:   4,
+			memoryMb:    16384,
+			diskType:    "pd-balanced",
+			want:        4*0.034650 + 16*0.004645,
+			wantOK:      true,
+		},
+		{name: "unknown machine family", machineType: "a2-highgpu-1g", guestCpus: 12, memoryMb: 87040, diskType: "pd-balanced", diskSizeGB: 40},
+		{name: "unknown disk type", machineType: "e2-standard-4", guestCpus: 4, memoryMb: 16384, diskType: "hyperdisk-balanced", diskSizeGB: 40},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			machineType := &computepb.MachineType{
+				Name:      ptr.Ptr(test.machineType),
+				GuestCpus: ptr.Ptr(test.guestCpus),
+				MemoryMb:  ptr.Ptr(test.memoryMb),
+			}
+
+			cost, ok := estimateHourlyCost(machineType, test.diskType, test.diskSizeGB)
+			if ok != test.wantOK {
+				t.Fatalf("estimateHourlyCost() ok = %v, want %v", ok, test.wantOK)
+			} else if math.Abs(cost-test.want) > 1e-9 {
+				t.Errorf("estimateHourlyCost() = %v, want %v", cost, test.want)
+			}
+		})
+	}
+}
@@ -2,14 +2,19 @@ package cmd
 
 import (
 	"context"
-	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
 	"fmt"
+	"net"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"cloud.google.com/go/compute/apiv1/computepb"
@@ -17,13 +22,25 @@ import (
 	"github.com/badal-io/devpod-provider-gcloud/pkg/options"
 	"github.com/badal-io/devpod-provider-gcloud/pkg/ptr"
 	"github.com/loft-sh/devpod/pkg/log"
-	"github.com/loft-sh/devpod/pkg/ssh"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 )
 
 // CreateCmd holds the cmd flags
-type CreateCmd struct{}
+type CreateCmd struct {
+	KeepOnCancel bool
+	Output       string
+
+	// Progress is called when the create enters a new phase, the progress is logged if it's nil
+	Progress ProgressFunc
+}
+
+// createSummary is printed after a successful create with --output json
+type createSummary struct {
+	*gcloud.InstanceDetails
+	DiskSizeGB int  `json:"diskSizeGb"`
+	IAP        bool `json:"iap"`
+}
 
 // NewCreateCmd defines a command
 func NewCreateCmd() *cobra.Command {
@@ -31,63 +48,467 @@ func NewCreateCmd() *cobra.Command {
 	createCmd := &cobra.Command{
 		Use:   "create",
 		Short: "Create an instance",
-		RunE: func(_ *cobra.Command, args []string) error {
+		RunE: func(cobraCmd *cobra.Command, args []string) error {
 			options, err := options.FromEnv(true, true)
-			if err != nil {
+			if err != nil && cmd.Output == "json" {
+				return printErrorOutput(err, true)
+			} else if err != nil {
 				return err
 			}
 
-			return cmd.Run(context.Background(), options, log.Default)
+			// the OPERATION_TIMEOUT only bounds the api calls of the create, waiting for the ssh
+			// access of a new instance commonly takes longer
+			err = cmd.Run(cobraCmd.Context(), options, log.Default)
+			if err != nil && cmd.Output == "json" {
+				return printErrorOutput(err, false)
+			}
+
+			return err
 		},
 	}
 
+	createCmd.Flags().BoolVar(&cmd.KeepOnCancel, "keep-on-cancel", false, "Keep a partially created instance if the command is interrupted")
+	createCmd.Flags().StringVar(&cmd.Output, "output", "plain", "The output format, either plain or json. The json output prints a summary of the created instance, or the error with a stable code")
 	return createCmd
 }
 
 // Run runs the command logic
-func (cmd *CreateCmd) Run(ctx context.Context, options *options.Options, log log.Logger) error {
-	client, err := gcloud.NewClient(ctx, options.Project, options.Zone)
+func (cmd *CreateCmd) Run(ctx context.Context, options *options.Options, log log.Logger) (err error) {
+	if cmd.Output != "plain" && cmd.Output != "json" {
+		return fmt.Errorf("unsupported output format %s, must be one of plain, json", cmd.Output)
+	}
+
+	client, err := sharedClient(ctx, options)
+	if err != nil {
+		return err
+	}
+
+	progress := cmd.progressFunc(log)
+	progress(PhasePreflight, 0)
+
+	if options.NestedVirtualization && !nestedVirtualizationPattern.MatchString(options.MachineType) {
+		log.Warnf("Nested virtualization is only supported on Intel machine families, it might not be available on %s", options.MachineType)
+	}
+
+	// the preflight checks are independent of each other, so they run concurrently,
+	// the machine type, image and subnetwork of an instance template aren't known
+	checks := []func() error{}
+	if options.InstanceTemplate == "" {
+		checks = append(checks, func() error { return validateMachineType(ctx, client, options) })
+	}
+	if options.InstanceTemplate == "" && options.BootDiskName == "" {
+		checks = append(checks, func() error { return validateDiskImage(ctx, client, options) })
+	}
+	if options.CheckQuota && options.InstanceTemplate == "" {
+		checks = append(checks, func() error { return checkQuota(ctx, client, options) })
+	}
+	if options.InstanceGroup != "" {
+		checks = append(checks, func() error { return validateInstanceGroup(ctx, client, options) })
+	}
+	if options.CheckPermissions {
+		checks = append(checks, func() error { return checkPermissions(ctx, client, options) })
+	}
+	internalIP := ""
+	if options.InternalIP != "" {
+		checks = append(checks, func() error {
+			var err error
+			internalIP, err = resolveInternalIP(ctx, client, options)
+			return err
+		})
+	}
+
+	// Check Cloud NAT and IAP configuration if using private IP (IAP), a bastion
+	// host has its own network path to the instance
+	if !options.PublicIP && options.BastionHost == "" {
+		checks = append(checks, func() error { return checkGcloudCLI(ctx) })
+		if options.SkipNATCheck || options.InstanceTemplate != "" {
+			log.Warn("Skipping the Cloud NAT check, outbound connectivity of the instance isn't verified")
+		} else {
+			checks = append(checks, func() error { return checkCloudNATConfiguration(ctx, client, options) })
+		}
+		checks = append(checks, func() error {
+			err := ensureIAPFirewallRules(ctx, options, log)
+			if err != nil {
+				log.Warnf("IAP firewall setup: %v", err)
+				log.Info("You may need to configure IAP firewall rules manually if connection fails")
+			}
+
+			return nil
+		})
+	}
+
+	err = runWithTimeout(ctx, options, func(ctx context.Context) error {
+		return runPreflightChecks(checks)
+	})
+	if err != nil {
+		return err
+	}
+
+	var instance *computepb.Instance
+	if options.InstanceTemplate != "" {
+		instance, err = buildTemplateInstance(options)
+	} else {
+		instance, err = buildInstance(options)
+	}
 	if err != nil {
 		return err
 	}
-	defer client.Close()
+	if internalIP != "" {
+		instance.NetworkInterfaces[0].NetworkIP = ptr.Ptr(internalIP)
+	}
+	for key, value := range buildLabels(options.WorkspaceID, activeAccount(ctx)) {
+		instance.Labels[key] = value
+	}
+
+	// an interrupt after the insert request leaves an instance behind that devpod doesn't know about
+	existing := false
+	defer func() {
+		if err != nil && errors.Is(ctx.Err(), context.Canceled) && !cmd.KeepOnCancel && !existing {
+			cleanupCanceledCreate(client, options, log)
+		}
+	}()
+
+	progress(PhaseInserting, 20)
+	started := func(operation string) error {
+		return writePendingOperation(options, operation)
+	}
+	err = runWithTimeout(ctx, options, func(ctx context.Context) error {
+		var err error
+		if options.InstanceTemplate != "" {
+			err = client.CreateFromTemplate(ctx, instance, instanceTemplateID(options), started)
+		} else {
+			err = client.Create(ctx, instance, started)
+		}
+		if ctx.Err() == nil {
+			// keep the operation of an interrupted or timed out create for the wait command
+			removePendingOperation(options)
+		}
+
+		return err
+	})
+	if errors.Is(err, gcloud.ErrAlreadyExists) {
+		// the instance isn't ours, so an interrupt from now on must not delete it
+		existing = true
+		if !options.CreateIfNotExists {
+			return fmt.Errorf(`instance %s already exists, e.g. from a previous failed create.
+
+Delete it first with:
+
+  gcloud compute instances delete %s --project=%s --zone=%s
+
+or set CREATE_IF_NOT_EXISTS=true to use the existing instance`, options.MachineID, options.MachineID, options.Project, options.Zone)
+		}
+
+		log.Infof("Instance %s already exists, using the existing instance", options.MachineID)
+	} else if err != nil {
+		return err
+	}
 
-	// Check Cloud NAT and IAP configuration if using private IP (IAP)
+	if options.InstanceGroup != "" && !existing {
+		err = runWithTimeout(ctx, options, func(ctx context.Context) error {
+			return addToInstanceGroup(ctx, client, options, log)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// Configure SSH with ProxyCommand for IAP if not using public IP
 	if !options.PublicIP {
-		err = checkCloudNATConfiguration(ctx, client, options)
+		err = configureIAPAndWait(ctx, client, options, log, progress)
 		if err != nil {
 			return err
 		}
+	} else {
+		err = waitForRunning(ctx, client, options, progress)
+		if err != nil {
+			return fmt.Errorf("waiting for instance running: %w", err)
+		}
+
+		// devpod waits for the startup of instances with a public ip while connecting
+		progress(PhaseWaitingForStartup, 70)
+	}
+
+	if options.WaitForBootstrap && !existing {
+		err = waitForBootstrap(ctx, client, options, log)
+		if err != nil {
+			return err
+		}
+	}
 
-		err = ensureIAPFirewallRules(ctx, options, log)
+	if options.PostCreateCommand != "" {
+		err = runPostCreateCommand(ctx, client, options, log)
 		if err != nil {
-			log.Warnf("IAP firewall setup: %v", err)
-			log.Info("You may need to configure IAP firewall rules manually if connection fails")
+			return err
 		}
 	}
 
-	instance, err := buildInstance(options)
+	if options.ShowCost && options.InstanceTemplate == "" && options.BootDiskName == "" {
+		diskSize, _ := strconv.Atoi(options.DiskSize)
+		logCostEstimate(ctx, client, options, diskSize, log)
+	}
+
+	progress(PhaseDone, 100)
+	if cmd.Output == "json" {
+		return printCreateSummary(ctx, client, options)
+	}
+
+	return nil
+}
+
+// validateInstanceGroup checks that the INSTANCE_GROUP exists in the zone of the instance
+func validateInstanceGroup(ctx context.Context, client *gcloud.Client, options *options.Options) error {
+	exists, err := client.HasInstanceGroup(ctx, options.InstanceGroup)
+	if err != nil {
+		return fmt.Errorf("get instance group %s: %w", options.InstanceGroup, err)
+	} else if !exists {
+		return fmt.Errorf("instance group %s doesn't exist in zone %s, only unmanaged instance groups in the zone of the instance are supported", options.InstanceGroup, options.Zone)
+	}
+
+	return nil
+}
+
+// addToInstanceGroup adds the new instance to the INSTANCE_GROUP
+func addToInstanceGroup(ctx context.Context, client *gcloud.Client, options *options.Options, log log.Logger) error {
+	log.Infof("Adding instance %s to instance group %s", options.MachineID, options.InstanceGroup)
+	err := client.AddInstanceToGroup(ctx, options.InstanceGroup, options.MachineID)
+	if err != nil {
+		return fmt.Errorf("add instance to instance group %s: %w", options.InstanceGroup, err)
+	}
+
+	return nil
+}
+
+// configureIAPAndWait writes the IAP ssh config of a new instance and waits until it's reachable through it
+func configureIAPAndWait(ctx context.Context, client *gcloud.Client, options *options.Options, log log.Logger, progress ProgressFunc) error {
+	progress(PhaseConfiguringSSH, 40)
+	strictHostKeyChecking := false
+	if options.StrictHostKeyChecking {
+		strictHostKeyChecking = writeKnownHosts(ctx, client, options, log)
+	}
+
+	// the readiness check connects through the generated ssh config
+	err := configureSSHForIAP(options, strictHostKeyChecking)
 	if err != nil {
 		return err
 	}
 
-	err = client.Create(ctx, instance)
+	// Wait for instance to be fully ready and startup script to complete
+	log.Info("Waiting for instance to be fully ready...")
+	if err := waitForInstanceReady(ctx, client, options, log, progress); err != nil {
+		return fmt.Errorf("waiting for instance ready: %w", err)
+	}
+
+	return nil
+}
+
+// bootstrapTimeout is how long create waits for the startup script to report the finished bootstrap
+const bootstrapTimeout = 10 * time.Minute
+
+// waitForBootstrap waits until the startup script reports the finished bootstrap through the
+// guest attributes. If it isn't reported in time, create continues like without WAIT_FOR_BOOTSTRAP.
+func waitForBootstrap(ctx context.Context, client *gcloud.Client, options *options.Options, log log.Logger) error {
+	log.Info("Waiting for the startup script to finish the bootstrap...")
+	waitCtx, cancel := context.WithTimeout(ctx, bootstrapTimeout)
+	defer cancel()
+
+	_, err := client.WaitForGuestAttribute(waitCtx, options.MachineID, bootstrapAttributeNamespace, bootstrapAttributeKey, 5*time.Second)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	} else if err != nil {
+		log.Warnf("The instance didn't report the finished bootstrap: %v, continuing without it", err)
+		return nil
+	}
+
+	log.Info("The bootstrap of the instance is done")
+	return nil
+}
+
+// progressFunc returns the progress callback of the command, which defaults to logging the progress
+func (cmd *CreateCmd) progressFunc(log log.Logger) ProgressFunc {
+	if cmd.Progress != nil {
+		return cmd.Progress
+	}
+
+	return func(phase string, percent int) {
+		logProgress(log, phase, percent)
+	}
+}
+
+// printCreateSummary prints the details of the created instance as json to stdout
+func printCreateSummary(ctx context.Context, client *gcloud.Client, options *options.Options) error {
+	details, err := client.Details(ctx, options.MachineID)
 	if err != nil {
 		return err
 	}
 
-	// Configure SSH with ProxyCommand for IAP if not using public IP
-	if !options.PublicIP {
-		// Wait for instance to be fully ready and startup script to complete
-		log.Info("Waiting for instance to be fully ready...")
-		if err := waitForInstanceReady(ctx, client, options, log); err != nil {
-			return fmt.Errorf("waiting for instance ready: %w", err)
+	// the disk size was already validated when building the instance
+	diskSize, _ := strconv.Atoi(options.DiskSize)
+	out, err := json.MarshalIndent(&createSummary{
+		InstanceDetails: details,
+		DiskSizeGB:      diskSize,
+		IAP:             !options.PublicIP,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintln(os.Stdout, string(out))
+	return err
+}
+
+// runPreflightChecks runs all checks concurrently and returns their combined errors
+func runPreflightChecks(checks []func() error) error {
+	errs := make([]error, len(checks))
+	wg := sync.WaitGroup{}
+	for i, check := range checks {
+		wg.Add(1)
+		go func(i int, check func() error) {
+			defer wg.Done()
+			errs[i] = check()
+		}(i, check)
+	}
+	wg.Wait()
+
+	failed := []error{}
+	messages := []string{}
+	for _, err := range errs {
+		if err != nil {
+			failed = append(failed, err)
+			messages = append(messages, err.Error())
 		}
+	}
 
-		return configureSSHForIAP(options)
+	if len(failed) == 0 {
+		return nil
+	} else if len(failed) == 1 {
+		return failed[0]
 	}
 
-	return nil
+	return fmt.Errorf("%d preflight checks failed:\n%s", len(messages), strings.Join(messages, "\n\n"))
+}
+
+// cleanupCanceledCreate deletes the instance of a canceled create on a best effort basis
+func cleanupCanceledCreate(client *gcloud.Client, options *options.Options, log log.Logger) {
+	// the command context is already canceled
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	instance, err := client.Get(ctx, options.MachineID)
+	if err != nil {
+		log.Warnf("Cleanup of canceled create: %v", err)
+		return
+	} else if instance == nil {
+		return
+	} else if !gcloud.IsManaged(instance) {
+		log.Debugf("Not deleting instance %s of the canceled create, it wasn't created by the provider", options.MachineID)
+		return
+	}
+
+	log.Infof("Create was canceled, deleting instance %s...", options.MachineID)
+	err = client.Delete(ctx, options.MachineID)
+	if err != nil {
+		log.Warnf("Cleanup of canceled create: %v, delete the instance manually with: gcloud compute instances delete %s --project=%s --zone=%s", err, options.MachineID, options.Project, options.Zone)
+	}
+}
+
+// buildBootDisk returns the boot disk created from the DISK_IMAGE or the existing BOOT_DISK_NAME,
+// without a DISK_INTERFACE the interface is chosen by compute engine based on the image
+func buildBootDisk(options *options.Options, diskSize int, resourcePolicies []string, diskLabels map[string]string) *computepb.AttachedDisk {
+	var diskInterface *string
+	if options.DiskInterface != "" {
+		diskInterface = ptr.Ptr(options.DiskInterface)
+	}
+
+	if options.BootDiskName != "" {
+		// the existing disk is never deleted with the instance
+		return &computepb.AttachedDisk{
+			AutoDelete: ptr.Ptr(false),
+			Boot:       ptr.Ptr(true),
+			DeviceName: ptr.Ptr(bootDiskDeviceName(options)),
+			Interface:  diskInterface,
+			Source:     ptr.Ptr(bootDiskSource(options)),
+		}
+	}
+
+	// without DISK_IOPS compute engine picks the default iops of the disk type
+	var provisionedIops *int64
+	if options.DiskIOPS > 0 {
+		provisionedIops = ptr.Ptr(int64(options.DiskIOPS))
+	}
+
+	return &computepb.AttachedDisk{
+		AutoDelete: ptr.Ptr(!options.KeepBootDisk),
+		Boot:       ptr.Ptr(true),
+		DeviceName: ptr.Ptr(bootDiskDeviceName(options)),
+		Interface:  diskInterface,
+		InitializeParams: &computepb.AttachedDiskInitializeParams{
+			DiskSizeGb:       ptr.Ptr(int64(diskSize)),
+			DiskType:         ptr.Ptr(fmt.Sprintf("projects/%s/zones/%s/diskTypes/%s", options.Project, options.Zone, options.DiskType)),
+			Labels:           diskLabels,
+			ProvisionedIops:  provisionedIops,
+			SourceImage:      ptr.Ptr(sourceImage(options)),
+			ResourcePolicies: resourcePolicies,
+		},
+	}
+}
+
+// bootDiskSource returns the BOOT_DISK_NAME as a resource path, a disk name refers to a disk in the zone
+func bootDiskSource(options *options.Options) string {
+	if strings.Contains(options.BootDiskName, "/") {
+		return options.BootDiskName
+	}
+
+	return fmt.Sprintf("projects/%s/zones/%s/disks/%s", options.Project, options.Zone, options.BootDiskName)
+}
+
+// buildTemplateInstance returns the instance overriding the INSTANCE_TEMPLATE, which only sets
+// the name, the labels and the ssh key. The metadata of the template is replaced by the ssh key.
+func buildTemplateInstance(options *options.Options) (*computepb.Instance, error) {
+	publicKey, err := getPublicKey(options)
+	if err != nil {
+		return nil, errors.Wrap(err, "generate public key")
+	}
+
+	return &computepb.Instance{
+		Name: ptr.Ptr(options.MachineID),
+		Labels: map[string]string{
+			gcloud.ManagedByLabel: gcloud.ManagedByValue,
+		},
+		Metadata: &computepb.Metadata{
+			Items: append([]*computepb.Items{
+				{
+					Key:   ptr.Ptr("ssh-keys"),
+					Value: ptr.Ptr("devpod:" + publicKey),
+				},
+			}, blockProjectSSHKeysItems(options)...),
+		},
+	}, nil
+}
+
+// blockProjectSSHKeysItems returns the metadata that makes the instance ignore the project wide
+// ssh keys with BLOCK_PROJECT_SSH_KEYS, so only the devpod key of the instance is accepted
+func blockProjectSSHKeysItems(options *options.Options) []*computepb.Items {
+	if !options.BlockProjectSSHKeys {
+		return nil
+	}
+
+	return []*computepb.Items{
+		{
+			Key:   ptr.Ptr("block-project-ssh-keys"),
+			Value: ptr.Ptr("TRUE"),
+		},
+	}
+}
+
+// instanceTemplateID returns the INSTANCE_TEMPLATE as a resource path, a template name refers
+// to a global template of the project
+func instanceTemplateID(options *options.Options) string {
+	if strings.Contains(options.InstanceTemplate, "/") {
+		return options.InstanceTemplate
+	}
+
+	return fmt.Sprintf("projects/%s/global/instanceTemplates/%s", options.Project, options.InstanceTemplate)
 }
 
 func buildInstance(options *options.Options) (*computepb.Instance, error) {
@@ -96,24 +517,33 @@ func buildInstance(options *options.Options) (*computepb.Instance, error) {
 		return nil, errors.Wrap(err, "parse disk size")
 	}
 
-	// generate ssh keys
-	publicKeyBase, err := ssh.GetPublicKeyBase(options.MachineFolder)
+	aliasIPRanges, err := parseAliasIPRanges(options.AliasIPRanges)
 	if err != nil {
-		return nil, errors.Wrap(err, "generate public key")
+		return nil, errors.Wrap(err, "parse alias ip ranges")
+	}
+
+	network, err := normalizeNetworkID(options)
+	if err != nil {
+		return nil, err
 	}
 
-	publicKey, err := base64.StdEncoding.DecodeString(publicKeyBase)
+	subnetwork, err := normalizeSubnetworkID(options)
 	if err != nil {
 		return nil, err
 	}
+
+	// generate ssh keys
+	publicKey, err := getPublicKey(options)
+	if err != nil {
+		return nil, errors.Wrap(err, "generate public key")
+	}
+
 	serviceAccounts := []*computepb.ServiceAccount{}
 	if options.ServiceAccount != "" {
 		serviceAccounts = []*computepb.ServiceAccount{
 			{
-				Email: &options.ServiceAccount,
-				Scopes: []string{
-					"https://www.googleapis.com/auth/cloud-platform",
-				},
+				Email:  &options.ServiceAccount,
+				Scopes: options.Scopes,
 			},
 		}
 	}
@@ -122,87 +552,275 @@ func buildInstance(options *options.Options) (*computepb.Instance, error) {
 	metadataItems := []*computepb.Items{
 		{
 			Key:   ptr.Ptr("ssh-keys"),
-			Value: ptr.Ptr("devpod:" + string(publicKey)),
+			Value: ptr.Ptr("devpod:" + publicKey),
 		},
 	}
 
-	// Add startup script for IAP (no public IP) to create devpod user
-	// Google's guest-agent doesn't auto-create users from metadata when connecting via IAP
-	if !options.PublicIP {
-		startupScript := `#!/bin/bash
-# Create devpod user if it doesn't exist (required for IAP SSH)
-if ! id -u devpod > /dev/null 2>&1; then
-  useradd -m -s /bin/bash devpod
-  usermod -aG sudo devpod
-  # Allow sudo without password for DevPod operations
-  echo "devpod ALL=(ALL) NOPASSWD:ALL" > /etc/sudoers.d/devpod
-  chmod 0440 /etc/sudoers.d/devpod
-
-  # Setup SSH authorized_keys from metadata
-  # Google's guest-agent doesn't populate this for IAP connections
-  mkdir -p /home/devpod/.ssh
-  chmod 700 /home/devpod/.ssh
-
-  # Extract devpod's public key from instance metadata
-  curl -s "http://metadata.google.internal/computeMetadata/v1/instance/attributes/ssh-keys" \
-    -H "Metadata-Flavor: Google" | \
-    grep "^devpod:" | \
-    sed 's/^devpod://' > /home/devpod/.ssh/authorized_keys
-
-  chmod 600 /home/devpod/.ssh/authorized_keys
-  chown -R devpod:devpod /home/devpod/.ssh
-fi
-`
+	metadataItems = append(metadataItems, blockProjectSSHKeysItems(options)...)
+
+	startupScript, err := buildStartupScript(options)
+	if err != nil {
+		return nil, err
+	}
+	if startupScript != "" {
 		metadataItems = append(metadataItems, &computepb.Items{
 			Key:   ptr.Ptr("startup-script"),
 			Value: ptr.Ptr(startupScript),
 		})
 	}
 
+	if options.StrictHostKeyChecking || options.WaitForBootstrap {
+		// the guest environment publishes the ssh host keys to the guest attributes and the
+		// startup script reports the finished bootstrap through them
+		metadataItems = append(metadataItems, &computepb.Items{
+			Key:   ptr.Ptr("enable-guest-attributes"),
+			Value: ptr.Ptr("TRUE"),
+		})
+	}
+
+	userData, err := buildCloudInit(options, publicKey)
+	if err != nil {
+		return nil, err
+	}
+	if userData != "" {
+		metadataItems = append(metadataItems, &computepb.Items{
+			Key:   ptr.Ptr("user-data"),
+			Value: ptr.Ptr(userData),
+		})
+	}
+
+	resourcePolicies, err := buildDiskResourcePolicies(options)
+	if err != nil {
+		return nil, err
+	}
+
+	diskLabels, err := parseLabels(options.DiskLabels)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse disk labels")
+	}
+
+	nodeAffinities, err := parseNodeAffinities(options.NodeAffinity)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse node affinity")
+	}
+
+	// local ssds don't survive a live migration
+	onHostMaintenance := getMaintenancePolicy(options.MachineType)
+	if options.LocalSSDCount > 0 {
+		onHostMaintenance = "TERMINATE"
+	}
+
 	// generate instance object
 	instance := &computepb.Instance{
+		Labels: map[string]string{
+			gcloud.ManagedByLabel: gcloud.ManagedByValue,
+		},
+		// no spot override is needed, the provider never creates spot or preemptible instances
 		Scheduling: &computepb.Scheduling{
-			AutomaticRestart:  ptr.Ptr(true),
-			OnHostMaintenance: ptr.Ptr(getMaintenancePolicy(options.MachineType)),
+			AutomaticRestart:  ptr.Ptr(options.AutomaticRestart),
+			OnHostMaintenance: ptr.Ptr(onHostMaintenance),
+			NodeAffinities:    nodeAffinities,
 		},
 		Metadata: &computepb.Metadata{
 			Items: metadataItems,
 		},
 		MachineType: ptr.Ptr(fmt.Sprintf("projects/%s/zones/%s/machineTypes/%s", options.Project, options.Zone, options.MachineType)),
 		Disks: []*computepb.AttachedDisk{
-			{
-				AutoDelete: ptr.Ptr(true),
-				Boot:       ptr.Ptr(true),
-				DeviceName: ptr.Ptr(options.MachineID),
-				InitializeParams: &computepb.AttachedDiskInitializeParams{
-					DiskSizeGb:  ptr.Ptr(int64(diskSize)),
-					DiskType:    ptr.Ptr(fmt.Sprintf("projects/%s/zones/%s/diskTypes/pd-balanced", options.Project, options.Zone)),
-					SourceImage: ptr.Ptr(options.DiskImage),
-				},
-			},
+			buildBootDisk(options, diskSize, resourcePolicies, diskLabels),
 		},
 		Tags: buildInstanceTags(options),
 		NetworkInterfaces: []*computepb.NetworkInterface{
 			{
-				Network:       normalizeNetworkID(options),
-				Subnetwork:    normalizeSubnetworkID(options),
+				Network:       network,
+				Subnetwork:    subnetwork,
 				AccessConfigs: getAccessConfig(options),
+				AliasIpRanges: aliasIPRanges,
 			},
 		},
-		Zone:            ptr.Ptr(fmt.Sprintf("projects/%s/zones/%s", options.Project, options.Zone)),
-		Name:            ptr.Ptr(options.MachineID),
-		ServiceAccounts: serviceAccounts,
+		Zone:                ptr.Ptr(fmt.Sprintf("projects/%s/zones/%s", options.Project, options.Zone)),
+		Name:                ptr.Ptr(options.MachineID),
+		ServiceAccounts:     serviceAccounts,
+		ReservationAffinity: buildReservationAffinity(options),
+	}
+
+	if options.Hostname != "" {
+		instance.Hostname = ptr.Ptr(options.Hostname)
+	}
+	// the name of a reserved address is resolved by resolveInternalIP
+	if net.ParseIP(options.InternalIP) != nil {
+		instance.NetworkInterfaces[0].NetworkIP = ptr.Ptr(options.InternalIP)
 	}
 
+	instance.AdvancedMachineFeatures = buildAdvancedMachineFeatures(options)
+	instance.Disks = append(instance.Disks, buildLocalSSDs(options)...)
 	return instance, nil
 }
 
+var invalidLabelPattern = regexp.MustCompile(`[^a-z0-9_-]+`)
+
+// buildLabels returns the labels that record which workspace and user created the instance
+func buildLabels(workspaceID, account string) map[string]string {
+	labels := map[string]string{}
+	if value := sanitizeLabelValue(workspaceID); value != "" {
+		labels[gcloud.WorkspaceLabel] = value
+	}
+	if value := sanitizeLabelValue(account); value != "" {
+		labels["devpod-user"] = value
+	}
+
+	return labels
+}
+
+// sanitizeLabelValue converts value into a valid label value of at most 63 lowercase
+// letters, digits, underscores and dashes, e.g. jane.doe@example.com to jane-doe-example-com
+func sanitizeLabelValue(value string) string {
+	value = strings.Trim(invalidLabelPattern.ReplaceAllString(strings.ToLower(value), "-"), "-")
+	if len(value) > 63 {
+		value = strings.TrimRight(value[:63], "-")
+	}
+
+	return value
+}
+
+// gcloudVersionTimeout bounds the gcloud version call of checkGcloudCLI, a gcloud that doesn't
+// answer within it would hang the ssh connections as well
+var gcloudVersionTimeout = 30 * time.Second
+
+// checkGcloudCLI verifies that the gcloud CLI, which opens the IAP tunnels of the ProxyCommand,
+// is installed and working. Otherwise ssh would only fail once the ConnectTimeout is reached.
+func checkGcloudCLI(ctx context.Context) error {
+	_, err := exec.LookPath("gcloud")
+	if err != nil {
+		return fmt.Errorf("gcloud CLI required for IAP, install the Google Cloud CLI or set PUBLIC_IP_ENABLED=true: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, gcloudVersionTimeout)
+	defer cancel()
+
+	output, err := exec.CommandContext(ctx, "gcloud", "version").CombinedOutput()
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("gcloud CLI required for IAP, but gcloud version didn't finish within %s", gcloudVersionTimeout)
+	} else if err != nil {
+		return fmt.Errorf("gcloud CLI required for IAP, but gcloud version failed: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	return nil
+}
+
+// activeAccountTimeout bounds the gcloud call of activeAccount, the label is left out
+// rather than stalling the create on a gcloud that doesn't answer
+var activeAccountTimeout = 10 * time.Second
+
+// activeAccount returns the active gcloud account or an empty string if it can't be determined
+func activeAccount(ctx context.Context) string {
+	ctx, cancel := context.WithTimeout(ctx, activeAccountTimeout)
+	defer cancel()
+
+	output, err := exec.CommandContext(ctx, "gcloud", "config", "get-value", "account").Output()
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(string(output))
+}
+
+// buildAdvancedMachineFeatures returns the cpu features of the instance or nil for the defaults
+func buildAdvancedMachineFeatures(options *options.Options) *computepb.AdvancedMachineFeatures {
+	if !options.NestedVirtualization && options.ThreadsPerCore == 0 && options.VisibleCoreCount == 0 {
+		return nil
+	}
+
+	advancedMachineFeatures := &computepb.AdvancedMachineFeatures{}
+	if options.NestedVirtualization {
+		advancedMachineFeatures.EnableNestedVirtualization = ptr.Ptr(true)
+	}
+	if options.ThreadsPerCore > 0 {
+		advancedMachineFeatures.ThreadsPerCore = ptr.Ptr(int32(options.ThreadsPerCore))
+	}
+	if options.VisibleCoreCount > 0 {
+		advancedMachineFeatures.VisibleCoreCount = ptr.Ptr(int32(options.VisibleCoreCount))
+	}
+
+	return advancedMachineFeatures
+}
+
+// buildReservationAffinity returns the reservations the instance may consume, by default any matching reservation
+func buildReservationAffinity(options *options.Options) *computepb.ReservationAffinity {
+	if options.ReservationAffinity == "" {
+		return nil
+	}
+
+	reservationAffinity := &computepb.ReservationAffinity{
+		ConsumeReservationType: ptr.Ptr(options.ReservationAffinity),
+	}
+	if options.ReservationAffinity == "SPECIFIC_RESERVATION" {
+		reservationAffinity.Key = ptr.Ptr("compute.googleapis.com/reservation-name")
+		reservationAffinity.Values = []string{options.Reservation}
+	}
+
+	return reservationAffinity
+}
+
+var resourcePolicyPattern = regexp.MustCompile(`^(?:https://www\.googleapis\.com/compute/v1/)?projects/([^/]+)/regions/([^/]+)/resourcePolicies/([^/]+)$`)
+
+// buildDiskResourcePolicies returns the resource policies of the boot disk. A short policy
+// name refers to a policy in the region of the instance.
+func buildDiskResourcePolicies(options *options.Options) ([]string, error) {
+	policy := strings.TrimSpace(options.DiskResourcePolicy)
+	if policy == "" {
+		return nil, nil
+	}
+
+	region := regionFromZone(options.Zone)
+	if !strings.Contains(policy, "/") {
+		return []string{fmt.Sprintf("projects/%s/regions/%s/resourcePolicies/%s", options.Project, region, policy)}, nil
+	}
+
+	matches := resourcePolicyPattern.FindStringSubmatch(policy)
+	if matches == nil {
+		return nil, fmt.Errorf("invalid DISK_RESOURCE_POLICY %s, expected a policy name or projects/PROJECT/regions/REGION/resourcePolicies/NAME", policy)
+	} else if matches[2] != region {
+		return nil, fmt.Errorf("DISK_RESOURCE_POLICY %s is in region %s, but the disk is created in region %s", policy, matches[2], region)
+	}
+
+	return []string{policy}, nil
+}
+
+// bootDiskDeviceName returns the device name of the boot disk, the disk is
+// available at /dev/disk/by-id/google-<device name> in the instance
+func bootDiskDeviceName(options *options.Options) string {
+	if options.BootDiskDeviceName != "" {
+		return options.BootDiskDeviceName
+	}
+
+	return options.MachineID
+}
+
+// buildLocalSSDs returns the configured number of local ssd scratch disks with
+// the stable device names local-ssd-0, local-ssd-1, ...
+func buildLocalSSDs(options *options.Options) []*computepb.AttachedDisk {
+	disks := []*computepb.AttachedDisk{}
+	for i := 0; i < options.LocalSSDCount; i++ {
+		disks = append(disks, &computepb.AttachedDisk{
+			AutoDelete: ptr.Ptr(true),
+			DeviceName: ptr.Ptr(fmt.Sprintf("local-ssd-%d", i)),
+			Type:       ptr.Ptr("SCRATCH"),
+			Interface:  ptr.Ptr("NVME"),
+			InitializeParams: &computepb.AttachedDiskInitializeParams{
+				DiskType: ptr.Ptr(fmt.Sprintf("projects/%s/zones/%s/diskTypes/local-ssd", options.Project, options.Zone)),
+			},
+		})
+	}
+
+	return disks
+}
+
 func getAccessConfig(options *options.Options) []*computepb.AccessConfig {
 	if options.PublicIP {
 		return []*computepb.AccessConfig{
 			{
 				Name:        ptr.Ptr("External NAT"),
-				NetworkTier: ptr.Ptr("STANDARD"),
+				NetworkTier: ptr.Ptr(options.NetworkTier),
 			},
 		}
 	}
@@ -210,6 +828,114 @@ func getAccessConfig(options *options.Options) []*computepb.AccessConfig {
 	return nil
 }
 
+var rangeNamePattern = regexp.MustCompile(`^[a-z]([-a-z0-9]*[a-z0-9])?$`)
+
+// parseNodeAffinities parses semicolon separated node affinities of the form
+// KEY OPERATOR VALUE[,VALUE], e.g. compute.googleapis.com/node-group-name IN group-1
+func parseNodeAffinities(value string) ([]*computepb.SchedulingNodeAffinity, error) {
+	nodeAffinities := []*computepb.SchedulingNodeAffinity{}
+	for _, entry := range strings.Split(value, ";") {
+		fields := strings.Fields(entry)
+		if len(fields) == 0 {
+			continue
+		} else if len(fields) != 3 {
+			return nil, fmt.Errorf("invalid node affinity %q, expected KEY OPERATOR VALUE[,VALUE]", strings.TrimSpace(entry))
+		}
+
+		operator := fields[1]
+		if operator != "IN" && operator != "NOT_IN" {
+			return nil, fmt.Errorf("invalid node affinity operator %s in %q, must be IN or NOT_IN", operator, strings.TrimSpace(entry))
+		}
+
+		nodeAffinities = append(nodeAffinities, &computepb.SchedulingNodeAffinity{
+			Key:      ptr.Ptr(fields[0]),
+			Operator: ptr.Ptr(operator),
+			Values:   strings.Split(fields[2], ","),
+		})
+	}
+
+	return nodeAffinities, nil
+}
+
+var (
+	labelKeyPattern   = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,62}$`)
+	labelValuePattern = regexp.MustCompile(`^[a-z0-9_-]{0,63}$`)
+)
+
+// parseLabels parses comma separated KEY=VALUE labels, e.g. the DISK_LABELS of the boot disk
+func parseLabels(value string) (map[string]string, error) {
+	labels := map[string]string{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		key, labelValue, _ := strings.Cut(entry, "=")
+		if !labelKeyPattern.MatchString(key) {
+			return nil, fmt.Errorf("invalid label key %q in %q, it must start with a lowercase letter and only contain lowercase letters, digits, underscores and dashes", key, entry)
+		} else if !labelValuePattern.MatchString(labelValue) {
+			return nil, fmt.Errorf("invalid label value %q in %q, it must only contain lowercase letters, digits, underscores and dashes", labelValue, entry)
+		} else if _, ok := labels[key]; ok {
+			return nil, fmt.Errorf("duplicate label key %q", key)
+		}
+
+		labels[key] = labelValue
+	}
+
+	if len(labels) > 64 {
+		return nil, fmt.Errorf("at most 64 labels are supported, got %d", len(labels))
+	}
+
+	return labels, nil
+}
+
+// parseAliasIPRanges parses a comma separated list of [rangeName:]ipCidrRange entries,
+// e.g. pods:/28,10.0.1.0/24. Without a range name the primary range of the subnet is used.
+func parseAliasIPRanges(value string) ([]*computepb.AliasIpRange, error) {
+	aliasIPRanges := []*computepb.AliasIpRange{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		aliasIPRange := &computepb.AliasIpRange{}
+		ipCidrRange := entry
+		if i := strings.Index(entry, ":"); i >= 0 {
+			rangeName := entry[:i]
+			if !rangeNamePattern.MatchString(rangeName) {
+				return nil, fmt.Errorf("invalid subnetwork range name %q in %q", rangeName, entry)
+			}
+
+			aliasIPRange.SubnetworkRangeName = ptr.Ptr(rangeName)
+			ipCidrRange = entry[i+1:]
+		}
+
+		if !isValidIPCidrRange(ipCidrRange) {
+			return nil, fmt.Errorf("invalid ip cidr range %q in %q, expected an ip, a cidr or a netmask like /28", ipCidrRange, entry)
+		}
+
+		aliasIPRange.IpCidrRange = ptr.Ptr(ipCidrRange)
+		aliasIPRanges = append(aliasIPRanges, aliasIPRange)
+	}
+
+	return aliasIPRanges, nil
+}
+
+// isValidIPCidrRange checks if value is an ip (10.0.0.1), a cidr (10.0.0.0/24) or a netmask (/24)
+func isValidIPCidrRange(value string) bool {
+	if strings.HasPrefix(value, "/") {
+		prefixLength, err := strconv.Atoi(value[1:])
+		return err == nil && prefixLength >= 0 && prefixLength <= 32
+	} else if strings.Contains(value, "/") {
+		_, _, err := net.ParseCIDR(value)
+		return err == nil
+	}
+
+	return net.ParseIP(value) != nil
+}
+
 func buildInstanceTags(options *options.Options) *computepb.Tags {
 	if len(options.Tag) == 0 {
 		return nil
@@ -218,63 +944,72 @@ func buildInstanceTags(options *options.Options) *computepb.Tags {
 	return &computepb.Tags{Items: []string{options.Tag}}
 }
 
-func normalizeNetworkID(options *options.Options) *string {
-	network := options.Network
-	project := options.Project
+var (
+	// the self links of the api are accepted with their scheme and host as well
+	networkURLPattern    = regexp.MustCompile(`^(?:https://[^/]+/compute/(?:v1|beta)/)?projects/([^/]+)/global/networks/([^/]+)$`)
+	subnetworkURLPattern = regexp.MustCompile(`^(?:https://[^/]+/compute/(?:v1|beta)/)?projects/([^/]+)/regions/([^/]+)/subnetworks/([^/]+)$`)
+	resourcePathPattern  = regexp.MustCompile(`^[^/]+(?:/[^/]+){0,2}$`)
+)
 
+func normalizeNetworkID(options *options.Options) (*string, error) {
+	network := strings.TrimSpace(options.Network)
 	if len(network) == 0 {
-		return nil
+		return nil, nil
 	}
 
-	// projects/{{project}}/regions/{{region}}/subnetworks/{{name}}
-	if regexp.MustCompile("projects/([^/]+)/global/networks/([^/]+)").MatchString(network) {
-		return ptr.Ptr(network)
+	// projects/{{project}}/global/networks/{{name}}
+	if match := networkURLPattern.FindStringSubmatch(network); match != nil {
+		return ptr.Ptr(fmt.Sprintf("projects/%s/global/networks/%s", match[1], match[2])), nil
+	}
+
+	s := strings.Split(network, "/")
+	if !resourcePathPattern.MatchString(network) || len(s) > 2 {
+		return nil, fmt.Errorf("invalid NETWORK %s, must be {name}, {project}/{name} or projects/{project}/global/networks/{name}", options.Network)
 	}
 
 	// {{project}}/{{name}}
-	if regexp.MustCompile("([^/]+)/([^/]+)").MatchString(network) {
-		s := strings.Split(network, "/")
-		return ptr.Ptr(fmt.Sprintf("projects/%s/global/networks/%s", s[0], s[1]))
+	if len(s) == 2 {
+		return ptr.Ptr(fmt.Sprintf("projects/%s/global/networks/%s", s[0], s[1])), nil
 	}
 
 	// {{name}}
-	return ptr.Ptr(fmt.Sprintf("projects/%s/global/networks/%s", project, network))
+	return ptr.Ptr(fmt.Sprintf("projects/%s/global/networks/%s", options.NetworkProject(), network)), nil
 }
 
-func normalizeSubnetworkID(options *options.Options) *string {
+func normalizeSubnetworkID(options *options.Options) (*string, error) {
 	sn := strings.TrimSpace(options.Subnetwork)
-
 	if len(sn) == 0 {
-		return nil
+		return nil, nil
 	}
 
-	project := options.Project
-	zone := options.Zone
-	region := zone[:strings.LastIndex(zone, "-")]
-
 	// projects/{{project}}/regions/{{region}}/subnetworks/{{name}}
-	if regexp.MustCompile("projects/([^/]+)/regions/([^/]+)/subnetworks/([^/]+)").MatchString(sn) {
-		return ptr.Ptr(sn)
+	if match := subnetworkURLPattern.FindStringSubmatch(sn); match != nil {
+		return ptr.Ptr(fmt.Sprintf("projects/%s/regions/%s/subnetworks/%s", match[1], match[2], match[3])), nil
 	}
 
-	// {{project}}/{{region}}/{{name}}
-	if regexp.MustCompile("([^/]+)/([^/]+)/([^/]+)").MatchString(sn) {
-		s := strings.Split(sn, "/")
-		return ptr.Ptr(fmt.Sprintf("projects/%s/regions/%s/subnetworks/%s", s[0], s[1], s[2]))
+	if !resourcePathPattern.MatchString(sn) {
+		return nil, fmt.Errorf("invalid SUBNETWORK %s, must be {name}, {region}/{name}, {project}/{region}/{name} or projects/{project}/regions/{region}/subnetworks/{name}", options.Subnetwork)
 	}
 
-	// {{region}}/{{name}}
-	if regexp.MustCompile("([^/]+)/([^/]+)").MatchString(sn) {
-		s := strings.Split(sn, "/")
-		return ptr.Ptr(fmt.Sprintf("projects/%s/regions/%s/subnetworks/%s", project, s[0], s[1]))
+	s := strings.Split(sn, "/")
+	switch len(s) {
+	case 3:
+		// {{project}}/{{region}}/{{name}}
+		return ptr.Ptr(fmt.Sprintf("projects/%s/regions/%s/subnetworks/%s", s[0], s[1], s[2])), nil
+	case 2:
+		// {{region}}/{{name}}
+		return ptr.Ptr(fmt.Sprintf("projects/%s/regions/%s/subnetworks/%s", options.NetworkProject(), s[0], s[1])), nil
 	}
 
 	// {{name}}
-	return ptr.Ptr(fmt.Sprintf("projects/%s/regions/%s/subnetworks/%s", project, region, sn))
+	return ptr.Ptr(fmt.Sprintf("projects/%s/regions/%s/subnetworks/%s", options.NetworkProject(), regionFromZone(options.Zone), sn)), nil
 }
 
 var gpuInstancePattern *regexp.Regexp = regexp.MustCompile(`^[agn][0-9]`)
 
+// nestedVirtualizationPattern matches the machine families with Intel Haswell or newer cpus
+var nestedVirtualizationPattern = regexp.MustCompile(`^(n1|n2|n4|c2|c3|c4|m1|m2|m3|h3|a2|a3)-`)
+
 func getMaintenancePolicy(machineType string) string {
 	if gpuInstancePattern.MatchString(machineType) {
 		return "TERMINATE"
@@ -283,11 +1018,266 @@ func getMaintenancePolicy(machineType string) string {
 	return "MIGRATE"
 }
 
+// validateMachineType verifies that the configured machine type is available in the zone
+func validateMachineType(ctx context.Context, client *gcloud.Client, options *options.Options) error {
+	machineType, err := client.GetMachineType(ctx, options.MachineType)
+	if err != nil {
+		return fmt.Errorf("failed to look up machine type %s: %w", options.MachineType, err)
+	} else if machineType == nil {
+		return fmt.Errorf(`machine type %s not available in zone %s.
+
+To list the machine types available in this zone, run:
+
+  gcloud compute machine-types list --project=%s --zones=%s`,
+			options.MachineType,
+			options.Zone,
+			options.Project,
+			options.Zone,
+		)
+	}
+
+	// VISIBLE_CORE_COUNT counts physical cores, which run 2 vCPUs unless THREADS_PER_CORE is set
+	threadsPerCore := 2
+	if options.ThreadsPerCore > 0 {
+		threadsPerCore = options.ThreadsPerCore
+	}
+	if cores := int(machineType.GetGuestCpus()) / threadsPerCore; options.VisibleCoreCount > cores {
+		return fmt.Errorf("VISIBLE_CORE_COUNT %d exceeds the %d cores of machine type %s with %d threads per core", options.VisibleCoreCount, cores, options.MachineType, threadsPerCore)
+	}
+
+	return nil
+}
+
+// sourceImage returns the disk image of the boot disk. A plain image name is
+// expanded with the DISK_IMAGE_PROJECT, like gcloud's --image-project, and the
+// family/<project>/<family> shorthand is expanded to the image family of the project.
+func sourceImage(options *options.Options) string {
+	if project, family, ok := options.ImageFamily(); ok {
+		return fmt.Sprintf("projects/%s/global/images/family/%s", project, family)
+	} else if options.DiskImageProject == "" || strings.Contains(options.DiskImage, "/") {
+		return options.DiskImage
+	}
+
+	return fmt.Sprintf("projects/%s/global/images/%s", options.DiskImageProject, options.DiskImage)
+}
+
+// validateDiskImage verifies that the configured disk image exists and is accessible
+func validateDiskImage(ctx context.Context, client *gcloud.Client, options *options.Options) error {
+	image, err := client.GetImage(ctx, sourceImage(options))
+	if project, family, ok := options.ImageFamily(); ok && (image == nil || err != nil) {
+		// shared golden image families are usually missing the roles/compute.imageUser grant
+		if errors.Is(err, gcloud.ErrPermissionDenied) {
+			return fmt.Errorf("image family %s not accessible in project %s, grant roles/compute.imageUser on the project to the provider credentials: %w", family, project, err)
+		} else if err != nil {
+			return fmt.Errorf("failed to look up image family %s in project %s: %w", family, project, err)
+		}
+
+		return fmt.Errorf("image family %s not found in project %s, make sure the family has at least one image that isn't deprecated", family, project)
+	} else if err != nil {
+		return fmt.Errorf("failed to look up disk image %s: %w", sourceImage(options), err)
+	} else if image == nil {
+		return fmt.Errorf("disk image %s not found, make sure DISK_IMAGE references an existing image or image family", sourceImage(options))
+	}
+
+	diskSize, err := strconv.Atoi(options.DiskSize)
+	if err == nil && int64(diskSize) < image.GetDiskSizeGb() {
+		return fmt.Errorf("DISK_SIZE %dGB is smaller than the %dGB required by disk image %s, increase DISK_SIZE to at least %d", diskSize, image.GetDiskSizeGb(), sourceImage(options), image.GetDiskSizeGb())
+	}
+
+	return nil
+}
+
+// resolveInternalIP returns the address of the INTERNAL_IP, which is either an address or the
+// name of a reserved internal address, and verifies it's within the primary range of the SUBNETWORK
+func resolveInternalIP(ctx context.Context, client *gcloud.Client, options *options.Options) (string, error) {
+	subnetworkID, err := normalizeSubnetworkID(options)
+	if err != nil {
+		return "", err
+	}
+
+	// the subnetwork id is projects/{project}/regions/{region}/subnetworks/{name}
+	parts := strings.Split(*subnetworkID, "/")
+	subnetwork, err := client.GetSubnetwork(ctx, parts[1], parts[3], parts[5])
+	if err != nil {
+		return "", fmt.Errorf("failed to look up subnetwork %s: %w", *subnetworkID, err)
+	} else if subnetwork == nil {
+		return "", fmt.Errorf("subnetwork %s not found", *subnetworkID)
+	}
+
+	ip := options.InternalIP
+	if net.ParseIP(ip) == nil {
+		address, err := client.GetAddress(ctx, parts[3], options.InternalIP)
+		if err != nil {
+			return "", fmt.Errorf("failed to look up internal address %s: %w", options.InternalIP, err)
+		} else if address == nil {
+			return "", fmt.Errorf("internal address %s not found in region %s of project %s, reserve it with: gcloud compute addresses create %s --region=%s --subnet=%s", options.InternalIP, parts[3], options.Project, options.InternalIP, parts[3], *subnetworkID)
+		} else if address.GetAddressType() != "INTERNAL" {
+			return "", fmt.Errorf("address %s is an external address, INTERNAL_IP must be an internal address", options.InternalIP)
+		}
+
+		ip = address.GetAddress()
+	}
+
+	err = ipInRange(ip, subnetwork.GetIpCidrRange())
+	if err != nil {
+		return "", fmt.Errorf("INTERNAL_IP %s: %w", options.InternalIP, err)
+	}
+
+	return ip, nil
+}
+
+// ipInRange checks that the ip is a usable address of the subnetwork range, the first two
+// and the last two addresses of a range are reserved by Google Cloud
+func ipInRange(ip, cidr string) error {
+	address := net.ParseIP(ip).To4()
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if address == nil || err != nil || ipNet.IP.To4() == nil {
+		return fmt.Errorf("%s isn't an ipv4 address of range %s", ip, cidr)
+	} else if !ipNet.Contains(address) {
+		return fmt.Errorf("%s isn't within the range %s of the subnetwork", ip, cidr)
+	}
+
+	ones, bits := ipNet.Mask.Size()
+	offset := binary.BigEndian.Uint32(address) - binary.BigEndian.Uint32(ipNet.IP.To4())
+	if size := uint32(1) << (bits - ones); offset < 2 || offset >= size-2 {
+		return fmt.Errorf("%s is reserved by Google Cloud in range %s", ip, cidr)
+	}
+
+	return nil
+}
+
+// regionFromZone extracts the region from a zone (us-central1-a -> us-central1)
+func regionFromZone(zone string) string {
+	return zone[:strings.LastIndex(zone, "-")]
+}
+
+// checkQuota verifies that the CPUs and GPUs of the machine type fit into the remaining regional quota
+func checkQuota(ctx context.Context, client *gcloud.Client, options *options.Options) error {
+	machineType, err := client.GetMachineType(ctx, options.MachineType)
+	if err != nil {
+		return fmt.Errorf("failed to look up machine type %s: %w", options.MachineType, err)
+	} else if machineType == nil {
+		return fmt.Errorf("machine type %s not available in zone %s", options.MachineType, options.Zone)
+	}
+
+	region := regionFromZone(options.Zone)
+	quotas, err := client.GetRegionQuotas(ctx, region)
+	if err != nil {
+		return fmt.Errorf("failed to check quota in region %s: %w", region, err)
+	}
+
+	// e.g. n2-standard-4 is limited by CPUS as well as N2_CPUS
+	requested := map[string]float64{
+		"CPUS": float64(machineType.GetGuestCpus()),
+		strings.ToUpper(strings.Split(options.MachineType, "-")[0]) + "_CPUS": float64(machineType.GetGuestCpus()),
+	}
+	for _, accelerator := range machineType.Accelerators {
+		requested[gpuQuotaMetric(accelerator.GetGuestAcceleratorType())] += float64(accelerator.GetGuestAcceleratorCount())
+	}
+
+	for metric, amount := range requested {
+		quota, ok := quotas[metric]
+		if !ok {
+			continue
+		}
+
+		if quota.GetUsage()+amount > quota.GetLimit() {
+			return fmt.Errorf(`not enough %s quota in region %s: machine type %s requires %v, but only %v of %v are available.
+
+Request a quota increase at https://console.cloud.google.com/iam-admin/quotas?project=%s`,
+				metric,
+				region,
+				options.MachineType,
+				amount,
+				quota.GetLimit()-quota.GetUsage(),
+				quota.GetLimit(),
+				options.Project,
+			)
+		}
+	}
+
+	return nil
+}
+
+// requiredPermissions returns the permissions that creating and connecting to the instance
+// requires by project, the subnetwork permissions belong to the HOST_PROJECT of a shared vpc
+func requiredPermissions(options *options.Options) map[string][]string {
+	permissions := map[string][]string{
+		options.Project: {
+			"compute.instances.create",
+			"compute.instances.setMetadata",
+			"compute.instances.setLabels",
+			"compute.disks.create",
+		},
+	}
+
+	if options.InstanceTemplate != "" {
+		permissions[options.Project] = append(permissions[options.Project], "compute.instanceTemplates.useReadOnly")
+	}
+	if options.ServiceAccount != "" {
+		permissions[options.Project] = append(permissions[options.Project], "compute.instances.setServiceAccount", "iam.serviceAccounts.actAs")
+	}
+	if !options.PublicIP && options.BastionHost == "" {
+		permissions[options.Project] = append(permissions[options.Project], "iap.tunnelInstances.accessViaIAP")
+	}
+
+	if options.Subnetwork != "" {
+		networkProject := options.NetworkProject()
+		permissions[networkProject] = append(permissions[networkProject], "compute.subnetworks.use")
+		if options.PublicIP {
+			permissions[networkProject] = append(permissions[networkProject], "compute.subnetworks.useExternalIp")
+		}
+	}
+
+	return permissions
+}
+
+// checkPermissions verifies that the caller has all required permissions before anything is created
+func checkPermissions(ctx context.Context, client *gcloud.Client, options *options.Options) error {
+	missing := []string{}
+	for project, permissions := range requiredPermissions(options) {
+		granted, err := client.TestPermissions(ctx, project, permissions)
+		if err != nil {
+			return fmt.Errorf("failed to check permissions: %w", err)
+		}
+
+		missing = append(missing, missingPermissions(permissions, granted, project)...)
+	}
+
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("missing permissions, grant them to the active account or unset CHECK_PERMISSIONS to skip the check:\n  %s", strings.Join(missing, "\n  "))
+	}
+
+	return nil
+}
+
+// missingPermissions returns the required permissions that weren't granted on the project
+func missingPermissions(required, granted []string, project string) []string {
+	grantedSet := map[string]bool{}
+	for _, permission := range granted {
+		grantedSet[permission] = true
+	}
+
+	missing := []string{}
+	for _, permission := range required {
+		if !grantedSet[permission] {
+			missing = append(missing, fmt.Sprintf("%s on project %s", permission, project))
+		}
+	}
+
+	return missing
+}
+
+// gpuQuotaMetric maps an accelerator type to its quota metric (nvidia-tesla-t4 -> NVIDIA_T4_GPUS)
+func gpuQuotaMetric(acceleratorType string) string {
+	metric := strings.Replace(acceleratorType, "-tesla", "", 1)
+	return strings.ToUpper(strings.ReplaceAll(metric, "-", "_")) + "_GPUS"
+}
+
 // checkCloudNATConfiguration verifies that Cloud NAT is configured for the subnet when using private IPs
 func checkCloudNATConfiguration(ctx context.Context, client *gcloud.Client, options *options.Options) error {
-	// Extract region from zone (zone format: us-central1-a -> region: us-central1)
-	zone := options.Zone
-	region := zone[:strings.LastIndex(zone, "-")]
+	region := regionFromZone(options.Zone)
 
 	// Extract subnet name from the configured subnetwork
 	// If no subnetwork is specified, we can't check Cloud NAT
@@ -295,21 +1285,12 @@ func checkCloudNATConfiguration(ctx context.Context, client *gcloud.Client, opti
 		return fmt.Errorf("subnetwork must be specified when using private IP (PUBLIC_IP=false)")
 	}
 
-	// Parse the subnet name from various possible formats
-	subnetName := options.Subnetwork
-	// Handle full resource path: projects/{project}/regions/{region}/subnetworks/{name}
-	if strings.Contains(subnetName, "/subnetworks/") {
-		parts := strings.Split(subnetName, "/")
-		subnetName = parts[len(parts)-1]
-	}
-	// Handle {region}/{name} format
-	if strings.Contains(subnetName, "/") && !strings.Contains(subnetName, "projects/") {
-		parts := strings.Split(subnetName, "/")
-		subnetName = parts[len(parts)-1]
-	}
+	// The name is the last segment of all supported formats, i.e. a name, {region}/{name},
+	// a resource path or a url
+	subnetName := path.Base(options.Subnetwork)
 
 	// Check if Cloud NAT is configured for this subnet
-	hasCloudNAT, err := client.CheckCloudNAT(ctx, region, subnetName)
+	hasCloudNAT, err := client.CheckCloudNAT(ctx, options.NetworkProject(), region, subnetName)
 	if err != nil {
 		return fmt.Errorf("failed to check Cloud NAT configuration: %w", err)
 	}
@@ -349,14 +1330,14 @@ For more information, see:
 https://cloud.google.com/nat/docs/gke-example#step_1_create_a_nat_configuration_using`,
 			subnetName,
 			region,
-			options.Project,
+			options.NetworkProject(),
 			region,
 			options.Network,
 			region,
-			options.Project,
+			options.NetworkProject(),
 			region,
 			subnetName,
-			options.Project,
+			options.NetworkProject(),
 		)
 	}
 
@@ -364,34 +1345,83 @@ https://cloud.google.com/nat/docs/gke-example#step_1_create_a_nat_configuration_
 }
 
 // configureSSHForIAP creates an SSH config file with ProxyCommand for IAP tunneling
-func configureSSHForIAP(options *options.Options) error {
-	// SSH config will be in the machine folder
-	sshConfigPath := filepath.Join(options.MachineFolder, "ssh_config")
+func configureSSHForIAP(options *options.Options, strictHostKeyChecking bool) error {
+	// SSH config will be in the machine folder or the SSH_CONFIG_PATH
+	sshConfigPath := options.SSHConfigFile()
+
+	hostKeyOptions := hostKeyConfig(options, strictHostKeyChecking) + controlMasterConfig(options)
+	if options.BastionHost != "" {
+		return writeSSHConfig(sshConfigPath, bastionSSHConfig(options, hostKeyOptions))
+	}
+
+	// The IAP tunnel is opened by gcloud for every ssh connection, so it authenticates
+	// with the credentials of the gcloud CLI and not with gcloud.DefaultTokenSource.
+	// There is no native tunnel yet that could share and refresh the token of the
+	// compute clients.
 
 	// Create SSH config content with ProxyCommand for IAP
-	// Using extended timeouts for agent download which can take 2-5 minutes
-	// Increased ConnectTimeout from 60s to 300s (5 minutes) for agent injection
+	// The default ConnectTimeout of 300s (5 minutes) leaves room for the agent
+	// download which can take 2-5 minutes
 	sshConfig := fmt.Sprintf(`# DevPod GCP Provider IAP SSH Configuration
 Host %s
     HostName %s
+    Port %d
+    User devpod
+    IdentityFile %s
+%s    ProxyCommand %sgcloud compute start-iap-tunnel %%h %%p --listen-on-stdin --project=%s --zone=%s --verbosity=%s
+    ConnectTimeout %d
+    ServerAliveInterval %d
+    ServerAliveCountMax 20
+    TCPKeepAlive yes
+`,
+		options.MachineID,         // Host
+		options.MachineID,         // HostName (will be resolved via ProxyCommand)
+		options.SSHPort,           // Port, also the target port of the IAP tunnel
+		sshKeyFile(options),       // IdentityFile - DevPod's key naming
+		hostKeyOptions,            // StrictHostKeyChecking, UserKnownHostsFile and ControlMaster
+		gcloudProxyEnv(options),   // proxy of the tunnel with SSH_PROXY
+		options.Project,           // GCP Project
+		options.Zone,              // GCP Zone
+		options.IAPVerbosity,      // gcloud verbosity of the tunnel
+		options.IAPConnectTimeout, // ConnectTimeout in seconds
+		options.IAPSSHKeepalive,   // ServerAliveInterval in seconds
+	)
+
+	return writeSSHConfig(sshConfigPath, sshConfig)
+}
+
+// bastionSSHConfig returns the ssh config that jumps through the bastion host to the
+// internal dns name of the instance instead of opening an IAP tunnel
+func bastionSSHConfig(options *options.Options, hostKeyOptions string) string {
+	return fmt.Sprintf(`# DevPod GCP Provider bastion SSH Configuration
+Host %s
+    HostName %s.%s.c.%s.internal
+    Port %d
     User devpod
     IdentityFile %s
-    StrictHostKeyChecking no
-    UserKnownHostsFile /dev/null
-    ProxyCommand gcloud compute start-iap-tunnel %%h %%p --listen-on-stdin --project=%s --zone=%s --verbosity=warning
-    ConnectTimeout 300
-    ServerAliveInterval 30
+%s    ProxyJump %s
+    ConnectTimeout %d
+    ServerAliveInterval %d
     ServerAliveCountMax 20
     TCPKeepAlive yes
 `,
-		options.MachineID,            // Host
-		options.MachineID,            // HostName (will be resolved via ProxyCommand)
-		filepath.Join(options.MachineFolder, "id_devpod_rsa"), // IdentityFile - DevPod's key naming
-		options.Project,              // GCP Project
-		options.Zone,                 // GCP Zone
+		options.MachineID,
+		options.MachineID, options.Zone, options.Project, // zonal internal dns name, resolved by the bastion
+		options.SSHPort,
+		sshKeyFile(options),
+		hostKeyOptions,
+		options.BastionHost,
+		options.IAPConnectTimeout,
+		options.IAPSSHKeepalive,
 	)
+}
+
+func writeSSHConfig(sshConfigPath, sshConfig string) error {
+	// the directory of the SSH_CONFIG_PATH might not exist yet
+	if err := os.MkdirAll(filepath.Dir(sshConfigPath), 0700); err != nil {
+		return fmt.Errorf("create ssh config directory: %w", err)
+	}
 
-	// Write SSH config file
 	if err := os.WriteFile(sshConfigPath, []byte(sshConfig), 0600); err != nil {
 		return fmt.Errorf("write ssh config: %w", err)
 	}
@@ -399,23 +1429,46 @@ Host %s
 	return nil
 }
 
-// ensureIAPFirewallRules checks for and automatically creates IAP firewall rules if missing
-func ensureIAPFirewallRules(ctx context.Context, options *options.Options, log log.Logger) error {
-	log.Info("Checking IAP firewall configuration...")
+const (
+	iapFirewallRuleName = "devpod-allow-iap"
+
+	// iapFirewallRuleDescription marks the firewall rule as created by the provider
+	iapFirewallRuleDescription = "Allow IAP SSH access for DevPod instances"
+)
+
+// findIAPFirewallRules returns the names of the firewall rules that allow IAP to reach the ssh port
+func findIAPFirewallRules(ctx context.Context, options *options.Options) (string, error) {
+	// Check if IAP firewall rule exists using gcloud command, the rule has to allow all ranges
+	filters := []string{}
+	for _, sourceRange := range options.IAPSourceRanges {
+		filters = append(filters, "sourceRanges:"+sourceRange)
+	}
+	filters = append(filters, fmt.Sprintf("allowed:tcp:%d", options.SSHPort))
 
-	// Check if IAP firewall rule exists using gcloud command
 	checkCmd := exec.CommandContext(ctx, "gcloud", "compute", "firewall-rules", "list",
-		"--project="+options.Project,
-		"--filter=sourceRanges:35.235.240.0/20 AND allowed:tcp:22",
+		"--project="+options.NetworkProject(),
+		"--filter="+strings.Join(filters, " AND "),
 		"--format=value(name)")
 
 	output, err := checkCmd.Output()
 	if err != nil {
-		return fmt.Errorf("failed to check firewall rules - ensure gcloud CLI is installed and configured")
+		return "", fmt.Errorf("failed to check firewall rules - ensure gcloud CLI is installed and configured")
+	}
+
+	return strings.TrimSpace(string(output)), nil
+}
+
+// ensureIAPFirewallRules checks for and automatically creates IAP firewall rules if missing
+func ensureIAPFirewallRules(ctx context.Context, options *options.Options, log log.Logger) error {
+	log.Info("Checking IAP firewall configuration...")
+
+	rules, err := findIAPFirewallRules(ctx, options)
+	if err != nil {
+		return err
 	}
 
-	if len(strings.TrimSpace(string(output))) > 0 {
-		log.Infof("IAP firewall rules are configured (%s)", strings.TrimSpace(string(output)))
+	if rules != "" {
+		log.Infof("IAP firewall rules are configured (%s)", rules)
 		return nil
 	}
 
@@ -430,15 +1483,15 @@ func ensureIAPFirewallRules(ctx context.Context, options *options.Options, log l
 
 	// Build create command
 	createArgs := []string{
-		"compute", "firewall-rules", "create", "devpod-allow-iap",
-		"--project=" + options.Project,
+		"compute", "firewall-rules", "create", iapFirewallRuleName,
+		"--project=" + options.NetworkProject(),
 		"--direction=INGRESS",
 		"--priority=1000",
 		"--network=" + network,
 		"--action=ALLOW",
-		"--rules=tcp:22",
-		"--source-ranges=35.235.240.0/20",
-		"--description=Allow IAP SSH access for DevPod instances",
+		"--rules=tcp:" + strconv.Itoa(options.SSHPort),
+		"--source-ranges=" + strings.Join(options.IAPSourceRanges, ","),
+		"--description=" + iapFirewallRuleDescription,
 	}
 
 	// Add target tags if specified
@@ -453,7 +1506,7 @@ func ensureIAPFirewallRules(ctx context.Context, options *options.Options, log l
 	if err := createCmd.Run(); err != nil {
 		return fmt.Errorf(`failed to create IAP firewall rule automatically.
 
-The source range 35.235.240.0/20 is Google's IAP forwarding range.
+The source ranges %s are the IAP forwarding ranges.
 
 To create it manually, run:
 
@@ -463,14 +1516,17 @@ To create it manually, run:
     --priority=1000 \
     --network=%s \
     --action=ALLOW \
-    --rules=tcp:22 \
-    --source-ranges=35.235.240.0/20%s
+    --rules=tcp:%d \
+    --source-ranges=%s%s
 
 For more info: https://cloud.google.com/iap/docs/using-tcp-forwarding#create-firewall-rule
 
 Error: %v`,
-			options.Project,
+			strings.Join(options.IAPSourceRanges, ", "),
+			options.NetworkProject(),
 			network,
+			options.SSHPort,
+			strings.Join(options.IAPSourceRanges, ","),
 			func() string {
 				if options.Tag != "" {
 					return " \\\n    --target-tags=" + options.Tag
@@ -481,64 +1537,88 @@ Error: %v`,
 		)
 	}
 
-	log.Info("Successfully created IAP firewall rule 'devpod-allow-iap'")
+	log.Infof("Successfully created IAP firewall rule '%s'", iapFirewallRuleName)
 	return nil
 }
 
-// waitForInstanceReady waits for the instance to be fully ready including startup script completion
-func waitForInstanceReady(ctx context.Context, client *gcloud.Client, options *options.Options, log log.Logger) error {
-	// First, wait for instance to be in RUNNING state
-	maxAttempts := 60 // 5 minutes (60 * 5 seconds)
-	for i := 0; i < maxAttempts; i++ {
+// waitForRunning waits for the instance to be in RUNNING state, polling with a backoff
+// that starts at one second and is capped at the poll interval
+func waitForRunning(ctx context.Context, client *gcloud.Client, options *options.Options, progress ProgressFunc) error {
+	progress(PhaseWaitingForRunning, 50)
+
+	deadline := time.Now().Add(5 * time.Minute)
+	pollInterval := time.Duration(0)
+	for {
 		status, err := client.Status(ctx, options.MachineID)
 		if err != nil {
 			return fmt.Errorf("check instance status: %w", err)
 		}
 
 		if status == "Running" {
-			break
+			return nil
 		}
 
-		if i == maxAttempts-1 {
+		pollInterval = nextPollInterval(pollInterval, options.StatusPollInterval)
+		if time.Now().Add(pollInterval).After(deadline) {
 			return fmt.Errorf("timeout waiting for instance to be running")
 		}
 
-		time.Sleep(5 * time.Second)
+		if err := sleepContext(ctx, pollInterval); err != nil {
+			return err
+		}
 	}
+}
 
+// waitForInstanceReady waits for the instance to be fully ready including startup script completion
+func waitForInstanceReady(ctx context.Context, client *gcloud.Client, options *options.Options, log log.Logger, progress ProgressFunc) error {
+	// First, wait for instance to be in RUNNING state
+	if err := waitForRunning(ctx, client, options, progress); err != nil {
+		return err
+	}
+
+	progress(PhaseWaitingForStartup, 70)
 	log.Info("Instance is running, waiting for startup script to complete...")
 
 	// Wait additional time for startup script to create devpod user
 	// Extended from 30s to 45s for slower instances
-	time.Sleep(45 * time.Second)
+	if !options.SkipUserCreation {
+		if err := sleepContext(ctx, 45*time.Second); err != nil {
+			return err
+		}
+	}
 
 	// Verify devpod user exists by attempting SSH connection with exponential backoff
-	sshConfigPath := filepath.Join(options.MachineFolder, "ssh_config")
+	sshConfigPath := options.SSHConfigFile()
 
-	// Try up to 12 times with exponential backoff (total ~4 minutes)
-	// This accommodates IAP tunnel initialization and user setup
-	maxRetries := 12
+	// Try READY_CHECK_ATTEMPTS times with exponential backoff (total ~4 minutes by default)
+	// This accommodates IAP tunnel initialization and user setup. Every attempt runs a new
+	// ssh process.
+	maxRetries := options.ReadyCheckAttempts
 	for attempt := 0; attempt < maxRetries; attempt++ {
-		// Calculate backoff: 5s, 10s, 15s, 20s, 25s, 30s, then stay at 30s
-		backoff := time.Duration(min(5*(attempt+1), 30)) * time.Second
-
-		// Increased connection timeout from 10s to 30s for IAP tunnel stability
-		testCmd := exec.CommandContext(ctx, "ssh",
-			"-F", sshConfigPath,
-			"-o", "ConnectTimeout=30",
-			"-o", "ConnectionAttempts=3",
-			options.MachineID,
-			"echo 'ready'")
-
-		if err := testCmd.Run(); err == nil {
+		// Calculate backoff: 5s, 10s, 15s, 20s, 25s, 30s, then stay at 30s, with jitter so
+		// that concurrent creates don't retry in lockstep
+		backoff := withJitter(time.Duration(min(5*(attempt+1), 30)) * time.Second)
+
+		if err := runReadinessCheck(ctx, sshConfigPath, options, readinessCommand(options)); err == nil {
 			log.Info("Instance is fully ready for SSH connections")
 			return nil
 		}
 
 		if attempt < maxRetries-1 {
-			log.Infof("Waiting for SSH to be ready (attempt %d/%d, retry in %v)...", attempt+1, maxRetries, backoff)
-			time.Sleep(backoff)
+			log.Infof("Waiting for SSH to be ready (attempt %d/%d, retry in %v)...", attempt+1, maxRetries, backoff.Round(time.Second))
+			if err := sleepContext(ctx, backoff); err != nil {
+				return err
+			}
+		}
+	}
+
+	// If ssh works but the egress check doesn't, the instance can't download the agent
+	if (options.CheckEgress || options.ReadyCheckCommand != "") && runReadinessCheck(ctx, sshConfigPath, options, defaultReadinessCommand) == nil {
+		if options.CheckEgress && runReadinessCheck(ctx, sshConfigPath, options, egressCheckCommand(options)) != nil {
+			return fmt.Errorf("instance %s is reachable over SSH but cannot reach %s, make sure Cloud NAT or another egress path is configured for the subnet", options.MachineID, options.EgressCheckURL)
 		}
+
+		return fmt.Errorf("instance %s is reachable over SSH but the READY_CHECK_COMMAND didn't succeed", options.MachineID)
 	}
 
 	// Extended waiting period - log warning but don't fail
@@ -548,6 +1628,61 @@ func waitForInstanceReady(ctx context.Context, client *gcloud.Client, options *o
 	return nil
 }
 
+const defaultReadinessCommand = "echo 'ready'"
+
+// readinessCommand returns the command used to check if the instance is ready, which is the
+// READY_CHECK_COMMAND if set. With CHECK_EGRESS it also verifies the instance has outbound
+// connectivity.
+func readinessCommand(options *options.Options) string {
+	command := defaultReadinessCommand
+	if options.ReadyCheckCommand != "" {
+		// the subshell keeps operators of the command from binding to the egress check
+		command = "(" + options.ReadyCheckCommand + ")"
+	}
+
+	if options.CheckEgress {
+		return egressCheckCommand(options) + " && " + command
+	}
+
+	return command
+}
+
+// egressCheckCommand returns the command that checks the instance can reach the EGRESS_CHECK_URL
+func egressCheckCommand(options *options.Options) string {
+	return "curl -sfI --max-time 10 " + shellQuote(options.EgressCheckURL) + " > /dev/null"
+}
+
+// nextPollInterval doubles the previous interval, starting at one second, up to maxInterval
+func nextPollInterval(previous, maxInterval time.Duration) time.Duration {
+	next := 2 * previous
+	if next < time.Second {
+		next = time.Second
+	}
+	if next > maxInterval {
+		next = maxInterval
+	}
+
+	return next
+}
+
+// runReadinessCheck runs the given command on the instance through the IAP ssh config
+func runReadinessCheck(ctx context.Context, sshConfigPath string, options *options.Options, command string) error {
+	// Increased connection timeout from 10s to 30s for IAP tunnel stability
+	testCmd := exec.CommandContext(ctx, "ssh",
+		"-F", sshConfigPath,
+		"-o", "ConnectTimeout=30",
+		"-o", "ConnectionAttempts=3",
+		options.MachineID,
+		command)
+
+	return testCmd.Run()
+}
+
+// shellQuote quotes value for use as a single argument in a posix shell
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
 // min returns the minimum of two integers
 func min(a, b int) int {
 	if a < b {
@@ -0,0 +1,2154 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/compute/apiv1/computepb"
+	"github.com/badal-io/devpod-provider-gcloud/pkg/gcloud"
+	"github.com/badal-io/devpod-provider-gcloud/pkg/options"
+	"github.com/badal-io/devpod-provider-gcloud/pkg/ptr"
+	"github.com/loft-sh/devpod/pkg/log"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+func TestValidateMachineType(t *testing.T) {
+	tests := []struct {
+		name        string
+		machineType string
+		wantErr     string
+	}{
+		{name: "available machine type", machineType: "e2-standard-2"},
+		{name: "unavailable machine type", machineType: "e2-missing-2", wantErr: "machine type e2-missing-2 not available in zone europe-west1-b"},
+		{name: "failing lookup", machineType: "e2-forbidden-2", wantErr: "failed to look up machine type e2-forbidden-2"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake, options := newFakeCompute(t)
+			fake.handle(http.MethodGet, "/machineTypes/e2-standard-2", func(w http.ResponseWriter, r *http.Request) {
+				writeJSON(w, map[string]interface{}{"name": "e2-standard-2", "guestCpus": 2})
+			})
+			fake.handle(http.MethodGet, "/machineTypes/e2-forbidden-2", func(w http.ResponseWriter, r *http.Request) {
+				writeError(w, http.StatusForbidden, "forbidden")
+			})
+			options.MachineType = test.machineType
+
+			client, err := sharedClient(context.Background(), options)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = validateMachineType(context.Background(), client, options)
+			if test.wantErr == "" && err != nil {
+				t.Errorf("validateMachineType() error = %v", err)
+			} else if test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)) {
+				t.Errorf("validateMachineType() error = %v, want %q", err, test.wantErr)
+			}
+			if err != nil && strings.Contains(test.wantErr, "not available") && !strings.Contains(err.Error(), "gcloud compute machine-types list --project="+options.Project+" --zones=europe-west1-b") {
+				t.Errorf("validateMachineType() error = %v, want the command to list the machine types", err)
+			}
+		})
+	}
+}
+
+func TestValidateMachineTypeVisibleCoreCount(t *testing.T) {
+	tests := []struct {
+		name             string
+		visibleCoreCount int
+		threadsPerCore   int
+		wantErr          string
+	}{
+		{name: "all cores", visibleCoreCount: 4},
+		{name: "more cores than the machine type", visibleCoreCount: 5, wantErr: "exceeds the 4 cores"},
+		{name: "vcpus instead of cores", visibleCoreCount: 8, wantErr: "exceeds the 4 cores"},
+		{name: "one thread per core", visibleCoreCount: 8, threadsPerCore: 1},
+		{name: "more than the vcpus with one thread per core", visibleCoreCount: 9, threadsPerCore: 1, wantErr: "exceeds the 8 cores"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake, options := newFakeCompute(t)
+			fake.handle(http.MethodGet, "/machineTypes/c2-standard-8", func(w http.ResponseWriter, r *http.Request) {
+				writeJSON(w, map[string]interface{}{"name": "c2-standard-8", "guestCpus": 8})
+			})
+			options.MachineType = "c2-standard-8"
+			options.VisibleCoreCount = test.visibleCoreCount
+			options.ThreadsPerCore = test.threadsPerCore
+
+			client, err := sharedClient(context.Background(), options)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = validateMachineType(context.Background(), client, options)
+			if test.wantErr == "" && err != nil {
+				t.Errorf("validateMachineType() error = %v", err)
+			} else if test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)) {
+				t.Errorf("validateMachineType() error = %v, want %q", err, test.wantErr)
+			}
+		})
+	}
+}
+
+// fakeSleeps replaces sleepContext for the test, the waits return immediately and are recorded
+func fakeSleeps(t *testing.T) *[]time.Duration {
+	t.Helper()
+
+	sleeps := &[]time.Duration{}
+	original := sleepContext
+	sleepContext = func(ctx context.Context, duration time.Duration) error {
+		*sleeps = append(*sleeps, duration)
+		return ctx.Err()
+	}
+	t.Cleanup(func() { sleepContext = original })
+
+	return sleeps
+}
+
+// fakeSSH puts an ssh script on the PATH that fails the first failures calls and every call
+// whose arguments contain failMatch, it returns the file the arguments of the calls are logged to
+func fakeSSH(t *testing.T, failures int, failMatch string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	calls := filepath.Join(dir, "calls")
+	script := `#!/bin/sh
+echo "$*" >> "$FAKE_SSH_CALLS"
+if [ -n "$FAKE_SSH_FAIL_MATCH" ]; then
+	case "$*" in
+	*"$FAKE_SSH_FAIL_MATCH"*) exit 1 ;;
+	esac
+fi
+[ "$(wc -l < "$FAKE_SSH_CALLS")" -gt "$FAKE_SSH_FAILURES" ]
+`
+	if err := os.WriteFile(filepath.Join(dir, "ssh"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("FAKE_SSH_CALLS", calls)
+	t.Setenv("FAKE_SSH_FAILURES", strconv.Itoa(failures))
+	t.Setenv("FAKE_SSH_FAIL_MATCH", failMatch)
+	return calls
+}
+
+func TestWaitForInstanceReadyRetries(t *testing.T) {
+	tests := []struct {
+		name              string
+		failures          int
+		failMatch         string
+		checkEgress       bool
+		readyCheckCommand string
+		wantCalls         []string
+		wantSleeps        int
+		wantErr           string
+	}{
+		{
+			name:      "ready at the first attempt",
+			wantCalls: []string{"echo 'ready'"},
+		},
+		{
+			name:       "ready at the third attempt",
+			failures:   2,
+			wantCalls:  []string{"echo 'ready'", "echo 'ready'", "echo 'ready'"},
+			wantSleeps: 2,
+		},
+		{
+			name:       "never ready",
+			failures:   3,
+			wantCalls:  []string{"echo 'ready'", "echo 'ready'", "echo 'ready'"},
+			wantSleeps: 2,
+		},
+		{
+			name:        "no egress",
+			failMatch:   "curl",
+			checkEgress: true,
+			wantCalls: []string{
+				"curl -sfI --max-time 10 'https://example.com' > /dev/null && echo 'ready'",
+				"curl -sfI --max-time 10 'https://example.com' > /dev/null && echo 'ready'",
+				"curl -sfI --max-time 10 'https://example.com' > /dev/null && echo 'ready'",
+				"echo 'ready'",
+				"curl -sfI --max-time 10 'https://example.com' > /dev/null",
+			},
+			wantSleeps: 2,
+			wantErr:    "cannot reach https://example.com",
+		},
+		{
+			name:              "failing ready check command",
+			failMatch:         "test -f",
+			readyCheckCommand: "test -f /ready",
+			wantCalls:         []string{"(test -f /ready)", "(test -f /ready)", "(test -f /ready)", "echo 'ready'"},
+			wantSleeps:        2,
+			wantErr:           "READY_CHECK_COMMAND didn't succeed",
+		},
+		{
+			name:              "ready check command passing at the second attempt",
+			failures:          1,
+			readyCheckCommand: "test -f /ready",
+			wantCalls:         []string{"(test -f /ready)", "(test -f /ready)"},
+			wantSleeps:        1,
+		},
+		{
+			name:              "failing ready check command with egress",
+			failMatch:         "test -f",
+			checkEgress:       true,
+			readyCheckCommand: "test -f /ready",
+			wantCalls: []string{
+				"curl -sfI --max-time 10 'https://example.com' > /dev/null && (test -f /ready)",
+				"curl -sfI --max-time 10 'https://example.com' > /dev/null && (test -f /ready)",
+				"curl -sfI --max-time 10 'https://example.com' > /dev/null && (test -f /ready)",
+				"echo 'ready'",
+				"curl -sfI --max-time 10 'https://example.com' > /dev/null",
+			},
+			wantSleeps: 2,
+			wantErr:    "READY_CHECK_COMMAND didn't succeed",
+		},
+		{
+			name:              "unreachable with ready check command",
+			failures:          4,
+			readyCheckCommand: "test -f /ready",
+			wantCalls:         []string{"(test -f /ready)", "(test -f /ready)", "(test -f /ready)", "echo 'ready'"},
+			wantSleeps:        2,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake, options := newFakeCompute(t)
+			fake.handle(http.MethodGet, "/instances/devpod-test", func(w http.ResponseWriter, r *http.Request) {
+				writeJSON(w, map[string]string{"name": "devpod-test", "status": "RUNNING"})
+			})
+			options.SkipUserCreation = true
+			options.ReadyCheckAttempts = 3
+			options.StatusPollInterval = 10 * time.Second
+			options.CheckEgress = test.checkEgress
+			options.EgressCheckURL = "https://example.com"
+			options.ReadyCheckCommand = test.readyCheckCommand
+			sleeps := fakeSleeps(t)
+			calls := fakeSSH(t, test.failures, test.failMatch)
+
+			client, err := sharedClient(context.Background(), options)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = waitForInstanceReady(context.Background(), client, options, discardLogger(), func(string, int) {})
+			if test.wantErr == "" && err != nil {
+				t.Errorf("waitForInstanceReady() error = %v", err)
+			} else if test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)) {
+				t.Errorf("waitForInstanceReady() error = %v, want %q", err, test.wantErr)
+			}
+
+			out, err := os.ReadFile(calls)
+			if err != nil {
+				t.Fatal(err)
+			}
+			prefix := "-F " + options.SSHConfigFile() + " -o ConnectTimeout=30 -o ConnectionAttempts=3 devpod-test "
+			var gotCalls []string
+			for _, call := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+				if !strings.HasPrefix(call, prefix) {
+					t.Fatalf("ssh called with %q, want the prefix %q", call, prefix)
+				}
+				gotCalls = append(gotCalls, strings.TrimPrefix(call, prefix))
+			}
+			if !reflect.DeepEqual(gotCalls, test.wantCalls) {
+				t.Errorf("ssh calls = %q, want %q", gotCalls, test.wantCalls)
+			}
+
+			if len(*sleeps) != test.wantSleeps {
+				t.Fatalf("sleeps = %v, want %d", *sleeps, test.wantSleeps)
+			}
+			for i, sleep := range *sleeps {
+				if backoff := time.Duration(5*(i+1)) * time.Second; sleep < backoff || sleep > backoff+backoff/5 {
+					t.Errorf("sleep %d = %v, want %v with up to a fifth of jitter", i, sleep, backoff)
+				}
+			}
+		})
+	}
+}
+
+func TestNextPollInterval(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxInterval time.Duration
+		want        []time.Duration
+	}{
+		{
+			name:        "doubles up to the max interval",
+			maxInterval: 10 * time.Second,
+			want:        []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second},
+		},
+		{
+			name:        "max interval below a second",
+			maxInterval: 500 * time.Millisecond,
+			want:        []time.Duration{500 * time.Millisecond, 500 * time.Millisecond},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			interval := time.Duration(0)
+			var got []time.Duration
+			for range test.want {
+				interval = nextPollInterval(interval, test.maxInterval)
+				got = append(got, interval)
+			}
+
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("intervals = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestWaitForInstanceReadyPollsStatus(t *testing.T) {
+	fake, options := newFakeCompute(t)
+	statuses := []string{"PROVISIONING", "STAGING", "STAGING", "STAGING", "RUNNING"}
+	fake.handle(http.MethodGet, "/instances/devpod-test", func(w http.ResponseWriter, r *http.Request) {
+		status := statuses[0]
+		if len(statuses) > 1 {
+			statuses = statuses[1:]
+		}
+		writeJSON(w, map[string]string{"name": "devpod-test", "status": status})
+	})
+	options.SkipUserCreation = true
+	options.ReadyCheckAttempts = 1
+	options.StatusPollInterval = 5 * time.Second
+	sleeps := fakeSleeps(t)
+	fakeSSH(t, 0, "")
+
+	client, err := sharedClient(context.Background(), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := waitForInstanceReady(context.Background(), client, options, discardLogger(), func(string, int) {}); err != nil {
+		t.Fatalf("waitForInstanceReady() error = %v", err)
+	}
+
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second}
+	if !reflect.DeepEqual(*sleeps, want) {
+		t.Errorf("sleeps = %v, want %v", *sleeps, want)
+	}
+}
+
+func TestValidateDiskImage(t *testing.T) {
+	tests := []struct {
+		name      string
+		diskImage string
+		diskSize  string
+		wantErr   string
+	}{
+		{name: "image", diskImage: "test-image", diskSize: "40"},
+		{name: "image of the required size", diskImage: "test-image", diskSize: "10"},
+		{name: "disk size smaller than the image", diskImage: "test-image", diskSize: "5", wantErr: "DISK_SIZE 5GB is smaller than the 10GB required by disk image"},
+		{name: "missing image", diskImage: "missing-image", diskSize: "40", wantErr: "not found, make sure DISK_IMAGE references an existing image"},
+		{name: "failing image lookup", diskImage: "broken-image", diskSize: "40", wantErr: "failed to look up disk image"},
+		{name: "image family", diskImage: "family/%s/golden", diskSize: "40"},
+		{name: "missing image family", diskImage: "family/%s/missing", diskSize: "40", wantErr: "image family missing not found in project"},
+		{name: "image family without access", diskImage: "family/%s/private", diskSize: "40", wantErr: "grant roles/compute.imageUser"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake, options := newFakeCompute(t)
+			fake.handleProject(http.MethodGet, "/global/images/test-image", func(w http.ResponseWriter, r *http.Request) {
+				writeJSON(w, map[string]interface{}{"name": "test-image", "diskSizeGb": "10"})
+			})
+			fake.handleProject(http.MethodGet, "/global/images/broken-image", func(w http.ResponseWriter, r *http.Request) {
+				writeError(w, http.StatusInternalServerError, "backendError")
+			})
+			fake.handleProject(http.MethodGet, "/global/images/family/golden", func(w http.ResponseWriter, r *http.Request) {
+				writeJSON(w, map[string]interface{}{"name": "golden-v2", "diskSizeGb": "20"})
+			})
+			fake.handleProject(http.MethodGet, "/global/images/family/private", func(w http.ResponseWriter, r *http.Request) {
+				writeError(w, http.StatusForbidden, "forbidden")
+			})
+			options.DiskImage = "projects/" + options.Project + "/global/images/" + test.diskImage
+			if strings.HasPrefix(test.diskImage, "family/") {
+				options.DiskImage = fmt.Sprintf(test.diskImage, options.Project)
+			}
+			options.DiskSize = test.diskSize
+
+			client, err := sharedClient(context.Background(), options)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = validateDiskImage(context.Background(), client, options)
+			if test.wantErr == "" && err != nil {
+				t.Errorf("validateDiskImage() error = %v", err)
+			} else if test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)) {
+				t.Errorf("validateDiskImage() error = %v, want %q", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestSourceImage(t *testing.T) {
+	tests := []struct {
+		name             string
+		diskImage        string
+		diskImageProject string
+		want             string
+	}{
+		{name: "image path", diskImage: "projects/cos-cloud/global/images/cos-101", want: "projects/cos-cloud/global/images/cos-101"},
+		{name: "image path with image project", diskImage: "projects/cos-cloud/global/images/cos-101", diskImageProject: "other", want: "projects/cos-cloud/global/images/cos-101"},
+		{name: "image name", diskImage: "cos-101", want: "cos-101"},
+		{name: "image name with image project", diskImage: "cos-101", diskImageProject: "cos-cloud", want: "projects/cos-cloud/global/images/cos-101"},
+		{name: "family shorthand", diskImage: "family/cos-cloud/cos-stable", want: "projects/cos-cloud/global/images/family/cos-stable"},
+		{name: "family shorthand with image project", diskImage: "family/cos-cloud/cos-stable", diskImageProject: "other", want: "projects/cos-cloud/global/images/family/cos-stable"},
+		{name: "incomplete family shorthand", diskImage: "family/cos-stable", want: "family/cos-stable"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := sourceImage(&options.Options{DiskImage: test.diskImage, DiskImageProject: test.diskImageProject})
+			if got != test.want {
+				t.Errorf("sourceImage() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+// newFakeCreate returns a fake compute api and the options of a create with public ip,
+// the preflight checks of the machine type and the disk image pass and the instance runs
+func newFakeCreate(t *testing.T) (*fakeCompute, *options.Options) {
+	t.Helper()
+
+	fake, options := newFakeCompute(t)
+	fake.handle(http.MethodGet, "/machineTypes/e2-standard-2", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]interface{}{"name": "e2-standard-2", "guestCpus": 2})
+	})
+	fake.handleProject(http.MethodGet, "/global/images/test-image", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]interface{}{"name": "test-image", "diskSizeGb": "10"})
+	})
+	fake.handle(http.MethodGet, "/instances/devpod-test", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]interface{}{"name": "devpod-test", "status": "RUNNING", "labels": map[string]string{gcloud.ManagedByLabel: gcloud.ManagedByValue}})
+	})
+	options.MachineType = "e2-standard-2"
+	options.DiskImage = "projects/" + options.Project + "/global/images/test-image"
+	options.DiskSize = "40"
+	options.PublicIP = true
+	return fake, options
+}
+
+func TestCreateAlreadyExists(t *testing.T) {
+	tests := []struct {
+		name              string
+		createIfNotExists bool
+		wantErr           string
+	}{
+		{name: "fails without CREATE_IF_NOT_EXISTS", wantErr: "instance devpod-test already exists"},
+		{name: "uses the existing instance with CREATE_IF_NOT_EXISTS", createIfNotExists: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake, options := newFakeCreate(t)
+			fake.handle(http.MethodPost, "/instances", func(w http.ResponseWriter, r *http.Request) {
+				writeError(w, http.StatusConflict, "alreadyExists")
+			})
+			options.CreateIfNotExists = test.createIfNotExists
+
+			client, err := sharedClient(context.Background(), options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := client.Create(context.Background(), &computepb.Instance{Name: ptr.Ptr("devpod-test")}, nil); !errors.Is(err, gcloud.ErrAlreadyExists) {
+				t.Errorf("Create() error = %v, want %v", err, gcloud.ErrAlreadyExists)
+			}
+
+			cmd := &CreateCmd{Output: "plain", Progress: func(string, int) {}}
+			err = cmd.Run(context.Background(), options, discardLogger())
+			if test.wantErr == "" && err != nil {
+				t.Errorf("Run() error = %v", err)
+			} else if test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)) {
+				t.Errorf("Run() error = %v, want %q", err, test.wantErr)
+			}
+
+			for _, request := range fake.requested() {
+				if strings.HasPrefix(request, http.MethodDelete) {
+					t.Errorf("the existing instance was deleted with %s", request)
+				}
+			}
+		})
+	}
+}
+
+func TestCreateCanceledCleanup(t *testing.T) {
+	tests := []struct {
+		name         string
+		keepOnCancel bool
+		labels       map[string]string
+		wantDelete   bool
+	}{
+		{name: "deletes the instance", labels: map[string]string{gcloud.ManagedByLabel: gcloud.ManagedByValue}, wantDelete: true},
+		{name: "keeps the instance with --keep-on-cancel", keepOnCancel: true, labels: map[string]string{gcloud.ManagedByLabel: gcloud.ManagedByValue}},
+		{name: "keeps an instance of someone else", labels: map[string]string{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			fake, options := newFakeCreate(t)
+			fake.handle(http.MethodPost, "/instances", func(w http.ResponseWriter, r *http.Request) {
+				writeOperation(w, "op-insert")
+			})
+			fake.handle(http.MethodPost, "/operations/op-insert/wait", func(w http.ResponseWriter, r *http.Request) {
+				// the command is interrupted while waiting for the insert
+				cancel()
+				writeOperation(w, "op-insert")
+			})
+			fake.handle(http.MethodGet, "/instances/devpod-test", func(w http.ResponseWriter, r *http.Request) {
+				writeJSON(w, map[string]interface{}{"name": "devpod-test", "status": "PROVISIONING", "labels": test.labels})
+			})
+			fake.handle(http.MethodDelete, "/instances/devpod-test", func(w http.ResponseWriter, r *http.Request) {
+				writeOperation(w, "op-delete")
+			})
+
+			cmd := &CreateCmd{KeepOnCancel: test.keepOnCancel, Output: "plain", Progress: func(string, int) {}}
+			if err := cmd.Run(ctx, options, discardLogger()); err == nil {
+				t.Fatal("Run() of a canceled create didn't fail")
+			}
+
+			deleted := false
+			for _, request := range fake.requested() {
+				deleted = deleted || request == "DELETE /instances/devpod-test"
+			}
+			if deleted != test.wantDelete {
+				t.Errorf("instance deleted = %t, want %t, requests: %v", deleted, test.wantDelete, fake.requested())
+			}
+		})
+	}
+}
+
+func TestCreateCanceledAfterAlreadyExists(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	fake, options := newFakeCreate(t)
+	fake.handle(http.MethodPost, "/instances", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusConflict, "alreadyExists")
+	})
+	fake.handle(http.MethodDelete, "/instances/devpod-test", func(w http.ResponseWriter, r *http.Request) {
+		writeOperation(w, "op-delete")
+	})
+	// the command is interrupted right after the insert was rejected
+	fake.responded = func(request string) {
+		if request == "POST /instances" {
+			cancel()
+		}
+	}
+
+	cmd := &CreateCmd{Output: "plain", Progress: func(string, int) {}}
+	if err := cmd.Run(ctx, options, discardLogger()); err == nil || !strings.Contains(err.Error(), "instance devpod-test already exists") {
+		t.Fatalf("Run() error = %v, want the existing instance", err)
+	} else if ctx.Err() == nil {
+		t.Fatal("the create wasn't interrupted")
+	}
+
+	for _, request := range fake.requested() {
+		if strings.HasPrefix(request, http.MethodDelete) {
+			t.Errorf("the existing instance was deleted with %s", request)
+		}
+	}
+}
+
+func TestRunPreflightChecks(t *testing.T) {
+	errMachineType := errors.New("machine type not found")
+	errDiskImage := errors.New("disk image not found")
+	succeed := func() error { return nil }
+	tests := []struct {
+		name    string
+		checks  []func() error
+		wantErr error
+		want    string
+	}{
+		{name: "no checks"},
+		{name: "all checks pass", checks: []func() error{succeed, succeed}},
+		{
+			name:    "one check fails",
+			checks:  []func() error{succeed, func() error { return errMachineType }},
+			wantErr: errMachineType,
+			want:    "machine type not found",
+		},
+		{
+			name:   "several checks fail",
+			checks: []func() error{func() error { return errMachineType }, succeed, func() error { return errDiskImage }},
+			want:   "2 preflight checks failed:\nmachine type not found\n\ndisk image not found",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := runPreflightChecks(test.checks)
+			if test.want == "" && err != nil {
+				t.Errorf("runPreflightChecks() error = %v", err)
+			} else if test.want != "" && (err == nil || err.Error() != test.want) {
+				t.Errorf("runPreflightChecks() error = %v, want %q", err, test.want)
+			}
+			if test.wantErr != nil && !errors.Is(err, test.wantErr) {
+				t.Errorf("runPreflightChecks() error = %v, want the error of the check", err)
+			}
+		})
+	}
+}
+
+func TestRunPreflightChecksConcurrently(t *testing.T) {
+	started := make(chan struct{})
+	checks := []func() error{
+		func() error {
+			<-started
+			return nil
+		},
+		func() error {
+			close(started)
+			return nil
+		},
+	}
+
+	done := make(chan error)
+	go func() { done <- runPreflightChecks(checks) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("runPreflightChecks() error = %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("runPreflightChecks() ran the checks one after the other")
+	}
+}
+
+// fakeBinary puts an executable shell script of the given name on the PATH of the test
+func fakeBinary(t *testing.T, name, script string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"+script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestCheckGcloudCLI(t *testing.T) {
+	sleep, err := exec.LookPath("sleep")
+	if err != nil {
+		t.Skip("sleep not found")
+	}
+
+	tests := []struct {
+		name    string
+		script  string
+		wantErr string
+	}{
+		{name: "working gcloud", script: "echo 'Google Cloud SDK 450.0.0'\n"},
+		{name: "missing gcloud", wantErr: "install the Google Cloud CLI or set PUBLIC_IP_ENABLED=true"},
+		{name: "failing gcloud version", script: "echo 'broken python' >&2\nexit 1\n", wantErr: "gcloud version failed: exit status 1: broken python"},
+		{name: "hanging gcloud version", script: "exec " + sleep + " 10\n", wantErr: "gcloud version didn't finish within 100ms"},
+	}
+
+	original := gcloudVersionTimeout
+	gcloudVersionTimeout = 100 * time.Millisecond
+	t.Cleanup(func() { gcloudVersionTimeout = original })
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// only the fake gcloud, if any, is found on the PATH
+			t.Setenv("PATH", t.TempDir())
+			if test.script != "" {
+				fakeBinary(t, "gcloud", test.script)
+			}
+
+			err := checkGcloudCLI(context.Background())
+			if test.wantErr == "" && err != nil {
+				t.Errorf("checkGcloudCLI() error = %v", err)
+			} else if test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)) {
+				t.Errorf("checkGcloudCLI() error = %v, want %q", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestCreateProgress(t *testing.T) {
+	tests := []struct {
+		name     string
+		publicIP bool
+		want     []string
+	}{
+		{
+			name:     "public ip",
+			publicIP: true,
+			want:     []string{PhasePreflight, PhaseInserting, PhaseWaitingForRunning, PhaseWaitingForStartup, PhaseDone},
+		},
+		{
+			name: "iap",
+			want: []string{PhasePreflight, PhaseInserting, PhaseConfiguringSSH, PhaseWaitingForRunning, PhaseWaitingForStartup, PhaseDone},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake, options := newFakeCreate(t)
+			fake.handle(http.MethodPost, "/instances", func(w http.ResponseWriter, r *http.Request) {
+				writeOperation(w, "op-insert")
+			})
+			options.PublicIP = test.publicIP
+			options.Subnetwork = "europe-west1/default"
+			options.SkipNATCheck = true
+			options.SkipUserCreation = true
+			options.ReadyCheckAttempts = 1
+			options.StatusPollInterval = time.Second
+			fakeSleeps(t)
+			fakeSSH(t, 0, "")
+			fakeBinary(t, "gcloud", "exit 0\n")
+
+			var phases []string
+			percent := -1
+			cmd := &CreateCmd{Output: "plain", Progress: func(phase string, p int) {
+				if p <= percent {
+					t.Errorf("phase %s reported %d%% after %d%%", phase, p, percent)
+				}
+				percent = p
+				phases = append(phases, phase)
+			}}
+			if err := cmd.Run(context.Background(), options, discardLogger()); err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+
+			if !reflect.DeepEqual(phases, test.want) {
+				t.Errorf("phases = %q, want %q", phases, test.want)
+			}
+			if percent != 100 {
+				t.Errorf("create finished at %d%%, want 100%%", percent)
+			}
+		})
+	}
+}
+
+func TestActiveAccount(t *testing.T) {
+	sleep, err := exec.LookPath("sleep")
+	if err != nil {
+		t.Skip("sleep not found")
+	}
+
+	tests := []struct {
+		name   string
+		script string
+		want   string
+	}{
+		{name: "active account", script: "echo 'jane.doe@example.com'\n", want: "jane.doe@example.com"},
+		{name: "missing gcloud"},
+		{name: "failing gcloud", script: "exit 1\n"},
+		{name: "hanging gcloud", script: "exec " + sleep + " 10\n"},
+	}
+
+	original := activeAccountTimeout
+	activeAccountTimeout = 100 * time.Millisecond
+	t.Cleanup(func() { activeAccountTimeout = original })
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("PATH", t.TempDir())
+			if test.script != "" {
+				fakeBinary(t, "gcloud", test.script)
+			}
+
+			if got := activeAccount(context.Background()); got != test.want {
+				t.Errorf("activeAccount() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestBuildLabels(t *testing.T) {
+	tests := []struct {
+		name        string
+		workspaceID string
+		account     string
+		want        map[string]string
+	}{
+		{name: "no workspace and account", want: map[string]string{}},
+		{
+			name:        "workspace and account",
+			workspaceID: "my-app",
+			account:     "Jane.Doe@example.com",
+			want:        map[string]string{gcloud.WorkspaceLabel: "my-app", "devpod-user": "jane-doe-example-com"},
+		},
+		{
+			name:        "long workspace",
+			workspaceID: strings.Repeat("a", 62) + ".b",
+			want:        map[string]string{gcloud.WorkspaceLabel: strings.Repeat("a", 62)},
+		},
+		{name: "account only", account: "ci@project.iam.gserviceaccount.com", want: map[string]string{"devpod-user": "ci-project-iam-gserviceaccount-com"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := buildLabels(test.workspaceID, test.account); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("buildLabels() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseLabels(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    map[string]string
+		wantErr string
+	}{
+		{name: "empty", want: map[string]string{}},
+		{name: "labels", value: "team=platform, cost-center=cc_42", want: map[string]string{"team": "platform", "cost-center": "cc_42"}},
+		{name: "empty value", value: "backup=,team=platform", want: map[string]string{"backup": "", "team": "platform"}},
+		{name: "key only", value: "backup", want: map[string]string{"backup": ""}},
+		{name: "trailing comma", value: "team=platform,", want: map[string]string{"team": "platform"}},
+		{name: "uppercase key", value: "Team=platform", wantErr: `invalid label key "Team"`},
+		{name: "key starting with a digit", value: "1team=platform", wantErr: `invalid label key "1team"`},
+		{name: "invalid value", value: "team=Platform Team", wantErr: `invalid label value "Platform Team"`},
+		{name: "value too long", value: "team=" + strings.Repeat("a", 64), wantErr: "invalid label value"},
+		{name: "duplicate key", value: "team=a,team=b", wantErr: `duplicate label key "team"`},
+		{name: "too many labels", value: manyLabels(65), wantErr: "at most 64 labels are supported, got 65"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			labels, err := parseLabels(test.value)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Errorf("parseLabels() error = %v, want %q", err, test.wantErr)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("parseLabels() error = %v", err)
+			}
+
+			if !reflect.DeepEqual(labels, test.want) {
+				t.Errorf("parseLabels() = %v, want %v", labels, test.want)
+			}
+		})
+	}
+}
+
+// manyLabels returns count distinct labels in the format of DISK_LABELS
+func manyLabels(count int) string {
+	labels := []string{}
+	for i := 0; i < count; i++ {
+		labels = append(labels, fmt.Sprintf("label%d=value", i))
+	}
+
+	return strings.Join(labels, ",")
+}
+
+func TestBuildInstanceDiskLabels(t *testing.T) {
+	_, options := newFakeCreate(t)
+	options.DiskLabels = "team=platform,backup=daily"
+
+	instance, err := buildInstance(options)
+	if err != nil {
+		t.Fatalf("buildInstance() error = %v", err)
+	}
+
+	want := map[string]string{"team": "platform", "backup": "daily"}
+	if got := instance.Disks[0].GetInitializeParams().GetLabels(); !reflect.DeepEqual(got, want) {
+		t.Errorf("boot disk labels = %v, want %v", got, want)
+	}
+	if _, ok := instance.Labels["team"]; ok {
+		t.Errorf("instance labels = %v, want the DISK_LABELS only on the disk", instance.Labels)
+	}
+
+	options.DiskLabels = "Team=platform"
+	if _, err := buildInstance(options); err == nil || !strings.Contains(err.Error(), "parse disk labels") {
+		t.Errorf("buildInstance() error = %v, want the invalid DISK_LABELS", err)
+	}
+}
+
+func TestCheckQuota(t *testing.T) {
+	tests := []struct {
+		name        string
+		machineType string
+		quotas      []map[string]interface{}
+		wantErr     string
+	}{
+		{
+			name:        "enough quota",
+			machineType: "n2-standard-4",
+			quotas: []map[string]interface{}{
+				{"metric": "CPUS", "limit": 24, "usage": 20},
+				{"metric": "N2_CPUS", "limit": 8, "usage": 4},
+			},
+		},
+		{
+			name:        "not enough cpus",
+			machineType: "n2-standard-4",
+			quotas:      []map[string]interface{}{{"metric": "CPUS", "limit": 24, "usage": 22}},
+			wantErr:     "not enough CPUS quota in region europe-west1: machine type n2-standard-4 requires 4, but only 2 of 24 are available",
+		},
+		{
+			name:        "not enough cpus of the family",
+			machineType: "n2-standard-4",
+			quotas: []map[string]interface{}{
+				{"metric": "CPUS", "limit": 24},
+				{"metric": "N2_CPUS", "limit": 2},
+			},
+			wantErr: "not enough N2_CPUS quota",
+		},
+		{
+			name:        "not enough gpus",
+			machineType: "g2-standard-4",
+			quotas: []map[string]interface{}{
+				{"metric": "CPUS", "limit": 24},
+				{"metric": "NVIDIA_L4_GPUS", "limit": 1, "usage": 1},
+			},
+			wantErr: "not enough NVIDIA_L4_GPUS quota",
+		},
+		{name: "metrics without quota", machineType: "g2-standard-4", quotas: []map[string]interface{}{}},
+		{name: "unknown machine type", machineType: "n2-missing-4", wantErr: "machine type n2-missing-4 not available in zone europe-west1-b"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake, options := newFakeCompute(t)
+			fake.handle(http.MethodGet, "/machineTypes/n2-standard-4", func(w http.ResponseWriter, r *http.Request) {
+				writeJSON(w, map[string]interface{}{"name": "n2-standard-4", "guestCpus": 4})
+			})
+			fake.handle(http.MethodGet, "/machineTypes/g2-standard-4", func(w http.ResponseWriter, r *http.Request) {
+				writeJSON(w, map[string]interface{}{
+					"name":         "g2-standard-4",
+					"guestCpus":    4,
+					"accelerators": []map[string]interface{}{{"guestAcceleratorType": "nvidia-l4", "guestAcceleratorCount": 1}},
+				})
+			})
+			fake.handleProject(http.MethodGet, "/regions/europe-west1", func(w http.ResponseWriter, r *http.Request) {
+				writeJSON(w, map[string]interface{}{"name": "europe-west1", "quotas": test.quotas})
+			})
+			options.MachineType = test.machineType
+
+			client, err := sharedClient(context.Background(), options)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = checkQuota(context.Background(), client, options)
+			if test.wantErr == "" && err != nil {
+				t.Errorf("checkQuota() error = %v", err)
+			} else if test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)) {
+				t.Errorf("checkQuota() error = %v, want %q", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestGPUQuotaMetric(t *testing.T) {
+	tests := map[string]string{
+		"nvidia-tesla-t4":       "NVIDIA_T4_GPUS",
+		"nvidia-tesla-a100":     "NVIDIA_A100_GPUS",
+		"nvidia-l4":             "NVIDIA_L4_GPUS",
+		"nvidia-h100-80gb":      "NVIDIA_H100_80GB_GPUS",
+		"nvidia-a100-80gb":      "NVIDIA_A100_80GB_GPUS",
+		"nvidia-tesla-p100":     "NVIDIA_P100_GPUS",
+		"nvidia-h100-mega-80gb": "NVIDIA_H100_MEGA_80GB_GPUS",
+	}
+
+	for acceleratorType, want := range tests {
+		if got := gpuQuotaMetric(acceleratorType); got != want {
+			t.Errorf("gpuQuotaMetric(%q) = %q, want %q", acceleratorType, got, want)
+		}
+	}
+}
+
+func TestParseAliasIPRanges(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    []string
+		wantErr string
+	}{
+		{name: "empty", want: []string{}},
+		{name: "netmask of the primary range", value: "/28", want: []string{":/28"}},
+		{name: "named ranges", value: "pods:/28, services:10.1.0.0/24", want: []string{"pods:/28", "services:10.1.0.0/24"}},
+		{name: "ip", value: "10.0.1.5", want: []string{":10.0.1.5"}},
+		{name: "trailing comma", value: "pods:/28,", want: []string{"pods:/28"}},
+		{name: "invalid range name", value: "Pods:/28", wantErr: `invalid subnetwork range name "Pods"`},
+		{name: "range name ending with a dash", value: "pods-:/28", wantErr: `invalid subnetwork range name "pods-"`},
+		{name: "netmask too long", value: "/33", wantErr: `invalid ip cidr range "/33"`},
+		{name: "invalid cidr", value: "pods:10.0.0.0/40", wantErr: `invalid ip cidr range "10.0.0.0/40"`},
+		{name: "invalid ip", value: "10.0.1", wantErr: `invalid ip cidr range "10.0.1"`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			aliasIPRanges, err := parseAliasIPRanges(test.value)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Errorf("parseAliasIPRanges() error = %v, want %q", err, test.wantErr)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("parseAliasIPRanges() error = %v", err)
+			}
+
+			got := []string{}
+			for _, aliasIPRange := range aliasIPRanges {
+				got = append(got, aliasIPRange.GetSubnetworkRangeName()+":"+aliasIPRange.GetIpCidrRange())
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("parseAliasIPRanges() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestBuildInstanceAliasIPRanges(t *testing.T) {
+	_, options := newFakeCreate(t)
+	options.AliasIPRanges = "pods:/28"
+
+	instance, err := buildInstance(options)
+	if err != nil {
+		t.Fatalf("buildInstance() error = %v", err)
+	}
+	if ranges := instance.NetworkInterfaces[0].AliasIpRanges; len(ranges) != 1 || ranges[0].GetSubnetworkRangeName() != "pods" || ranges[0].GetIpCidrRange() != "/28" {
+		t.Errorf("alias ip ranges = %v, want pods:/28", ranges)
+	}
+
+	options.AliasIPRanges = "pods:/40"
+	if _, err := buildInstance(options); err == nil || !strings.Contains(err.Error(), "parse alias ip ranges") {
+		t.Errorf("buildInstance() error = %v, want the invalid ALIAS_IP_RANGES", err)
+	}
+}
+
+func TestGetAccessConfig(t *testing.T) {
+	if accessConfigs := getAccessConfig(&options.Options{NetworkTier: "PREMIUM"}); accessConfigs != nil {
+		t.Errorf("getAccessConfig() = %v without a public ip, want none", accessConfigs)
+	}
+
+	accessConfigs := getAccessConfig(&options.Options{PublicIP: true, NetworkTier: "PREMIUM"})
+	if len(accessConfigs) != 1 || accessConfigs[0].GetNetworkTier() != "PREMIUM" || accessConfigs[0].GetName() != "External NAT" {
+		t.Errorf("getAccessConfig() = %v, want an External NAT of the PREMIUM tier", accessConfigs)
+	}
+}
+
+func TestReadinessCommand(t *testing.T) {
+	tests := []struct {
+		name              string
+		checkEgress       bool
+		egressCheckURL    string
+		readyCheckCommand string
+		want              string
+	}{
+		{name: "default", want: "echo 'ready'"},
+		{name: "egress", checkEgress: true, egressCheckURL: "https://github.com", want: "curl -sfI --max-time 10 'https://github.com' > /dev/null && echo 'ready'"},
+		{name: "egress url with a quote", checkEgress: true, egressCheckURL: "https://example.com/it's", want: `curl -sfI --max-time 10 'https://example.com/it'\''s' > /dev/null && echo 'ready'`},
+		{name: "ready check command", readyCheckCommand: "test -f /a || test -f /b", want: "(test -f /a || test -f /b)"},
+		{name: "egress and ready check command", checkEgress: true, egressCheckURL: "https://github.com", readyCheckCommand: "test -f /a", want: "curl -sfI --max-time 10 'https://github.com' > /dev/null && (test -f /a)"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := readinessCommand(&options.Options{CheckEgress: test.checkEgress, EgressCheckURL: test.egressCheckURL, ReadyCheckCommand: test.readyCheckCommand})
+			if got != test.want {
+				t.Errorf("readinessCommand() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestBuildInstanceLocalSSDs(t *testing.T) {
+	_, options := newFakeCreate(t)
+	instance, err := buildInstance(options)
+	if err != nil {
+		t.Fatalf("buildInstance() error = %v", err)
+	}
+	if len(instance.Disks) != 1 || instance.GetScheduling().GetOnHostMaintenance() != "MIGRATE" {
+		t.Errorf("buildInstance() disks = %d, maintenance policy = %s, want only the boot disk and a live migration", len(instance.Disks), instance.GetScheduling().GetOnHostMaintenance())
+	}
+
+	options.LocalSSDCount = 2
+	instance, err = buildInstance(options)
+	if err != nil {
+		t.Fatalf("buildInstance() error = %v", err)
+	}
+	if len(instance.Disks) != 3 {
+		t.Fatalf("buildInstance() disks = %d, want the boot disk and 2 local ssds", len(instance.Disks))
+	}
+	for _, disk := range instance.Disks[1:] {
+		if disk.GetType() != "SCRATCH" || disk.GetInterface() != "NVME" || !disk.GetAutoDelete() ||
+			disk.GetInitializeParams().GetDiskType() != "projects/"+options.Project+"/zones/europe-west1-b/diskTypes/local-ssd" {
+			t.Errorf("local ssd = %v, want an auto deleted NVME scratch disk of type local-ssd", disk)
+		}
+	}
+	if policy := instance.GetScheduling().GetOnHostMaintenance(); policy != "TERMINATE" {
+		t.Errorf("maintenance policy = %s with local ssds, want TERMINATE", policy)
+	}
+}
+
+func TestBuildReservationAffinity(t *testing.T) {
+	if reservationAffinity := buildReservationAffinity(&options.Options{}); reservationAffinity != nil {
+		t.Errorf("buildReservationAffinity() = %v without RESERVATION_AFFINITY, want none", reservationAffinity)
+	}
+
+	reservationAffinity := buildReservationAffinity(&options.Options{ReservationAffinity: "NO_RESERVATION"})
+	if reservationAffinity.GetConsumeReservationType() != "NO_RESERVATION" || reservationAffinity.Key != nil || len(reservationAffinity.Values) != 0 {
+		t.Errorf("buildReservationAffinity() = %v, want NO_RESERVATION", reservationAffinity)
+	}
+
+	reservationAffinity = buildReservationAffinity(&options.Options{ReservationAffinity: "SPECIFIC_RESERVATION", Reservation: "my-reservation"})
+	if reservationAffinity.GetConsumeReservationType() != "SPECIFIC_RESERVATION" || reservationAffinity.GetKey() != "compute.googleapis.com/reservation-name" ||
+		!reflect.DeepEqual(reservationAffinity.Values, []string{"my-reservation"}) {
+		t.Errorf("buildReservationAffinity() = %v, want the reservation my-reservation", reservationAffinity)
+	}
+}
+
+func TestBuildInstanceKeepBootDisk(t *testing.T) {
+	_, options := newFakeCreate(t)
+	for _, keepBootDisk := range []bool{false, true} {
+		options.KeepBootDisk = keepBootDisk
+		instance, err := buildInstance(options)
+		if err != nil {
+			t.Fatalf("buildInstance() error = %v", err)
+		}
+
+		if autoDelete := instance.Disks[0].GetAutoDelete(); autoDelete == keepBootDisk {
+			t.Errorf("boot disk auto delete = %v with KEEP_BOOT_DISK=%v", autoDelete, keepBootDisk)
+		}
+	}
+}
+
+func TestBuildAdvancedMachineFeaturesNestedVirtualization(t *testing.T) {
+	if features := buildAdvancedMachineFeatures(&options.Options{}); features != nil {
+		t.Errorf("buildAdvancedMachineFeatures() = %v without NESTED_VIRTUALIZATION, want the defaults", features)
+	}
+
+	features := buildAdvancedMachineFeatures(&options.Options{NestedVirtualization: true})
+	if !features.GetEnableNestedVirtualization() || features.ThreadsPerCore != nil || features.VisibleCoreCount != nil {
+		t.Errorf("buildAdvancedMachineFeatures() = %v, want only nested virtualization", features)
+	}
+}
+
+func TestNestedVirtualizationPattern(t *testing.T) {
+	for machineType, want := range map[string]bool{
+		"n1-standard-4":  true,
+		"n2-standard-8":  true,
+		"c3-highcpu-4":   true,
+		"a2-highgpu-1g":  true,
+		"e2-standard-2":  false,
+		"n2d-standard-4": false,
+		"t2a-standard-4": false,
+	} {
+		if got := nestedVirtualizationPattern.MatchString(machineType); got != want {
+			t.Errorf("nested virtualization supported on %s = %v, want %v", machineType, got, want)
+		}
+	}
+}
+
+func TestBuildDiskResourcePolicies(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  string
+		want    []string
+		wantErr string
+	}{
+		{name: "no policy"},
+		{name: "policy name", policy: " daily-snapshots ", want: []string{"projects/test-project/regions/europe-west1/resourcePolicies/daily-snapshots"}},
+		{name: "policy path", policy: "projects/other/regions/europe-west1/resourcePolicies/daily", want: []string{"projects/other/regions/europe-west1/resourcePolicies/daily"}},
+		{
+			name:   "policy url",
+			policy: "https://www.googleapis.com/compute/v1/projects/other/regions/europe-west1/resourcePolicies/daily",
+			want:   []string{"https://www.googleapis.com/compute/v1/projects/other/regions/europe-west1/resourcePolicies/daily"},
+		},
+		{name: "other region", policy: "projects/other/regions/us-central1/resourcePolicies/daily", wantErr: "is in region us-central1, but the disk is created in region europe-west1"},
+		{name: "invalid path", policy: "projects/other/resourcePolicies/daily", wantErr: "invalid DISK_RESOURCE_POLICY"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			policies, err := buildDiskResourcePolicies(&options.Options{Project: "test-project", Zone: "europe-west1-b", DiskResourcePolicy: test.policy})
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Errorf("buildDiskResourcePolicies() error = %v, want %q", err, test.wantErr)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("buildDiskResourcePolicies() error = %v", err)
+			}
+
+			if !reflect.DeepEqual(policies, test.want) {
+				t.Errorf("buildDiskResourcePolicies() = %q, want %q", policies, test.want)
+			}
+		})
+	}
+}
+
+func TestBuildInstanceDiskResourcePolicy(t *testing.T) {
+	_, options := newFakeCreate(t)
+	options.DiskResourcePolicy = "daily-snapshots"
+
+	instance, err := buildInstance(options)
+	if err != nil {
+		t.Fatalf("buildInstance() error = %v", err)
+	}
+	if want := []string{"projects/" + options.Project + "/regions/europe-west1/resourcePolicies/daily-snapshots"}; !reflect.DeepEqual(instance.Disks[0].GetInitializeParams().GetResourcePolicies(), want) {
+		t.Errorf("boot disk resource policies = %q, want %q", instance.Disks[0].GetInitializeParams().GetResourcePolicies(), want)
+	}
+
+	options.DiskResourcePolicy = "projects/" + options.Project + "/regions/us-central1/resourcePolicies/daily-snapshots"
+	if _, err := buildInstance(options); err == nil {
+		t.Error("buildInstance() succeeded with a policy of another region")
+	}
+}
+
+func TestConfigureSSHForIAPSettings(t *testing.T) {
+	_, options := newFakeCompute(t)
+	options.IAPVerbosity = "debug"
+	options.IAPConnectTimeout = 600
+	options.IAPSSHKeepalive = 15
+
+	if err := configureSSHForIAP(options, false); err != nil {
+		t.Fatalf("configureSSHForIAP() error = %v", err)
+	}
+
+	sshConfig, err := os.ReadFile(options.SSHConfigFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"--project=" + options.Project + " --zone=europe-west1-b --verbosity=debug\n",
+		"    ConnectTimeout 600\n",
+		"    ServerAliveInterval 15\n",
+	} {
+		if !strings.Contains(string(sshConfig), want) {
+			t.Errorf("ssh config = %q, want %q", sshConfig, want)
+		}
+	}
+}
+
+func TestBuildInstanceStrictHostKeyChecking(t *testing.T) {
+	_, options := newFakeCreate(t)
+	for _, strictHostKeyChecking := range []bool{false, true} {
+		options.StrictHostKeyChecking = strictHostKeyChecking
+		instance, err := buildInstance(options)
+		if err != nil {
+			t.Fatalf("buildInstance() error = %v", err)
+		}
+
+		guestAttributes := false
+		for _, item := range instance.GetMetadata().GetItems() {
+			if item.GetKey() == "enable-guest-attributes" && item.GetValue() == "TRUE" {
+				guestAttributes = true
+			}
+		}
+		if guestAttributes != strictHostKeyChecking {
+			t.Errorf("guest attributes enabled = %v with STRICT_HOST_KEY_CHECKING=%v", guestAttributes, strictHostKeyChecking)
+		}
+	}
+}
+
+func TestSSHPort(t *testing.T) {
+	_, options := newFakeCompute(t)
+	options.SSHPort = 2222
+	options.IAPSourceRanges = []string{"35.235.240.0/20"}
+
+	if err := configureSSHForIAP(options, false); err != nil {
+		t.Fatalf("configureSSHForIAP() error = %v", err)
+	}
+	sshConfig, err := os.ReadFile(options.SSHConfigFile())
+	if err != nil {
+		t.Fatal(err)
+	} else if !strings.Contains(string(sshConfig), "    Port 2222\n") {
+		t.Errorf("ssh config = %q, want the port 2222", sshConfig)
+	}
+
+	// the firewall rule allows the port of sshd
+	calls := filepath.Join(t.TempDir(), "calls")
+	fakeBinary(t, "gcloud", `echo "$*" >> `+calls+"\n")
+	if err := ensureIAPFirewallRules(context.Background(), options, discardLogger()); err != nil {
+		t.Fatalf("ensureIAPFirewallRules() error = %v", err)
+	}
+	out, err := os.ReadFile(calls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"--filter=sourceRanges:35.235.240.0/20 AND allowed:tcp:2222", "--rules=tcp:2222"} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf("gcloud calls = %q, want %q", out, want)
+		}
+	}
+}
+
+func TestBuildInstanceDeviceNames(t *testing.T) {
+	_, options := newFakeCreate(t)
+	options.LocalSSDCount = 2
+
+	instance, err := buildInstance(options)
+	if err != nil {
+		t.Fatalf("buildInstance() error = %v", err)
+	}
+	deviceNames := []string{}
+	for _, disk := range instance.Disks {
+		deviceNames = append(deviceNames, disk.GetDeviceName())
+	}
+	if want := []string{"devpod-test", "local-ssd-0", "local-ssd-1"}; !reflect.DeepEqual(deviceNames, want) {
+		t.Errorf("device names = %q, want %q", deviceNames, want)
+	}
+
+	options.BootDiskDeviceName = "workspace"
+	instance, err = buildInstance(options)
+	if err != nil {
+		t.Fatalf("buildInstance() error = %v", err)
+	}
+	if deviceName := instance.Disks[0].GetDeviceName(); deviceName != "workspace" {
+		t.Errorf("boot disk device name = %q, want the BOOT_DISK_DEVICE_NAME workspace", deviceName)
+	}
+}
+
+func TestPrintCreateSummary(t *testing.T) {
+	fake, options := newFakeCreate(t)
+	fake.handle(http.MethodGet, "/instances/devpod-test", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]interface{}{
+			"name":              "devpod-test",
+			"status":            "RUNNING",
+			"machineType":       "zones/europe-west1-b/machineTypes/e2-standard-2",
+			"networkInterfaces": []map[string]interface{}{{"networkIP": "10.0.0.2"}},
+		})
+	})
+	options.PublicIP = false
+
+	client, err := sharedClient(context.Background(), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := captureStdout(t, func() error { return printCreateSummary(context.Background(), client, options) })
+	if err != nil {
+		t.Fatalf("printCreateSummary() error = %v", err)
+	}
+
+	summary := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(out), &summary); err != nil {
+		t.Fatalf("summary %q isn't json: %v", out, err)
+	}
+	want := map[string]interface{}{
+		"name":           "devpod-test",
+		"status":         "Running",
+		"instanceStatus": "RUNNING",
+		"machineType":    "e2-standard-2",
+		"zone":           "europe-west1-b",
+		"internalIP":     "10.0.0.2",
+		"diskSizeGb":     float64(40),
+		"iap":            true,
+	}
+	if !reflect.DeepEqual(summary, want) {
+		t.Errorf("summary = %v, want %v", summary, want)
+	}
+}
+
+func TestCreateUnsupportedOutput(t *testing.T) {
+	fake, options := newFakeCreate(t)
+	err := (&CreateCmd{Output: "yaml"}).Run(context.Background(), options, discardLogger())
+	if err == nil || err.Error() != "unsupported output format yaml, must be one of plain, json" {
+		t.Errorf("Run() error = %v, want the unsupported output format", err)
+	}
+	if requests := fake.requested(); len(requests) != 0 {
+		t.Errorf("requests = %q, want none for an unsupported output", requests)
+	}
+}
+
+func TestDiskImageProject(t *testing.T) {
+	fake, options := newFakeCreate(t)
+	options.DiskImage = "test-image"
+	options.DiskImageProject = options.Project
+
+	client, err := sharedClient(context.Background(), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := validateDiskImage(context.Background(), client, options); err != nil {
+		t.Errorf("validateDiskImage() error = %v", err)
+	}
+	if want := []string{"GET /global/images/test-image"}; !reflect.DeepEqual(fake.requested(), want) {
+		t.Errorf("requests = %q, want %q", fake.requested(), want)
+	}
+
+	instance, err := buildInstance(options)
+	if err != nil {
+		t.Fatalf("buildInstance() error = %v", err)
+	}
+	if image := instance.Disks[0].GetInitializeParams().GetSourceImage(); image != "projects/"+options.Project+"/global/images/test-image" {
+		t.Errorf("boot disk image = %q, want the image of the DISK_IMAGE_PROJECT", image)
+	}
+
+	// the error names the expanded image
+	options.DiskImage = "missing-image"
+	if err := validateDiskImage(context.Background(), client, options); err == nil || !strings.Contains(err.Error(), "disk image projects/"+options.Project+"/global/images/missing-image not found") {
+		t.Errorf("validateDiskImage() error = %v, want the expanded missing image", err)
+	}
+}
+
+func TestConfigureSSHForIAPBastionHost(t *testing.T) {
+	_, options := newFakeCompute(t)
+	options.BastionHost = "jump@bastion.example.com:2200"
+	options.IAPConnectTimeout = 300
+	options.IAPSSHKeepalive = 30
+
+	if err := configureSSHForIAP(options, false); err != nil {
+		t.Fatalf("configureSSHForIAP() error = %v", err)
+	}
+
+	sshConfig, err := os.ReadFile(options.SSHConfigFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"Host devpod-test\n",
+		"    HostName devpod-test.europe-west1-b.c." + options.Project + ".internal\n",
+		"    Port 22\n",
+		"    StrictHostKeyChecking no\n",
+		"    ProxyJump jump@bastion.example.com:2200\n",
+		"    ConnectTimeout 300\n",
+	} {
+		if !strings.Contains(string(sshConfig), want) {
+			t.Errorf("ssh config = %q, want %q", sshConfig, want)
+		}
+	}
+	if strings.Contains(string(sshConfig), "start-iap-tunnel") {
+		t.Errorf("ssh config = %q, want no IAP tunnel with a bastion host", sshConfig)
+	}
+}
+
+func TestCreateSkipNATCheck(t *testing.T) {
+	tests := []struct {
+		name         string
+		skipNATCheck bool
+		wantErr      string
+	}{
+		{name: "checks the Cloud NAT", wantErr: "Cloud NAT is not configured for subnet 'default' in region 'europe-west1'"},
+		{name: "skips the Cloud NAT check", skipNATCheck: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake, options := newFakeCreate(t)
+			fake.handle(http.MethodPost, "/instances", func(w http.ResponseWriter, r *http.Request) {
+				writeOperation(w, "op-insert")
+			})
+			fake.handleProject(http.MethodGet, "/regions/europe-west1/routers", func(w http.ResponseWriter, r *http.Request) {
+				writeJSON(w, map[string]interface{}{"items": []map[string]string{{"name": "router-without-nat"}}})
+			})
+			options.PublicIP = false
+			options.Subnetwork = "europe-west1/default"
+			options.SkipNATCheck = test.skipNATCheck
+			options.SkipUserCreation = true
+			options.ReadyCheckAttempts = 1
+			options.StatusPollInterval = time.Second
+			fakeSleeps(t)
+			fakeSSH(t, 0, "")
+			fakeBinary(t, "gcloud", "exit 0\n")
+
+			err := (&CreateCmd{Output: "plain", Progress: func(string, int) {}}).Run(context.Background(), options, discardLogger())
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Errorf("Run() error = %v, want %q", err, test.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+
+			checked := false
+			for _, request := range fake.requested() {
+				checked = checked || request == "GET /regions/europe-west1/routers"
+			}
+			if checked == test.skipNATCheck {
+				t.Errorf("Cloud NAT checked = %v with SKIP_NAT_CHECK=%v, requests: %q", checked, test.skipNATCheck, fake.requested())
+			}
+		})
+	}
+}
+
+func TestCreateManagedByLabel(t *testing.T) {
+	fake, options := newFakeCreate(t)
+	var inserted computepb.Instance
+	fake.handle(http.MethodPost, "/instances", func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&inserted); err != nil {
+			t.Error(err)
+		}
+		writeOperation(w, "op-insert")
+	})
+	options.SkipUserCreation = true
+	options.ReadyCheckAttempts = 1
+	options.StatusPollInterval = time.Second
+	fakeSleeps(t)
+	fakeSSH(t, 0, "")
+	fakeBinary(t, "gcloud", "echo jane@example.com\n")
+
+	if err := (&CreateCmd{Output: "plain", Progress: func(string, int) {}}).Run(context.Background(), options, discardLogger()); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	// the labels of the user are added to the label marking the instance as managed
+	want := map[string]string{gcloud.ManagedByLabel: gcloud.ManagedByValue, "devpod-user": "jane-example-com"}
+	if !reflect.DeepEqual(inserted.Labels, want) {
+		t.Errorf("labels = %v, want %v", inserted.Labels, want)
+	}
+}
+
+func TestNormalizeNetworkID(t *testing.T) {
+	tests := []struct {
+		name        string
+		network     string
+		hostProject string
+		want        string
+		wantErr     bool
+	}{
+		{name: "unset"},
+		{name: "name", network: "dev", want: "projects/my-project/global/networks/dev"},
+		{name: "name of the host project", network: "dev", hostProject: "host-project", want: "projects/host-project/global/networks/dev"},
+		{name: "project and name", network: "other/dev", want: "projects/other/global/networks/dev"},
+		{name: "resource path", network: "projects/other/global/networks/dev", want: "projects/other/global/networks/dev"},
+		{name: "self link", network: "https://www.googleapis.com/compute/v1/projects/other/global/networks/dev", want: "projects/other/global/networks/dev"},
+		{name: "surrounding spaces", network: " dev ", want: "projects/my-project/global/networks/dev"},
+		{name: "too many parts", network: "a/b/c", wantErr: true},
+		{name: "partial resource path", network: "projects/other/global/networks", wantErr: true},
+		{name: "empty part", network: "other//dev", wantErr: true},
+		{name: "subnetwork path", network: "projects/other/regions/europe-west1/subnetworks/dev", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := normalizeNetworkID(&options.Options{Project: "my-project", HostProject: test.hostProject, Network: test.network})
+			if test.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "invalid NETWORK") {
+					t.Fatalf("normalizeNetworkID() error = %v, want invalid NETWORK", err)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("normalizeNetworkID() error = %v", err)
+			}
+
+			if test.want == "" {
+				if got != nil {
+					t.Errorf("normalizeNetworkID() = %q, want nil", *got)
+				}
+			} else if got == nil || *got != test.want {
+				t.Errorf("normalizeNetworkID() = %v, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeSubnetworkID(t *testing.T) {
+	tests := []struct {
+		name        string
+		subnetwork  string
+		hostProject string
+		want        string
+		wantErr     bool
+	}{
+		{name: "unset"},
+		{name: "name", subnetwork: "dev", want: "projects/my-project/regions/europe-west1/subnetworks/dev"},
+		{name: "region and name", subnetwork: "us-central1/dev", want: "projects/my-project/regions/us-central1/subnetworks/dev"},
+		{name: "region and name of the host project", subnetwork: "us-central1/dev", hostProject: "host-project", want: "projects/host-project/regions/us-central1/subnetworks/dev"},
+		{name: "project, region and name", subnetwork: "other/us-central1/dev", want: "projects/other/regions/us-central1/subnetworks/dev"},
+		{name: "resource path", subnetwork: "projects/other/regions/us-central1/subnetworks/dev", want: "projects/other/regions/us-central1/subnetworks/dev"},
+		{name: "self link", subnetwork: "https://www.googleapis.com/compute/beta/projects/other/regions/us-central1/subnetworks/dev", want: "projects/other/regions/us-central1/subnetworks/dev"},
+		{name: "too many parts", subnetwork: "a/b/c/d", wantErr: true},
+		{name: "empty part", subnetwork: "us-central1//dev", wantErr: true},
+		{name: "trailing slash", subnetwork: "us-central1/dev/", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := normalizeSubnetworkID(&options.Options{Project: "my-project", HostProject: test.hostProject, Zone: "europe-west1-b", Subnetwork: test.subnetwork})
+			if test.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "invalid SUBNETWORK") {
+					t.Fatalf("normalizeSubnetworkID() error = %v, want invalid SUBNETWORK", err)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("normalizeSubnetworkID() error = %v", err)
+			}
+
+			if test.want == "" {
+				if got != nil {
+					t.Errorf("normalizeSubnetworkID() = %q, want nil", *got)
+				}
+			} else if got == nil || *got != test.want {
+				t.Errorf("normalizeSubnetworkID() = %v, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestCreateInstanceTemplate(t *testing.T) {
+	fake, options := newFakeCreate(t)
+	var inserted computepb.Instance
+	var template string
+	fake.handle(http.MethodPost, "/instances", func(w http.ResponseWriter, r *http.Request) {
+		template = r.URL.Query().Get("sourceInstanceTemplate")
+		// the field names of the rest api only match the proto json names
+		body, _ := io.ReadAll(r.Body)
+		if err := protojson.Unmarshal(body, &inserted); err != nil {
+			t.Error(err)
+		}
+		writeOperation(w, "op-insert")
+	})
+	options.InstanceTemplate = "dev-template"
+	options.SkipUserCreation = true
+	options.ReadyCheckAttempts = 1
+	options.StatusPollInterval = time.Second
+	fakeSleeps(t)
+	fakeSSH(t, 0, "")
+	fakeBinary(t, "gcloud", "exit 0\n")
+
+	if err := (&CreateCmd{Output: "plain", Progress: func(string, int) {}}).Run(context.Background(), options, discardLogger()); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	if want := "projects/" + options.Project + "/global/instanceTemplates/dev-template"; template != want {
+		t.Errorf("sourceInstanceTemplate = %q, want %q", template, want)
+	}
+
+	// only the name, the labels and the ssh key override the template
+	if inserted.GetName() != "devpod-test" || inserted.GetMachineType() != "" || len(inserted.GetDisks()) != 0 || len(inserted.GetNetworkInterfaces()) != 0 {
+		t.Errorf("inserted instance = %v, want only the name, labels and metadata", &inserted)
+	}
+	if items := inserted.GetMetadata().GetItems(); len(items) != 1 || items[0].GetKey() != "ssh-keys" || !strings.HasPrefix(items[0].GetValue(), "devpod:") {
+		t.Errorf("metadata = %v, want the ssh key", items)
+	}
+
+	// the machine type and image of the template aren't validated
+	for _, request := range fake.requested() {
+		if strings.Contains(request, "/machineTypes/") || strings.Contains(request, "/images/") {
+			t.Errorf("requested %s, want no validation of the template", request)
+		}
+	}
+}
+
+func TestInstanceTemplateID(t *testing.T) {
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{template: "dev-template", want: "projects/my-project/global/instanceTemplates/dev-template"},
+		{template: "projects/other/global/instanceTemplates/dev-template", want: "projects/other/global/instanceTemplates/dev-template"},
+		{template: "projects/my-project/regions/europe-west1/instanceTemplates/dev-template", want: "projects/my-project/regions/europe-west1/instanceTemplates/dev-template"},
+	}
+
+	for _, test := range tests {
+		if got := instanceTemplateID(&options.Options{Project: "my-project", InstanceTemplate: test.template}); got != test.want {
+			t.Errorf("instanceTemplateID(%q) = %q, want %q", test.template, got, test.want)
+		}
+	}
+}
+
+func TestBuildInstanceBootDiskName(t *testing.T) {
+	_, options := newFakeCreate(t)
+	for bootDiskName, wantSource := range map[string]string{
+		"workspace": "projects/" + options.Project + "/zones/europe-west1-b/disks/workspace",
+		"projects/other/zones/europe-west1-c/disks/workspace": "projects/other/zones/europe-west1-c/disks/workspace",
+	} {
+		options.BootDiskName = bootDiskName
+		options.DiskImage = ""
+		options.KeepBootDisk = false
+		instance, err := buildInstance(options)
+		if err != nil {
+			t.Fatalf("buildInstance() error = %v", err)
+		}
+
+		// the existing disk is attached instead of a disk created from the image and is never deleted
+		disk := instance.Disks[0]
+		if disk.GetSource() != wantSource || disk.InitializeParams != nil || !disk.GetBoot() || disk.GetAutoDelete() {
+			t.Errorf("boot disk = %v, want the existing disk %s", disk, wantSource)
+		}
+	}
+}
+
+func TestCreateBootDiskNameSkipsImage(t *testing.T) {
+	fake, options := newFakeCreate(t)
+	fake.handle(http.MethodPost, "/instances", func(w http.ResponseWriter, r *http.Request) {
+		writeOperation(w, "op-insert")
+	})
+	options.BootDiskName = "workspace"
+	options.DiskImage = ""
+	options.SkipUserCreation = true
+	options.ReadyCheckAttempts = 1
+	options.StatusPollInterval = time.Second
+	fakeSleeps(t)
+	fakeSSH(t, 0, "")
+	fakeBinary(t, "gcloud", "exit 0\n")
+
+	if err := (&CreateCmd{Output: "plain", Progress: func(string, int) {}}).Run(context.Background(), options, discardLogger()); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	for _, request := range fake.requested() {
+		if strings.Contains(request, "/images/") {
+			t.Errorf("requested %s, want no disk image validation", request)
+		}
+	}
+}
+
+func TestBuildInstanceHostname(t *testing.T) {
+	_, options := newFakeCreate(t)
+	for _, hostname := range []string{"", "devpod.example.internal"} {
+		options.Hostname = hostname
+		instance, err := buildInstance(options)
+		if err != nil {
+			t.Fatalf("buildInstance() error = %v", err)
+		}
+
+		// without INSTANCE_HOSTNAME the default hostname of the zone is used
+		if (instance.Hostname == nil) != (hostname == "") || instance.GetHostname() != hostname {
+			t.Errorf("hostname = %v, want %q", instance.Hostname, hostname)
+		}
+	}
+}
+
+func TestBuildInstanceScopes(t *testing.T) {
+	_, options := newFakeCreate(t)
+	options.ServiceAccount = "devpod@" + options.Project + ".iam.gserviceaccount.com"
+	for _, scopes := range [][]string{{"https://www.googleapis.com/auth/compute"}, {}} {
+		options.Scopes = scopes
+		instance, err := buildInstance(options)
+		if err != nil {
+			t.Fatalf("buildInstance() error = %v", err)
+		}
+
+		// SCOPES=none still attaches the service account
+		if len(instance.ServiceAccounts) != 1 || instance.ServiceAccounts[0].GetEmail() != options.ServiceAccount || !reflect.DeepEqual(instance.ServiceAccounts[0].Scopes, scopes) {
+			t.Errorf("service accounts = %v, want %s with the scopes %q", instance.ServiceAccounts, options.ServiceAccount, scopes)
+		}
+	}
+}
+
+func TestCreateInstanceGroup(t *testing.T) {
+	tests := []struct {
+		name         string
+		groupExists  bool
+		wantErr      string
+		wantAddition bool
+	}{
+		{name: "existing group", groupExists: true, wantAddition: true},
+		{name: "missing group", wantErr: "instance group devpod-group doesn't exist in zone europe-west1-b"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake, options := newFakeCreate(t)
+			fake.handle(http.MethodPost, "/instances", func(w http.ResponseWriter, r *http.Request) {
+				writeOperation(w, "op-insert")
+			})
+			if test.groupExists {
+				fake.handle(http.MethodGet, "/instanceGroups/devpod-group", func(w http.ResponseWriter, r *http.Request) {
+					writeJSON(w, map[string]string{"name": "devpod-group"})
+				})
+			}
+			var added []string
+			fake.handle(http.MethodPost, "/instanceGroups/devpod-group/addInstances", func(w http.ResponseWriter, r *http.Request) {
+				var request computepb.InstanceGroupsAddInstancesRequest
+				if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+					t.Error(err)
+				}
+				for _, instance := range request.Instances {
+					added = append(added, instance.GetInstance())
+				}
+				writeOperation(w, "op-add")
+			})
+			options.InstanceGroup = "devpod-group"
+			options.SkipUserCreation = true
+			options.ReadyCheckAttempts = 1
+			options.StatusPollInterval = time.Second
+			fakeSleeps(t)
+			fakeSSH(t, 0, "")
+			fakeBinary(t, "gcloud", "exit 0\n")
+
+			err := (&CreateCmd{Output: "plain", Progress: func(string, int) {}}).Run(context.Background(), options, discardLogger())
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("Run() error = %v, want %q", err, test.wantErr)
+				}
+				for _, request := range fake.requested() {
+					if request == "POST /instances" {
+						t.Errorf("instance inserted although the instance group doesn't exist")
+					}
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+
+			if want := []string{"projects/" + options.Project + "/zones/europe-west1-b/instances/devpod-test"}; !reflect.DeepEqual(added, want) {
+				t.Errorf("added instances = %q, want %q", added, want)
+			}
+		})
+	}
+}
+
+func TestBlockProjectSSHKeys(t *testing.T) {
+	_, options := newFakeCreate(t)
+	for _, block := range []bool{false, true} {
+		options.BlockProjectSSHKeys = block
+		instance, err := buildInstance(options)
+		if err != nil {
+			t.Fatalf("buildInstance() error = %v", err)
+		}
+		templateInstance, err := buildTemplateInstance(options)
+		if err != nil {
+			t.Fatalf("buildTemplateInstance() error = %v", err)
+		}
+
+		for _, metadata := range []*computepb.Metadata{instance.GetMetadata(), templateInstance.GetMetadata()} {
+			blocked := false
+			for _, item := range metadata.GetItems() {
+				if item.GetKey() == "block-project-ssh-keys" {
+					blocked = item.GetValue() == "TRUE"
+				}
+			}
+			if blocked != block {
+				t.Errorf("block-project-ssh-keys set = %v with BLOCK_PROJECT_SSH_KEYS=%v", blocked, block)
+			}
+		}
+	}
+}
+
+func TestBuildInstanceDiskInterface(t *testing.T) {
+	_, options := newFakeCreate(t)
+	for _, bootDiskName := range []string{"", "workspace"} {
+		for _, diskInterface := range []string{"", "NVME"} {
+			options.BootDiskName = bootDiskName
+			options.DiskInterface = diskInterface
+			instance, err := buildInstance(options)
+			if err != nil {
+				t.Fatalf("buildInstance() error = %v", err)
+			}
+
+			// without DISK_INTERFACE compute engine chooses the interface of the image
+			if disk := instance.Disks[0]; (disk.Interface == nil) != (diskInterface == "") || disk.GetInterface() != diskInterface {
+				t.Errorf("boot disk interface = %v, want %q with BOOT_DISK_NAME=%q", disk.Interface, diskInterface, bootDiskName)
+			}
+		}
+	}
+}
+
+func TestCheckCloudNATConfigurationSubnetwork(t *testing.T) {
+	for subnetwork, want := range map[string]bool{
+		"default":              true,
+		"europe-west1/default": true,
+		"projects/other/regions/europe-west1/subnetworks/default":                                       true,
+		"https://www.googleapis.com/compute/v1/projects/other/regions/europe-west1/subnetworks/default": true,
+		"default-2": false,
+	} {
+		fake, options := newFakeCompute(t)
+		options.Subnetwork = subnetwork
+		fake.handleProject(http.MethodGet, "/regions/europe-west1/routers", func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, map[string]interface{}{"items": []map[string]interface{}{{
+				"name": "nat-router",
+				"nats": []map[string]interface{}{{
+					"sourceSubnetworkIpRangesToNat": "LIST_OF_SUBNETWORKS",
+					"subnetworks":                   []map[string]string{{"name": "https://www.googleapis.com/compute/v1/projects/" + options.Project + "/regions/europe-west1/subnetworks/default"}},
+				}},
+			}}})
+		})
+
+		client, err := sharedClient(context.Background(), options)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = checkCloudNATConfiguration(context.Background(), client, options)
+		if want && err != nil {
+			t.Errorf("checkCloudNATConfiguration() error = %v with SUBNETWORK=%s", err, subnetwork)
+		} else if !want && (err == nil || !strings.Contains(err.Error(), "Cloud NAT is not configured for subnet 'default-2'")) {
+			t.Errorf("checkCloudNATConfiguration() error = %v with SUBNETWORK=%s, want no Cloud NAT", err, subnetwork)
+		}
+	}
+}
+
+func TestConfigureSSHForIAPSSHConfigPath(t *testing.T) {
+	_, options := newFakeCompute(t)
+	options.SSHConfigPath = filepath.Join(t.TempDir(), "ssh", "devpod")
+
+	// the directory of the SSH_CONFIG_PATH is created
+	if err := configureSSHForIAP(options, false); err != nil {
+		t.Fatalf("configureSSHForIAP() error = %v", err)
+	}
+
+	sshConfig, err := os.ReadFile(filepath.Join(options.SSHConfigPath, "devpod-test.conf"))
+	if err != nil {
+		t.Fatal(err)
+	} else if !strings.Contains(string(sshConfig), "Host devpod-test\n") {
+		t.Errorf("ssh config = %q, want the host of the instance", sshConfig)
+	}
+	if _, err := os.Stat(filepath.Join(options.MachineFolder, "ssh_config")); !os.IsNotExist(err) {
+		t.Errorf("ssh config written to the machine folder: %v", err)
+	}
+}
+
+func TestIAPSourceRanges(t *testing.T) {
+	_, options := newFakeCompute(t)
+	options.IAPSourceRanges = []string{"35.235.240.0/20", "10.10.0.0/16"}
+
+	calls := filepath.Join(t.TempDir(), "calls")
+	fakeBinary(t, "gcloud", `echo "$*" >> `+calls+`
+case "$*" in
+*" create "*) exit 1 ;;
+esac
+`)
+
+	// the rule has to allow all ranges, a failed create shows the command with them
+	err := ensureIAPFirewallRules(context.Background(), options, discardLogger())
+	if err == nil || !strings.Contains(err.Error(), "--source-ranges=35.235.240.0/20,10.10.0.0/16") || !strings.Contains(err.Error(), "The source ranges 35.235.240.0/20, 10.10.0.0/16 are") {
+		t.Errorf("ensureIAPFirewallRules() error = %v, want the manual command with the source ranges", err)
+	}
+	out, err := os.ReadFile(calls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"--filter=sourceRanges:35.235.240.0/20 AND sourceRanges:10.10.0.0/16 AND allowed:tcp:22", "--source-ranges=35.235.240.0/20,10.10.0.0/16"} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf("gcloud calls = %q, want %q", out, want)
+		}
+	}
+}
+
+func TestWaitForInstanceReadySkipUserCreation(t *testing.T) {
+	for _, skipUserCreation := range []bool{false, true} {
+		fake, options := newFakeCompute(t)
+		fake.handle(http.MethodGet, "/instances/devpod-test", func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, map[string]string{"name": "devpod-test", "status": "RUNNING"})
+		})
+		options.SkipUserCreation = skipUserCreation
+		options.ReadyCheckAttempts = 1
+		options.StatusPollInterval = time.Second
+		sleeps := fakeSleeps(t)
+		fakeSSH(t, 0, "")
+
+		client, err := sharedClient(context.Background(), options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := waitForInstanceReady(context.Background(), client, options, discardLogger(), func(string, int) {}); err != nil {
+			t.Fatalf("waitForInstanceReady() error = %v", err)
+		}
+
+		// the wait for the startup script to create the user is skipped for an existing user
+		want := []time.Duration{45 * time.Second}
+		if skipUserCreation {
+			want = []time.Duration{}
+		}
+		if !reflect.DeepEqual(*sleeps, want) {
+			t.Errorf("sleeps = %v with SKIP_USER_CREATION=%v, want %v", *sleeps, skipUserCreation, want)
+		}
+	}
+}
+
+func TestWaitForBootstrap(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		canceled   bool
+		wantErr    error
+		wantOutput string
+	}{
+		{name: "bootstrap done", status: http.StatusOK, wantOutput: "The bootstrap of the instance is done"},
+		{name: "guest attributes unavailable", status: http.StatusForbidden, wantOutput: "didn't report the finished bootstrap"},
+		{name: "canceled", status: http.StatusOK, canceled: true, wantErr: context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake, options := newFakeCompute(t)
+			fake.handle(http.MethodGet, "/instances/devpod-test/getGuestAttributes", func(w http.ResponseWriter, r *http.Request) {
+				if test.status != http.StatusOK {
+					writeError(w, test.status, http.StatusText(test.status))
+					return
+				}
+				writeJSON(w, map[string]interface{}{"queryValue": map[string]interface{}{"items": []map[string]string{
+					{"namespace": bootstrapAttributeNamespace, "key": bootstrapAttributeKey, "value": "done"},
+				}}})
+			})
+
+			client, err := sharedClient(context.Background(), options)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if test.canceled {
+				cancel()
+			}
+
+			// a missing report is only a warning, create continues without it
+			out := &bytes.Buffer{}
+			err = waitForBootstrap(ctx, client, options, log.NewStreamLogger(out, out, logrus.InfoLevel))
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("waitForBootstrap() error = %v, want %v", err, test.wantErr)
+			}
+			if !strings.Contains(out.String(), test.wantOutput) {
+				t.Errorf("output = %q, want %q", out.String(), test.wantOutput)
+			}
+		})
+	}
+}
+
+func TestBuildInstanceWaitForBootstrap(t *testing.T) {
+	_, options := newFakeCreate(t)
+	options.WaitForBootstrap = true
+	options.IdleTimeout = time.Hour
+	options.ServiceAccount = "devpod@" + options.Project + ".iam.gserviceaccount.com"
+	options.Scopes = []string{"https://www.googleapis.com/auth/cloud-platform"}
+
+	instance, err := buildInstance(options)
+	if err != nil {
+		t.Fatalf("buildInstance() error = %v", err)
+	}
+
+	metadata := map[string]string{}
+	for _, item := range instance.GetMetadata().GetItems() {
+		metadata[item.GetKey()] = item.GetValue()
+	}
+	if metadata["enable-guest-attributes"] != "TRUE" {
+		t.Errorf("enable-guest-attributes = %q, want TRUE for the bootstrap report", metadata["enable-guest-attributes"])
+	}
+
+	// the bootstrap is reported once the rest of the startup script is done
+	want := fmt.Sprintf(bootstrapDoneScript, bootstrapAttributeNamespace, bootstrapAttributeKey)
+	if script := metadata["startup-script"]; !strings.HasSuffix(script, want) || !strings.Contains(script, "idle") {
+		t.Errorf("startup-script = %q, want the idle stop followed by the bootstrap report", script)
+	}
+}
+
+func TestBuildInstanceDiskTypeIOPS(t *testing.T) {
+	_, options := newFakeCreate(t)
+	for _, test := range []struct {
+		diskType string
+		iops     int
+	}{
+		{diskType: "pd-balanced"},
+		{diskType: "pd-extreme", iops: 12000},
+	} {
+		options.DiskType = test.diskType
+		options.DiskIOPS = test.iops
+		instance, err := buildInstance(options)
+		if err != nil {
+			t.Fatalf("buildInstance() error = %v", err)
+		}
+
+		params := instance.Disks[0].GetInitializeParams()
+		if want := "projects/" + options.Project + "/zones/europe-west1-b/diskTypes/" + test.diskType; params.GetDiskType() != want {
+			t.Errorf("disk type = %q, want %q", params.GetDiskType(), want)
+		}
+		// without DISK_IOPS the default iops of the disk type are used
+		if (params.ProvisionedIops == nil) != (test.iops == 0) || params.GetProvisionedIops() != int64(test.iops) {
+			t.Errorf("provisioned iops = %v, want %d", params.ProvisionedIops, test.iops)
+		}
+	}
+}
+
+func TestIPInRange(t *testing.T) {
+	tests := []struct {
+		ip      string
+		cidr    string
+		wantErr string
+	}{
+		{ip: "10.128.0.2", cidr: "10.128.0.0/20"},
+		{ip: "10.128.15.253", cidr: "10.128.0.0/20"},
+		{ip: "10.128.0.1", cidr: "10.128.0.0/20", wantErr: "reserved by Google Cloud"},
+		{ip: "10.128.15.254", cidr: "10.128.0.0/20", wantErr: "reserved by Google Cloud"},
+		{ip: "10.129.0.2", cidr: "10.128.0.0/20", wantErr: "isn't within the range"},
+		{ip: "fd20::2", cidr: "10.128.0.0/20", wantErr: "isn't an ipv4 address"},
+	}
+
+	for _, test := range tests {
+		err := ipInRange(test.ip, test.cidr)
+		if test.wantErr == "" && err != nil {
+			t.Errorf("ipInRange(%s, %s) error = %v", test.ip, test.cidr, err)
+		} else if test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)) {
+			t.Errorf("ipInRange(%s, %s) error = %v, want %q", test.ip, test.cidr, err, test.wantErr)
+		}
+	}
+}
+
+func TestResolveInternalIP(t *testing.T) {
+	tests := []struct {
+		name       string
+		internalIP string
+		subnetwork string
+		want       string
+		wantErr    string
+	}{
+		{name: "address", internalIP: "10.128.0.10", subnetwork: "dev", want: "10.128.0.10"},
+		{name: "reserved address", internalIP: "devpod-ip", subnetwork: "dev", want: "10.128.0.20"},
+		{name: "address outside the range", internalIP: "10.200.0.10", subnetwork: "dev", wantErr: "isn't within the range 10.128.0.0/20"},
+		{name: "missing reserved address", internalIP: "missing-ip", subnetwork: "dev", wantErr: "gcloud compute addresses create missing-ip --region=europe-west1"},
+		{name: "external address", internalIP: "external-ip", subnetwork: "dev", wantErr: "is an external address"},
+		{name: "missing subnetwork", internalIP: "10.128.0.10", subnetwork: "missing", wantErr: "subnetwork projects/"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake, options := newFakeCompute(t)
+			fake.handleProject(http.MethodGet, "/regions/europe-west1/subnetworks/dev", func(w http.ResponseWriter, r *http.Request) {
+				writeJSON(w, map[string]string{"name": "dev", "ipCidrRange": "10.128.0.0/20"})
+			})
+			fake.handleProject(http.MethodGet, "/regions/europe-west1/addresses/devpod-ip", func(w http.ResponseWriter, r *http.Request) {
+				writeJSON(w, map[string]string{"name": "devpod-ip", "address": "10.128.0.20", "addressType": "INTERNAL"})
+			})
+			fake.handleProject(http.MethodGet, "/regions/europe-west1/addresses/external-ip", func(w http.ResponseWriter, r *http.Request) {
+				writeJSON(w, map[string]string{"name": "external-ip", "address": "34.76.0.1", "addressType": "EXTERNAL"})
+			})
+			options.InternalIP = test.internalIP
+			options.Subnetwork = test.subnetwork
+
+			client, err := sharedClient(context.Background(), options)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := resolveInternalIP(context.Background(), client, options)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("resolveInternalIP() error = %v, want %q", err, test.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("resolveInternalIP() error = %v", err)
+			} else if got != test.want {
+				t.Errorf("resolveInternalIP() = %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestBuildInstanceInternalIP(t *testing.T) {
+	_, options := newFakeCreate(t)
+	for internalIP, want := range map[string]string{"": "", "10.128.0.10": "10.128.0.10", "devpod-ip": ""} {
+		options.InternalIP = internalIP
+		instance, err := buildInstance(options)
+		if err != nil {
+			t.Fatalf("buildInstance() error = %v", err)
+		}
+
+		// the name of a reserved address is only set after it's resolved
+		if got := instance.NetworkInterfaces[0].GetNetworkIP(); got != want {
+			t.Errorf("network ip = %q with INTERNAL_IP=%q, want %q", got, internalIP, want)
+		}
+	}
+}
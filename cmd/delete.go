@@ -2,6 +2,10 @@ package cmd
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"path"
+	"time"
 
 	"github.com/badal-io/devpod-provider-gcloud/pkg/gcloud"
 	"github.com/badal-io/devpod-provider-gcloud/pkg/options"
@@ -18,13 +22,15 @@ func NewDeleteCmd() *cobra.Command {
 	deleteCmd := &cobra.Command{
 		Use:   "delete",
 		Short: "Delete an instance",
-		RunE: func(_ *cobra.Command, args []string) error {
+		RunE: func(cobraCmd *cobra.Command, args []string) error {
 			options, err := options.FromEnv(true, true)
 			if err != nil {
 				return err
 			}
 
-			return cmd.Run(context.Background(), options, log.Default)
+			return runWithTimeout(cobraCmd.Context(), options, func(ctx context.Context) error {
+				return cmd.Run(ctx, options, log.Default)
+			})
 		},
 	}
 
@@ -33,11 +39,108 @@ func NewDeleteCmd() *cobra.Command {
 
 // Run runs the command logic
 func (cmd *DeleteCmd) Run(ctx context.Context, options *options.Options, log log.Logger) error {
-	client, err := gcloud.NewClient(ctx, options.Project, options.Zone)
+	client, err := sharedClient(ctx, options)
 	if err != nil {
 		return err
 	}
-	defer client.Close()
 
-	return client.Delete(ctx, options.MachineID)
+	instance, err := client.Get(ctx, options.MachineID)
+	if err != nil {
+		return err
+	}
+
+	// deleted instances leave the group on their own, removing them first stops the load
+	// balancer from sending traffic to an instance that is shutting down
+	if options.InstanceGroup != "" && instance != nil {
+		err = client.RemoveInstanceFromGroup(ctx, options.InstanceGroup, options.MachineID)
+		if err != nil && !errors.Is(err, gcloud.ErrNotFound) {
+			log.Warnf("Remove instance from instance group %s: %v", options.InstanceGroup, err)
+		}
+	}
+
+	if options.ForceDelete {
+		err = client.ForceDelete(ctx, options.MachineID, forceDeleteAttempts, forceDeleteWait)
+	} else {
+		err = client.Delete(ctx, options.MachineID)
+	}
+	if errors.Is(err, gcloud.ErrNotFound) {
+		log.Infof("Instance %s doesn't exist anymore", options.MachineID)
+	} else if err != nil && options.ForceDelete {
+		return fmt.Errorf(`force delete of instance %s failed: %w
+
+Check the state of the instance with:
+
+  gcloud compute instances describe %s --project=%s --zone=%s
+
+and contact Google Cloud support if it stays stuck`, options.MachineID, err, options.MachineID, options.Project, options.Zone)
+	} else if err != nil {
+		return err
+	}
+
+	// disks without auto delete outlive the instance and keep incurring costs
+	if instance != nil {
+		for _, disk := range instance.Disks {
+			if !disk.GetAutoDelete() && disk.GetType() != "SCRATCH" {
+				log.Infof("Kept disk %s, reattach it or delete it with: gcloud compute disks delete %s --project=%s --zone=%s", path.Base(disk.GetSource()), path.Base(disk.GetSource()), options.Project, options.Zone)
+			}
+		}
+	}
+
+	// the address reserved by pause isn't released with the instance
+	err = releasePausedAddress(ctx, client, options, log)
+	if err != nil {
+		log.Warnf("Release address reserved by pause: %v", err)
+	}
+
+	if options.CleanupOnDelete {
+		err = cleanupIAPFirewallRule(ctx, client, options, log)
+		if err != nil {
+			log.Warnf("Cleanup of IAP firewall rule: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// the retries and the wait of a FORCE_DELETE, an instance stuck in a transition is
+// reported instead of waiting for the operation timeout
+const (
+	forceDeleteAttempts = 5
+	forceDeleteWait     = 5 * time.Minute
+)
+
+// cleanupIAPFirewallRule deletes the IAP firewall rule created by the provider once
+// no other instance with the tag the rule targets is left
+func cleanupIAPFirewallRule(ctx context.Context, client *gcloud.Client, options *options.Options, log log.Logger) error {
+	if options.Tag == "" {
+		// the rule applies to all instances of the network, so it might still be in use
+		return nil
+	}
+	if options.HostProject != "" {
+		// the rule belongs to the host project, which is shared with other service projects
+		return nil
+	}
+
+	firewall, err := client.GetFirewall(ctx, iapFirewallRuleName)
+	if err != nil {
+		return err
+	} else if firewall == nil || firewall.GetDescription() != iapFirewallRuleDescription {
+		// never delete rules that weren't created by the provider
+		return nil
+	}
+
+	instances, err := client.ListInstancesWithTag(ctx, options.Tag)
+	if err != nil {
+		return err
+	}
+
+	for _, instance := range instances {
+		if instance.GetName() != options.MachineID {
+			log.Debugf("Keeping IAP firewall rule, still used by instance %s", instance.GetName())
+			return nil
+		}
+	}
+
+	log.Infof("Deleting IAP firewall rule '%s' as no DevPod instances are left", iapFirewallRuleName)
+	return client.DeleteFirewall(ctx, iapFirewallRuleName)
 }
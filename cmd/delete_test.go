@@ -0,0 +1,260 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/badal-io/devpod-provider-gcloud/pkg/gcloud"
+	"github.com/loft-sh/devpod/pkg/log"
+	"github.com/sirupsen/logrus"
+)
+
+func TestCleanupIAPFirewallRule(t *testing.T) {
+	tests := []struct {
+		name        string
+		description string
+		instances   []string
+		wantDelete  bool
+	}{
+		{
+			name:        "last instance",
+			description: iapFirewallRuleDescription,
+			instances:   []string{"devpod-test"},
+			wantDelete:  true,
+		},
+		{
+			name:        "no instances left",
+			description: iapFirewallRuleDescription,
+			wantDelete:  true,
+		},
+		{
+			name:        "other instance with the tag",
+			description: iapFirewallRuleDescription,
+			instances:   []string{"devpod-test", "devpod-other"},
+		},
+		{
+			name:        "rule not created by the provider",
+			description: "Allow ssh through IAP",
+			instances:   []string{"devpod-test"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake, options := newFakeCompute(t)
+			options.Tag = "devpod"
+			fake.handleProject(http.MethodGet, "/global/firewalls/"+iapFirewallRuleName, func(w http.ResponseWriter, r *http.Request) {
+				writeJSON(w, map[string]string{"name": iapFirewallRuleName, "description": test.description})
+			})
+			fake.handleProject(http.MethodGet, "/aggregated/instances", func(w http.ResponseWriter, r *http.Request) {
+				if filter := r.URL.Query().Get("filter"); !strings.Contains(filter, gcloud.ManagedByLabel) {
+					t.Errorf("instances listed with filter %q, want a filter on the %s label", filter, gcloud.ManagedByLabel)
+				}
+
+				instances := []map[string]interface{}{}
+				for _, name := range test.instances {
+					instances = append(instances, map[string]interface{}{"name": name, "tags": map[string][]string{"items": {"devpod"}}})
+				}
+				writeJSON(w, map[string]interface{}{"items": map[string]interface{}{"zones/europe-west1-b": map[string]interface{}{"instances": instances}}})
+			})
+			deleted := false
+			fake.handleProject(http.MethodDelete, "/global/firewalls/"+iapFirewallRuleName, func(w http.ResponseWriter, r *http.Request) {
+				deleted = true
+				writeOperation(w, "delete-firewall")
+			})
+
+			client, err := sharedClient(context.Background(), options)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = cleanupIAPFirewallRule(context.Background(), client, options, discardLogger())
+			if err != nil {
+				t.Fatalf("cleanupIAPFirewallRule() error = %v", err)
+			} else if deleted != test.wantDelete {
+				t.Errorf("cleanupIAPFirewallRule() deleted the rule = %v, want %v", deleted, test.wantDelete)
+			}
+		})
+	}
+}
+
+func TestCleanupIAPFirewallRuleSkipped(t *testing.T) {
+	tests := []struct {
+		name        string
+		tag         string
+		hostProject string
+	}{
+		{name: "without tag"},
+		{name: "rule of the host project", tag: "devpod", hostProject: "host-project"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake, options := newFakeCompute(t)
+			options.Tag = test.tag
+			options.HostProject = test.hostProject
+
+			client, err := sharedClient(context.Background(), options)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = cleanupIAPFirewallRule(context.Background(), client, options, discardLogger())
+			if err != nil {
+				t.Fatalf("cleanupIAPFirewallRule() error = %v", err)
+			} else if requests := fake.requested(); len(requests) != 0 {
+				t.Errorf("requests = %q, want the rule to be left alone", requests)
+			}
+		})
+	}
+}
+
+func TestDeleteCleanupOnDelete(t *testing.T) {
+	tests := []struct {
+		name       string
+		ruleStatus int
+		wantDelete bool
+	}{
+		{name: "deletes the rule after the instance", ruleStatus: http.StatusOK, wantDelete: true},
+		{name: "missing rule", ruleStatus: http.StatusNotFound},
+		{name: "failing cleanup doesn't fail the delete", ruleStatus: http.StatusForbidden},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake, options := newFakeCompute(t)
+			options.Tag = "devpod"
+			options.CleanupOnDelete = true
+			fake.handle(http.MethodGet, "/instances/devpod-test", func(w http.ResponseWriter, r *http.Request) {
+				writeJSON(w, map[string]interface{}{"name": "devpod-test", "status": "RUNNING"})
+			})
+			fake.handle(http.MethodDelete, "/instances/devpod-test", func(w http.ResponseWriter, r *http.Request) {
+				writeOperation(w, "op-delete")
+			})
+			fake.handleProject(http.MethodGet, "/global/firewalls/"+iapFirewallRuleName, func(w http.ResponseWriter, r *http.Request) {
+				if test.ruleStatus != http.StatusOK {
+					writeError(w, test.ruleStatus, http.StatusText(test.ruleStatus))
+					return
+				}
+				writeJSON(w, map[string]string{"name": iapFirewallRuleName, "description": iapFirewallRuleDescription})
+			})
+			fake.handleProject(http.MethodGet, "/aggregated/instances", func(w http.ResponseWriter, r *http.Request) {
+				writeJSON(w, map[string]interface{}{"items": map[string]interface{}{}})
+			})
+			fake.handleProject(http.MethodDelete, "/global/firewalls/"+iapFirewallRuleName, func(w http.ResponseWriter, r *http.Request) {
+				writeJSON(w, map[string]string{"name": "op-delete-firewall", "status": "DONE"})
+			})
+
+			if err := (&DeleteCmd{}).Run(context.Background(), options, discardLogger()); err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+
+			instanceDeleted, ruleDeleted := -1, -1
+			for i, request := range fake.requested() {
+				switch request {
+				case "DELETE /instances/devpod-test":
+					instanceDeleted = i
+				case "DELETE /global/firewalls/" + iapFirewallRuleName:
+					ruleDeleted = i
+				}
+			}
+			if instanceDeleted < 0 {
+				t.Fatalf("the instance wasn't deleted, requests: %q", fake.requested())
+			}
+			if (ruleDeleted >= 0) != test.wantDelete {
+				t.Errorf("rule deleted = %v, want %v, requests: %q", ruleDeleted >= 0, test.wantDelete, fake.requested())
+			} else if test.wantDelete && ruleDeleted < instanceDeleted {
+				t.Errorf("the rule was deleted before the instance, requests: %q", fake.requested())
+			}
+		})
+	}
+}
+
+func TestDeleteReportsKeptDisks(t *testing.T) {
+	fake, options := newFakeCompute(t)
+	fake.handle(http.MethodGet, "/instances/devpod-test", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]interface{}{
+			"name":   "devpod-test",
+			"status": "RUNNING",
+			"disks": []map[string]interface{}{
+				{"source": "projects/" + options.Project + "/zones/europe-west1-b/disks/devpod-test", "boot": true, "autoDelete": false},
+				{"source": "projects/" + options.Project + "/zones/europe-west1-b/disks/devpod-test-data", "autoDelete": true},
+				{"type": "SCRATCH", "autoDelete": false},
+			},
+		})
+	})
+	fake.handle(http.MethodDelete, "/instances/devpod-test", func(w http.ResponseWriter, r *http.Request) {
+		writeOperation(w, "op-delete")
+	})
+
+	out := &bytes.Buffer{}
+	if err := (&DeleteCmd{}).Run(context.Background(), options, log.NewStreamLogger(out, out, logrus.InfoLevel)); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	want := "Kept disk devpod-test, reattach it or delete it with: gcloud compute disks delete devpod-test --project=" + options.Project + " --zone=europe-west1-b"
+	if got := out.String(); !strings.Contains(got, want) || strings.Count(got, "Kept disk") != 1 {
+		t.Errorf("output = %q, want only the boot disk reported as kept", got)
+	}
+}
+
+func TestDeleteForceDeleteFailure(t *testing.T) {
+	fake, options := newFakeCompute(t)
+	options.ForceDelete = true
+	fake.handle(http.MethodGet, "/instances/devpod-test", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]interface{}{"name": "devpod-test", "status": "STOPPING"})
+	})
+	fake.handle(http.MethodDelete, "/instances/devpod-test", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusBadRequest, "invalid")
+	})
+
+	// a failed force delete explains how to check the state of the stuck instance
+	err := (&DeleteCmd{}).Run(context.Background(), options, discardLogger())
+	want := "gcloud compute instances describe devpod-test --project=" + options.Project + " --zone=europe-west1-b"
+	if err == nil || !strings.HasPrefix(err.Error(), "force delete of instance devpod-test failed: ") || !strings.Contains(err.Error(), want) {
+		t.Fatalf("Run() error = %v, want the force delete failure with %q", err, want)
+	}
+}
+
+func TestDeleteInstanceGroup(t *testing.T) {
+	for _, removeStatus := range []int{http.StatusOK, http.StatusNotFound, http.StatusForbidden} {
+		fake, options := newFakeCompute(t)
+		options.InstanceGroup = "devpod-group"
+		fake.handle(http.MethodGet, "/instances/devpod-test", func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, map[string]interface{}{"name": "devpod-test", "status": "RUNNING"})
+		})
+		fake.handle(http.MethodPost, "/instanceGroups/devpod-group/removeInstances", func(w http.ResponseWriter, r *http.Request) {
+			if removeStatus != http.StatusOK {
+				writeError(w, removeStatus, "error")
+				return
+			}
+			writeOperation(w, "op-remove")
+		})
+		fake.handle(http.MethodDelete, "/instances/devpod-test", func(w http.ResponseWriter, r *http.Request) {
+			writeOperation(w, "op-delete")
+		})
+
+		// the instance leaves the group before it's deleted, a failure is only a warning
+		out := &bytes.Buffer{}
+		if err := (&DeleteCmd{}).Run(context.Background(), options, log.NewStreamLogger(out, out, logrus.InfoLevel)); err != nil {
+			t.Fatalf("Run() error = %v with remove status %d", err, removeStatus)
+		}
+
+		var sequence []string
+		for _, request := range fake.requested() {
+			if !strings.Contains(request, "/operations/") {
+				sequence = append(sequence, request)
+			}
+		}
+		want := []string{"GET /instances/devpod-test", "POST /instanceGroups/devpod-group/removeInstances", "DELETE /instances/devpod-test"}
+		if len(sequence) < len(want) || strings.Join(sequence[:len(want)], ",") != strings.Join(want, ",") {
+			t.Errorf("requests = %q, want %q", sequence, want)
+		}
+		if warned := strings.Contains(out.String(), "Remove instance from instance group devpod-group"); warned != (removeStatus == http.StatusForbidden) {
+			t.Errorf("output = %q with remove status %d", out.String(), removeStatus)
+		}
+	}
+}
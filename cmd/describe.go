@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/badal-io/devpod-provider-gcloud/pkg/options"
+	"github.com/loft-sh/devpod/pkg/log"
+	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// DescribeCmd holds the cmd flags
+type DescribeCmd struct {
+	Fields []string
+}
+
+// NewDescribeCmd defines a command
+func NewDescribeCmd() *cobra.Command {
+	cmd := &DescribeCmd{}
+	describeCmd := &cobra.Command{
+		Use:   "describe",
+		Short: "Print the full compute api representation of an instance",
+		RunE: func(cobraCmd *cobra.Command, args []string) error {
+			options, err := options.FromEnv(true, false)
+			if err != nil {
+				return err
+			}
+
+			return runWithTimeout(cobraCmd.Context(), options, func(ctx context.Context) error {
+				return cmd.Run(ctx, options, log.Default)
+			})
+		},
+	}
+
+	describeCmd.Flags().StringSliceVar(&cmd.Fields, "fields", nil, "Only print the given top level fields, e.g. networkInterfaces,disks")
+	return describeCmd
+}
+
+// Run runs the command logic
+func (cmd *DescribeCmd) Run(ctx context.Context, options *options.Options, log log.Logger) error {
+	client, err := sharedClient(ctx, options)
+	if err != nil {
+		return err
+	}
+
+	instance, err := client.Get(ctx, options.MachineID)
+	if err != nil {
+		return err
+	} else if instance == nil {
+		return fmt.Errorf("instance %s doesn't exist in project %s and zone %s", options.MachineID, options.Project, options.Zone)
+	}
+
+	out, err := protojson.MarshalOptions{Multiline: true, Indent: "  "}.Marshal(instance)
+	if err != nil {
+		return err
+	}
+
+	if len(cmd.Fields) > 0 {
+		out, err = selectFields(out, cmd.Fields)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = fmt.Fprintln(os.Stdout, string(out))
+	return err
+}
+
+// selectFields returns the given top level fields of the json object
+func selectFields(out []byte, fields []string) ([]byte, error) {
+	all := map[string]json.RawMessage{}
+	err := json.Unmarshal(out, &all)
+	if err != nil {
+		return nil, err
+	}
+
+	selected := map[string]json.RawMessage{}
+	for _, field := range fields {
+		field = strings.TrimSpace(field)
+		value, ok := all[field]
+		if !ok {
+			continue
+		}
+
+		selected[field] = value
+	}
+
+	return json.MarshalIndent(selected, "", "  ")
+}
@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSelectFields(t *testing.T) {
+	out, err := selectFields([]byte(`{"name": "devpod-test", "status": "RUNNING", "disks": [{"boot": true}]}`), []string{"name", " disks", "missing"})
+	if err != nil {
+		t.Fatalf("selectFields() error = %v", err)
+	}
+
+	selected := map[string]interface{}{}
+	if err := json.Unmarshal(out, &selected); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{"name": "devpod-test", "disks": []interface{}{map[string]interface{}{"boot": true}}}
+	if !reflect.DeepEqual(selected, want) {
+		t.Errorf("selectFields() = %v, want %v", selected, want)
+	}
+
+	if _, err := selectFields([]byte(`[]`), []string{"name"}); err == nil {
+		t.Error("selectFields() succeeded for a json array")
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	tests := []struct {
+		name     string
+		fields   []string
+		instance bool
+		want     []string
+		wantErr  string
+	}{
+		{name: "full instance", instance: true, want: []string{"name", "status", "networkInterfaces"}},
+		{name: "selected fields", instance: true, fields: []string{"networkInterfaces"}, want: []string{"networkInterfaces"}},
+		{name: "missing instance", wantErr: "instance devpod-test doesn't exist in project"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake, options := newFakeCompute(t)
+			if test.instance {
+				fake.handle(http.MethodGet, "/instances/devpod-test", func(w http.ResponseWriter, r *http.Request) {
+					writeJSON(w, map[string]interface{}{
+						"name":              "devpod-test",
+						"status":            "RUNNING",
+						"networkInterfaces": []map[string]interface{}{{"networkIP": "10.0.0.2"}},
+					})
+				})
+			}
+
+			out, err := captureStdout(t, func() error {
+				return (&DescribeCmd{Fields: test.fields}).Run(context.Background(), options, discardLogger())
+			})
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Errorf("Run() error = %v, want %q", err, test.wantErr)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+
+			described := map[string]json.RawMessage{}
+			if err := json.Unmarshal([]byte(out), &described); err != nil {
+				t.Fatalf("output %q isn't json: %v", out, err)
+			}
+			fields := []string{}
+			for field := range described {
+				fields = append(fields, field)
+			}
+			if len(fields) != len(test.want) {
+				t.Errorf("fields = %q, want %q", fields, test.want)
+			}
+			for _, field := range test.want {
+				if _, ok := described[field]; !ok {
+					t.Errorf("fields = %q, want %q", fields, field)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/badal-io/devpod-provider-gcloud/pkg/gcloud"
+	"github.com/badal-io/devpod-provider-gcloud/pkg/options"
+	"github.com/loft-sh/devpod/pkg/log"
+	"github.com/spf13/cobra"
+)
+
+// DoctorCmd holds the cmd flags
+type DoctorCmd struct{}
+
+// doctorCheck is a single diagnostic with the hint printed if it fails
+type doctorCheck struct {
+	name string
+	hint string
+	run  func(ctx context.Context) error
+}
+
+// NewDoctorCmd defines a command
+func NewDoctorCmd() *cobra.Command {
+	cmd := &DoctorCmd{}
+	doctorCmd := &cobra.Command{
+		Use:   "doctor",
+		Short: "Check the credentials, api access and network prerequisites of the provider",
+		RunE: func(cobraCmd *cobra.Command, args []string) error {
+			options, err := options.FromEnv(false, false)
+			if err != nil {
+				return err
+			}
+
+			return runWithTimeout(cobraCmd.Context(), options, func(ctx context.Context) error {
+				return cmd.Run(ctx, options, log.Default)
+			})
+		},
+	}
+
+	return doctorCmd
+}
+
+// Run runs the command logic
+func (cmd *DoctorCmd) Run(ctx context.Context, options *options.Options, log log.Logger) error {
+	failed := 0
+	checks := cmd.checks(options)
+	for _, check := range checks {
+		err := check.run(ctx)
+		if err != nil {
+			failed++
+			fmt.Fprintf(os.Stdout, "[FAIL] %s: %v\n       %s\n", check.name, err, check.hint)
+			continue
+		}
+
+		fmt.Fprintf(os.Stdout, "[PASS] %s\n", check.name)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d checks failed", failed, len(checks))
+	}
+
+	return nil
+}
+
+// checks returns the diagnostics that apply to the options, the network checks are only
+// run for private instances reached through IAP
+func (cmd *DoctorCmd) checks(options *options.Options) []doctorCheck {
+	var client *gcloud.Client
+	checks := []doctorCheck{
+		{
+			name: "Credentials resolve",
+			hint: "Run gcloud auth application-default login or set GCLOUD_JSON_AUTH",
+			run: func(ctx context.Context) error {
+				tokenSource, err := gcloud.DefaultTokenSource(ctx)
+				if err != nil {
+					return err
+				}
+
+				_, err = tokenSource.Token()
+				return err
+			},
+		},
+		{
+			name: "Compute API is reachable",
+			hint: "Enable the Compute Engine API for PROJECT and grant the account access to instances",
+			run: func(ctx context.Context) error {
+				var err error
+				client, err = sharedClient(ctx, options)
+				if err != nil {
+					return err
+				}
+
+				return client.Init(ctx)
+			},
+		},
+		{
+			name: fmt.Sprintf("Zone %s exists", options.Zone),
+			hint: "Set ZONE to an existing zone, e.g. us-central1-a",
+			run: func(ctx context.Context) error {
+				if client == nil {
+					return fmt.Errorf("compute api isn't reachable")
+				}
+
+				hasZone, err := client.HasZone(ctx, regionFromZone(options.Zone), options.Zone)
+				if err != nil {
+					return err
+				} else if !hasZone {
+					return fmt.Errorf("zone %s not found in project %s", options.Zone, options.Project)
+				}
+
+				return nil
+			},
+		},
+	}
+
+	if options.PublicIP || options.BastionHost != "" {
+		return checks
+	}
+
+	checks = append(checks, doctorCheck{
+		name: "gcloud CLI is installed",
+		hint: "Install the Google Cloud CLI, it opens the IAP tunnels for ssh",
+		run:  checkGcloudCLI,
+	})
+	if !options.SkipNATCheck {
+		checks = append(checks, doctorCheck{
+			name: "Cloud NAT is configured for the subnetwork",
+			hint: "Configure Cloud NAT for SUBNETWORK or set SKIP_NAT_CHECK=true if egress works otherwise",
+			run: func(ctx context.Context) error {
+				if client == nil {
+					return fmt.Errorf("compute api isn't reachable")
+				}
+
+				return checkCloudNATConfiguration(ctx, client, options)
+			},
+		})
+	}
+
+	return append(checks, doctorCheck{
+		name: "IAP firewall rule allows ssh",
+		hint: fmt.Sprintf("Allow tcp:%d from %s, the create adds the rule %s if it's missing", options.SSHPort, strings.Join(options.IAPSourceRanges, ","), iapFirewallRuleName),
+		run: func(ctx context.Context) error {
+			rules, err := findIAPFirewallRules(ctx, options)
+			if err != nil {
+				return err
+			} else if rules == "" {
+				return fmt.Errorf("no firewall rule found in project %s", options.NetworkProject())
+			}
+
+			return nil
+		},
+	})
+}
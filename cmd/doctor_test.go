@@ -0,0 +1,130 @@
+package cmd
+
+import (
+	"context"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/badal-io/devpod-provider-gcloud/pkg/options"
+)
+
+func TestDoctorChecks(t *testing.T) {
+	tests := []struct {
+		name    string
+		options options.Options
+		want    []string
+	}{
+		{
+			name:    "public ip",
+			options: options.Options{Zone: "europe-west1-b", PublicIP: true},
+			want:    []string{"Credentials resolve", "Compute API is reachable", "Zone europe-west1-b exists"},
+		},
+		{
+			name:    "bastion host",
+			options: options.Options{Zone: "europe-west1-b", BastionHost: "bastion.example.com"},
+			want:    []string{"Credentials resolve", "Compute API is reachable", "Zone europe-west1-b exists"},
+		},
+		{
+			name:    "iap",
+			options: options.Options{Zone: "europe-west1-b"},
+			want: []string{
+				"Credentials resolve", "Compute API is reachable", "Zone europe-west1-b exists",
+				"gcloud CLI is installed", "Cloud NAT is configured for the subnetwork", "IAP firewall rule allows ssh",
+			},
+		},
+		{
+			name:    "iap without nat check",
+			options: options.Options{Zone: "europe-west1-b", SkipNATCheck: true},
+			want: []string{
+				"Credentials resolve", "Compute API is reachable", "Zone europe-west1-b exists",
+				"gcloud CLI is installed", "IAP firewall rule allows ssh",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var names []string
+			for _, check := range (&DoctorCmd{}).checks(&tt.options) {
+				names = append(names, check.name)
+			}
+
+			if !reflect.DeepEqual(names, tt.want) {
+				t.Fatalf("checks = %q, want %q", names, tt.want)
+			}
+		})
+	}
+}
+
+func TestDoctorRun(t *testing.T) {
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", filepath.Join(t.TempDir(), "missing.json"))
+
+	tests := []struct {
+		name       string
+		zones      []string
+		wantErr    string
+		wantOutput []string
+	}{
+		{
+			name:    "zone exists",
+			zones:   []string{"europe-west1-b", "europe-west1-c"},
+			wantErr: "1 of 3 checks failed",
+			wantOutput: []string{
+				"[FAIL] Credentials resolve: ",
+				"       Run gcloud auth application-default login or set GCLOUD_JSON_AUTH",
+				"[PASS] Compute API is reachable",
+				"[PASS] Zone europe-west1-b exists",
+			},
+		},
+		{
+			name:    "zone missing",
+			zones:   []string{"europe-west1-c"},
+			wantErr: "2 of 3 checks failed",
+			wantOutput: []string{
+				"[PASS] Compute API is reachable",
+				"[FAIL] Zone europe-west1-b exists: zone europe-west1-b not found in project ",
+				"       Set ZONE to an existing zone, e.g. us-central1-a",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, options := newFakeCompute(t)
+			options.PublicIP = true
+			fake.handle(http.MethodGet, "/instances", func(w http.ResponseWriter, r *http.Request) {
+				writeJSON(w, map[string]interface{}{})
+			})
+			fake.handleProject(http.MethodGet, "/regions/europe-west1", func(w http.ResponseWriter, r *http.Request) {
+				var zones []string
+				for _, zone := range tt.zones {
+					zones = append(zones, "https://www.googleapis.com/compute/v1/projects/"+options.Project+"/zones/"+zone)
+				}
+				writeJSON(w, map[string]interface{}{"name": "europe-west1", "zones": zones})
+			})
+
+			output, err := captureStdout(t, func() error {
+				return (&DoctorCmd{}).Run(context.Background(), options, discardLogger())
+			})
+			if err == nil || err.Error() != tt.wantErr {
+				t.Fatalf("Run() error = %v, want %s", err, tt.wantErr)
+			}
+			for _, want := range tt.wantOutput {
+				if !strings.Contains(output, want) {
+					t.Errorf("output = %q, want %q", output, want)
+				}
+			}
+		})
+	}
+}
+
+func TestDoctorFirewallHint(t *testing.T) {
+	checks := (&DoctorCmd{}).checks(&options.Options{SSHPort: 2222, IAPSourceRanges: []string{"35.235.240.0/20", "10.10.0.0/16"}})
+	hint := checks[len(checks)-1].hint
+	if want := "Allow tcp:2222 from 35.235.240.0/20,10.10.0.0/16, "; !strings.HasPrefix(hint, want) {
+		t.Errorf("hint = %q, want %q", hint, want)
+	}
+}
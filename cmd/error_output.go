@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/badal-io/devpod-provider-gcloud/pkg/gcloud"
+	"github.com/pkg/errors"
+)
+
+// The codes of the json error output, they are stable so automation can branch on them
+const (
+	errorCodeInvalidOptions   = "INVALID_OPTIONS"
+	errorCodeNotFound         = "NOT_FOUND"
+	errorCodePermissionDenied = "PERMISSION_DENIED"
+	errorCodeQuotaExceeded    = "QUOTA_EXCEEDED"
+	errorCodeTimeout          = "TIMEOUT"
+	errorCodeCanceled         = "CANCELED"
+	errorCodeUnknown          = "UNKNOWN"
+)
+
+// errorOutput is printed instead of the summary if a command with --output json fails
+type errorOutput struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Hint    string `json:"hint,omitempty"`
+}
+
+// newErrorOutput classifies err by the sentinel errors of the gcloud client
+func newErrorOutput(err error, invalidOptions bool) *errorOutput {
+	output := &errorOutput{Message: err.Error()}
+	switch {
+	case invalidOptions:
+		output.Code = errorCodeInvalidOptions
+		output.Hint = "Fix the provider options with: devpod provider set-options gcloud"
+	case errors.Is(err, gcloud.ErrQuotaExceeded):
+		output.Code = errorCodeQuotaExceeded
+		output.Hint = "Request a quota increase, use a smaller MACHINE_TYPE or a different ZONE"
+	case errors.Is(err, gcloud.ErrPermissionDenied):
+		output.Code = errorCodePermissionDenied
+		output.Hint = "Grant the missing permissions to the provider credentials, CHECK_PERMISSIONS=true lists them before the create"
+	case errors.Is(err, gcloud.ErrNotFound):
+		output.Code = errorCodeNotFound
+		output.Hint = "Check that the PROJECT, ZONE and the referenced resources exist"
+	case errors.Is(err, context.DeadlineExceeded):
+		output.Code = errorCodeTimeout
+		output.Hint = "Increase OPERATION_TIMEOUT"
+	case errors.Is(err, context.Canceled):
+		output.Code = errorCodeCanceled
+	default:
+		output.Code = errorCodeUnknown
+	}
+
+	return output
+}
+
+// printErrorOutput prints err as json to stdout and returns it, so the command still fails
+func printErrorOutput(err error, invalidOptions bool) error {
+	out, marshalErr := json.MarshalIndent(newErrorOutput(err, invalidOptions), "", "  ")
+	if marshalErr != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprintln(os.Stdout, string(out))
+	return err
+}
@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/badal-io/devpod-provider-gcloud/pkg/gcloud"
+)
+
+func TestNewErrorOutput(t *testing.T) {
+	tests := []struct {
+		name           string
+		err            error
+		invalidOptions bool
+		wantCode       string
+		wantHint       bool
+	}{
+		{name: "invalid options", err: errors.New("couldn't find option PROJECT"), invalidOptions: true, wantCode: errorCodeInvalidOptions, wantHint: true},
+		{name: "quota exceeded", err: fmt.Errorf("create instance: %w", gcloud.ErrQuotaExceeded), wantCode: errorCodeQuotaExceeded, wantHint: true},
+		{name: "permission denied", err: fmt.Errorf("create instance: %w", gcloud.ErrPermissionDenied), wantCode: errorCodePermissionDenied, wantHint: true},
+		{name: "not found", err: fmt.Errorf("get image: %w", gcloud.ErrNotFound), wantCode: errorCodeNotFound, wantHint: true},
+		{name: "timeout", err: fmt.Errorf("wait: %w", context.DeadlineExceeded), wantCode: errorCodeTimeout, wantHint: true},
+		{name: "canceled", err: context.Canceled, wantCode: errorCodeCanceled},
+		{name: "other error", err: errors.New("dial tcp: connection refused"), wantCode: errorCodeUnknown},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			output := newErrorOutput(test.err, test.invalidOptions)
+			if output.Code != test.wantCode || output.Message != test.err.Error() || (output.Hint != "") != test.wantHint {
+				t.Errorf("newErrorOutput() = %+v, want the code %s with the message %q", output, test.wantCode, test.err)
+			}
+		})
+	}
+}
+
+func TestPrintErrorOutput(t *testing.T) {
+	err := fmt.Errorf("create instance: %w", gcloud.ErrQuotaExceeded)
+	out, got := captureStdout(t, func() error { return printErrorOutput(err, false) })
+
+	// the command still fails with the error after printing it
+	if got != err {
+		t.Errorf("printErrorOutput() = %v, want %v", got, err)
+	}
+
+	var output errorOutput
+	if err := json.Unmarshal([]byte(out), &output); err != nil {
+		t.Fatalf("output %q isn't json: %v", out, err)
+	}
+	if output.Code != errorCodeQuotaExceeded || output.Message != "create instance: "+gcloud.ErrQuotaExceeded.Error() {
+		t.Errorf("output = %+v, want the quota error", output)
+	}
+}
+
+func TestCreateJSONErrorOutput(t *testing.T) {
+	t.Setenv("MACHINE_ID", "")
+	t.Setenv("PROVIDER_CONFIG", "")
+
+	createCmd := NewCreateCmd()
+	createCmd.SetArgs([]string{"--output", "json"})
+	createCmd.SilenceUsage = true
+	createCmd.SilenceErrors = true
+	out, execErr := captureStdout(t, createCmd.Execute)
+	if execErr == nil {
+		t.Fatal("Execute() didn't fail without the options")
+	}
+
+	var output errorOutput
+	if err := json.Unmarshal([]byte(out), &output); err != nil {
+		t.Fatalf("output %q isn't json: %v", out, err)
+	}
+	if output.Code != errorCodeInvalidOptions || output.Message != execErr.Error() {
+		t.Errorf("output = %+v, want the invalid options error %q", output, execErr)
+	}
+}
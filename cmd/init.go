@@ -3,7 +3,6 @@ package cmd
 import (
 	"context"
 
-	"github.com/badal-io/devpod-provider-gcloud/pkg/gcloud"
 	"github.com/badal-io/devpod-provider-gcloud/pkg/options"
 	"github.com/loft-sh/devpod/pkg/log"
 	"github.com/spf13/cobra"
@@ -18,13 +17,15 @@ func NewInitCmd() *cobra.Command {
 	initCmd := &cobra.Command{
 		Use:   "init",
 		Short: "Init an instance",
-		RunE: func(_ *cobra.Command, args []string) error {
+		RunE: func(cobraCmd *cobra.Command, args []string) error {
 			options, err := options.FromEnv(false, false)
 			if err != nil {
 				return err
 			}
 
-			return cmd.Run(context.Background(), options, log.Default)
+			return runWithTimeout(cobraCmd.Context(), options, func(ctx context.Context) error {
+				return cmd.Run(ctx, options, log.Default)
+			})
 		},
 	}
 
@@ -33,11 +34,10 @@ func NewInitCmd() *cobra.Command {
 
 // Run runs the command logic
 func (cmd *InitCmd) Run(ctx context.Context, options *options.Options, log log.Logger) error {
-	client, err := gcloud.NewClient(ctx, options.Project, options.Zone)
+	client, err := sharedClient(ctx, options)
 	if err != nil {
 		return err
 	}
-	defer client.Close()
 
 	return client.Init(ctx)
 }
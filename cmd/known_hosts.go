@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/badal-io/devpod-provider-gcloud/pkg/gcloud"
+	"github.com/badal-io/devpod-provider-gcloud/pkg/options"
+	"github.com/loft-sh/devpod/pkg/log"
+)
+
+const knownHostsFile = "known_hosts"
+
+// writeKnownHosts waits for the instance to publish its ssh host keys and writes them
+// to the known_hosts file of the machine. It returns false if the keys couldn't be retrieved.
+func writeKnownHosts(ctx context.Context, client *gcloud.Client, options *options.Options, log log.Logger) bool {
+	log.Info("Waiting for the ssh host keys of the instance...")
+	for attempt := 0; attempt < 24; attempt++ {
+		hostKeys, err := client.GetHostKeys(ctx, options.MachineID)
+		if err != nil {
+			log.Warnf("Retrieve ssh host keys: %v, falling back to disabled host key checking", err)
+			return false
+		} else if hostKeys != nil {
+			err = os.WriteFile(filepath.Join(options.MachineFolder, knownHostsFile), []byte(buildKnownHosts(options.MachineID, hostKeys)), 0600)
+			if err != nil {
+				log.Warnf("Write known hosts: %v, falling back to disabled host key checking", err)
+				return false
+			}
+
+			return true
+		}
+
+		if err := sleepContext(ctx, 5*time.Second); err != nil {
+			return false
+		}
+	}
+
+	log.Warn("The instance didn't publish its ssh host keys, falling back to disabled host key checking")
+	return false
+}
+
+// buildKnownHosts returns a known_hosts entry for every host key of the given host
+func buildKnownHosts(host string, hostKeys map[string]string) string {
+	keyTypes := make([]string, 0, len(hostKeys))
+	for keyType := range hostKeys {
+		keyTypes = append(keyTypes, keyType)
+	}
+	sort.Strings(keyTypes)
+
+	lines := []string{}
+	for _, keyType := range keyTypes {
+		lines = append(lines, fmt.Sprintf("%s %s %s", host, keyType, strings.TrimSpace(hostKeys[keyType])))
+	}
+
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// hostKeyArgs returns the host key checking options of the system ssh that connects to the ip
+// of the instance, following hostKeyConfig. Checking falls back to disabled if no known_hosts
+// file was written. The entries are written for the machine id, the HostKeyAlias makes ssh
+// look up the key of the ip by it.
+func hostKeyArgs(options *options.Options) []string {
+	knownHosts := filepath.Join(options.MachineFolder, knownHostsFile)
+	if _, err := os.Stat(knownHosts); !options.StrictHostKeyChecking || err != nil {
+		return []string{"-o", "StrictHostKeyChecking=no", "-o", "UserKnownHostsFile=/dev/null"}
+	}
+
+	return []string{"-o", "StrictHostKeyChecking=yes", "-o", "UserKnownHostsFile=" + knownHosts, "-o", "HostKeyAlias=" + options.MachineID}
+}
+
+// hostKeyConfig returns the host key checking part of the ssh config
+func hostKeyConfig(options *options.Options, strictHostKeyChecking bool) string {
+	if !strictHostKeyChecking {
+		return "    StrictHostKeyChecking no\n    UserKnownHostsFile /dev/null\n"
+	}
+
+	return fmt.Sprintf("    StrictHostKeyChecking yes\n    UserKnownHostsFile %s\n", filepath.Join(options.MachineFolder, knownHostsFile))
+}
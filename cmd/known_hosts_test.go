@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/badal-io/devpod-provider-gcloud/pkg/options"
+)
+
+func TestBuildKnownHosts(t *testing.T) {
+	knownHosts := buildKnownHosts("devpod-test", map[string]string{
+		"ssh-rsa":     "AAAArsa\n",
+		"ssh-ed25519": " AAAAed25519",
+	})
+
+	if want := "devpod-test ssh-ed25519 AAAAed25519\ndevpod-test ssh-rsa AAAArsa\n"; knownHosts != want {
+		t.Errorf("buildKnownHosts() = %q, want %q", knownHosts, want)
+	}
+}
+
+func TestHostKeyConfig(t *testing.T) {
+	options := &options.Options{MachineFolder: "/machines/devpod-test"}
+
+	if config := hostKeyConfig(options, false); config != "    StrictHostKeyChecking no\n    UserKnownHostsFile /dev/null\n" {
+		t.Errorf("hostKeyConfig() = %q, want disabled host key checking", config)
+	}
+	if config := hostKeyConfig(options, true); config != "    StrictHostKeyChecking yes\n    UserKnownHostsFile /machines/devpod-test/known_hosts\n" {
+		t.Errorf("hostKeyConfig() = %q, want the known hosts of the machine", config)
+	}
+}
+
+func TestWriteKnownHosts(t *testing.T) {
+	tests := []struct {
+		name         string
+		responses    []int
+		want         bool
+		wantRequests int
+	}{
+		{name: "published host keys", responses: []int{http.StatusOK}, want: true, wantRequests: 1},
+		{name: "waits for the host keys", responses: []int{http.StatusNotFound, http.StatusNotFound, http.StatusOK}, want: true, wantRequests: 3},
+		{name: "falls back without access", responses: []int{http.StatusForbidden}, wantRequests: 1},
+		{name: "falls back if the keys are never published", responses: []int{http.StatusNotFound}, wantRequests: 24},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fakeSleeps(t)
+			fake, options := newFakeCompute(t)
+			requests := 0
+			fake.handle(http.MethodGet, "/instances/devpod-test/getGuestAttributes", func(w http.ResponseWriter, r *http.Request) {
+				status := test.responses[len(test.responses)-1]
+				if requests < len(test.responses) {
+					status = test.responses[requests]
+				}
+				requests++
+
+				if r.URL.Query().Get("queryPath") != "hostkeys/" {
+					t.Errorf("queryPath = %q, want hostkeys/", r.URL.Query().Get("queryPath"))
+				}
+				if status != http.StatusOK {
+					writeError(w, status, http.StatusText(status))
+					return
+				}
+				writeJSON(w, map[string]interface{}{"queryValue": map[string]interface{}{"items": []map[string]string{
+					{"namespace": "hostkeys", "key": "ssh-ed25519", "value": "AAAAed25519"},
+					{"namespace": "other", "key": "ssh-rsa", "value": "AAAArsa"},
+				}}})
+			})
+
+			client, err := sharedClient(context.Background(), options)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := writeKnownHosts(context.Background(), client, options, discardLogger()); got != test.want {
+				t.Errorf("writeKnownHosts() = %v, want %v", got, test.want)
+			}
+			if requests != test.wantRequests {
+				t.Errorf("requests = %d, want %d", requests, test.wantRequests)
+			}
+
+			knownHosts, err := os.ReadFile(filepath.Join(options.MachineFolder, knownHostsFile))
+			if !test.want {
+				if err == nil {
+					t.Errorf("known hosts = %q, want none", knownHosts)
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(string(knownHosts), "devpod-test ssh-ed25519 AAAAed25519\n") || strings.Contains(string(knownHosts), "AAAArsa") {
+				t.Errorf("known hosts = %q, want only the host keys of the hostkeys namespace", knownHosts)
+			}
+		})
+	}
+}
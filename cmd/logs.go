@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/badal-io/devpod-provider-gcloud/pkg/gcloud"
+	"github.com/badal-io/devpod-provider-gcloud/pkg/options"
+	"github.com/loft-sh/devpod/pkg/log"
+	"github.com/spf13/cobra"
+)
+
+// LogsCmd holds the cmd flags
+type LogsCmd struct {
+	Follow bool
+	Serial bool
+}
+
+// NewLogsCmd defines a command
+func NewLogsCmd() *cobra.Command {
+	cmd := &LogsCmd{}
+	logsCmd := &cobra.Command{
+		Use:   "logs",
+		Short: "Print the startup script log of the instance",
+		RunE: func(cobraCmd *cobra.Command, args []string) error {
+			options, err := options.FromEnv(true, true)
+			if err != nil {
+				return err
+			}
+
+			return cmd.Run(cobraCmd.Context(), options, log.Default)
+		},
+	}
+
+	logsCmd.Flags().BoolVar(&cmd.Follow, "follow", false, "Stream new log lines until interrupted")
+	logsCmd.Flags().BoolVar(&cmd.Serial, "serial", false, "Read the log from the serial console instead of connecting with ssh, e.g. if ssh isn't working yet")
+	return logsCmd
+}
+
+// Run runs the command logic
+func (cmd *LogsCmd) Run(ctx context.Context, options *options.Options, log log.Logger) error {
+	client, err := sharedClient(ctx, options)
+	if err != nil {
+		return err
+	}
+
+	// the timeout only applies to the api call and not to following the log
+	getCtx, cancel := context.WithTimeout(ctx, options.OperationTimeout)
+	defer cancel()
+	instance, err := client.Get(getCtx, options.MachineID)
+	if err != nil {
+		return err
+	} else if instance == nil {
+		return fmt.Errorf("instance %s doesn't exist", options.MachineID)
+	}
+
+	if cmd.Serial {
+		return cmd.serialLogs(ctx, client, options)
+	}
+
+	privateKey, err := getPrivateKey(options)
+	if err != nil {
+		return fmt.Errorf("load private key: %w", err)
+	}
+
+	return runOnInstance(ctx, options, instance, privateKey, cmd.journalctlCommand(), os.Stdin, os.Stdout, os.Stderr, log)
+}
+
+// journalctlCommand returns the command printing the log of the startup script service
+func (cmd *LogsCmd) journalctlCommand() string {
+	command := "sudo journalctl --no-pager -u google-startup-scripts.service"
+	if cmd.Follow {
+		command += " --follow"
+	}
+
+	return command
+}
+
+// serialLogs prints the startup script lines of the serial console, which the metadata
+// script runner writes the script output to
+func (cmd *LogsCmd) serialLogs(ctx context.Context, client *gcloud.Client, options *options.Options) error {
+	start := int64(0)
+	partialLine := ""
+	for {
+		contents, next, err := client.GetSerialPortOutput(ctx, options.MachineID, start)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			return fmt.Errorf("get serial port output: %w", err)
+		}
+		start = next
+
+		lines := strings.Split(partialLine+contents, "\n")
+		partialLine = lines[len(lines)-1]
+		for _, line := range lines[:len(lines)-1] {
+			if strings.Contains(line, "startup-script") {
+				fmt.Fprintln(os.Stdout, strings.TrimRight(line, "\r"))
+			}
+		}
+
+		if !cmd.Follow {
+			if strings.Contains(partialLine, "startup-script") {
+				fmt.Fprintln(os.Stdout, strings.TrimRight(partialLine, "\r"))
+			}
+
+			return nil
+		}
+
+		if err := sleepContext(ctx, 2*time.Second); err != nil {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestJournalctlCommand(t *testing.T) {
+	if command := (&LogsCmd{}).journalctlCommand(); command != "sudo journalctl --no-pager -u google-startup-scripts.service" {
+		t.Errorf("journalctlCommand() = %q, want the startup script log", command)
+	}
+	if command := (&LogsCmd{Follow: true}).journalctlCommand(); command != "sudo journalctl --no-pager -u google-startup-scripts.service --follow" {
+		t.Errorf("journalctlCommand() = %q, want the followed startup script log", command)
+	}
+}
+
+func TestLogsSerial(t *testing.T) {
+	tests := []struct {
+		name   string
+		follow bool
+		want   string
+	}{
+		{name: "prints the current output", want: "Oct 14 startup-script: one\nOct 14 startup-script: tw\n"},
+		{name: "follows the output", follow: true, want: "Oct 14 startup-script: one\nOct 14 startup-script: two\nOct 14 startup-script: three\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fakeSleeps(t)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			// the output is split in the middle of a line, the last page ends the follow
+			pages := map[string][2]string{
+				"0":  {"Oct 14 startup-script: one\nOct 14 google_guest_agent: other\r\nOct 14 startup-script: tw", "76"},
+				"76": {"o\r\nOct 14 startup-script: three\n", "110"},
+			}
+			fake, options := newFakeCompute(t)
+			fake.handle(http.MethodGet, "/instances/devpod-test", func(w http.ResponseWriter, r *http.Request) {
+				writeJSON(w, map[string]string{"name": "devpod-test", "status": "RUNNING"})
+			})
+			fake.handle(http.MethodGet, "/instances/devpod-test/serialPort", func(w http.ResponseWriter, r *http.Request) {
+				page, ok := pages[r.URL.Query().Get("start")]
+				if !ok {
+					cancel()
+					writeError(w, http.StatusServiceUnavailable, "unavailable")
+					return
+				}
+				writeJSON(w, map[string]string{"contents": page[0], "next": page[1]})
+			})
+
+			out, err := captureStdout(t, func() error {
+				return (&LogsCmd{Serial: true, Follow: test.follow}).Run(ctx, options, discardLogger())
+			})
+			if err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+			if out != test.want {
+				t.Errorf("Run() printed %q, want %q", out, test.want)
+			}
+		})
+	}
+}
+
+func TestLogsSerialError(t *testing.T) {
+	fake, options := newFakeCompute(t)
+	fake.handle(http.MethodGet, "/instances/devpod-test", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]string{"name": "devpod-test", "status": "RUNNING"})
+	})
+	fake.handle(http.MethodGet, "/instances/devpod-test/serialPort", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusForbidden, "forbidden")
+	})
+
+	err := (&LogsCmd{Serial: true}).Run(context.Background(), options, discardLogger())
+	if err == nil || !strings.Contains(err.Error(), "get serial port output") {
+		t.Errorf("Run() error = %v, want the failing serial port output", err)
+	}
+
+	_, options = newFakeCompute(t)
+	err = (&LogsCmd{Serial: true}).Run(context.Background(), options, discardLogger())
+	if err == nil || err.Error() != "instance devpod-test doesn't exist" {
+		t.Errorf("Run() error = %v, want the missing instance", err)
+	}
+}
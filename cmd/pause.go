@@ -0,0 +1,138 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"path"
+
+	"cloud.google.com/go/compute/apiv1/computepb"
+	"github.com/badal-io/devpod-provider-gcloud/pkg/gcloud"
+	"github.com/badal-io/devpod-provider-gcloud/pkg/options"
+	"github.com/loft-sh/devpod/pkg/log"
+	"github.com/spf13/cobra"
+)
+
+// PauseCmd holds the cmd flags
+type PauseCmd struct{}
+
+// NewPauseCmd defines a command
+func NewPauseCmd() *cobra.Command {
+	cmd := &PauseCmd{}
+	pauseCmd := &cobra.Command{
+		Use:   "pause",
+		Short: "Stop an instance and keep its external ip reserved for the resume",
+		RunE: func(cobraCmd *cobra.Command, args []string) error {
+			options, err := options.FromEnv(true, false)
+			if err != nil {
+				return err
+			}
+
+			return runWithTimeout(cobraCmd.Context(), options, func(ctx context.Context) error {
+				return cmd.Run(ctx, options, log.Default)
+			})
+		},
+	}
+
+	return pauseCmd
+}
+
+// pausedAddressDescription marks the addresses reserved by pause, only these are released on delete
+const pausedAddressDescription = "Reserved by DevPod to keep the external ip of a paused instance"
+
+// Run runs the command logic
+func (cmd *PauseCmd) Run(ctx context.Context, options *options.Options, log log.Logger) error {
+	client, err := sharedClient(ctx, options)
+	if err != nil {
+		return err
+	}
+
+	instance, err := client.Get(ctx, options.MachineID)
+	if err != nil {
+		return err
+	} else if instance == nil {
+		return fmt.Errorf("instance %s doesn't exist", options.MachineID)
+	}
+
+	region := regionFromZone(options.Zone)
+	name := pausedAddressName(options.MachineID)
+	reserved, err := client.GetAddress(ctx, region, name)
+	if err != nil {
+		return fmt.Errorf("get address %s: %w", name, err)
+	}
+
+	ip, reserve, err := addressToReserve(instance, reserved)
+	if err != nil {
+		return err
+	} else if reserve {
+		log.Infof("Reserving external ip %s of instance %s as address %s", ip, options.MachineID, name)
+		err = client.ReserveAddress(ctx, region, name, ip, externalNetworkTier(instance), pausedAddressDescription)
+		if err != nil {
+			return fmt.Errorf("reserve external ip %s: %w", ip, err)
+		}
+	} else if ip == "" {
+		log.Infof("Instance %s has no external ip, stopping it without reserving one", options.MachineID)
+	}
+
+	// the ip is reserved before the stop, as stopping releases an ephemeral ip
+	return client.Stop(ctx, options.MachineID, false, options.DiscardLocalSSD)
+}
+
+// addressToReserve returns the external ip of the instance and whether it has to be promoted to
+// a static address. It doesn't have to if the instance has no external ip or if the address
+// reserved by an earlier pause already holds it.
+func addressToReserve(instance *computepb.Instance, reserved *computepb.Address) (string, bool, error) {
+	ip := externalIP(instance)
+	if ip == "" {
+		return "", false, nil
+	} else if reserved == nil {
+		return ip, true, nil
+	} else if reserved.GetAddress() != ip {
+		return "", false, fmt.Errorf("address %s is already reserved for %s, but instance %s uses %s, release it with: gcloud compute addresses delete %s --region=%s", reserved.GetName(), reserved.GetAddress(), instance.GetName(), ip, reserved.GetName(), path.Base(reserved.GetRegion()))
+	}
+
+	return ip, false, nil
+}
+
+// releasePausedAddress releases the address reserved by pause for the instance, if any
+func releasePausedAddress(ctx context.Context, client *gcloud.Client, options *options.Options, log log.Logger) error {
+	region := regionFromZone(options.Zone)
+	name := pausedAddressName(options.MachineID)
+	address, err := client.GetAddress(ctx, region, name)
+	if err != nil {
+		return err
+	} else if address == nil || address.GetDescription() != pausedAddressDescription {
+		// never release addresses that weren't reserved by pause
+		return nil
+	}
+
+	log.Infof("Releasing address %s reserved by pause", name)
+	return client.DeleteAddress(ctx, region, name)
+}
+
+// pausedAddressName returns the name of the address reserved by pause for the instance
+func pausedAddressName(machineID string) string {
+	const suffix = "-ip"
+	if len(machineID) > 63-len(suffix) {
+		machineID = machineID[:63-len(suffix)]
+	}
+
+	return machineID + suffix
+}
+
+// externalIP returns the external ip of the first network interface of the instance
+func externalIP(instance *computepb.Instance) string {
+	if len(instance.GetNetworkInterfaces()) == 0 || len(instance.GetNetworkInterfaces()[0].GetAccessConfigs()) == 0 {
+		return ""
+	}
+
+	return instance.GetNetworkInterfaces()[0].GetAccessConfigs()[0].GetNatIP()
+}
+
+// externalNetworkTier returns the network tier of the external ip of the instance
+func externalNetworkTier(instance *computepb.Instance) string {
+	if len(instance.GetNetworkInterfaces()) == 0 || len(instance.GetNetworkInterfaces()[0].GetAccessConfigs()) == 0 {
+		return ""
+	}
+
+	return instance.GetNetworkInterfaces()[0].GetAccessConfigs()[0].GetNetworkTier()
+}
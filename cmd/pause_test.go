@@ -0,0 +1,180 @@
+package cmd
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/compute/apiv1/computepb"
+	"github.com/badal-io/devpod-provider-gcloud/pkg/ptr"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// apiRequests returns the requests of the fake without the polling of the operations
+func apiRequests(fake *fakeCompute) []string {
+	requests := []string{}
+	for _, request := range fake.requested() {
+		if !strings.Contains(request, "/operations/") {
+			requests = append(requests, request)
+		}
+	}
+
+	return requests
+}
+
+func TestAddressToReserve(t *testing.T) {
+	instance := &computepb.Instance{
+		Name: ptr.Ptr("devpod-test"),
+		NetworkInterfaces: []*computepb.NetworkInterface{
+			{AccessConfigs: []*computepb.AccessConfig{{NatIP: ptr.Ptr("203.0.113.10")}}},
+		},
+	}
+
+	tests := []struct {
+		name        string
+		instance    *computepb.Instance
+		reserved    *computepb.Address
+		wantIP      string
+		wantReserve bool
+		wantErr     string
+	}{
+		{name: "no external ip", instance: &computepb.Instance{NetworkInterfaces: []*computepb.NetworkInterface{{}}}},
+		{name: "ephemeral ip", instance: instance, wantIP: "203.0.113.10", wantReserve: true},
+		{name: "already reserved", instance: instance, reserved: &computepb.Address{Address: ptr.Ptr("203.0.113.10")}, wantIP: "203.0.113.10"},
+		{
+			name:     "reserved for another ip",
+			instance: instance,
+			reserved: &computepb.Address{Name: ptr.Ptr("devpod-test-ip"), Address: ptr.Ptr("203.0.113.20"), Region: ptr.Ptr("https://www.googleapis.com/compute/v1/projects/p/regions/europe-west1")},
+			wantErr:  "address devpod-test-ip is already reserved for 203.0.113.20, but instance devpod-test uses 203.0.113.10, release it with: gcloud compute addresses delete devpod-test-ip --region=europe-west1",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ip, reserve, err := addressToReserve(test.instance, test.reserved)
+			if test.wantErr != "" {
+				if err == nil || err.Error() != test.wantErr {
+					t.Fatalf("addressToReserve() error = %v, want %q", err, test.wantErr)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("addressToReserve() error = %v", err)
+			}
+
+			if ip != test.wantIP || reserve != test.wantReserve {
+				t.Errorf("addressToReserve() = %q, %v, want %q, %v", ip, reserve, test.wantIP, test.wantReserve)
+			}
+		})
+	}
+}
+
+func TestPausedAddressName(t *testing.T) {
+	if got := pausedAddressName("devpod-test"); got != "devpod-test-ip" {
+		t.Errorf("pausedAddressName() = %q, want devpod-test-ip", got)
+	}
+
+	// the name of the address is limited to 63 characters like the instance name
+	long := strings.Repeat("a", 63)
+	if got := pausedAddressName(long); got != strings.Repeat("a", 60)+"-ip" {
+		t.Errorf("pausedAddressName() = %q, want the machine id shortened to 60 characters", got)
+	}
+}
+
+func TestPause(t *testing.T) {
+	tests := []struct {
+		name         string
+		natIP        string
+		wantRequests []string
+	}{
+		{
+			name:  "external ip",
+			natIP: "203.0.113.10",
+			wantRequests: []string{
+				"GET /instances/devpod-test",
+				"GET /regions/europe-west1/addresses/devpod-test-ip",
+				"POST /regions/europe-west1/addresses",
+				"POST /instances/devpod-test/stop",
+			},
+		},
+		{
+			name: "no external ip",
+			wantRequests: []string{
+				"GET /instances/devpod-test",
+				"GET /regions/europe-west1/addresses/devpod-test-ip",
+				"POST /instances/devpod-test/stop",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake, options := newFakeCompute(t)
+			fake.handle(http.MethodGet, "/instances/devpod-test", func(w http.ResponseWriter, r *http.Request) {
+				accessConfigs := []map[string]string{}
+				if test.natIP != "" {
+					accessConfigs = append(accessConfigs, map[string]string{"name": "External NAT", "natIP": test.natIP, "networkTier": "STANDARD"})
+				}
+				writeJSON(w, map[string]interface{}{
+					"name":              "devpod-test",
+					"status":            "RUNNING",
+					"networkInterfaces": []map[string]interface{}{{"name": "nic0", "accessConfigs": accessConfigs}},
+				})
+			})
+			var reserved computepb.Address
+			fake.handleProject(http.MethodPost, "/regions/europe-west1/addresses", func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				if err := protojson.Unmarshal(body, &reserved); err != nil {
+					t.Error(err)
+				}
+				writeOperation(w, "op-address")
+			})
+			fake.handle(http.MethodPost, "/instances/devpod-test/stop", func(w http.ResponseWriter, r *http.Request) {
+				writeOperation(w, "op-stop")
+			})
+
+			if err := (&PauseCmd{}).Run(context.Background(), options, discardLogger()); err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+
+			// the ip is reserved before the stop releases it
+			if requests := apiRequests(fake); !reflect.DeepEqual(requests, test.wantRequests) {
+				t.Errorf("requests = %q, want %q", requests, test.wantRequests)
+			}
+			if test.natIP != "" && (reserved.GetAddress() != test.natIP || reserved.GetName() != "devpod-test-ip" || reserved.GetNetworkTier() != "STANDARD" || reserved.GetDescription() != pausedAddressDescription) {
+				t.Errorf("reserved address = %v, want %s of the instance", &reserved, test.natIP)
+			}
+		})
+	}
+}
+
+func TestReleasePausedAddress(t *testing.T) {
+	for description, wantRelease := range map[string]bool{
+		pausedAddressDescription: true,
+		"Reserved by the team":   false,
+	} {
+		fake, options := newFakeCompute(t)
+		fake.handleProject(http.MethodGet, "/regions/europe-west1/addresses/devpod-test-ip", func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, map[string]string{"name": "devpod-test-ip", "address": "203.0.113.10", "description": description})
+		})
+		released := false
+		fake.handleProject(http.MethodDelete, "/regions/europe-west1/addresses/devpod-test-ip", func(w http.ResponseWriter, r *http.Request) {
+			released = true
+			writeOperation(w, "op-release")
+		})
+
+		client, err := sharedClient(context.Background(), options)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// only the addresses reserved by pause are released
+		if err := releasePausedAddress(context.Background(), client, options, discardLogger()); err != nil {
+			t.Fatalf("releasePausedAddress() error = %v", err)
+		} else if released != wantRelease {
+			t.Errorf("released = %v with the description %q, want %v", released, description, wantRelease)
+		}
+	}
+}
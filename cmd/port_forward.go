@@ -0,0 +1,182 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+
+	"github.com/badal-io/devpod-provider-gcloud/pkg/options"
+	"github.com/loft-sh/devpod/pkg/log"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// PortForwardCmd holds the cmd flags
+type PortForwardCmd struct {
+	LocalPort  int
+	RemotePort int
+}
+
+// NewPortForwardCmd defines a command
+func NewPortForwardCmd() *cobra.Command {
+	cmd := &PortForwardCmd{}
+	portForwardCmd := &cobra.Command{
+		Use:   "port-forward",
+		Short: "Forward a local port to a port on the instance",
+		RunE: func(cobraCmd *cobra.Command, args []string) error {
+			options, err := options.FromEnv(true, true)
+			if err != nil {
+				return err
+			}
+
+			return cmd.Run(cobraCmd.Context(), options, log.Default)
+		},
+	}
+
+	portForwardCmd.Flags().IntVar(&cmd.LocalPort, "local", 0, "The local port to listen on, a free port is chosen if not set")
+	portForwardCmd.Flags().IntVar(&cmd.RemotePort, "remote", 0, "The port on the instance to forward to")
+	_ = portForwardCmd.MarkFlagRequired("remote")
+	return portForwardCmd
+}
+
+// Run runs the command logic
+func (cmd *PortForwardCmd) Run(ctx context.Context, options *options.Options, log log.Logger) error {
+	localPort, err := cmd.localPort()
+	if err != nil {
+		return err
+	} else if cmd.RemotePort < 1 || cmd.RemotePort > 65535 {
+		return fmt.Errorf("invalid remote port %d", cmd.RemotePort)
+	}
+
+	client, err := sharedClient(ctx, options)
+	if err != nil {
+		return err
+	}
+
+	// the timeout only applies to the api call and not to the forwarding itself
+	getCtx, cancel := context.WithTimeout(ctx, options.OperationTimeout)
+	defer cancel()
+	instance, err := client.Get(getCtx, options.MachineID)
+	if err != nil {
+		return err
+	} else if instance == nil {
+		return fmt.Errorf("instance %s doesn't exist", options.MachineID)
+	}
+
+	target, err := directSSHTarget(options, instance)
+	if err != nil {
+		return err
+	} else if target == "" {
+		return cmd.forwardIAP(ctx, options, localPort, log)
+	}
+
+	privateKey, err := getPrivateKey(options)
+	if err != nil {
+		return fmt.Errorf("load private key: %w", err)
+	}
+
+	sshClient, err := newSSHClient(options, target+":"+strconv.Itoa(options.SSHPort), privateKey)
+	if err != nil {
+		return errors.Wrap(err, "create ssh client")
+	}
+	defer sshClient.Close()
+
+	return cmd.forwardSSH(ctx, sshClient, localPort, log)
+}
+
+// localPort returns the requested local port or a free one
+func (cmd *PortForwardCmd) localPort() (string, error) {
+	if cmd.LocalPort == 0 {
+		return findAvailablePort()
+	} else if cmd.LocalPort < 0 || cmd.LocalPort > 65535 {
+		return "", fmt.Errorf("invalid local port %d", cmd.LocalPort)
+	}
+
+	return strconv.Itoa(cmd.LocalPort), nil
+}
+
+// forwardIAP lets ssh forward the port through the IAP ProxyCommand of the generated ssh config
+func (cmd *PortForwardCmd) forwardIAP(ctx context.Context, options *options.Options, localPort string, log log.Logger) error {
+	sshArgs := []string{
+		"-F", options.SSHConfigFile(),
+		"-N",
+		"-o", "ExitOnForwardFailure=yes",
+		"-L", fmt.Sprintf("localhost:%s:localhost:%d", localPort, cmd.RemotePort),
+		options.MachineID,
+	}
+
+	sshCmd := exec.CommandContext(ctx, "ssh", sshArgs...)
+	sshCmd.Stdout = os.Stdout
+	sshCmd.Stderr = os.Stderr
+	err := sshCmd.Start()
+	if err != nil {
+		return errors.Wrap(err, "start ssh")
+	}
+
+	go func() {
+		if waitForPort(ctx, localPort) {
+			log.Infof("Forwarding localhost:%s to port %d on %s", localPort, cmd.RemotePort, options.MachineID)
+		}
+	}()
+
+	err = sshCmd.Wait()
+	if ctx.Err() != nil {
+		return nil
+	}
+
+	return err
+}
+
+// forwardSSH accepts local connections and forwards each of them over the ssh connection
+func (cmd *PortForwardCmd) forwardSSH(ctx context.Context, sshClient *gossh.Client, localPort string, log log.Logger) error {
+	listener, err := net.Listen("tcp", "localhost:"+localPort)
+	if err != nil {
+		return errors.Wrap(err, "listen on local port")
+	}
+	defer listener.Close()
+
+	go func() {
+		<-ctx.Done()
+		_ = listener.Close()
+	}()
+
+	log.Infof("Forwarding localhost:%s to port %d", localPort, cmd.RemotePort)
+	remoteAddr := "localhost:" + strconv.Itoa(cmd.RemotePort)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			return errors.Wrap(err, "accept connection")
+		}
+
+		go func() {
+			defer conn.Close()
+
+			remoteConn, err := sshClient.Dial("tcp", remoteAddr)
+			if err != nil {
+				log.Warnf("Forward connection to %s: %v", remoteAddr, err)
+				return
+			}
+			defer remoteConn.Close()
+
+			done := make(chan struct{}, 2)
+			go func() {
+				_, _ = io.Copy(remoteConn, conn)
+				done <- struct{}{}
+			}()
+			go func() {
+				_, _ = io.Copy(conn, remoteConn)
+				done <- struct{}{}
+			}()
+			<-done
+		}()
+	}
+}
@@ -0,0 +1,144 @@
+package cmd
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestPortForwardLocalPort(t *testing.T) {
+	tests := []struct {
+		name      string
+		localPort int
+		want      string
+		wantErr   string
+	}{
+		{name: "requested port", localPort: 8080, want: "8080"},
+		{name: "highest port", localPort: 65535, want: "65535"},
+		{name: "negative port", localPort: -1, wantErr: "invalid local port -1"},
+		{name: "port out of range", localPort: 65536, wantErr: "invalid local port 65536"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			port, err := (&PortForwardCmd{LocalPort: test.localPort}).localPort()
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Errorf("localPort() error = %v, want %q", err, test.wantErr)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("localPort() error = %v", err)
+			}
+
+			if port != test.want {
+				t.Errorf("localPort() = %q, want %q", port, test.want)
+			}
+		})
+	}
+
+	t.Run("free port", func(t *testing.T) {
+		port, err := (&PortForwardCmd{}).localPort()
+		if err != nil {
+			t.Fatalf("localPort() error = %v", err)
+		}
+
+		if number, err := strconv.Atoi(port); err != nil || number < 1 || number > 65535 {
+			t.Errorf("localPort() = %q, want a port number", port)
+		}
+	})
+}
+
+func TestPortForwardFlags(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr string
+	}{
+		{name: "missing remote port", args: []string{"--local", "8080"}, wantErr: `required flag(s) "remote" not set`},
+		{name: "invalid local port", args: []string{"--local", "http", "--remote", "80"}, wantErr: `invalid argument "http" for "--local"`},
+		{name: "invalid remote port", args: []string{"--remote", "80/tcp"}, wantErr: `invalid argument "80/tcp" for "--remote"`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cmd := NewPortForwardCmd()
+			cmd.SetArgs(test.args)
+			cmd.SetOut(&strings.Builder{})
+			cmd.SetErr(&strings.Builder{})
+
+			err := cmd.Execute()
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("Execute() error = %v, want %q", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestPortForwardRun(t *testing.T) {
+	tests := []struct {
+		name       string
+		cmd        PortForwardCmd
+		instance   bool
+		wantErr    string
+		wantLookup bool
+		wantArgs   string
+	}{
+		{
+			name:       "forwards through the IAP ssh config",
+			cmd:        PortForwardCmd{LocalPort: 8080, RemotePort: 3000},
+			instance:   true,
+			wantLookup: true,
+			wantArgs:   "-N -o ExitOnForwardFailure=yes -L localhost:8080:localhost:3000 devpod-test",
+		},
+		{name: "invalid remote port", cmd: PortForwardCmd{LocalPort: 8080}, wantErr: "invalid remote port 0"},
+		{name: "remote port out of range", cmd: PortForwardCmd{LocalPort: 8080, RemotePort: 65536}, wantErr: "invalid remote port 65536"},
+		{name: "invalid local port", cmd: PortForwardCmd{LocalPort: -1, RemotePort: 3000}, wantErr: "invalid local port -1"},
+		{name: "missing instance", cmd: PortForwardCmd{LocalPort: 8080, RemotePort: 3000}, wantLookup: true, wantErr: "instance devpod-test doesn't exist"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			calls := fakeSSH(t, 0, "")
+			fake, options := newFakeCompute(t)
+			if test.instance {
+				fake.handle(http.MethodGet, "/instances/devpod-test", func(w http.ResponseWriter, r *http.Request) {
+					writeJSON(w, map[string]interface{}{"name": "devpod-test", "status": "RUNNING"})
+				})
+			}
+
+			err := test.cmd.Run(ctx, options, discardLogger())
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Errorf("Run() error = %v, want %q", err, test.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+
+			if lookup := len(fake.requested()) > 0; lookup != test.wantLookup {
+				t.Errorf("instance looked up = %v, want %v", lookup, test.wantLookup)
+			}
+
+			out, err := os.ReadFile(calls)
+			if test.wantArgs == "" {
+				if err == nil {
+					t.Errorf("ssh called with %q", out)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("ssh wasn't called: %v", err)
+			}
+
+			if want := "-F " + options.SSHConfigFile() + " " + test.wantArgs; strings.TrimSpace(string(out)) != want {
+				t.Errorf("ssh args = %q, want %q", strings.TrimSpace(string(out)), want)
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/compute/apiv1/computepb"
+	"github.com/badal-io/devpod-provider-gcloud/pkg/gcloud"
+	"github.com/badal-io/devpod-provider-gcloud/pkg/options"
+	"github.com/loft-sh/devpod/pkg/log"
+)
+
+// runPostCreateCommand runs POST_CREATE_COMMAND on the new instance over the same ssh
+// transport as the command command, logs its output and fails if it exits non-zero
+func runPostCreateCommand(ctx context.Context, client *gcloud.Client, options *options.Options, log log.Logger) error {
+	privateKey, err := getPrivateKey(options)
+	if err != nil {
+		return fmt.Errorf("load private key: %w", err)
+	}
+
+	instance, err := client.Get(ctx, options.MachineID)
+	if err != nil {
+		return err
+	} else if instance == nil {
+		return fmt.Errorf("instance %s doesn't exist", options.MachineID)
+	}
+
+	// instances with a public ip skip the readiness check of the create
+	if options.PublicIP {
+		err = waitForPublicSSH(ctx, options, instance, privateKey, log)
+		if err != nil {
+			return err
+		}
+	}
+
+	log.Info("Running post create command...")
+	output := &bytes.Buffer{}
+	err = runOnInstance(ctx, options, instance, privateKey, options.PostCreateCommand, nil, output, output, log)
+	for _, line := range strings.Split(strings.TrimRight(output.String(), "\n"), "\n") {
+		if line != "" {
+			log.Info(line)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("post create command: %w", err)
+	}
+
+	return nil
+}
+
+// waitForPublicSSH waits until the instance accepts ssh connections on its public ip
+func waitForPublicSSH(ctx context.Context, options *options.Options, instance *computepb.Instance, privateKey []byte, log log.Logger) error {
+	maxRetries := 12
+	var lastErr error
+	for attempt := 0; attempt < maxRetries; attempt++ {
+		lastErr = runOnInstance(ctx, options, instance, privateKey, defaultReadinessCommand, nil, io.Discard, io.Discard, log)
+		if lastErr == nil {
+			return nil
+		}
+
+		backoff := time.Duration(min(5*(attempt+1), 30)) * time.Second
+		log.Debugf("Waiting for SSH to be ready (attempt %d/%d, retry in %v): %v", attempt+1, maxRetries, backoff, lastErr)
+		if err := sleepContext(ctx, backoff); err != nil {
+			return err
+		}
+	}
+
+	return fmt.Errorf("instance %s isn't reachable over SSH: %w", options.MachineID, lastErr)
+}
@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/loft-sh/devpod/pkg/log"
+	"github.com/sirupsen/logrus"
+)
+
+func TestRunPostCreateCommand(t *testing.T) {
+	tests := []struct {
+		name      string
+		exitCode  string
+		wantErr   string
+		wantCalls int
+	}{
+		{name: "logs the output", exitCode: "0", wantCalls: 1},
+		{name: "fails without retrying the command", exitCode: "3", wantErr: "post create command: exit status 3", wantCalls: 1},
+		{name: "retries failing connections", exitCode: "255", wantErr: "failed after 3 attempts", wantCalls: 3},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fakeSleeps(t)
+			calls := filepath.Join(t.TempDir(), "calls")
+			fakeBinary(t, "ssh", `echo "$*" >> `+calls+`
+echo "installed tools"
+echo "a warning" >&2
+exit `+test.exitCode+"\n")
+
+			fake, options := newFakeCompute(t)
+			fake.handle(http.MethodGet, "/instances/devpod-test", func(w http.ResponseWriter, r *http.Request) {
+				writeJSON(w, map[string]string{"name": "devpod-test", "status": "RUNNING"})
+			})
+			options.PostCreateCommand = "make tools"
+
+			client, err := sharedClient(context.Background(), options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out := &bytes.Buffer{}
+			err = runPostCreateCommand(context.Background(), client, options, log.NewStreamLogger(out, out, logrus.InfoLevel))
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Errorf("runPostCreateCommand() error = %v, want %q", err, test.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("runPostCreateCommand() error = %v", err)
+			}
+
+			sshCalls, err := os.ReadFile(calls)
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines := strings.Split(strings.TrimSpace(string(sshCalls)), "\n")
+			if len(lines) != test.wantCalls || !strings.HasSuffix(lines[0], " devpod-test make tools") {
+				t.Errorf("ssh calls = %q, want %d calls of the post create command", lines, test.wantCalls)
+			}
+			for _, want := range []string{"installed tools", "a warning"} {
+				if !strings.Contains(out.String(), want) {
+					t.Errorf("output = %q, want %q", out.String(), want)
+				}
+			}
+		})
+	}
+}
+
+func TestRunPostCreateCommandMissingInstance(t *testing.T) {
+	_, options := newFakeCompute(t)
+	options.PostCreateCommand = "make tools"
+
+	client, err := sharedClient(context.Background(), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = runPostCreateCommand(context.Background(), client, options, discardLogger())
+	if err == nil || err.Error() != "instance devpod-test doesn't exist" {
+		t.Errorf("runPostCreateCommand() error = %v, want the missing instance", err)
+	}
+}
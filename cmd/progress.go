@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/loft-sh/devpod/pkg/log"
+	"github.com/sirupsen/logrus"
+)
+
+// ProgressFunc is called with the phase of a create and the completed percentage
+type ProgressFunc func(phase string, percent int)
+
+// The phases of a create in the order they are reported. The configuring-ssh phase
+// is skipped for instances with a public ip.
+const (
+	PhasePreflight         = "preflight"
+	PhaseInserting         = "inserting"
+	PhaseConfiguringSSH    = "configuring-ssh"
+	PhaseWaitingForRunning = "waiting-for-running"
+	PhaseWaitingForStartup = "waiting-for-startup"
+	PhaseDone              = "done"
+)
+
+// progressEvent is the log record of a phase with LOG_FORMAT=json, it has the fields of
+// the other records and the phase and percentage for the devpod ui
+type progressEvent struct {
+	Time    time.Time    `json:"time"`
+	Level   logrus.Level `json:"level"`
+	Message string       `json:"message"`
+	Phase   string       `json:"phase"`
+	Percent int          `json:"percent"`
+}
+
+// jsonLogger is implemented by the loggers that print json records with LOG_FORMAT=json
+type jsonLogger interface {
+	GetFormat() log.Format
+	JSON(level logrus.Level, value interface{})
+}
+
+// logProgress logs the phase of a create, as a progress event with LOG_FORMAT=json
+func logProgress(logger log.Logger, phase string, percent int) {
+	message := fmt.Sprintf("Create phase %s (%d%%)", phase, percent)
+	if jsonLogger, ok := logger.(jsonLogger); ok && jsonLogger.GetFormat() == log.JSONFormat {
+		jsonLogger.JSON(logrus.InfoLevel, &progressEvent{
+			Time:    time.Now(),
+			Level:   logrus.InfoLevel,
+			Message: message,
+			Phase:   phase,
+			Percent: percent,
+		})
+		return
+	}
+
+	logger.Info(message)
+}
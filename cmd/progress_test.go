@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/loft-sh/devpod/pkg/log"
+	"github.com/sirupsen/logrus"
+)
+
+func TestLogProgress(t *testing.T) {
+	t.Run("json", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		logger := log.NewStreamLogger(out, out, logrus.InfoLevel)
+		if err := setupLogger(logger, "json"); err != nil {
+			t.Fatal(err)
+		}
+
+		(&CreateCmd{}).progressFunc(logger)(PhaseInserting, 20)
+
+		record := map[string]interface{}{}
+		if err := json.Unmarshal(out.Bytes(), &record); err != nil {
+			t.Fatalf("logged %q, want a json record: %v", out.String(), err)
+		}
+		want := map[string]interface{}{"level": "info", "message": "Create phase inserting (20%)", "phase": "inserting", "percent": float64(20)}
+		for key, value := range want {
+			if record[key] != value {
+				t.Errorf("record %s = %v, want %v", key, record[key], value)
+			}
+		}
+		if record["time"] == nil {
+			t.Errorf("record %q has no time", out.String())
+		}
+	})
+
+	t.Run("text", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		logger := log.NewStreamLogger(out, out, logrus.InfoLevel)
+		if err := setupLogger(logger, "text"); err != nil {
+			t.Fatal(err)
+		}
+
+		(&CreateCmd{}).progressFunc(logger)(PhaseInserting, 20)
+		if got := out.String(); got != "Create phase inserting (20%)\n" {
+			t.Errorf("logged %q, want the phase at info level", got)
+		}
+	})
+}
@@ -0,0 +1,156 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"path"
+
+	"cloud.google.com/go/compute/apiv1/computepb"
+	"github.com/badal-io/devpod-provider-gcloud/pkg/options"
+	"github.com/badal-io/devpod-provider-gcloud/pkg/ptr"
+	"github.com/loft-sh/devpod/pkg/log"
+	"github.com/spf13/cobra"
+)
+
+// RecreateCmd holds the cmd flags
+type RecreateCmd struct{}
+
+// NewRecreateCmd defines a command
+func NewRecreateCmd() *cobra.Command {
+	cmd := &RecreateCmd{}
+	recreateCmd := &cobra.Command{
+		Use:   "recreate",
+		Short: "Recreate an instance with a fresh boot disk, keeping its data disks",
+		RunE: func(cobraCmd *cobra.Command, args []string) error {
+			options, err := options.FromEnv(true, true)
+			if err != nil {
+				return err
+			}
+
+			return cmd.Run(cobraCmd.Context(), options, log.Default)
+		},
+	}
+
+	return recreateCmd
+}
+
+// Run runs the command logic
+func (cmd *RecreateCmd) Run(ctx context.Context, options *options.Options, log log.Logger) error {
+	// like for create, the OPERATION_TIMEOUT only bounds the api calls and not the ssh wait
+	err := runWithTimeout(ctx, options, func(ctx context.Context) error {
+		return cmd.recreate(ctx, options, log)
+	})
+	if err != nil || options.PublicIP {
+		return err
+	}
+
+	client, err := sharedClient(ctx, options)
+	if err != nil {
+		return err
+	}
+
+	return configureIAPAndWait(ctx, client, options, log, func(phase string, percent int) {})
+}
+
+// recreate replaces the instance by a new one with the data disks of the old one
+func (cmd *RecreateCmd) recreate(ctx context.Context, options *options.Options, log log.Logger) error {
+	if options.InstanceTemplate != "" {
+		// reattaching the data disks would replace the disks of the template, including the boot disk
+		return fmt.Errorf("recreate doesn't support instances created from an INSTANCE_TEMPLATE")
+	}
+	if options.BootDiskName != "" {
+		return fmt.Errorf("recreate replaces the boot disk, which would delete the BOOT_DISK_NAME disk")
+	}
+
+	client, err := sharedClient(ctx, options)
+	if err != nil {
+		return err
+	}
+
+	instance, err := client.Get(ctx, options.MachineID)
+	if err != nil {
+		return err
+	} else if instance == nil {
+		return fmt.Errorf("instance %s doesn't exist", options.MachineID)
+	}
+
+	dataDisks := reusableDisks(instance)
+	if len(dataDisks) == 0 {
+		return fmt.Errorf("instance %s has no data disks to keep, use delete and create instead", options.MachineID)
+	}
+
+	// build the new instance before deleting anything, so invalid options don't leave the workspace without an instance
+	newInstance, err := buildInstance(options)
+	if err != nil {
+		return err
+	}
+	if options.InternalIP != "" {
+		internalIP, err := resolveInternalIP(ctx, client, options)
+		if err != nil {
+			return err
+		}
+
+		newInstance.NetworkInterfaces[0].NetworkIP = ptr.Ptr(internalIP)
+	}
+	for key, value := range instance.GetLabels() {
+		newInstance.Labels[key] = value
+	}
+
+	for _, disk := range instance.Disks {
+		// the boot disk is replaced, a kept one would block the name of the new boot disk
+		autoDelete := disk.GetBoot()
+		if disk.GetType() == "SCRATCH" || disk.GetAutoDelete() == autoDelete {
+			continue
+		}
+
+		err = client.SetDiskAutoDelete(ctx, options.MachineID, disk.GetDeviceName(), autoDelete)
+		if err != nil {
+			return fmt.Errorf("set auto delete of disk %s: %w", path.Base(disk.GetSource()), err)
+		}
+	}
+
+	log.Infof("Deleting instance %s", options.MachineID)
+	err = client.Delete(ctx, options.MachineID)
+	if err != nil {
+		return err
+	}
+
+	for _, disk := range dataDisks {
+		log.Infof("Reattaching disk %s", path.Base(disk.GetSource()))
+		newInstance.Disks = append(newInstance.Disks, &computepb.AttachedDisk{
+			AutoDelete: ptr.Ptr(false),
+			DeviceName: ptr.Ptr(disk.GetDeviceName()),
+			Mode:       ptr.Ptr(disk.GetMode()),
+			Source:     ptr.Ptr(disk.GetSource()),
+		})
+	}
+
+	log.Infof("Creating instance %s", options.MachineID)
+	err = client.Create(ctx, newInstance, func(operation string) error {
+		return writePendingOperation(options, operation)
+	})
+	if ctx.Err() == nil {
+		removePendingOperation(options)
+	}
+	if err != nil {
+		return fmt.Errorf("create instance, the data disks are kept and can be reattached manually: %w", err)
+	}
+
+	if options.InstanceGroup != "" {
+		return addToInstanceGroup(ctx, client, options, log)
+	}
+
+	return nil
+}
+
+// reusableDisks returns the persistent disks of the instance other than the boot disk
+func reusableDisks(instance *computepb.Instance) []*computepb.AttachedDisk {
+	disks := []*computepb.AttachedDisk{}
+	for _, disk := range instance.Disks {
+		if !disk.GetBoot() && disk.GetType() != "SCRATCH" {
+			disks = append(disks, disk)
+		}
+	}
+
+	return disks
+}
@@ -0,0 +1,171 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/badal-io/devpod-provider-gcloud/pkg/gcloud"
+)
+
+func TestRecreate(t *testing.T) {
+	fake, options := newFakeCreate(t)
+	diskSource := "projects/" + options.Project + "/zones/europe-west1-b/disks/"
+	fake.handle(http.MethodGet, "/instances/devpod-test", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]interface{}{
+			"name":   "devpod-test",
+			"status": "RUNNING",
+			"labels": map[string]string{gcloud.ManagedByLabel: gcloud.ManagedByValue, "team": "dev"},
+			"disks": []map[string]interface{}{
+				{"boot": true, "autoDelete": true, "deviceName": "boot", "type": "PERSISTENT", "source": diskSource + "devpod-test"},
+				{"autoDelete": true, "deviceName": "home", "mode": "READ_WRITE", "type": "PERSISTENT", "source": diskSource + "home"},
+				{"autoDelete": false, "deviceName": "data", "mode": "READ_ONLY", "type": "PERSISTENT", "source": diskSource + "data"},
+				{"autoDelete": true, "deviceName": "local-ssd-0", "type": "SCRATCH"},
+			},
+		})
+	})
+	autoDeletes := []string{}
+	fake.handle(http.MethodPost, "/instances/devpod-test/setDiskAutoDelete", func(w http.ResponseWriter, r *http.Request) {
+		autoDeletes = append(autoDeletes, r.URL.Query().Get("deviceName")+"="+r.URL.Query().Get("autoDelete"))
+		writeOperation(w, "op-auto-delete")
+	})
+	fake.handle(http.MethodDelete, "/instances/devpod-test", func(w http.ResponseWriter, r *http.Request) {
+		writeOperation(w, "op-delete")
+	})
+	var inserted struct {
+		Labels map[string]string `json:"labels"`
+		Disks  []struct {
+			Boot       bool   `json:"boot"`
+			AutoDelete bool   `json:"autoDelete"`
+			DeviceName string `json:"deviceName"`
+			Mode       string `json:"mode"`
+			Source     string `json:"source"`
+		} `json:"disks"`
+	}
+	fake.handle(http.MethodPost, "/instances", func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&inserted); err != nil {
+			t.Errorf("decode instance: %v", err)
+		}
+		writeOperation(w, "op-insert")
+	})
+
+	if err := (&RecreateCmd{}).Run(context.Background(), options, discardLogger()); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	// the data disks have to outlive the old instance before it's deleted and the new one is created
+	sequence := []string{}
+	for _, request := range fake.requested() {
+		if !strings.HasPrefix(request, http.MethodGet) && !strings.Contains(request, "/operations/") {
+			sequence = append(sequence, request)
+		}
+	}
+	wantSequence := []string{
+		"POST /instances/devpod-test/setDiskAutoDelete",
+		"DELETE /instances/devpod-test",
+		"POST /instances",
+	}
+	if !reflect.DeepEqual(sequence, wantSequence) {
+		t.Errorf("requests = %q, want %q", sequence, wantSequence)
+	}
+	if want := []string{"home=false"}; !reflect.DeepEqual(autoDeletes, want) {
+		t.Errorf("auto delete set to %q, want %q", autoDeletes, want)
+	}
+
+	if inserted.Labels["team"] != "dev" || inserted.Labels[gcloud.ManagedByLabel] != gcloud.ManagedByValue {
+		t.Errorf("labels = %v, want the labels of the old instance", inserted.Labels)
+	}
+	reattached := []string{}
+	for _, disk := range inserted.Disks {
+		if disk.Boot {
+			if disk.Source != "" {
+				t.Errorf("boot disk source = %q, want a new boot disk", disk.Source)
+			}
+			continue
+		}
+		if disk.AutoDelete {
+			t.Errorf("disk %s is reattached with auto delete", disk.DeviceName)
+		}
+		reattached = append(reattached, disk.DeviceName+" "+disk.Mode+" "+disk.Source)
+	}
+	wantReattached := []string{
+		"home READ_WRITE " + diskSource + "home",
+		"data READ_ONLY " + diskSource + "data",
+	}
+	if !reflect.DeepEqual(reattached, wantReattached) {
+		t.Errorf("reattached disks = %q, want %q", reattached, wantReattached)
+	}
+}
+
+func TestRecreateWithoutReusableDisks(t *testing.T) {
+	tests := []struct {
+		name    string
+		disks   []map[string]interface{}
+		wantErr string
+	}{
+		{
+			name:    "only a boot disk",
+			disks:   []map[string]interface{}{{"boot": true, "autoDelete": true, "deviceName": "boot", "type": "PERSISTENT"}},
+			wantErr: "has no data disks to keep",
+		},
+		{
+			name: "only local ssds",
+			disks: []map[string]interface{}{
+				{"boot": true, "autoDelete": true, "deviceName": "boot", "type": "PERSISTENT"},
+				{"autoDelete": true, "deviceName": "local-ssd-0", "type": "SCRATCH"},
+			},
+			wantErr: "has no data disks to keep",
+		},
+		{name: "no instance", wantErr: "instance devpod-test doesn't exist"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake, options := newFakeCreate(t)
+			fake.handle(http.MethodGet, "/instances/devpod-test", func(w http.ResponseWriter, r *http.Request) {
+				if test.disks == nil {
+					writeError(w, http.StatusNotFound, "notFound")
+					return
+				}
+				writeJSON(w, map[string]interface{}{"name": "devpod-test", "status": "RUNNING", "disks": test.disks})
+			})
+
+			err := (&RecreateCmd{}).Run(context.Background(), options, discardLogger())
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("Run() error = %v, want %q", err, test.wantErr)
+			}
+			if requests := fake.requested(); !reflect.DeepEqual(requests, []string{"GET /instances/devpod-test"}) {
+				t.Errorf("requests = %q, want only the lookup of the instance", requests)
+			}
+		})
+	}
+}
+
+func TestRecreateInstanceTemplate(t *testing.T) {
+	fake, options := newFakeCompute(t)
+	options.InstanceTemplate = "dev-template"
+
+	err := (&RecreateCmd{}).recreate(context.Background(), options, discardLogger())
+	if err == nil || !strings.Contains(err.Error(), "INSTANCE_TEMPLATE") {
+		t.Fatalf("recreate() error = %v, want INSTANCE_TEMPLATE isn't supported", err)
+	}
+	if requests := fake.requested(); len(requests) != 0 {
+		t.Errorf("requests = %q, want none", requests)
+	}
+}
+
+func TestRecreateBootDiskName(t *testing.T) {
+	fake, options := newFakeCompute(t)
+	options.BootDiskName = "workspace"
+
+	err := (&RecreateCmd{}).recreate(context.Background(), options, discardLogger())
+	if err == nil || !strings.Contains(err.Error(), "BOOT_DISK_NAME") {
+		t.Fatalf("recreate() error = %v, want the BOOT_DISK_NAME disk to be kept", err)
+	}
+	if requests := fake.requested(); len(requests) != 0 {
+		t.Errorf("requests = %q, want none", requests)
+	}
+}
@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/badal-io/devpod-provider-gcloud/pkg/options"
+	"github.com/loft-sh/devpod/pkg/log"
+	"github.com/spf13/cobra"
+)
+
+// ResizeDiskCmd holds the cmd flags
+type ResizeDiskCmd struct {
+	Size           int64
+	GrowFilesystem bool
+}
+
+// NewResizeDiskCmd defines a command
+func NewResizeDiskCmd() *cobra.Command {
+	cmd := &ResizeDiskCmd{}
+	resizeDiskCmd := &cobra.Command{
+		Use:   "resize-disk",
+		Short: "Grow the boot disk of an instance",
+		RunE: func(cobraCmd *cobra.Command, args []string) error {
+			options, err := options.FromEnv(true, true)
+			if err != nil {
+				return err
+			}
+
+			return runWithTimeout(cobraCmd.Context(), options, func(ctx context.Context) error {
+				return cmd.Run(ctx, options, log.Default)
+			})
+		},
+	}
+
+	resizeDiskCmd.Flags().Int64Var(&cmd.Size, "size", 0, "The new size of the boot disk in GB, it must not be smaller than the current size")
+	resizeDiskCmd.Flags().BoolVar(&cmd.GrowFilesystem, "grow-filesystem", false, "Grow the root partition and filesystem of a running instance over ssh")
+	return resizeDiskCmd
+}
+
+// growFilesystemScript grows the partition of the root filesystem and the filesystem itself,
+// growpart exits with 1 if the partition already fills the disk
+const growFilesystemScript = `set -e
+source=$(findmnt -n -o SOURCE /)
+fstype=$(findmnt -n -o FSTYPE /)
+disk=/dev/$(lsblk -n -o PKNAME "$source")
+partition=$(cat /sys/class/block/$(basename "$source")/partition)
+sudo growpart "$disk" "$partition" || [ $? -eq 1 ]
+case "$fstype" in
+  ext2|ext3|ext4) sudo resize2fs "$source" ;;
+  xfs) sudo xfs_growfs / ;;
+  *) echo "unsupported filesystem $fstype, grow it manually" >&2; exit 1 ;;
+esac
+`
+
+// Run runs the command logic
+func (cmd *ResizeDiskCmd) Run(ctx context.Context, options *options.Options, log log.Logger) error {
+	if cmd.Size <= 0 {
+		return fmt.Errorf("--size must be a positive number of GB")
+	}
+
+	client, err := sharedClient(ctx, options)
+	if err != nil {
+		return err
+	}
+
+	instance, err := client.Get(ctx, options.MachineID)
+	if err != nil {
+		return err
+	} else if instance == nil {
+		return fmt.Errorf("instance %s doesn't exist", options.MachineID)
+	}
+
+	bootDisk := ""
+	for _, disk := range instance.GetDisks() {
+		if disk.GetBoot() {
+			bootDisk = path.Base(disk.GetSource())
+		}
+	}
+	if bootDisk == "" {
+		return fmt.Errorf("instance %s has no boot disk", options.MachineID)
+	}
+
+	log.Infof("Resizing boot disk %s of instance %s to %dGB", bootDisk, options.MachineID, cmd.Size)
+	err = client.ResizeDisk(ctx, bootDisk, cmd.Size)
+	if err != nil {
+		return fmt.Errorf("resize boot disk: %w", err)
+	}
+
+	if !cmd.GrowFilesystem {
+		return nil
+	} else if isContainerOptimizedOS(options) {
+		log.Infof("Container-Optimized OS grows its stateful partition on the next reboot")
+		return nil
+	} else if instance.GetStatus() != "RUNNING" {
+		log.Infof("Instance %s isn't running, the root filesystem is grown on the next boot by most images", options.MachineID)
+		return nil
+	}
+
+	privateKey, err := getPrivateKey(options)
+	if err != nil {
+		return fmt.Errorf("load private key: %w", err)
+	}
+
+	log.Infof("Growing the root filesystem of instance %s", options.MachineID)
+	return runOnInstance(ctx, options, instance, privateKey, growFilesystemScript, nil, os.Stdout, os.Stderr, log)
+}
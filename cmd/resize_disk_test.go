@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/compute/apiv1/computepb"
+	"github.com/loft-sh/devpod/pkg/log"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+func TestResizeDisk(t *testing.T) {
+	const diskSource = "https://www.googleapis.com/compute/v1/projects/test-project/zones/europe-west1-b/disks/devpod-test"
+	tests := []struct {
+		name           string
+		size           int64
+		growFilesystem bool
+		disks          []map[string]interface{}
+		missing        bool
+		wantRequests   []string
+		wantErr        string
+		wantLog        string
+	}{
+		{
+			name:         "grow",
+			size:         60,
+			disks:        []map[string]interface{}{{"boot": true, "source": diskSource}},
+			wantRequests: []string{"GET /instances/devpod-test", "GET /disks/devpod-test", "POST /disks/devpod-test/resize"},
+		},
+		{
+			name:           "grow the filesystem of a stopped instance",
+			size:           60,
+			growFilesystem: true,
+			disks:          []map[string]interface{}{{"boot": true, "source": diskSource}},
+			wantRequests:   []string{"GET /instances/devpod-test", "GET /disks/devpod-test", "POST /disks/devpod-test/resize"},
+			wantLog:        "isn't running, the root filesystem is grown on the next boot",
+		},
+		{
+			name:         "shrink",
+			size:         20,
+			disks:        []map[string]interface{}{{"boot": true, "source": diskSource}},
+			wantRequests: []string{"GET /instances/devpod-test", "GET /disks/devpod-test"},
+			wantErr:      "can't be shrunk from 40GB to 20GB",
+		},
+		{
+			name:         "invalid size",
+			wantRequests: []string{},
+			wantErr:      "--size must be a positive number of GB",
+		},
+		{
+			name:         "no boot disk",
+			size:         60,
+			disks:        []map[string]interface{}{{"source": diskSource + "-data"}},
+			wantRequests: []string{"GET /instances/devpod-test"},
+			wantErr:      "has no boot disk",
+		},
+		{
+			name:         "missing instance",
+			size:         60,
+			missing:      true,
+			wantRequests: []string{"GET /instances/devpod-test"},
+			wantErr:      "instance devpod-test doesn't exist",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake, options := newFakeCompute(t)
+			if !test.missing {
+				fake.handle(http.MethodGet, "/instances/devpod-test", func(w http.ResponseWriter, r *http.Request) {
+					writeJSON(w, map[string]interface{}{"name": "devpod-test", "status": "TERMINATED", "disks": test.disks})
+				})
+			}
+			fake.handle(http.MethodGet, "/disks/devpod-test", func(w http.ResponseWriter, r *http.Request) {
+				writeJSON(w, map[string]string{"name": "devpod-test", "sizeGb": "40"})
+			})
+			var resize computepb.DisksResizeRequest
+			fake.handle(http.MethodPost, "/disks/devpod-test/resize", func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				if err := protojson.Unmarshal(body, &resize); err != nil {
+					t.Error(err)
+				}
+				writeOperation(w, "op-resize")
+			})
+
+			out := &bytes.Buffer{}
+			cmd := &ResizeDiskCmd{Size: test.size, GrowFilesystem: test.growFilesystem}
+			err := cmd.Run(context.Background(), options, log.NewStreamLogger(out, out, logrus.InfoLevel))
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("Run() error = %v, want %q", err, test.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("Run() error = %v", err)
+			} else if resize.GetSizeGb() != test.size {
+				t.Errorf("resize size = %d, want %d", resize.GetSizeGb(), test.size)
+			}
+
+			if requests := apiRequests(fake); !reflect.DeepEqual(requests, test.wantRequests) {
+				t.Errorf("requests = %q, want %q", requests, test.wantRequests)
+			}
+			if !strings.Contains(out.String(), test.wantLog) {
+				t.Errorf("log = %q, want %q", out.String(), test.wantLog)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/badal-io/devpod-provider-gcloud/pkg/options"
+	"github.com/loft-sh/devpod/pkg/log"
+	"github.com/spf13/cobra"
+)
+
+// ResumeCmd holds the cmd flags
+type ResumeCmd struct{}
+
+// NewResumeCmd defines a command
+func NewResumeCmd() *cobra.Command {
+	cmd := &ResumeCmd{}
+	resumeCmd := &cobra.Command{
+		Use:   "resume",
+		Short: "Start a paused instance with the external ip reserved by pause",
+		RunE: func(cobraCmd *cobra.Command, args []string) error {
+			options, err := options.FromEnv(true, true)
+			if err != nil {
+				return err
+			}
+
+			return runWithTimeout(cobraCmd.Context(), options, func(ctx context.Context) error {
+				return cmd.Run(ctx, options, log.Default)
+			})
+		},
+	}
+
+	return resumeCmd
+}
+
+// Run runs the command logic
+func (cmd *ResumeCmd) Run(ctx context.Context, options *options.Options, log log.Logger) error {
+	client, err := sharedClient(ctx, options)
+	if err != nil {
+		return err
+	}
+
+	instance, err := client.Get(ctx, options.MachineID)
+	if err != nil {
+		return err
+	} else if instance == nil {
+		return fmt.Errorf("instance %s doesn't exist", options.MachineID)
+	}
+
+	name := pausedAddressName(options.MachineID)
+	reserved, err := client.GetAddress(ctx, regionFromZone(options.Zone), name)
+	if err != nil {
+		return fmt.Errorf("get address %s: %w", name, err)
+	}
+
+	// a promoted address stays attached while the instance is stopped, it only has to be
+	// reattached if the access config of the instance was changed in the meantime
+	if reserved != nil && len(instance.GetNetworkInterfaces()) > 0 && externalIP(instance) != reserved.GetAddress() {
+		log.Infof("Reattaching external ip %s to instance %s", reserved.GetAddress(), options.MachineID)
+		err = client.SetExternalIP(ctx, options.MachineID, instance.GetNetworkInterfaces()[0], reserved.GetAddress(), reserved.GetNetworkTier())
+		if err != nil {
+			return fmt.Errorf("reattach external ip %s: %w", reserved.GetAddress(), err)
+		}
+	}
+
+	return (&StartCmd{}).Run(ctx, options, log)
+}
@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"cloud.google.com/go/compute/apiv1/computepb"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+func TestResume(t *testing.T) {
+	tests := []struct {
+		name         string
+		natIP        string
+		reserved     string
+		wantRequests []string
+	}{
+		{
+			name:     "address still attached",
+			natIP:    "203.0.113.10",
+			reserved: "203.0.113.10",
+			wantRequests: []string{
+				"GET /instances/devpod-test",
+				"GET /regions/europe-west1/addresses/devpod-test-ip",
+				"GET /instances/devpod-test",
+				"POST /instances/devpod-test/start",
+			},
+		},
+		{
+			name:     "access config replaced",
+			natIP:    "203.0.113.20",
+			reserved: "203.0.113.10",
+			wantRequests: []string{
+				"GET /instances/devpod-test",
+				"GET /regions/europe-west1/addresses/devpod-test-ip",
+				"POST /instances/devpod-test/deleteAccessConfig",
+				"POST /instances/devpod-test/addAccessConfig",
+				"GET /instances/devpod-test",
+				"POST /instances/devpod-test/start",
+			},
+		},
+		{
+			name:  "not paused",
+			natIP: "203.0.113.20",
+			wantRequests: []string{
+				"GET /instances/devpod-test",
+				"GET /regions/europe-west1/addresses/devpod-test-ip",
+				"GET /instances/devpod-test",
+				"POST /instances/devpod-test/start",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake, options := newFakeCompute(t)
+			fake.handle(http.MethodGet, "/instances/devpod-test", func(w http.ResponseWriter, r *http.Request) {
+				writeJSON(w, map[string]interface{}{
+					"name":   "devpod-test",
+					"status": "TERMINATED",
+					"networkInterfaces": []map[string]interface{}{{
+						"name":          "nic0",
+						"accessConfigs": []map[string]string{{"name": "External NAT", "natIP": test.natIP}},
+					}},
+				})
+			})
+			if test.reserved != "" {
+				fake.handleProject(http.MethodGet, "/regions/europe-west1/addresses/devpod-test-ip", func(w http.ResponseWriter, r *http.Request) {
+					writeJSON(w, map[string]string{"name": "devpod-test-ip", "address": test.reserved, "networkTier": "PREMIUM"})
+				})
+			}
+			fake.handle(http.MethodPost, "/instances/devpod-test/deleteAccessConfig", func(w http.ResponseWriter, r *http.Request) {
+				writeOperation(w, "op-delete-access-config")
+			})
+			var added computepb.AccessConfig
+			fake.handle(http.MethodPost, "/instances/devpod-test/addAccessConfig", func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				if err := protojson.Unmarshal(body, &added); err != nil {
+					t.Error(err)
+				}
+				writeOperation(w, "op-add-access-config")
+			})
+			fake.handle(http.MethodPost, "/instances/devpod-test/start", func(w http.ResponseWriter, r *http.Request) {
+				writeOperation(w, "op-start")
+			})
+
+			if err := (&ResumeCmd{}).Run(context.Background(), options, discardLogger()); err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+
+			if requests := apiRequests(fake); !reflect.DeepEqual(requests, test.wantRequests) {
+				t.Errorf("requests = %q, want %q", requests, test.wantRequests)
+			}
+			if test.natIP != test.reserved && test.reserved != "" && (added.GetNatIP() != test.reserved || added.GetName() != "External NAT" || added.GetNetworkTier() != "PREMIUM") {
+				t.Errorf("added access config = %v, want the reserved address %s", &added, test.reserved)
+			}
+		})
+	}
+}
@@ -1,11 +1,22 @@
 package cmd
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/badal-io/devpod-provider-gcloud/pkg/gcloud"
+	"github.com/badal-io/devpod-provider-gcloud/pkg/options"
 	log2 "github.com/loft-sh/devpod/pkg/log"
 	"github.com/spf13/cobra"
 	"golang.org/x/crypto/ssh"
-	"os"
-	"os/exec"
 )
 
 // NewRootCmd returns a new root command
@@ -17,22 +28,96 @@ func NewRootCmd() *cobra.Command {
 		SilenceUsage:  true,
 
 		PersistentPreRunE: func(cobraCmd *cobra.Command, args []string) error {
-			log2.Default.MakeRaw()
-			return nil
+			return setupLogger(log2.Default, os.Getenv("LOG_FORMAT"))
 		},
 	}
 
 	return gcloudCmd
 }
 
+// setupLogger configures the output format of the logger, which is the default logger
+// passed to every command
+func setupLogger(logger *log2.StreamLogger, format string) error {
+	switch format {
+	case "", "text":
+		logger.MakeRaw()
+	case "json":
+		// emits one record per line with level, time and message fields
+		logger.SetFormat(log2.JSONFormat)
+	default:
+		return fmt.Errorf("unsupported LOG_FORMAT %q, must be one of text, json", format)
+	}
+
+	return nil
+}
+
+// sharedClient returns the shared client for the project and zone of the options with the
+// api call options applied
+func sharedClient(ctx context.Context, options *options.Options) (*gcloud.Client, error) {
+	client, err := gcloud.SharedClient(ctx, options.Project, options.Zone)
+	if err != nil {
+		return nil, err
+	}
+
+	client.SetCallOptions(options.MaxAPIRetries, options.APICallTimeout)
+	client.SetWorkspace(sanitizeLabelValue(options.WorkspaceID))
+	return client, nil
+}
+
+// runWithTimeout runs fn with a context derived from ctx that is canceled after the configured operation timeout
+func runWithTimeout(ctx context.Context, options *options.Options, fn func(ctx context.Context) error) error {
+	ctx, cancel := context.WithTimeout(ctx, options.OperationTimeout)
+	defer cancel()
+
+	err := fn(ctx)
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("operation timed out after %s, consider increasing OPERATION_TIMEOUT: %w", options.OperationTimeout, err)
+	}
+
+	return err
+}
+
+// sleepContext waits for the given duration or until ctx is done, tests replace it to
+// skip the waits
+var sleepContext = func(ctx context.Context, duration time.Duration) error {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+var (
+	jitterMutex  sync.Mutex
+	jitterSource = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// withJitter adds a random jitter of up to a fifth to the duration
+func withJitter(duration time.Duration) time.Duration {
+	jitterMutex.Lock()
+	defer jitterMutex.Unlock()
+
+	return duration + time.Duration(jitterSource.Int63n(int64(duration)/5+1))
+}
+
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
 	// build the root command
 	rootCmd := BuildRoot()
 
+	// cancel the running command on interrupt so it can clean up
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+
 	// execute command
-	err := rootCmd.Execute()
+	err := rootCmd.ExecuteContext(ctx)
+	stop()
+	_ = gcloud.CloseAll()
+	_ = gcloud.CleanupEnvJson()
 	if err != nil {
 		if exitErr, ok := err.(*ssh.ExitError); ok {
 			os.Exit(exitErr.ExitStatus())
@@ -60,5 +145,19 @@ func BuildRoot() *cobra.Command {
 	rootCmd.AddCommand(NewCommandCmd())
 	rootCmd.AddCommand(NewTokenCmd())
 	rootCmd.AddCommand(NewInitCmd())
+	rootCmd.AddCommand(NewBatchCmd())
+	rootCmd.AddCommand(NewPortForwardCmd())
+	rootCmd.AddCommand(NewDescribeCmd())
+	rootCmd.AddCommand(NewSSHConfigCmd())
+	rootCmd.AddCommand(NewLogsCmd())
+	rootCmd.AddCommand(NewWaitCmd())
+	rootCmd.AddCommand(NewRecreateCmd())
+	rootCmd.AddCommand(NewDoctorCmd())
+	rootCmd.AddCommand(NewRotateKeyCmd())
+	rootCmd.AddCommand(NewUpdateCmd())
+	rootCmd.AddCommand(NewPauseCmd())
+	rootCmd.AddCommand(NewResumeCmd())
+	rootCmd.AddCommand(NewResizeDiskCmd())
+	rootCmd.AddCommand(NewProxyConnectCmd())
 	return rootCmd
 }
@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/loft-sh/devpod/pkg/log"
+	"github.com/sirupsen/logrus"
+)
+
+func TestSetupLoggerJSON(t *testing.T) {
+	out := &bytes.Buffer{}
+	logger := log.NewStreamLogger(out, out, logrus.InfoLevel)
+	if err := setupLogger(logger, "json"); err != nil {
+		t.Fatal(err)
+	}
+
+	logger.Info("Waiting for instance to be fully ready")
+	logger.Warnf("Skipping the Cloud NAT check")
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("logged %d lines, want one record per message: %q", len(lines), out.String())
+	}
+
+	want := []struct {
+		level   string
+		message string
+	}{
+		{level: "info", message: "Waiting for instance to be fully ready"},
+		{level: "warning", message: "Skipping the Cloud NAT check"},
+	}
+	for i, line := range lines {
+		record := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("line %q isn't json: %v", line, err)
+		}
+
+		if record["level"] != want[i].level {
+			t.Errorf("level = %v, want %s", record["level"], want[i].level)
+		}
+		if record["message"] != want[i].message {
+			t.Errorf("message = %v, want %s", record["message"], want[i].message)
+		}
+		if timestamp, _ := record["time"].(string); timestamp == "" {
+			t.Errorf("record %q has no time", line)
+		} else if _, err := time.Parse(time.RFC3339Nano, timestamp); err != nil {
+			t.Errorf("time %q isn't RFC 3339: %v", timestamp, err)
+		}
+	}
+}
+
+func TestSetupLoggerText(t *testing.T) {
+	for name, format := range map[string]string{"unset": "", "text": "text"} {
+		t.Run(name, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			logger := log.NewStreamLogger(out, out, logrus.InfoLevel)
+			if err := setupLogger(logger, format); err != nil {
+				t.Fatal(err)
+			}
+
+			logger.Info("Waiting for instance to be fully ready")
+			if got := out.String(); got != "Waiting for instance to be fully ready\n" {
+				t.Errorf("logged %q, want the plain message", got)
+			}
+		})
+	}
+}
+
+func TestSetupLoggerUnsupportedFormat(t *testing.T) {
+	logger := log.NewStreamLogger(&bytes.Buffer{}, &bytes.Buffer{}, logrus.InfoLevel)
+	if err := setupLogger(logger, "xml"); err == nil || !strings.Contains(err.Error(), "unsupported LOG_FORMAT") {
+		t.Errorf("setupLogger() error = %v, want unsupported LOG_FORMAT", err)
+	}
+}
@@ -0,0 +1,122 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"cloud.google.com/go/compute/apiv1/computepb"
+	"github.com/badal-io/devpod-provider-gcloud/pkg/options"
+	"github.com/badal-io/devpod-provider-gcloud/pkg/ptr"
+	"github.com/loft-sh/devpod/pkg/log"
+	"github.com/spf13/cobra"
+)
+
+// RotateKeyCmd holds the cmd flags
+type RotateKeyCmd struct{}
+
+// NewRotateKeyCmd defines a command
+func NewRotateKeyCmd() *cobra.Command {
+	cmd := &RotateKeyCmd{}
+	rotateKeyCmd := &cobra.Command{
+		Use:   "rotate-key",
+		Short: "Replace the ssh key of an instance with the current devpod key",
+		RunE: func(cobraCmd *cobra.Command, args []string) error {
+			options, err := options.FromEnv(true, true)
+			if err != nil {
+				return err
+			}
+
+			return runWithTimeout(cobraCmd.Context(), options, func(ctx context.Context) error {
+				return cmd.Run(ctx, options, log.Default)
+			})
+		},
+	}
+
+	return rotateKeyCmd
+}
+
+// Run runs the command logic
+func (cmd *RotateKeyCmd) Run(ctx context.Context, options *options.Options, log log.Logger) error {
+	client, err := sharedClient(ctx, options)
+	if err != nil {
+		return err
+	}
+
+	instance, err := client.Get(ctx, options.MachineID)
+	if err != nil {
+		return err
+	} else if instance == nil {
+		return fmt.Errorf("instance %s doesn't exist", options.MachineID)
+	}
+
+	publicKey, err := getPublicKey(options)
+	if err != nil {
+		return err
+	}
+
+	metadata, changed := mergeSSHKey(instance.GetMetadata(), publicKey)
+	if !changed {
+		log.Infof("Instance %s already has the current ssh key", options.MachineID)
+		return nil
+	}
+
+	// the guest environment picks up the new key from the metadata
+	log.Infof("Updating the ssh key of instance %s", options.MachineID)
+	return client.SetMetadata(ctx, options.MachineID, metadata)
+}
+
+// mergeSSHKey returns a copy of the metadata with the devpod key of the ssh-keys item replaced
+// by publicKey and whether it changed. The keys of other users and all other items, like the
+// startup-script, are kept.
+func mergeSSHKey(metadata *computepb.Metadata, publicKey string) (*computepb.Metadata, bool) {
+	devpodKey := "devpod:" + strings.TrimSpace(publicKey)
+	merged := &computepb.Metadata{
+		Fingerprint: ptr.Ptr(metadata.GetFingerprint()),
+	}
+
+	found := false
+	changed := false
+	for _, item := range metadata.GetItems() {
+		if item.GetKey() != "ssh-keys" {
+			merged.Items = append(merged.Items, item)
+			continue
+		}
+
+		lines := []string{}
+		for _, line := range strings.Split(item.GetValue(), "\n") {
+			if strings.TrimSpace(line) == "" {
+				continue
+			} else if !strings.HasPrefix(line, "devpod:") {
+				lines = append(lines, line)
+			} else if !found {
+				found = true
+				changed = changed || strings.TrimSpace(line) != devpodKey
+				lines = append(lines, devpodKey)
+			} else {
+				// duplicate devpod keys are dropped
+				changed = true
+			}
+		}
+		if !found {
+			found = true
+			changed = true
+			lines = append(lines, devpodKey)
+		}
+
+		merged.Items = append(merged.Items, &computepb.Items{
+			Key:   ptr.Ptr("ssh-keys"),
+			Value: ptr.Ptr(strings.Join(lines, "\n")),
+		})
+	}
+
+	if !found {
+		changed = true
+		merged.Items = append(merged.Items, &computepb.Items{
+			Key:   ptr.Ptr("ssh-keys"),
+			Value: ptr.Ptr(devpodKey),
+		})
+	}
+
+	return merged, changed
+}
@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+
+	"cloud.google.com/go/compute/apiv1/computepb"
+	"github.com/badal-io/devpod-provider-gcloud/pkg/ptr"
+)
+
+func TestMergeSSHKey(t *testing.T) {
+	startupScript := &computepb.Items{Key: ptr.Ptr("startup-script"), Value: ptr.Ptr("#!/bin/sh\necho started")}
+	tests := []struct {
+		name        string
+		items       []*computepb.Items
+		wantItems   map[string]string
+		wantChanged bool
+	}{
+		{
+			name:        "no ssh keys",
+			items:       []*computepb.Items{startupScript},
+			wantItems:   map[string]string{"startup-script": "#!/bin/sh\necho started", "ssh-keys": "devpod:ssh-ed25519 NEW"},
+			wantChanged: true,
+		},
+		{
+			name: "keys of other users",
+			items: []*computepb.Items{
+				startupScript,
+				{Key: ptr.Ptr("ssh-keys"), Value: ptr.Ptr("alice:ssh-rsa ALICE\nbob:ssh-rsa BOB\n")},
+			},
+			wantItems:   map[string]string{"startup-script": "#!/bin/sh\necho started", "ssh-keys": "alice:ssh-rsa ALICE\nbob:ssh-rsa BOB\ndevpod:ssh-ed25519 NEW"},
+			wantChanged: true,
+		},
+		{
+			name: "rotated devpod key",
+			items: []*computepb.Items{
+				startupScript,
+				{Key: ptr.Ptr("ssh-keys"), Value: ptr.Ptr("alice:ssh-rsa ALICE\ndevpod:ssh-ed25519 OLD\nbob:ssh-rsa BOB")},
+			},
+			wantItems:   map[string]string{"startup-script": "#!/bin/sh\necho started", "ssh-keys": "alice:ssh-rsa ALICE\ndevpod:ssh-ed25519 NEW\nbob:ssh-rsa BOB"},
+			wantChanged: true,
+		},
+		{
+			name: "duplicate devpod keys",
+			items: []*computepb.Items{
+				{Key: ptr.Ptr("ssh-keys"), Value: ptr.Ptr("devpod:ssh-ed25519 NEW\ndevpod:ssh-ed25519 OLD")},
+			},
+			wantItems:   map[string]string{"ssh-keys": "devpod:ssh-ed25519 NEW"},
+			wantChanged: true,
+		},
+		{
+			name: "current devpod key",
+			items: []*computepb.Items{
+				startupScript,
+				{Key: ptr.Ptr("ssh-keys"), Value: ptr.Ptr("alice:ssh-rsa ALICE\ndevpod:ssh-ed25519 NEW")},
+			},
+			wantItems: map[string]string{"startup-script": "#!/bin/sh\necho started", "ssh-keys": "alice:ssh-rsa ALICE\ndevpod:ssh-ed25519 NEW"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			metadata := &computepb.Metadata{Fingerprint: ptr.Ptr("fingerprint"), Items: test.items}
+			merged, changed := mergeSSHKey(metadata, "ssh-ed25519 NEW\n")
+			if changed != test.wantChanged {
+				t.Errorf("mergeSSHKey() changed = %t, want %t", changed, test.wantChanged)
+			}
+			if merged.GetFingerprint() != "fingerprint" {
+				t.Errorf("mergeSSHKey() fingerprint = %q, want the one of the current metadata", merged.GetFingerprint())
+			}
+
+			items := map[string]string{}
+			for _, item := range merged.GetItems() {
+				if _, ok := items[item.GetKey()]; ok {
+					t.Errorf("mergeSSHKey() item %s is duplicated", item.GetKey())
+				}
+				items[item.GetKey()] = item.GetValue()
+			}
+			if !reflect.DeepEqual(items, test.wantItems) {
+				t.Errorf("mergeSSHKey() items = %q, want %q", items, test.wantItems)
+			}
+		})
+	}
+}
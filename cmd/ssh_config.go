@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/badal-io/devpod-provider-gcloud/pkg/options"
+	"github.com/loft-sh/devpod/pkg/log"
+	"github.com/spf13/cobra"
+)
+
+// SSHConfigCmd holds the cmd flags
+type SSHConfigCmd struct{}
+
+// NewSSHConfigCmd defines a command
+func NewSSHConfigCmd() *cobra.Command {
+	cmd := &SSHConfigCmd{}
+	sshConfigCmd := &cobra.Command{
+		Use:   "ssh-config",
+		Short: "Regenerate the IAP ssh config of an existing instance",
+		RunE: func(cobraCmd *cobra.Command, args []string) error {
+			options, err := options.FromEnv(true, true)
+			if err != nil {
+				return err
+			}
+
+			return runWithTimeout(cobraCmd.Context(), options, func(ctx context.Context) error {
+				return cmd.Run(ctx, options, log.Default)
+			})
+		},
+	}
+
+	return sshConfigCmd
+}
+
+// Run runs the command logic
+func (cmd *SSHConfigCmd) Run(ctx context.Context, options *options.Options, log log.Logger) error {
+	if options.PublicIP {
+		return fmt.Errorf("the ssh config is only used for instances without a public ip, set PUBLIC_IP_ENABLED=false")
+	}
+
+	client, err := sharedClient(ctx, options)
+	if err != nil {
+		return err
+	}
+
+	instance, err := client.Get(ctx, options.MachineID)
+	if err != nil {
+		return err
+	} else if instance == nil {
+		return fmt.Errorf("instance %s doesn't exist", options.MachineID)
+	}
+
+	strictHostKeyChecking := false
+	if options.StrictHostKeyChecking {
+		strictHostKeyChecking = writeKnownHosts(ctx, client, options, log)
+	}
+
+	err = configureSSHForIAP(options, strictHostKeyChecking)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Wrote ssh config to %s", options.SSHConfigFile())
+	return nil
+}
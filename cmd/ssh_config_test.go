@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestSSHConfig(t *testing.T) {
+	tests := []struct {
+		name                  string
+		publicIP              bool
+		instance              bool
+		strictHostKeyChecking bool
+		want                  string
+		wantErr               string
+	}{
+		{name: "iap", instance: true, want: "    StrictHostKeyChecking no\n"},
+		{name: "strict host key checking", instance: true, strictHostKeyChecking: true, want: "    StrictHostKeyChecking yes\n"},
+		{name: "public ip", publicIP: true, instance: true, wantErr: "set PUBLIC_IP_ENABLED=false"},
+		{name: "missing instance", wantErr: "instance devpod-test doesn't exist"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake, options := newFakeCompute(t)
+			options.PublicIP = test.publicIP
+			options.StrictHostKeyChecking = test.strictHostKeyChecking
+			if test.instance {
+				fake.handle(http.MethodGet, "/instances/devpod-test", func(w http.ResponseWriter, r *http.Request) {
+					writeJSON(w, map[string]string{"name": "devpod-test", "status": "RUNNING"})
+				})
+			}
+			fake.handle(http.MethodGet, "/instances/devpod-test/getGuestAttributes", func(w http.ResponseWriter, r *http.Request) {
+				writeJSON(w, map[string]interface{}{"queryValue": map[string]interface{}{"items": []map[string]string{
+					{"namespace": "hostkeys", "key": "ssh-ed25519", "value": "AAAAed25519"},
+				}}})
+			})
+
+			err := (&SSHConfigCmd{}).Run(context.Background(), options, discardLogger())
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Errorf("Run() error = %v, want %q", err, test.wantErr)
+				}
+				if _, err := os.Stat(options.SSHConfigFile()); err == nil {
+					t.Error("the ssh config was written")
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+
+			sshConfig, err := os.ReadFile(options.SSHConfigFile())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(sshConfig), "Host devpod-test\n") || !strings.Contains(string(sshConfig), test.want) {
+				t.Errorf("ssh config = %q, want %q", sshConfig, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+
+	"github.com/badal-io/devpod-provider-gcloud/pkg/options"
+	"github.com/loft-sh/devpod/pkg/ssh"
+	"github.com/pkg/errors"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+const (
+	ed25519PrivateKeyFile = "id_devpod_ed25519"
+	ed25519PublicKeyFile  = "id_devpod_ed25519.pub"
+)
+
+// sshKeyFile returns the path of the private key used to connect to the instance
+func sshKeyFile(options *options.Options) string {
+	if options.SSHKeyType == "ed25519" {
+		return filepath.Join(options.MachineFolder, ed25519PrivateKeyFile)
+	}
+
+	return filepath.Join(options.MachineFolder, ssh.DevPodSSHPrivateKeyFile)
+}
+
+// getPublicKey returns the public key in authorized_keys format, the key pair of the
+// configured type is generated in the machine folder if it doesn't exist yet
+func getPublicKey(options *options.Options) (string, error) {
+	if options.SSHKeyType == "ed25519" {
+		err := ensureED25519Key(options.MachineFolder)
+		if err != nil {
+			return "", err
+		}
+
+		out, err := os.ReadFile(filepath.Join(options.MachineFolder, ed25519PublicKeyFile))
+		if err != nil {
+			return "", errors.Wrap(err, "read public ssh key")
+		}
+
+		return string(out), nil
+	}
+
+	publicKeyBase, err := ssh.GetPublicKeyBase(options.MachineFolder)
+	if err != nil {
+		return "", err
+	}
+
+	publicKey, err := base64.StdEncoding.DecodeString(publicKeyBase)
+	if err != nil {
+		return "", err
+	}
+
+	return string(publicKey), nil
+}
+
+// getPrivateKey returns the private key of the configured type
+func getPrivateKey(options *options.Options) ([]byte, error) {
+	if options.SSHKeyType == "ed25519" {
+		err := ensureED25519Key(options.MachineFolder)
+		if err != nil {
+			return nil, err
+		}
+
+		out, err := os.ReadFile(filepath.Join(options.MachineFolder, ed25519PrivateKeyFile))
+		if err != nil {
+			return nil, errors.Wrap(err, "read private ssh key")
+		}
+
+		return out, nil
+	}
+
+	return ssh.GetPrivateKeyRawBase(options.MachineFolder)
+}
+
+// ensureED25519Key generates an ed25519 key pair in dir if it doesn't exist yet
+func ensureED25519Key(dir string) error {
+	privateKeyFile := filepath.Join(dir, ed25519PrivateKeyFile)
+	_, err := os.Stat(privateKeyFile)
+	if err == nil {
+		return nil
+	}
+
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	publicKeyRaw, privateKeyRaw, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return errors.Wrap(err, "generate key pair")
+	}
+
+	block, err := gossh.MarshalPrivateKey(privateKeyRaw, "")
+	if err != nil {
+		return errors.Wrap(err, "marshal private ssh key")
+	}
+
+	publicKey, err := gossh.NewPublicKey(publicKeyRaw)
+	if err != nil {
+		return errors.Wrap(err, "marshal public ssh key")
+	}
+
+	err = os.WriteFile(filepath.Join(dir, ed25519PublicKeyFile), gossh.MarshalAuthorizedKey(publicKey), 0644)
+	if err != nil {
+		return errors.Wrap(err, "write public ssh key")
+	}
+
+	err = os.WriteFile(privateKeyFile, pem.EncodeToMemory(block), 0600)
+	if err != nil {
+		return errors.Wrap(err, "write private ssh key")
+	}
+
+	return nil
+}
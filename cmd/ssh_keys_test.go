@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/badal-io/devpod-provider-gcloud/pkg/options"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func TestSSHKeys(t *testing.T) {
+	tests := []struct {
+		sshKeyType  string
+		wantType    string
+		wantKeyFile string
+	}{
+		{sshKeyType: "rsa", wantType: gossh.KeyAlgoRSA, wantKeyFile: "id_devpod_rsa"},
+		{sshKeyType: "ed25519", wantType: gossh.KeyAlgoED25519, wantKeyFile: ed25519PrivateKeyFile},
+	}
+
+	for _, test := range tests {
+		t.Run(test.sshKeyType, func(t *testing.T) {
+			options := &options.Options{SSHKeyType: test.sshKeyType, MachineFolder: filepath.Join(t.TempDir(), "machine")}
+
+			publicKey, err := getPublicKey(options)
+			if err != nil {
+				t.Fatalf("getPublicKey() error = %v", err)
+			}
+			parsedPublicKey, _, _, _, err := gossh.ParseAuthorizedKey([]byte(publicKey))
+			if err != nil {
+				t.Fatalf("getPublicKey() = %q, want an authorized key: %v", publicKey, err)
+			} else if parsedPublicKey.Type() != test.wantType {
+				t.Errorf("public key type = %s, want %s", parsedPublicKey.Type(), test.wantType)
+			}
+
+			privateKey, err := getPrivateKey(options)
+			if err != nil {
+				t.Fatalf("getPrivateKey() error = %v", err)
+			}
+			signer, err := gossh.ParsePrivateKey(privateKey)
+			if err != nil {
+				t.Fatalf("parse private key: %v", err)
+			} else if !bytes.Equal(signer.PublicKey().Marshal(), parsedPublicKey.Marshal()) {
+				t.Error("the private key doesn't belong to the public key")
+			}
+
+			// the key pair is generated once and reused afterwards
+			again, err := getPublicKey(options)
+			if err != nil {
+				t.Fatal(err)
+			} else if again != publicKey {
+				t.Errorf("getPublicKey() = %q the second time, want %q", again, publicKey)
+			}
+
+			keyFile := sshKeyFile(options)
+			if keyFile != filepath.Join(options.MachineFolder, test.wantKeyFile) {
+				t.Errorf("sshKeyFile() = %s, want %s in the machine folder", keyFile, test.wantKeyFile)
+			}
+			info, err := os.Stat(keyFile)
+			if err != nil {
+				t.Fatalf("sshKeyFile() doesn't exist: %v", err)
+			} else if info.Mode().Perm()&0o077 != 0 {
+				t.Errorf("private key mode = %v, want it only readable by the user", info.Mode().Perm())
+			}
+		})
+	}
+}
@@ -0,0 +1,209 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/badal-io/devpod-provider-gcloud/pkg/options"
+	"github.com/loft-sh/devpod/pkg/ssh"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/net/proxy"
+)
+
+// ProxyConnectCmd holds the cmd flags
+type ProxyConnectCmd struct{}
+
+// NewProxyConnectCmd defines a command, it's used as the ProxyCommand of the system ssh
+// to connect through the SSH_PROXY
+func NewProxyConnectCmd() *cobra.Command {
+	cmd := &ProxyConnectCmd{}
+	proxyConnectCmd := &cobra.Command{
+		Use:    "proxy-connect PROXY_URL HOST PORT",
+		Short:  "Connect stdin and stdout to the host through the proxy",
+		Hidden: true,
+		Args:   cobra.ExactArgs(3),
+		RunE: func(cobraCmd *cobra.Command, args []string) error {
+			return cmd.Run(cobraCmd.Context(), args[0], net.JoinHostPort(args[1], args[2]))
+		},
+	}
+
+	return proxyConnectCmd
+}
+
+// Run runs the command logic
+func (cmd *ProxyConnectCmd) Run(ctx context.Context, proxyURL, addr string) error {
+	dialer, err := sshProxyDialer(proxyURL)
+	if err != nil {
+		return err
+	}
+
+	conn, err := dialer.Dial("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("dial %s through proxy: %w", addr, err)
+	}
+	defer conn.Close()
+
+	// the connection is done once either side closes it
+	done := make(chan error, 2)
+	go func() {
+		_, err := io.Copy(conn, os.Stdin)
+		done <- err
+	}()
+	go func() {
+		_, err := io.Copy(os.Stdout, conn)
+		done <- err
+	}()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err := <-done:
+		return err
+	}
+}
+
+// newSSHClient connects to the ssh server at addr as the devpod user, through the SSH_PROXY if set
+func newSSHClient(options *options.Options, addr string, privateKey []byte) (*gossh.Client, error) {
+	if options.SSHProxy == "" {
+		return ssh.NewSSHClient("devpod", addr, privateKey)
+	}
+
+	dialer, err := sshProxyDialer(options.SSHProxy)
+	if err != nil {
+		return nil, err
+	}
+
+	sshConfig, err := ssh.ConfigFromKeyBytes(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	sshConfig.User = "devpod"
+
+	conn, err := dialer.Dial("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("dial to %v through proxy failed: %w", addr, err)
+	}
+
+	clientConn, channels, requests, err := gossh.NewClientConn(conn, addr, sshConfig)
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("dial to %v through proxy failed: %w", addr, err)
+	}
+
+	return gossh.NewClient(clientConn, channels, requests), nil
+}
+
+// sshProxyDialer returns the dialer of the proxy url, socks5 proxies are supported by
+// golang.org/x/net/proxy while http proxies are tunneled through with CONNECT
+func sshProxyDialer(proxyURL string) (proxy.Dialer, error) {
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse SSH_PROXY")
+	}
+
+	if u.Scheme == "http" {
+		return &httpConnectDialer{proxyURL: u}, nil
+	}
+
+	return proxy.FromURL(u, proxy.Direct)
+}
+
+// httpConnectDialer opens connections through an http proxy with the CONNECT method
+type httpConnectDialer struct {
+	proxyURL *url.URL
+}
+
+func (d *httpConnectDialer) Dial(network, addr string) (net.Conn, error) {
+	conn, err := net.Dial(network, d.proxyURL.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: http.Header{},
+	}
+	if d.proxyURL.User != nil {
+		password, _ := d.proxyURL.User.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(d.proxyURL.User.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+
+	err = req.Write(conn)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		_ = conn.Close()
+		return nil, fmt.Errorf("proxy %s refused to connect to %s: %s", d.proxyURL.Host, addr, resp.Status)
+	}
+
+	// the ssh server sends its banner right away, so it might already be buffered
+	return &bufferedConn{Conn: conn, reader: reader}, nil
+}
+
+// bufferedConn reads from the buffered reader of the connection before reading from the connection
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+// proxyCommandConfig returns the ProxyCommand of the system ssh for the public ip path, which
+// connects through the SSH_PROXY with the provider binary
+func proxyCommandConfig(options *options.Options) (string, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return "", errors.Wrap(err, "find provider binary")
+	}
+
+	return fmt.Sprintf("ProxyCommand=%s proxy-connect %s %%h %%p", shellQuote(executable), shellQuote(options.SSHProxy)), nil
+}
+
+// gcloudProxyEnv returns the environment prefix of the IAP ProxyCommand that makes gcloud
+// connect to IAP through the SSH_PROXY. The ProxyCommand runs in the environment of whoever
+// starts ssh with the config, so the credentials of the proxy url can't be passed through
+// the environment of the provider and end up in plain text in the ssh config.
+func gcloudProxyEnv(options *options.Options) string {
+	if options.SSHProxy == "" {
+		return ""
+	}
+
+	// the proxy url was validated when the options were loaded
+	u, _ := url.Parse(options.SSHProxy)
+	proxyType := "socks5"
+	if u.Scheme == "http" {
+		proxyType = "http"
+	}
+
+	env := fmt.Sprintf("env CLOUDSDK_PROXY_TYPE=%s CLOUDSDK_PROXY_ADDRESS=%s CLOUDSDK_PROXY_PORT=%s ", proxyType, u.Hostname(), u.Port())
+	if u.User != nil {
+		password, _ := u.User.Password()
+		env += fmt.Sprintf("CLOUDSDK_PROXY_USERNAME=%s CLOUDSDK_PROXY_PASSWORD=%s ", shellQuote(u.User.Username()), shellQuote(password))
+	}
+
+	return env
+}
@@ -2,8 +2,9 @@ package cmd
 
 import (
 	"context"
+	"fmt"
+	"strings"
 
-	"github.com/badal-io/devpod-provider-gcloud/pkg/gcloud"
 	"github.com/badal-io/devpod-provider-gcloud/pkg/options"
 	"github.com/loft-sh/devpod/pkg/log"
 	"github.com/spf13/cobra"
@@ -18,13 +19,15 @@ func NewStartCmd() *cobra.Command {
 	startCmd := &cobra.Command{
 		Use:   "start",
 		Short: "Start an instance",
-		RunE: func(_ *cobra.Command, args []string) error {
+		RunE: func(cobraCmd *cobra.Command, args []string) error {
 			options, err := options.FromEnv(true, true)
 			if err != nil {
 				return err
 			}
 
-			return cmd.Run(context.Background(), options, log.Default)
+			return runWithTimeout(cobraCmd.Context(), options, func(ctx context.Context) error {
+				return cmd.Run(ctx, options, log.Default)
+			})
 		},
 	}
 
@@ -33,11 +36,28 @@ func NewStartCmd() *cobra.Command {
 
 // Run runs the command logic
 func (cmd *StartCmd) Run(ctx context.Context, options *options.Options, log log.Logger) error {
-	client, err := gcloud.NewClient(ctx, options.Project, options.Zone)
+	client, err := sharedClient(ctx, options)
 	if err != nil {
 		return err
 	}
-	defer client.Close()
 
-	return client.Start(ctx, options.MachineID)
+	instance, err := client.Get(ctx, options.MachineID)
+	if err != nil {
+		return err
+	} else if instance == nil {
+		return fmt.Errorf("instance %s doesn't exist", options.MachineID)
+	}
+
+	// only stopped instances can be started, so retries of a start succeed right away
+	switch status := instance.GetStatus(); status {
+	case "RUNNING", "PROVISIONING", "STAGING":
+		log.Debugf("Instance %s is already %s", options.MachineID, strings.ToLower(status))
+		return nil
+	case "TERMINATED":
+		return client.Start(ctx, options.MachineID)
+	case "SUSPENDED":
+		return client.Resume(ctx, options.MachineID)
+	default:
+		return fmt.Errorf("instance %s is %s, start it again once it's stopped", options.MachineID, strings.ToLower(status))
+	}
 }
@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestStart(t *testing.T) {
+	tests := []struct {
+		name         string
+		status       string
+		wantRequests []string
+		wantErr      string
+	}{
+		{name: "running", status: "RUNNING"},
+		{name: "staging", status: "STAGING"},
+		{name: "stopped", status: "TERMINATED", wantRequests: []string{"POST /instances/devpod-test/start"}},
+		{name: "suspended", status: "SUSPENDED", wantRequests: []string{"POST /instances/devpod-test/resume"}},
+		{name: "stopping", status: "STOPPING", wantErr: "instance devpod-test is stopping, start it again once it's stopped"},
+		{name: "missing", wantErr: "instance devpod-test doesn't exist"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake, options := newFakeCompute(t)
+			if test.status != "" {
+				fake.handle(http.MethodGet, "/instances/devpod-test", func(w http.ResponseWriter, r *http.Request) {
+					writeJSON(w, map[string]interface{}{"name": "devpod-test", "status": test.status})
+				})
+			}
+			for _, action := range []string{"start", "resume"} {
+				action := action
+				fake.handle(http.MethodPost, "/instances/devpod-test/"+action, func(w http.ResponseWriter, r *http.Request) {
+					writeOperation(w, "op-"+action)
+				})
+			}
+
+			err := (&StartCmd{}).Run(context.Background(), options, discardLogger())
+			if test.wantErr != "" {
+				if err == nil || err.Error() != test.wantErr {
+					t.Fatalf("Run() error = %v, want %q", err, test.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+
+			var requests []string
+			for _, request := range fake.requested() {
+				if strings.HasPrefix(request, "POST /instances/") {
+					requests = append(requests, request)
+				}
+			}
+			if !reflect.DeepEqual(requests, test.wantRequests) {
+				t.Errorf("requests = %q, want %q", requests, test.wantRequests)
+			}
+		})
+	}
+}
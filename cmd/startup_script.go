@@ -0,0 +1,281 @@
+package cmd
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/badal-io/devpod-provider-gcloud/pkg/options"
+)
+
+// createUserScript creates the devpod user for IAP (no public IP), since Google's
+// guest-agent doesn't auto-create users from metadata when connecting via IAP
+const createUserScript = `# Create devpod user if it doesn't exist (required for IAP SSH)
+if ! id -u devpod > /dev/null 2>&1; then
+  useradd -m -s /bin/bash devpod
+  usermod -aG sudo devpod
+  # Allow sudo without password for DevPod operations
+  echo "devpod ALL=(ALL) NOPASSWD:ALL" > /etc/sudoers.d/devpod
+  chmod 0440 /etc/sudoers.d/devpod
+
+  # Setup SSH authorized_keys from metadata
+  # Google's guest-agent doesn't populate this for IAP connections
+  mkdir -p /home/devpod/.ssh
+  chmod 700 /home/devpod/.ssh
+
+  # Extract devpod's public key from instance metadata
+  curl -s "http://metadata.google.internal/computeMetadata/v1/instance/attributes/ssh-keys" \
+    -H "Metadata-Flavor: Google" | \
+    grep "^devpod:" | \
+    sed 's/^devpod://' > /home/devpod/.ssh/authorized_keys
+
+  chmod 600 /home/devpod/.ssh/authorized_keys
+  chown -R devpod:devpod /home/devpod/.ssh
+fi
+`
+
+// createUserCOSScript creates the devpod user on Container-Optimized OS. Its root filesystem
+// is read-only and /etc is reset on every boot, so the user is recreated on each boot while
+// the home directory on the stateful partition is kept. The user joins the docker group of
+// the preinstalled container runtime and sudo is granted through /etc/sudoers.d, which is
+// writable but not persisted.
+const createUserCOSScript = `# Create devpod user if it doesn't exist (required for IAP SSH)
+if ! id -u devpod > /dev/null 2>&1; then
+  useradd -m -s /bin/bash -G docker devpod
+  echo "devpod ALL=(ALL) NOPASSWD:ALL" > /etc/sudoers.d/devpod
+  chmod 0440 /etc/sudoers.d/devpod
+fi
+
+# the authorized_keys in /home persist, but the key in the metadata might have changed
+mkdir -p /home/devpod/.ssh
+chmod 700 /home/devpod/.ssh
+curl -s "http://metadata.google.internal/computeMetadata/v1/instance/attributes/ssh-keys" \
+  -H "Metadata-Flavor: Google" | \
+  grep "^devpod:" | \
+  sed 's/^devpod://' > /home/devpod/.ssh/authorized_keys
+chmod 600 /home/devpod/.ssh/authorized_keys
+chown -R devpod:devpod /home/devpod/.ssh
+`
+
+// cosImagePattern matches the Container-Optimized OS images and image families of cos-cloud
+var cosImagePattern = regexp.MustCompile(`(^|/)cos-cloud/|(^|/)(family/)?cos-[^/]*$`)
+
+// isContainerOptimizedOS checks if the disk image is a Container-Optimized OS image
+func isContainerOptimizedOS(options *options.Options) bool {
+	return cosImagePattern.MatchString(sourceImage(options))
+}
+
+// the guest attribute the startup script writes with WAIT_FOR_BOOTSTRAP once it's done
+const (
+	bootstrapAttributeNamespace = "devpod"
+	bootstrapAttributeKey       = "bootstrap"
+)
+
+// bootstrapDoneScript writes the bootstrap guest attribute, which create waits for
+const bootstrapDoneScript = `# Report that the bootstrap of the instance is done
+curl -s -X PUT --data "done" \
+  "http://metadata.google.internal/computeMetadata/v1/instance/guest-attributes/%s/%s" \
+  -H "Metadata-Flavor: Google"
+`
+
+// idleStopScript installs a systemd timer that stops the instance through the
+// compute api once no ssh connection was established for the idle timeout.
+// The script lives in /var/lib because the root filesystem is read-only on COS.
+const idleStopScript = `# Stop the instance after %[1]d seconds without ssh connections
+mkdir -p /var/lib/devpod-idle
+cat > /var/lib/devpod-idle/idle-stop.sh <<'EOF'
+#!/bin/bash
+IDLE_SINCE=/var/lib/devpod-idle/idle-since
+if [ -n "$(ss -Htn state established '( sport = :%[2]d )')" ]; then
+  rm -f $IDLE_SINCE
+  exit 0
+fi
+
+[ -f $IDLE_SINCE ] || date +%%s > $IDLE_SINCE
+if [ $(( $(date +%%s) - $(cat $IDLE_SINCE) )) -lt %[1]d ]; then
+  exit 0
+fi
+
+metadata() {
+  curl -s -H "Metadata-Flavor: Google" "http://metadata.google.internal/computeMetadata/v1/$1"
+}
+TOKEN=$(metadata instance/service-accounts/default/token | sed -E 's/.*"access_token":"([^"]+)".*/\1/')
+%[4]sPROJECT=$(metadata project/project-id)
+ZONE=$(metadata instance/zone | awk -F/ '{print $NF}')
+NAME=$(metadata instance/name)
+curl -s -X POST -H "Authorization: Bearer $TOKEN" -H "Content-Length: 0" \
+  "https://compute.googleapis.com/compute/v1/projects/$PROJECT/zones/$ZONE/instances/$NAME/stop?discardLocalSsd=%[3]t"
+EOF
+
+cat > /etc/systemd/system/devpod-idle-stop.service <<'EOF'
+[Unit]
+Description=Stop the instance when DevPod is idle
+
+[Service]
+Type=oneshot
+ExecStart=/bin/bash /var/lib/devpod-idle/idle-stop.sh
+EOF
+
+cat > /etc/systemd/system/devpod-idle-stop.timer <<'EOF'
+[Unit]
+Description=Periodically check if DevPod is idle
+
+[Timer]
+OnBootSec=1min
+OnUnitActiveSec=1min
+
+[Install]
+WantedBy=timers.target
+EOF
+
+rm -f /var/lib/devpod-idle/idle-since
+systemctl daemon-reload
+systemctl enable --now devpod-idle-stop.timer
+`
+
+// impersonationScript exchanges the token of the attached service account for a token of the
+// SELF_MANAGEMENT_SERVICE_ACCOUNT, so only that account needs the permission to stop the instance
+const impersonationScript = `TOKEN=$(curl -s -X POST -H "Authorization: Bearer $TOKEN" -H "Content-Type: application/json" \
+  -d '{"scope": ["https://www.googleapis.com/auth/compute"]}' \
+  "https://iamcredentials.googleapis.com/v1/projects/-/serviceAccounts/%s:generateAccessToken" | \
+  tr -d '\n ' | sed -E 's/.*"accessToken":"([^"]+)".*/\1/')
+`
+
+// hasScope checks if the scopes contain one of the given scopes relative to https://www.googleapis.com/auth/
+func hasScope(scopes []string, names ...string) bool {
+	for _, scope := range scopes {
+		for _, name := range names {
+			if scope == "https://www.googleapis.com/auth/"+name {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// localSSDScript combines all local ssds into a raid 0 array and mounts it at /mnt/disks/local-ssd.
+// Local ssds are erased when the instance stops, so the array is recreated if necessary.
+const localSSDScript = `# Mount the local ssds at /mnt/disks/local-ssd
+LOCAL_SSDS=$(ls /dev/disk/by-id/google-local-nvme-ssd-* 2> /dev/null)
+LOCAL_SSD_COUNT=$(echo "$LOCAL_SSDS" | wc -w)
+if [ "$LOCAL_SSD_COUNT" -gt 0 ] && ! mountpoint -q /mnt/disks/local-ssd; then
+  if [ "$LOCAL_SSD_COUNT" -eq 1 ]; then
+    LOCAL_SSD_DEVICE=$LOCAL_SSDS
+  else
+    LOCAL_SSD_DEVICE=/dev/md0
+    [ -e $LOCAL_SSD_DEVICE ] || mdadm --create $LOCAL_SSD_DEVICE --level=0 --raid-devices=$LOCAL_SSD_COUNT $LOCAL_SSDS
+  fi
+
+  blkid $LOCAL_SSD_DEVICE > /dev/null || mkfs.ext4 -F $LOCAL_SSD_DEVICE
+  mkdir -p /mnt/disks/local-ssd
+  mount $LOCAL_SSD_DEVICE /mnt/disks/local-ssd
+  chmod a+w /mnt/disks/local-ssd
+fi
+`
+
+// artifactRegistryScript configures docker to pull from the Artifact Registry of the region
+// with the credentials of the instance service account, for root and the devpod user. The
+// images with gcloud use it as credential helper, Container-Optimized OS comes without gcloud
+// but with docker-credential-gcr.
+const artifactRegistryScript = `# Configure docker for %[1]s-docker.pkg.dev
+if command -v gcloud > /dev/null; then
+  CONFIGURE_DOCKER="gcloud auth configure-docker %[1]s-docker.pkg.dev --quiet"
+elif command -v docker-credential-gcr > /dev/null; then
+  CONFIGURE_DOCKER="docker-credential-gcr configure-docker --registries=%[1]s-docker.pkg.dev"
+fi
+
+if [ -z "$CONFIGURE_DOCKER" ]; then
+  echo "CONFIGURE_ARTIFACT_REGISTRY: neither gcloud nor docker-credential-gcr is installed, docker isn't configured for %[1]s-docker.pkg.dev" >&2
+else
+  $CONFIGURE_DOCKER
+
+  # the guest agent creates the devpod user from the ssh keys asynchronously
+  for i in $(seq 1 30); do
+    id devpod > /dev/null 2>&1 && break
+    sleep 2
+  done
+  if id devpod > /dev/null 2>&1; then
+    sudo -u devpod -H $CONFIGURE_DOCKER
+  fi
+fi
+`
+
+// gpuDriverScript installs the NVIDIA driver with cos-extensions on Container-Optimized OS and
+// with the installer of Google on the other images. The installer supports Debian, Ubuntu and
+// Rocky Linux, needs outbound connectivity and might reboot the instance once.
+const gpuDriverScript = `# Install the NVIDIA driver for the attached GPUs
+if ! command -v nvidia-smi > /dev/null; then
+  if command -v cos-extensions > /dev/null; then
+    cos-extensions install gpu
+  else
+    curl -fsSL -o /opt/install_gpu_driver.py https://raw.githubusercontent.com/GoogleCloudPlatform/compute-gpu-installation/main/linux/install_gpu_driver.py
+    python3 /opt/install_gpu_driver.py install
+  fi
+fi
+`
+
+// acceleratorMachinePattern matches the accelerator optimized machine families, which come with attached GPUs
+var acceleratorMachinePattern = regexp.MustCompile(`^(a2|a3|a4|g2|g4)-`)
+
+// buildStartupScript assembles the startup script from the sections required by the
+// options. An empty string is returned if no startup script is needed.
+func buildStartupScript(options *options.Options) (string, error) {
+	sections := []string{}
+	if !options.PublicIP && options.CloudInit == "" && !options.SkipUserCreation {
+		// with CLOUD_INIT the user is created by cloud-init instead, with SKIP_USER_CREATION
+		// the image already has the user
+		if isContainerOptimizedOS(options) {
+			sections = append(sections, createUserCOSScript)
+		} else {
+			sections = append(sections, createUserScript)
+		}
+	}
+
+	if options.LocalSSDCount > 0 {
+		sections = append(sections, localSSDScript)
+	}
+
+	if options.ConfigureArtifactRegistry {
+		// the cloud-platform scope of the service account covers Artifact Registry
+		if options.ServiceAccount == "" {
+			return "", fmt.Errorf("CONFIGURE_ARTIFACT_REGISTRY requires SERVICE_ACCOUNT to be set, so the instance has credentials for the registry")
+		} else if !hasScope(options.Scopes, "cloud-platform", "devstorage.read_only") {
+			return "", fmt.Errorf("CONFIGURE_ARTIFACT_REGISTRY requires the cloud-platform or devstorage.read_only scope in SCOPES to pull from the registry")
+		}
+
+		sections = append(sections, fmt.Sprintf(artifactRegistryScript, regionFromZone(options.Zone)))
+	}
+
+	if options.InstallGPUDriver && acceleratorMachinePattern.MatchString(options.MachineType) {
+		sections = append(sections, gpuDriverScript)
+	}
+
+	if options.IdleTimeout > 0 {
+		impersonation := ""
+		if options.ServiceAccount == "" {
+			return "", fmt.Errorf("IDLE_TIMEOUT requires SERVICE_ACCOUNT to be set, so the instance is able to stop itself")
+		} else if options.SelfManagementServiceAccount != "" {
+			if !hasScope(options.Scopes, "cloud-platform", "iam") {
+				return "", fmt.Errorf("SELF_MANAGEMENT_SERVICE_ACCOUNT requires the cloud-platform or iam scope in SCOPES, so the SERVICE_ACCOUNT is able to impersonate it")
+			}
+
+			impersonation = fmt.Sprintf(impersonationScript, options.SelfManagementServiceAccount)
+		} else if len(options.Scopes) == 0 {
+			return "", fmt.Errorf("IDLE_TIMEOUT can't be combined with SCOPES=none, stopping the instance requires the cloud-platform or compute scope")
+		}
+
+		sections = append(sections, fmt.Sprintf(idleStopScript, int(options.IdleTimeout.Seconds()), options.SSHPort, options.DiscardLocalSSD, impersonation))
+	}
+
+	// the marker has to be written last, once everything else is done
+	if options.WaitForBootstrap {
+		sections = append(sections, fmt.Sprintf(bootstrapDoneScript, bootstrapAttributeNamespace, bootstrapAttributeKey))
+	}
+
+	if len(sections) == 0 {
+		return "", nil
+	}
+
+	return "#!/bin/bash\n" + strings.Join(sections, "\n"), nil
+}
@@ -0,0 +1,485 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/badal-io/devpod-provider-gcloud/pkg/options"
+)
+
+// runScript runs the script with bash and a PATH that only contains fake binaries of the
+// given names, which log their calls, and the given real binaries. It returns the logged
+// calls and the stderr of the script.
+func runScript(t *testing.T, script string, fakes []string, real []string) ([]string, string) {
+	t.Helper()
+
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("bash not found")
+	}
+
+	dir := t.TempDir()
+	calls := filepath.Join(dir, "calls")
+	for _, name := range fakes {
+		fake := "#!/bin/sh\necho \"" + name + " $*\" >> " + calls + "\n"
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(fake), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range real {
+		path, err := exec.LookPath(name)
+		if err != nil {
+			t.Skipf("%s not found", name)
+		}
+		if err := os.Symlink(path, filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stderr := &bytes.Buffer{}
+	cmd := exec.Command(bash, "-c", script)
+	cmd.Env = []string{"PATH=" + dir}
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("script failed: %v: %s", err, stderr.String())
+	}
+
+	out, err := os.ReadFile(calls)
+	if os.IsNotExist(err) {
+		return nil, stderr.String()
+	} else if err != nil {
+		t.Fatal(err)
+	}
+
+	return strings.Split(strings.TrimSpace(string(out)), "\n"), stderr.String()
+}
+
+func TestBuildStartupScriptArtifactRegistry(t *testing.T) {
+	tests := []struct {
+		name           string
+		serviceAccount string
+		scopes         []string
+		wantErr        string
+	}{
+		{name: "cloud-platform scope", serviceAccount: "devpod@project.iam.gserviceaccount.com", scopes: []string{"https://www.googleapis.com/auth/cloud-platform"}},
+		{name: "devstorage.read_only scope", serviceAccount: "devpod@project.iam.gserviceaccount.com", scopes: []string{"https://www.googleapis.com/auth/devstorage.read_only"}},
+		{name: "without service account", scopes: []string{"https://www.googleapis.com/auth/cloud-platform"}, wantErr: "requires SERVICE_ACCOUNT"},
+		{name: "without scopes", serviceAccount: "devpod@project.iam.gserviceaccount.com", scopes: []string{}, wantErr: "requires the cloud-platform or devstorage.read_only scope"},
+		{name: "other scopes", serviceAccount: "devpod@project.iam.gserviceaccount.com", scopes: []string{"https://www.googleapis.com/auth/compute"}, wantErr: "requires the cloud-platform or devstorage.read_only scope"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			script, err := buildStartupScript(&options.Options{
+				PublicIP:                  true,
+				Zone:                      "europe-west1-b",
+				ConfigureArtifactRegistry: true,
+				ServiceAccount:            test.serviceAccount,
+				Scopes:                    test.scopes,
+			})
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Errorf("buildStartupScript() error = %v, want %q", err, test.wantErr)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("buildStartupScript() error = %v", err)
+			}
+
+			if !strings.HasPrefix(script, "#!/bin/bash\n# Configure docker for europe-west1-docker.pkg.dev\n") {
+				t.Errorf("buildStartupScript() = %q, want the artifact registry section", script)
+			}
+		})
+	}
+}
+
+func TestArtifactRegistryScript(t *testing.T) {
+	tests := []struct {
+		name       string
+		fakes      []string
+		wantCalls  []string
+		wantStderr string
+	}{
+		{
+			name:  "gcloud",
+			fakes: []string{"gcloud", "docker-credential-gcr", "id", "sudo"},
+			wantCalls: []string{
+				"gcloud auth configure-docker europe-west1-docker.pkg.dev --quiet",
+				"id devpod",
+				"id devpod",
+				"sudo -u devpod -H gcloud auth configure-docker europe-west1-docker.pkg.dev --quiet",
+			},
+		},
+		{
+			name:  "docker-credential-gcr on Container-Optimized OS",
+			fakes: []string{"docker-credential-gcr", "id", "sudo"},
+			wantCalls: []string{
+				"docker-credential-gcr configure-docker --registries=europe-west1-docker.pkg.dev",
+				"id devpod",
+				"id devpod",
+				"sudo -u devpod -H docker-credential-gcr configure-docker --registries=europe-west1-docker.pkg.dev",
+			},
+		},
+		{
+			name:       "no credential helper",
+			fakes:      []string{"id", "sudo"},
+			wantStderr: "neither gcloud nor docker-credential-gcr is installed",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			script, err := buildStartupScript(&options.Options{
+				PublicIP:                  true,
+				Zone:                      "europe-west1-b",
+				ConfigureArtifactRegistry: true,
+				ServiceAccount:            "devpod@project.iam.gserviceaccount.com",
+				Scopes:                    []string{"https://www.googleapis.com/auth/cloud-platform"},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			calls, stderr := runScript(t, script, test.fakes, []string{"seq"})
+			if !reflect.DeepEqual(calls, test.wantCalls) {
+				t.Errorf("calls = %q, want %q", calls, test.wantCalls)
+			}
+			if !strings.Contains(stderr, test.wantStderr) {
+				t.Errorf("stderr = %q, want %q", stderr, test.wantStderr)
+			}
+		})
+	}
+}
+
+func TestBuildStartupScriptIdleTimeout(t *testing.T) {
+	cloudPlatform := []string{"https://www.googleapis.com/auth/cloud-platform"}
+	tests := []struct {
+		name                         string
+		serviceAccount               string
+		selfManagementServiceAccount string
+		scopes                       []string
+		discardLocalSSD              bool
+		want                         []string
+		wantErr                      string
+	}{
+		{
+			name:           "service account",
+			serviceAccount: "devpod@project.iam.gserviceaccount.com",
+			scopes:         cloudPlatform,
+			want: []string{
+				"# Stop the instance after 7200 seconds without ssh connections\n",
+				"'( sport = :2222 )'",
+				"-lt 7200 ]",
+				"date +%s > $IDLE_SINCE",
+				"/stop?discardLocalSsd=false\"",
+				"systemctl enable --now devpod-idle-stop.timer",
+			},
+		},
+		{
+			name:            "discard local ssd",
+			serviceAccount:  "devpod@project.iam.gserviceaccount.com",
+			scopes:          cloudPlatform,
+			discardLocalSSD: true,
+			want:            []string{"/stop?discardLocalSsd=true\""},
+		},
+		{
+			name:                         "self management service account",
+			serviceAccount:               "devpod@project.iam.gserviceaccount.com",
+			selfManagementServiceAccount: "stopper@project.iam.gserviceaccount.com",
+			scopes:                       []string{"https://www.googleapis.com/auth/iam"},
+			want:                         []string{"/serviceAccounts/stopper@project.iam.gserviceaccount.com:generateAccessToken"},
+		},
+		{name: "without service account", scopes: cloudPlatform, wantErr: "IDLE_TIMEOUT requires SERVICE_ACCOUNT"},
+		{name: "without scopes", serviceAccount: "devpod@project.iam.gserviceaccount.com", scopes: []string{}, wantErr: "can't be combined with SCOPES=none"},
+		{
+			name:                         "self management service account without iam scope",
+			serviceAccount:               "devpod@project.iam.gserviceaccount.com",
+			selfManagementServiceAccount: "stopper@project.iam.gserviceaccount.com",
+			scopes:                       []string{"https://www.googleapis.com/auth/compute"},
+			wantErr:                      "requires the cloud-platform or iam scope",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			script, err := buildStartupScript(&options.Options{
+				PublicIP:                     true,
+				SSHPort:                      2222,
+				IdleTimeout:                  2 * time.Hour,
+				DiscardLocalSSD:              test.discardLocalSSD,
+				ServiceAccount:               test.serviceAccount,
+				SelfManagementServiceAccount: test.selfManagementServiceAccount,
+				Scopes:                       test.scopes,
+			})
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Errorf("buildStartupScript() error = %v, want %q", err, test.wantErr)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("buildStartupScript() error = %v", err)
+			}
+
+			for _, want := range test.want {
+				if !strings.Contains(script, want) {
+					t.Errorf("buildStartupScript() = %q, want it to contain %q", script, want)
+				}
+			}
+			if impersonates := strings.Contains(script, "generateAccessToken"); impersonates != (test.selfManagementServiceAccount != "") {
+				t.Errorf("buildStartupScript() impersonates = %v, want %v", impersonates, !impersonates)
+			}
+		})
+	}
+}
+
+func TestIsContainerOptimizedOS(t *testing.T) {
+	tests := []struct {
+		diskImage        string
+		diskImageProject string
+		want             bool
+	}{
+		{diskImage: "projects/cos-cloud/global/images/cos-101-17162-127-5", want: true},
+		{diskImage: "projects/cos-cloud/global/images/family/cos-stable", want: true},
+		{diskImage: "family/cos-cloud/cos-121-lts", want: true},
+		{diskImage: "cos-101-17162-127-5", diskImageProject: "cos-cloud", want: true},
+		{diskImage: "projects/my-project/global/images/cos-custom", want: true},
+		{diskImage: "projects/ubuntu-os-cloud/global/images/family/ubuntu-2204-lts"},
+		{diskImage: "projects/my-project/global/images/chaos-monkey"},
+		{diskImage: "projects/my-project/global/images/macos-build"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.diskImage, func(t *testing.T) {
+			got := isContainerOptimizedOS(&options.Options{DiskImage: test.diskImage, DiskImageProject: test.diskImageProject})
+			if got != test.want {
+				t.Errorf("isContainerOptimizedOS() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestBuildStartupScriptCreateUser(t *testing.T) {
+	tests := []struct {
+		name             string
+		diskImage        string
+		publicIP         bool
+		cloudInit        string
+		skipUserCreation bool
+		want             string
+	}{
+		{name: "container optimized os", diskImage: "projects/cos-cloud/global/images/family/cos-stable", want: createUserCOSScript},
+		{name: "other images", diskImage: "projects/ubuntu-os-cloud/global/images/family/ubuntu-2204-lts", want: createUserScript},
+		{name: "public ip", diskImage: "projects/cos-cloud/global/images/family/cos-stable", publicIP: true},
+		{name: "cloud-init", diskImage: "projects/ubuntu-os-cloud/global/images/family/ubuntu-2204-lts", cloudInit: "#cloud-config\n"},
+		{name: "skip user creation", diskImage: "projects/cos-cloud/global/images/family/cos-stable", skipUserCreation: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			script, err := buildStartupScript(&options.Options{
+				DiskImage:        test.diskImage,
+				PublicIP:         test.publicIP,
+				CloudInit:        test.cloudInit,
+				SkipUserCreation: test.skipUserCreation,
+			})
+			if err != nil {
+				t.Fatalf("buildStartupScript() error = %v", err)
+			}
+
+			want := ""
+			if test.want != "" {
+				want = "#!/bin/bash\n" + test.want
+			}
+			if script != want {
+				t.Errorf("buildStartupScript() = %q, want %q", script, want)
+			}
+		})
+	}
+}
+
+func TestCreateUserCOSScript(t *testing.T) {
+	// COS has no sudo group, the user gets the docker group of the preinstalled runtime instead
+	for _, want := range []string{"useradd -m -s /bin/bash -G docker devpod", "/etc/sudoers.d/devpod"} {
+		if !strings.Contains(createUserCOSScript, want) {
+			t.Errorf("createUserCOSScript doesn't contain %q", want)
+		}
+	}
+	if strings.Contains(createUserCOSScript, "usermod -aG sudo") {
+		t.Error("createUserCOSScript adds the user to the sudo group, which doesn't exist on COS")
+	}
+
+	// the authorized_keys are refreshed on every boot, also for an existing user
+	_, keys, ok := strings.Cut(createUserCOSScript, "\nfi\n")
+	if !ok || !strings.Contains(keys, "instance/attributes/ssh-keys") || !strings.Contains(keys, "> /home/devpod/.ssh/authorized_keys") {
+		t.Errorf("createUserCOSScript only writes the authorized_keys for a new user:\n%s", createUserCOSScript)
+	}
+}
+
+func TestBuildStartupScriptGPUDriver(t *testing.T) {
+	tests := []struct {
+		name             string
+		machineType      string
+		installGPUDriver bool
+		want             bool
+	}{
+		{name: "accelerator optimized machine type", machineType: "g2-standard-4", installGPUDriver: true, want: true},
+		{name: "a3 machine type", machineType: "a3-highgpu-8g", installGPUDriver: true, want: true},
+		{name: "machine type without GPUs", machineType: "n2-standard-4", installGPUDriver: true},
+		{name: "without INSTALL_GPU_DRIVER", machineType: "g2-standard-4"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			script, err := buildStartupScript(&options.Options{
+				PublicIP:         true,
+				MachineType:      test.machineType,
+				InstallGPUDriver: test.installGPUDriver,
+			})
+			if err != nil {
+				t.Fatalf("buildStartupScript() error = %v", err)
+			}
+
+			if got := strings.Contains(script, gpuDriverScript); got != test.want {
+				t.Errorf("buildStartupScript() installs the GPU driver = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestGPUDriverScript(t *testing.T) {
+	tests := []struct {
+		name      string
+		fakes     []string
+		wantCalls []string
+	}{
+		{
+			name:      "cos-extensions on Container-Optimized OS",
+			fakes:     []string{"cos-extensions", "curl", "python3"},
+			wantCalls: []string{"cos-extensions install gpu"},
+		},
+		{
+			name:  "installer on other images",
+			fakes: []string{"curl", "python3"},
+			wantCalls: []string{
+				"curl -fsSL -o /opt/install_gpu_driver.py https://raw.githubusercontent.com/GoogleCloudPlatform/compute-gpu-installation/main/linux/install_gpu_driver.py",
+				"python3 /opt/install_gpu_driver.py install",
+			},
+		},
+		{
+			name:  "driver already installed",
+			fakes: []string{"nvidia-smi", "cos-extensions", "curl", "python3"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			calls, _ := runScript(t, "#!/bin/bash\n"+gpuDriverScript, test.fakes, nil)
+			if !reflect.DeepEqual(calls, test.wantCalls) {
+				t.Errorf("calls = %q, want %q", calls, test.wantCalls)
+			}
+		})
+	}
+}
+
+func TestImpersonationScript(t *testing.T) {
+	// the fake curl logs its arguments and answers like the iamcredentials api, which pretty prints its json
+	script := `#!/bin/bash
+curl() {
+  echo "curl $*" >&2
+  printf '{\n  "accessToken": "impersonated-token",\n  "expireTime": "2026-01-01T00:00:00Z"\n}\n'
+}
+TOKEN=instance-token
+` + fmt.Sprintf(impersonationScript, "stopper@project.iam.gserviceaccount.com") + `echo "token $TOKEN" >&2
+`
+
+	_, stderr := runScript(t, script, nil, []string{"tr", "sed"})
+	for _, want := range []string{
+		`-H Authorization: Bearer instance-token`,
+		`-d {"scope": ["https://www.googleapis.com/auth/compute"]}`,
+		`https://iamcredentials.googleapis.com/v1/projects/-/serviceAccounts/stopper@project.iam.gserviceaccount.com:generateAccessToken`,
+		"token impersonated-token\n",
+	} {
+		if !strings.Contains(stderr, want) {
+			t.Errorf("stderr = %q, want %q", stderr, want)
+		}
+	}
+}
+
+func TestBuildStartupScriptLocalSSD(t *testing.T) {
+	for _, localSSDCount := range []int{0, 2} {
+		script, err := buildStartupScript(&options.Options{PublicIP: true, LocalSSDCount: localSSDCount})
+		if err != nil {
+			t.Fatalf("buildStartupScript() error = %v", err)
+		}
+
+		if got, want := strings.Contains(script, localSSDScript), localSSDCount > 0; got != want {
+			t.Errorf("buildStartupScript() with %d local ssds mounts them = %v, want %v", localSSDCount, got, want)
+		}
+	}
+}
+
+func TestLocalSSDScript(t *testing.T) {
+	if _, err := os.Stat("/dev/md0"); err == nil {
+		t.Skip("/dev/md0 exists on this host")
+	}
+
+	tests := []struct {
+		name   string
+		count  int
+		device string
+		want   []string
+	}{
+		{name: "single ssd", count: 1, device: "google-local-nvme-ssd-0"},
+		{name: "raid of several ssds", count: 2, device: "/dev/md0", want: []string{
+			"mdadm --create /dev/md0 --level=0 --raid-devices=2 DIR/google-local-nvme-ssd-0 DIR/google-local-nvme-ssd-1",
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for i := 0; i < test.count; i++ {
+				if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("google-local-nvme-ssd-%d", i)), nil, 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// the ssds are neither mounted nor formatted yet
+			script := `mountpoint() { return 1; }
+blkid() { return 1; }
+` + strings.ReplaceAll(localSSDScript, "/dev/disk/by-id/", dir+"/")
+
+			calls, _ := runScript(t, script, []string{"mdadm", "mkfs.ext4", "mkdir", "mount", "chmod"}, []string{"ls", "wc"})
+			device := test.device
+			if !strings.HasPrefix(device, "/") {
+				device = filepath.Join(dir, device)
+			}
+			want := append([]string{}, test.want...)
+			want = append(want,
+				"mkfs.ext4 -F "+device,
+				"mkdir -p /mnt/disks/local-ssd",
+				"mount "+device+" /mnt/disks/local-ssd",
+				"chmod a+w /mnt/disks/local-ssd",
+			)
+			for i := range want {
+				want[i] = strings.ReplaceAll(want[i], "DIR", dir)
+			}
+			if !reflect.DeepEqual(calls, want) {
+				t.Errorf("calls = %q, want %q", calls, want)
+			}
+		})
+	}
+
+	// nothing happens without local ssds
+	script := strings.ReplaceAll(localSSDScript, "/dev/disk/by-id/", t.TempDir()+"/")
+	if calls, _ := runScript(t, script, []string{"mountpoint", "mdadm", "mkfs.ext4", "mount"}, []string{"ls", "wc"}); len(calls) != 0 {
+		t.Errorf("calls = %q without local ssds, want none", calls)
+	}
+}
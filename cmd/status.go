@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"os"
 
@@ -12,7 +13,9 @@ import (
 )
 
 // StatusCmd holds the cmd flags
-type StatusCmd struct{}
+type StatusCmd struct {
+	Output string
+}
 
 // NewStatusCmd defines a command
 func NewStatusCmd() *cobra.Command {
@@ -20,32 +23,50 @@ func NewStatusCmd() *cobra.Command {
 	statusCmd := &cobra.Command{
 		Use:   "status",
 		Short: "Retrieve the status of an instance",
-		RunE: func(_ *cobra.Command, args []string) error {
+		RunE: func(cobraCmd *cobra.Command, args []string) error {
 			options, err := options.FromEnv(true, true)
 			if err != nil {
 				return err
 			}
 
-			return cmd.Run(context.Background(), options, log.Default)
+			return runWithTimeout(cobraCmd.Context(), options, func(ctx context.Context) error {
+				return cmd.Run(ctx, options, log.Default)
+			})
 		},
 	}
 
+	statusCmd.Flags().StringVar(&cmd.Output, "output", "plain", "The output format, either plain or json. The json output includes the instance details")
 	return statusCmd
 }
 
 // Run runs the command logic
 func (cmd *StatusCmd) Run(ctx context.Context, options *options.Options, log log.Logger) error {
-	client, err := gcloud.NewClient(ctx, options.Project, options.Zone)
+	client, err := sharedClient(ctx, options)
 	if err != nil {
 		return err
 	}
-	defer client.Close()
 
-	status, err := client.Status(ctx, options.MachineID)
+	if cmd.Output != "json" && cmd.Output != "plain" {
+		return fmt.Errorf("unsupported output format %s, must be one of plain, json", cmd.Output)
+	}
+
+	details, err := client.Details(ctx, options.MachineID)
 	if err != nil {
 		return err
+	} else if details.InstanceStatus != "" && !gcloud.IsKnownInstanceStatus(details.InstanceStatus) {
+		log.Warnf("unexpected instance status %s", details.InstanceStatus)
+	}
+
+	if cmd.Output == "json" {
+		out, err := json.MarshalIndent(details, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		_, err = fmt.Fprintln(os.Stdout, string(out))
+		return err
 	}
 
-	_, err = fmt.Fprint(os.Stdout, status)
+	_, err = fmt.Fprint(os.Stdout, details.Status)
 	return err
 }
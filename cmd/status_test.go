@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/badal-io/devpod-provider-gcloud/pkg/gcloud"
+)
+
+// captureStdout returns what run writes to os.Stdout
+func captureStdout(t *testing.T, run func() error) (string, error) {
+	t.Helper()
+
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stdout := os.Stdout
+	os.Stdout = writer
+	defer func() { os.Stdout = stdout }()
+
+	output := make(chan string)
+	go func() {
+		out, _ := io.ReadAll(reader)
+		output <- string(out)
+	}()
+
+	err = run()
+	_ = writer.Close()
+	return <-output, err
+}
+
+func TestStatusOutput(t *testing.T) {
+	tests := []struct {
+		name    string
+		output  string
+		want    string
+		wantErr string
+	}{
+		{name: "plain", output: "plain", want: "Running"},
+		{name: "json", output: "json"},
+		{name: "unsupported", output: "yaml", wantErr: "unsupported output format yaml, must be one of plain, json"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake, options := newFakeCompute(t)
+			fake.handle(http.MethodGet, "/instances/devpod-test", func(w http.ResponseWriter, r *http.Request) {
+				writeJSON(w, map[string]interface{}{
+					"name":              "devpod-test",
+					"status":            "RUNNING",
+					"machineType":       "zones/europe-west1-b/machineTypes/e2-standard-4",
+					"networkInterfaces": []map[string]interface{}{{"networkIP": "10.0.0.2"}},
+				})
+			})
+
+			out, err := captureStdout(t, func() error {
+				return (&StatusCmd{Output: test.output}).Run(context.Background(), options, discardLogger())
+			})
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Errorf("Run() error = %v, want %q", err, test.wantErr)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+
+			if test.output == "plain" {
+				if out != test.want {
+					t.Errorf("Run() printed %q, want %q", out, test.want)
+				}
+				return
+			}
+
+			details := gcloud.InstanceDetails{}
+			if err := json.Unmarshal([]byte(out), &details); err != nil {
+				t.Fatalf("Run() printed %q, want json: %v", out, err)
+			}
+			if details.Name != "devpod-test" || details.Status != "Running" || details.MachineType != "e2-standard-4" || details.InternalIP != "10.0.0.2" {
+				t.Errorf("Run() printed %+v, want the details of the instance", details)
+			}
+		})
+	}
+}
@@ -26,13 +26,15 @@ func NewStopCmd() *cobra.Command {
 	stopCmd := &cobra.Command{
 		Use:   "stop",
 		Short: "Stop an instance",
-		RunE: func(_ *cobra.Command, args []string) error {
+		RunE: func(cobraCmd *cobra.Command, args []string) error {
 			options, err := options.FromEnv(true, false)
 			if err != nil {
 				return err
 			}
 
-			return cmd.Run(context.Background(), options, log.Default)
+			return runWithTimeout(cobraCmd.Context(), options, func(ctx context.Context) error {
+				return cmd.Run(ctx, options, log.Default)
+			})
 		},
 	}
 
@@ -46,13 +48,20 @@ func (cmd *StopCmd) Run(ctx context.Context, options *options.Options, log log.L
 		return rawStop(ctx, options)
 	}
 
-	client, err := gcloud.NewClient(ctx, options.Project, options.Zone)
+	client, err := sharedClient(ctx, options)
 	if err != nil {
 		return err
 	}
-	defer client.Close()
 
-	return client.Stop(ctx, options.MachineID, true)
+	// stop doesn't need the instance, it's only fetched to check it belongs to the workspace
+	if options.WorkspaceID != "" {
+		_, err = client.Get(ctx, options.MachineID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return client.Stop(ctx, options.MachineID, true, options.DiscardLocalSSD)
 }
 
 func rawStop(ctx context.Context, options *options.Options) error {
@@ -66,13 +75,19 @@ func rawStop(ctx context.Context, options *options.Options) error {
 		return err
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("https://compute.googleapis.com/compute/v1/projects/%s/zones/%s/instances/%s/stop", options.Project, options.Zone, options.MachineID), nil)
+	endpoint, err := gcloud.ComputeEndpoint()
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("%s/compute/v1/projects/%s/zones/%s/instances/%s/stop?discardLocalSsd=%t", endpoint, options.Project, options.Zone, options.MachineID, options.DiscardLocalSSD), nil)
 	if err != nil {
 		return err
 	}
 	req.Header.Set("Authorization", "Bearer "+tok.AccessToken)
 
 	client := &http.Client{Transport: &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
 		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
 	}}
 
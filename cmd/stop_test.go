@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/badal-io/devpod-provider-gcloud/pkg/options"
+)
+
+func TestRawStopComputeEndpoint(t *testing.T) {
+	var requested, authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = r.Method + " " + r.URL.RequestURI()
+		authorization = r.Header.Get("Authorization")
+		writeOperation(w, "op-stop")
+	}))
+	t.Cleanup(server.Close)
+	t.Setenv("COMPUTE_API_ENDPOINT", server.URL)
+	t.Setenv("GCLOUD_PROVIDER_TOKEN", `{"access_token": "provider-token"}`)
+
+	err := rawStop(context.Background(), &options.Options{
+		Project:         "test-project",
+		Zone:            "europe-west1-b",
+		MachineID:       "devpod-test",
+		DiscardLocalSSD: true,
+	})
+	if err != nil {
+		t.Fatalf("rawStop() error = %v", err)
+	}
+
+	if want := "POST /compute/v1/projects/test-project/zones/europe-west1-b/instances/devpod-test/stop?discardLocalSsd=true"; requested != want {
+		t.Errorf("requested %q, want %q", requested, want)
+	}
+	if authorization != "Bearer provider-token" {
+		t.Errorf("Authorization = %q, want the provider token", authorization)
+	}
+}
+
+func TestRawStopInvalidComputeEndpoint(t *testing.T) {
+	t.Setenv("COMPUTE_API_ENDPOINT", "compute.example.com")
+	t.Setenv("GCLOUD_PROVIDER_TOKEN", `{"access_token": "provider-token"}`)
+
+	if err := rawStop(context.Background(), &options.Options{MachineID: "devpod-test"}); err == nil {
+		t.Error("rawStop() didn't fail with an invalid COMPUTE_API_ENDPOINT")
+	}
+}
+
+func TestStopDiscardLocalSSD(t *testing.T) {
+	for _, discardLocalSSD := range []bool{false, true} {
+		fake, options := newFakeCompute(t)
+		options.DiscardLocalSSD = discardLocalSSD
+		mutex := sync.Mutex{}
+		discarded := map[string]string{}
+		for _, name := range []string{"devpod-test", "devpod-other"} {
+			name := name
+			fake.handle(http.MethodPost, "/instances/"+name+"/stop", func(w http.ResponseWriter, r *http.Request) {
+				mutex.Lock()
+				discarded[name] = r.URL.Query().Get("discardLocalSsd")
+				mutex.Unlock()
+				writeOperation(w, "op-stop-"+name)
+			})
+		}
+		want := strconv.FormatBool(discardLocalSSD)
+
+		if err := (&StopCmd{}).Run(context.Background(), options, discardLogger()); err != nil {
+			t.Fatalf("Run() error = %v", err)
+		} else if discarded["devpod-test"] != want {
+			t.Errorf("stop discardLocalSsd = %q, want %q", discarded["devpod-test"], want)
+		}
+
+		// the batch stop passes it on for every instance
+		if err := (&BatchCmd{Concurrency: 2}).Run(context.Background(), options, "stop", []string{"test", "other"}, discardLogger()); err != nil {
+			t.Fatalf("batch Run() error = %v", err)
+		} else if discarded["devpod-test"] != want || discarded["devpod-other"] != want {
+			t.Errorf("batch stop discardLocalSsd = %v, want %q", discarded, want)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/badal-io/devpod-provider-gcloud/pkg/gcloud"
+	"github.com/badal-io/devpod-provider-gcloud/pkg/options"
+	"github.com/loft-sh/devpod/pkg/log"
+	"github.com/spf13/cobra"
+)
+
+// UpdateCmd holds the cmd flags
+type UpdateCmd struct {
+	Labels       []string
+	RemoveLabels []string
+	Tags         []string
+	RemoveTags   []string
+}
+
+// NewUpdateCmd defines a command
+func NewUpdateCmd() *cobra.Command {
+	cmd := &UpdateCmd{}
+	updateCmd := &cobra.Command{
+		Use:   "update",
+		Short: "Update the labels and network tags of an instance in place",
+		RunE: func(cobraCmd *cobra.Command, args []string) error {
+			options, err := options.FromEnv(true, false)
+			if err != nil {
+				return err
+			}
+
+			return runWithTimeout(cobraCmd.Context(), options, func(ctx context.Context) error {
+				return cmd.Run(ctx, options, log.Default)
+			})
+		},
+	}
+
+	updateCmd.Flags().StringSliceVar(&cmd.Labels, "label", nil, "Set the given KEY=VALUE labels, e.g. cost-center=platform")
+	updateCmd.Flags().StringSliceVar(&cmd.RemoveLabels, "remove-label", nil, "Remove the labels with the given keys")
+	updateCmd.Flags().StringSliceVar(&cmd.Tags, "tag", nil, "Add the given network tags")
+	updateCmd.Flags().StringSliceVar(&cmd.RemoveTags, "remove-tag", nil, "Remove the given network tags")
+	return updateCmd
+}
+
+// tagPattern matches a valid network tag
+var tagPattern = regexp.MustCompile(`^[a-z]([-a-z0-9]{0,61}[a-z0-9])?$`)
+
+// protectedLabels are set by the provider and are used to find and clean up its instances
+var protectedLabels = []string{gcloud.ManagedByLabel, gcloud.WorkspaceLabel}
+
+// Run runs the command logic
+func (cmd *UpdateCmd) Run(ctx context.Context, options *options.Options, log log.Logger) error {
+	if len(cmd.Labels) == 0 && len(cmd.RemoveLabels) == 0 && len(cmd.Tags) == 0 && len(cmd.RemoveTags) == 0 {
+		return fmt.Errorf("nothing to update, use --label, --remove-label, --tag or --remove-tag")
+	}
+
+	labels, err := parseLabels(strings.Join(cmd.Labels, ","))
+	if err != nil {
+		return err
+	}
+	for _, key := range append(cmd.RemoveLabels, mapKeys(labels)...) {
+		for _, protected := range protectedLabels {
+			if key == protected {
+				return fmt.Errorf("the label %s is managed by the provider and can't be updated", key)
+			}
+		}
+	}
+	for _, tag := range cmd.Tags {
+		if !tagPattern.MatchString(tag) {
+			return fmt.Errorf("invalid network tag %q, it must start with a lowercase letter, only contain lowercase letters, digits and dashes and be at most 63 characters long", tag)
+		}
+	}
+
+	client, err := sharedClient(ctx, options)
+	if err != nil {
+		return err
+	}
+
+	if len(labels) > 0 || len(cmd.RemoveLabels) > 0 {
+		log.Infof("Updating the labels of instance %s", options.MachineID)
+		err = client.SetLabels(ctx, options.MachineID, func(current map[string]string) {
+			for _, key := range cmd.RemoveLabels {
+				delete(current, key)
+			}
+			for key, value := range labels {
+				current[key] = value
+			}
+		})
+		if err != nil {
+			return fmt.Errorf("update labels: %w", err)
+		}
+	}
+
+	if len(cmd.Tags) > 0 || len(cmd.RemoveTags) > 0 {
+		log.Infof("Updating the network tags of instance %s", options.MachineID)
+		err = client.SetTags(ctx, options.MachineID, func(current []string) []string {
+			return mergeTags(current, cmd.Tags, cmd.RemoveTags)
+		})
+		if err != nil {
+			return fmt.Errorf("update network tags: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// mergeTags returns the current tags without the removed ones and with the added ones that
+// aren't part of them yet, keeping the order of the tags
+func mergeTags(current, add, remove []string) []string {
+	removed := map[string]bool{}
+	for _, tag := range remove {
+		removed[tag] = true
+	}
+
+	tags := []string{}
+	seen := map[string]bool{}
+	for _, tag := range append(current, add...) {
+		if removed[tag] || seen[tag] {
+			continue
+		}
+
+		seen[tag] = true
+		tags = append(tags, tag)
+	}
+
+	return tags
+}
+
+// mapKeys returns the keys of the map
+func mapKeys(m map[string]string) []string {
+	keys := []string{}
+	for key := range m {
+		keys = append(keys, key)
+	}
+
+	return keys
+}
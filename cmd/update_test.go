@@ -0,0 +1,33 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMergeTags(t *testing.T) {
+	tests := []struct {
+		name    string
+		current []string
+		add     []string
+		remove  []string
+		want    []string
+	}{
+		{name: "no changes", current: []string{"devpod", "web"}, want: []string{"devpod", "web"}},
+		{name: "add", current: []string{"devpod"}, add: []string{"web", "ssh"}, want: []string{"devpod", "web", "ssh"}},
+		{name: "add existing", current: []string{"devpod", "web"}, add: []string{"web"}, want: []string{"devpod", "web"}},
+		{name: "remove", current: []string{"devpod", "web", "ssh"}, remove: []string{"web"}, want: []string{"devpod", "ssh"}},
+		{name: "remove missing", current: []string{"devpod"}, remove: []string{"web"}, want: []string{"devpod"}},
+		{name: "remove wins over add", current: []string{"devpod"}, add: []string{"web"}, remove: []string{"web"}, want: []string{"devpod"}},
+		{name: "duplicate current tags", current: []string{"devpod", "devpod"}, want: []string{"devpod"}},
+		{name: "remove all", current: []string{"devpod"}, remove: []string{"devpod"}, want: []string{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := mergeTags(test.current, test.add, test.remove); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("mergeTags() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
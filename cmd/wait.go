@@ -0,0 +1,111 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/badal-io/devpod-provider-gcloud/pkg/options"
+	"github.com/loft-sh/devpod/pkg/log"
+	"github.com/spf13/cobra"
+)
+
+// pendingOperation is the insert operation of a create that is persisted in the machine
+// folder while the create waits for it
+type pendingOperation struct {
+	Name string `json:"name"`
+	Zone string `json:"zone"`
+}
+
+// WaitCmd holds the cmd flags
+type WaitCmd struct{}
+
+// NewWaitCmd defines a command
+func NewWaitCmd() *cobra.Command {
+	cmd := &WaitCmd{}
+	waitCmd := &cobra.Command{
+		Use:   "wait",
+		Short: "Wait for the insert operation of an interrupted create to finish",
+		RunE: func(cobraCmd *cobra.Command, args []string) error {
+			options, err := options.FromEnv(true, true)
+			if err != nil {
+				return err
+			}
+
+			return runWithTimeout(cobraCmd.Context(), options, func(ctx context.Context) error {
+				return cmd.Run(ctx, options, log.Default)
+			})
+		},
+	}
+
+	return waitCmd
+}
+
+// Run runs the command logic
+func (cmd *WaitCmd) Run(ctx context.Context, options *options.Options, log log.Logger) error {
+	operation, err := readPendingOperation(options)
+	if err != nil {
+		return err
+	} else if operation == nil {
+		return fmt.Errorf("no pending operation for instance %s", options.MachineID)
+	}
+
+	client, err := sharedClient(ctx, options)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Waiting for operation %s in zone %s...", operation.Name, operation.Zone)
+	err = client.WaitForOperation(ctx, operation.Zone, operation.Name)
+	if err != nil {
+		return err
+	}
+
+	removePendingOperation(options)
+	log.Infof("Operation %s is done", operation.Name)
+	return nil
+}
+
+func pendingOperationPath(options *options.Options) string {
+	return filepath.Join(options.MachineFolder, "operation.json")
+}
+
+// writePendingOperation persists the operation so the wait can be resumed
+func writePendingOperation(options *options.Options, name string) error {
+	out, err := json.Marshal(&pendingOperation{Name: name, Zone: options.Zone})
+	if err != nil {
+		return err
+	}
+
+	err = os.WriteFile(pendingOperationPath(options), out, 0600)
+	if err != nil {
+		return fmt.Errorf("write pending operation: %w", err)
+	}
+
+	return nil
+}
+
+// readPendingOperation returns the persisted operation or nil if there is none
+func readPendingOperation(options *options.Options) (*pendingOperation, error) {
+	out, err := os.ReadFile(pendingOperationPath(options))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("read pending operation: %w", err)
+	}
+
+	operation := &pendingOperation{}
+	err = json.Unmarshal(out, operation)
+	if err != nil {
+		return nil, fmt.Errorf("parse pending operation: %w", err)
+	}
+
+	return operation, nil
+}
+
+func removePendingOperation(options *options.Options) {
+	_ = os.Remove(pendingOperationPath(options))
+}
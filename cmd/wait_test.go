@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPendingOperationRoundTrip(t *testing.T) {
+	_, options := newFakeCompute(t)
+
+	operation, err := readPendingOperation(options)
+	if err != nil || operation != nil {
+		t.Fatalf("readPendingOperation() = %v, %v, want no operation", operation, err)
+	}
+
+	if err := writePendingOperation(options, "op-insert"); err != nil {
+		t.Fatal(err)
+	}
+	operation, err = readPendingOperation(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (&pendingOperation{Name: "op-insert", Zone: options.Zone}); !reflect.DeepEqual(operation, want) {
+		t.Errorf("readPendingOperation() = %+v, want %+v", operation, want)
+	}
+
+	removePendingOperation(options)
+	operation, err = readPendingOperation(options)
+	if err != nil || operation != nil {
+		t.Errorf("readPendingOperation() after remove = %v, %v, want no operation", operation, err)
+	}
+}
+
+func TestWaitResumesCanceledCreate(t *testing.T) {
+	tests := []struct {
+		name        string
+		operation   map[string]interface{}
+		wantErr     string
+		wantPending bool
+	}{
+		{
+			name:      "operation done",
+			operation: map[string]interface{}{"name": "op-insert", "status": "DONE"},
+		},
+		{
+			name: "operation failed",
+			operation: map[string]interface{}{"name": "op-insert", "status": "DONE", "error": map[string]interface{}{
+				"errors": []map[string]string{{"code": "ZONE_RESOURCE_POOL_EXHAUSTED", "message": "the zone does not have enough resources"}},
+			}},
+			wantErr:     "operation op-insert failed: the zone does not have enough resources",
+			wantPending: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			fake, options := newFakeCreate(t)
+			fake.handle(http.MethodPost, "/instances", func(w http.ResponseWriter, r *http.Request) {
+				writeOperation(w, "op-insert")
+			})
+			canceled := false
+			fake.handle(http.MethodPost, "/operations/op-insert/wait", func(w http.ResponseWriter, r *http.Request) {
+				// the create is interrupted while waiting for the insert, the wait command resumes it
+				if !canceled {
+					canceled = true
+					cancel()
+					writeOperation(w, "op-insert")
+					return
+				}
+				writeJSON(w, test.operation)
+			})
+
+			// the interrupted create keeps the operation for the wait command
+			create := &CreateCmd{KeepOnCancel: true, Output: "plain", Progress: func(string, int) {}}
+			if err := create.Run(ctx, options, discardLogger()); err == nil {
+				t.Fatal("Run() of a canceled create didn't fail")
+			}
+			operation, err := readPendingOperation(options)
+			if err != nil || operation == nil || operation.Name != "op-insert" {
+				t.Fatalf("readPendingOperation() after the canceled create = %v, %v, want op-insert", operation, err)
+			}
+
+			err = (&WaitCmd{}).Run(context.Background(), options, discardLogger())
+			if test.wantErr == "" && err != nil {
+				t.Errorf("Run() error = %v", err)
+			} else if test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)) {
+				t.Errorf("Run() error = %v, want %q", err, test.wantErr)
+			}
+
+			operation, err = readPendingOperation(options)
+			if err != nil {
+				t.Fatal(err)
+			} else if pending := operation != nil; pending != test.wantPending {
+				t.Errorf("operation pending after the wait = %t, want %t", pending, test.wantPending)
+			}
+		})
+	}
+}
+
+func TestWaitWithoutPendingOperation(t *testing.T) {
+	_, options := newFakeCompute(t)
+
+	err := (&WaitCmd{}).Run(context.Background(), options, discardLogger())
+	if err == nil || !strings.Contains(err.Error(), "no pending operation for instance devpod-test") {
+		t.Errorf("Run() error = %v, want no pending operation", err)
+	}
+}
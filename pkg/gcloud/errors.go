@@ -0,0 +1,119 @@
+package gcloud
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/googleapis/gax-go/v2/apierror"
+	"google.golang.org/api/googleapi"
+)
+
+// The errors returned by Get, Create and Delete can be checked against these with errors.Is,
+// while the underlying api error is still available with errors.As
+var (
+	ErrNotFound         = errors.New("not found")
+	ErrPermissionDenied = errors.New("permission denied")
+	ErrQuotaExceeded    = errors.New("quota exceeded")
+)
+
+// classifiedError is an api error classified as one of the sentinel errors
+type classifiedError struct {
+	kind error
+	err  error
+}
+
+func (e *classifiedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *classifiedError) Unwrap() error {
+	return e.err
+}
+
+func (e *classifiedError) Is(target error) bool {
+	return target == e.kind
+}
+
+// classifyError marks err with the sentinel error matching its api error code and returns
+// errors that don't match any of them as they are
+func classifyError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case isQuotaExceeded(err):
+		// quota errors are returned as 403, so they have to be checked before permissions
+		return &classifiedError{kind: ErrQuotaExceeded, err: err}
+	case isNotFound(err):
+		return &classifiedError{kind: ErrNotFound, err: err}
+	case isPermissionDenied(err):
+		return &classifiedError{kind: ErrPermissionDenied, err: err}
+	}
+
+	return err
+}
+
+// isConflict checks if err is returned because another operation on the resource is in progress
+func isConflict(err error) bool {
+	apiError, ok := err.(*apierror.APIError)
+	if ok {
+		googleAPIError, ok := apiError.Unwrap().(*googleapi.Error)
+		if ok {
+			if googleAPIError.Code == 409 {
+				return true
+			}
+
+			for _, item := range googleAPIError.Errors {
+				if item.Reason == "resourceNotReady" {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+// isPreconditionFailed checks if err is returned because the fingerprint of a request doesn't
+// match the current one of the resource
+func isPreconditionFailed(err error) bool {
+	apiError, ok := err.(*apierror.APIError)
+	if ok {
+		googleAPIError, ok := apiError.Unwrap().(*googleapi.Error)
+		if ok {
+			if googleAPIError.Code == 412 {
+				return true
+			}
+
+			for _, item := range googleAPIError.Errors {
+				if item.Reason == "conditionNotMet" {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+// isQuotaExceeded checks if err is a quota or rate limit error of the compute api, either
+// returned by the request or as the error of the operation
+func isQuotaExceeded(err error) bool {
+	apiError, ok := err.(*apierror.APIError)
+	if ok {
+		googleAPIError, ok := apiError.Unwrap().(*googleapi.Error)
+		if ok {
+			if googleAPIError.Code == 429 {
+				return true
+			}
+
+			for _, item := range googleAPIError.Errors {
+				if item.Reason == "quotaExceeded" || item.Reason == "rateLimitExceeded" {
+					return true
+				}
+			}
+		}
+	}
+
+	// the operation errors only carry the error code in the message
+	return strings.Contains(err.Error(), "QUOTA_EXCEEDED")
+}
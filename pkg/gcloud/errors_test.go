@@ -0,0 +1,92 @@
+package gcloud
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/googleapis/gax-go/v2/apierror"
+	"google.golang.org/api/googleapi"
+)
+
+// apiError returns the api error of the given code and reason as returned by the compute clients
+func apiError(t *testing.T, code int, reason string) error {
+	t.Helper()
+
+	err, ok := apierror.FromError(&googleapi.Error{
+		Code:    code,
+		Message: reason,
+		Errors:  []googleapi.ErrorItem{{Reason: reason, Message: reason}},
+	})
+	if !ok {
+		t.Fatalf("no api error for code %d", code)
+	}
+
+	return err
+}
+
+func TestClassifyError(t *testing.T) {
+	sentinels := []error{ErrNotFound, ErrPermissionDenied, ErrQuotaExceeded}
+	tests := []struct {
+		name string
+		err  func(t *testing.T) error
+		want error
+	}{
+		{name: "not found", err: func(t *testing.T) error { return apiError(t, 404, "notFound") }, want: ErrNotFound},
+		{name: "permission denied", err: func(t *testing.T) error { return apiError(t, 403, "forbidden") }, want: ErrPermissionDenied},
+		{name: "quota exceeded", err: func(t *testing.T) error { return apiError(t, 403, "quotaExceeded") }, want: ErrQuotaExceeded},
+		{name: "rate limit exceeded", err: func(t *testing.T) error { return apiError(t, 403, "rateLimitExceeded") }, want: ErrQuotaExceeded},
+		{name: "too many requests", err: func(t *testing.T) error { return apiError(t, 429, "tooManyRequests") }, want: ErrQuotaExceeded},
+		{name: "quota exceeded operation", err: func(t *testing.T) error {
+			return errors.New("operation failed: QUOTA_EXCEEDED: Quota 'CPUS' exceeded")
+		}, want: ErrQuotaExceeded},
+		{name: "conflict", err: func(t *testing.T) error { return apiError(t, 409, "alreadyExists") }},
+		{name: "server error", err: func(t *testing.T) error { return apiError(t, 500, "backendError") }},
+		{name: "other error", err: func(t *testing.T) error { return fmt.Errorf("dial tcp: connection refused") }},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.err(t)
+			got := classifyError(err)
+			if got.Error() != err.Error() {
+				t.Errorf("classifyError() error = %q, want the message %q", got, err)
+			}
+
+			var target *apierror.APIError
+			if errors.As(err, &target) && !errors.As(got, &target) {
+				t.Errorf("classifyError() error = %v, the api error isn't available anymore", got)
+			}
+
+			for _, sentinel := range sentinels {
+				if is := errors.Is(got, sentinel); is != (sentinel == test.want) {
+					t.Errorf("errors.Is(classifyError(), %v) = %t", sentinel, is)
+				}
+			}
+		})
+	}
+
+	if err := classifyError(nil); err != nil {
+		t.Errorf("classifyError(nil) = %v, want nil", err)
+	}
+}
+
+func TestIsConflict(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "conflict", err: apiError(t, 409, "conflict"), want: true},
+		{name: "resource not ready", err: apiError(t, 400, "resourceNotReady"), want: true},
+		{name: "bad request", err: apiError(t, 400, "invalid")},
+		{name: "not found", err: apiError(t, 404, "notFound")},
+		{name: "other error", err: fmt.Errorf("dial tcp: connection refused")},
+	}
+
+	for _, test := range tests {
+		if got := isConflict(test.err); got != test.want {
+			t.Errorf("isConflict() with %s = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
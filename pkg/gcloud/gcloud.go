@@ -1,16 +1,26 @@
 package gcloud
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
+	"net/url"
 	"os"
 	"path"
-	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	compute "cloud.google.com/go/compute/apiv1"
 	computepb "cloud.google.com/go/compute/apiv1/computepb"
+	"github.com/badal-io/devpod-provider-gcloud/pkg/ptr"
+	"github.com/googleapis/gax-go/v2"
 	"github.com/googleapis/gax-go/v2/apierror"
 	"github.com/loft-sh/devpod/pkg/client"
 	"golang.org/x/oauth2"
@@ -26,6 +36,14 @@ func NewClient(ctx context.Context, project, zone string, opts ...option.ClientO
 		return nil, err
 	}
 
+	networkOpts, httpClient, err := networkClientOptions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	opts = append(networkOpts, opts...)
+
+	// The Compute Engine API is only served over REST, there are no gRPC
+	// transports available for these clients.
 	instanceClient, err := compute.NewInstancesRESTClient(ctx, opts...)
 	if err != nil {
 		return nil, err
@@ -36,55 +54,320 @@ func NewClient(ctx context.Context, project, zone string, opts ...option.ClientO
 		return nil, err
 	}
 
+	machineTypesClient, err := compute.NewMachineTypesRESTClient(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	imagesClient, err := compute.NewImagesRESTClient(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	regionsClient, err := compute.NewRegionsRESTClient(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	firewallsClient, err := compute.NewFirewallsRESTClient(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	operationsClient, err := compute.NewZoneOperationsRESTClient(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	instanceGroupsClient, err := compute.NewInstanceGroupsRESTClient(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	addressesClient, err := compute.NewAddressesRESTClient(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	subnetworksClient, err := compute.NewSubnetworksRESTClient(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	disksClient, err := compute.NewDisksRESTClient(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+
 	return &Client{
-		InstanceClient: instanceClient,
-		RoutersClient:  routersClient,
-		Project:        project,
-		Zone:           zone,
+		InstanceClient:       instanceClient,
+		RoutersClient:        routersClient,
+		MachineTypesClient:   machineTypesClient,
+		ImagesClient:         imagesClient,
+		RegionsClient:        regionsClient,
+		FirewallsClient:      firewallsClient,
+		OperationsClient:     operationsClient,
+		InstanceGroupsClient: instanceGroupsClient,
+		AddressesClient:      addressesClient,
+		SubnetworksClient:    subnetworksClient,
+		DisksClient:          disksClient,
+		Project:              project,
+		Zone:                 zone,
+
+		httpClient:   httpClient,
+		machineTypes: map[string]*computepb.MachineType{},
 	}, nil
 }
 
+var (
+	sharedClientsMutex sync.Mutex
+	sharedClients      = map[string]*Client{}
+)
+
+// SharedClient returns a client for the given project and zone that is created on first
+// use and reused by subsequent calls within the same process. The options are only
+// applied when the client is created. SetCallOptions and SetWorkspace change the shared
+// client, so the last caller wins, which is fine as every process runs a single command.
+// Shared clients are closed by CloseAll.
+func SharedClient(ctx context.Context, project, zone string, opts ...option.ClientOption) (*Client, error) {
+	sharedClientsMutex.Lock()
+	defer sharedClientsMutex.Unlock()
+
+	key := project + "/" + zone
+	if client, ok := sharedClients[key]; ok {
+		return client, nil
+	}
+
+	client, err := NewClient(ctx, project, zone, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	sharedClients[key] = client
+	return client, nil
+}
+
+// SetWorkspace sets the workspace label value that the instances returned by Get must have
+func (c *Client) SetWorkspace(workspace string) {
+	c.workspace = workspace
+}
+
+// SetCallOptions configures the get, start, stop and delete calls of the client to retry
+// transient errors up to maxRetries times and to give up on a single call after the timeout,
+// inserts only get the timeout. Zero values keep the defaults of the generated clients, which
+// don't retry or time out.
+func (c *Client) SetCallOptions(maxRetries int, timeout time.Duration) {
+	c.callOptions = nil
+	if maxRetries > 0 {
+		c.callOptions = append(c.callOptions, gax.WithRetry(func() gax.Retryer {
+			return &limitedRetryer{
+				retryer: gax.OnHTTPCodes(gax.Backoff{
+					Initial:    time.Second,
+					Max:        30 * time.Second,
+					Multiplier: 2,
+				}, http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout),
+				remaining: maxRetries,
+			}
+		}))
+	}
+
+	c.callTimeout = timeout
+}
+
+// callContext returns the context for a single api call. The vendored gax has no
+// WithTimeout call option, so the per-call timeout is applied as a context deadline.
+func (c *Client) callContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.callTimeout <= 0 {
+		return ctx, func() {}
+	}
+
+	return context.WithTimeout(ctx, c.callTimeout)
+}
+
+// limitedRetryer stops retrying after the given number of retries
+type limitedRetryer struct {
+	retryer   gax.Retryer
+	remaining int
+}
+
+func (r *limitedRetryer) Retry(err error) (time.Duration, bool) {
+	if r.remaining <= 0 {
+		return 0, false
+	}
+
+	r.remaining--
+	return r.retryer.Retry(err)
+}
+
+// CloseAll closes all clients created by SharedClient
+func CloseAll() error {
+	sharedClientsMutex.Lock()
+	defer sharedClientsMutex.Unlock()
+
+	var closeErr error
+	for key, client := range sharedClients {
+		err := client.Close()
+		if err != nil && closeErr == nil {
+			closeErr = err
+		}
+
+		delete(sharedClients, key)
+	}
+
+	return closeErr
+}
+
 type Client struct {
-	InstanceClient *compute.InstancesClient
-	RoutersClient  *compute.RoutersClient
+	InstanceClient       *compute.InstancesClient
+	RoutersClient        *compute.RoutersClient
+	MachineTypesClient   *compute.MachineTypesClient
+	ImagesClient         *compute.ImagesClient
+	RegionsClient        *compute.RegionsClient
+	FirewallsClient      *compute.FirewallsClient
+	OperationsClient     *compute.ZoneOperationsClient
+	InstanceGroupsClient *compute.InstanceGroupsClient
+	AddressesClient      *compute.AddressesClient
+	SubnetworksClient    *compute.SubnetworksClient
+	DisksClient          *compute.DisksClient
 
 	Project string
 	Zone    string
+
+	// httpClient is the http client of the HTTPS_PROXY for the apis that aren't part of the
+	// vendored clients, nil without a proxy
+	httpClient *http.Client
+
+	machineTypesMutex sync.Mutex
+	machineTypes      map[string]*computepb.MachineType
+
+	callOptions []gax.CallOption
+	callTimeout time.Duration
+
+	workspace string
 }
 
+var (
+	envJSONMutex sync.Mutex
+	envJSONPath  string
+)
+
+// SetupEnvJson writes the credentials of GCLOUD_JSON_AUTH to a file for the application
+// default credentials. Every process gets its own temporary file, so concurrent invocations
+// for different projects don't overwrite each other's credentials. CleanupEnvJson removes it.
 func SetupEnvJson(ctx context.Context) error {
-	if os.Getenv("GCLOUD_JSON_AUTH") != "" {
-		exePath, err := os.Executable()
-		if err != nil {
-			return err
-		}
-		destination := filepath.Join(path.Dir(exePath), "gcloud_auth.json")
+	if os.Getenv("GCLOUD_JSON_AUTH") == "" {
+		return nil
+	}
 
-		f, err := os.OpenFile(destination, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o600)
-		if err != nil {
-			return err
-		}
-		defer f.Close()
+	envJSONMutex.Lock()
+	defer envJSONMutex.Unlock()
+	if envJSONPath != "" {
+		return nil
+	}
 
-		_, err = f.WriteString(os.Getenv("GCLOUD_JSON_AUTH"))
-		if err != nil {
-			return err
-		}
+	// CreateTemp creates the file only readable by the user
+	f, err := os.CreateTemp("", "gcloud_auth-*.json")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
 
-		return os.Setenv("GOOGLE_APPLICATION_CREDENTIALS", destination)
+	_, err = f.WriteString(os.Getenv("GCLOUD_JSON_AUTH"))
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return err
 	}
 
-	return nil
+	envJSONPath = f.Name()
+	return os.Setenv("GOOGLE_APPLICATION_CREDENTIALS", envJSONPath)
 }
 
-func DefaultTokenSource(ctx context.Context) (oauth2.TokenSource, error) {
-	scopes := []string{
-		"https://www.googleapis.com/auth/cloud-platform",
+// CleanupEnvJson removes the credentials file written by SetupEnvJson
+func CleanupEnvJson() error {
+	envJSONMutex.Lock()
+	defer envJSONMutex.Unlock()
+	if envJSONPath == "" {
+		return nil
 	}
 
+	err := os.Remove(envJSONPath)
+	envJSONPath = ""
+	return err
+}
+
+var scopes = []string{
+	"https://www.googleapis.com/auth/cloud-platform",
+}
+
+func DefaultTokenSource(ctx context.Context) (oauth2.TokenSource, error) {
 	return google.DefaultTokenSource(ctx, scopes...)
 }
 
+const defaultComputeEndpoint = "https://compute.googleapis.com"
+
+// ComputeEndpoint returns the compute api endpoint, which can be overridden with
+// COMPUTE_API_ENDPOINT, e.g. for private service connect
+func ComputeEndpoint() (string, error) {
+	endpoint := os.Getenv("COMPUTE_API_ENDPOINT")
+	if endpoint == "" {
+		return defaultComputeEndpoint, nil
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" || (u.Path != "" && u.Path != "/") {
+		return "", fmt.Errorf("invalid COMPUTE_API_ENDPOINT %s, expected an url like https://compute-myendpoint.p.googleapis.com", endpoint)
+	}
+
+	return strings.TrimSuffix(endpoint, "/"), nil
+}
+
+// resourceManagerEndpoint returns the resource manager api endpoint. With a private service
+// connect endpoint in COMPUTE_API_ENDPOINT, the resource manager api of the same endpoint is used.
+func resourceManagerEndpoint() (string, error) {
+	endpoint, err := ComputeEndpoint()
+	if err != nil {
+		return "", err
+	}
+
+	u, _ := url.Parse(endpoint)
+	if !strings.HasPrefix(u.Host, "compute-") || !strings.HasSuffix(u.Host, ".p.googleapis.com") {
+		return "https://cloudresourcemanager.googleapis.com", nil
+	}
+
+	u.Host = "cloudresourcemanager-" + strings.TrimPrefix(u.Host, "compute-")
+	return u.String(), nil
+}
+
+// networkClientOptions returns the client options for a custom api endpoint and
+// for routing the api calls through the proxy configured in HTTPS_PROXY. The http client
+// of the proxy is returned as well, it's nil without a proxy.
+func networkClientOptions(ctx context.Context) ([]option.ClientOption, *http.Client, error) {
+	opts := []option.ClientOption{}
+
+	endpoint, err := ComputeEndpoint()
+	if err != nil {
+		return nil, nil, err
+	} else if endpoint != defaultComputeEndpoint {
+		opts = append(opts, option.WithEndpoint(endpoint))
+	}
+
+	if os.Getenv("HTTPS_PROXY") != "" || os.Getenv("https_proxy") != "" {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.Proxy = http.ProxyFromEnvironment
+
+		// the base client is used for the token exchange as well as the api calls
+		baseClient := &http.Client{Transport: transport}
+		httpClient, err := google.DefaultClient(context.WithValue(ctx, oauth2.HTTPClient, baseClient), scopes...)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return append(opts, option.WithHTTPClient(httpClient)), httpClient, nil
+	}
+
+	return opts, nil, nil
+}
+
 func ParseToken(tok string) (*oauth2.Token, error) {
 	oauthToken := &oauth2.Token{}
 	err := json.Unmarshal([]byte(tok), oauthToken)
@@ -117,9 +400,11 @@ func GetToken(ctx context.Context) ([]byte, error) {
 }
 
 func (c *Client) Init(ctx context.Context) error {
+	// a single result is enough to verify access, even in projects with many instances
 	_, err := c.InstanceClient.List(ctx, &computepb.ListInstancesRequest{
-		Project: c.Project,
-		Zone:    c.Zone,
+		Project:    c.Project,
+		Zone:       c.Zone,
+		MaxResults: ptr.Ptr(uint32(1)),
 	}).Next()
 	if err != nil && err != iterator.Done {
 		return fmt.Errorf("cannot list instances: %v", err)
@@ -128,25 +413,138 @@ func (c *Client) Init(ctx context.Context) error {
 	return nil
 }
 
-func (c *Client) Create(ctx context.Context, instance *computepb.Instance) error {
-	operation, err := c.InstanceClient.Insert(ctx, &computepb.InsertInstanceRequest{
+// ManagedByLabel and ManagedByValue mark the instances created by the provider, so
+// cleanups never touch resources they don't own
+const (
+	ManagedByLabel = "managed-by"
+	ManagedByValue = "devpod-provider-gcloud"
+)
+
+// WorkspaceLabel holds the devpod workspace an instance was created for
+const WorkspaceLabel = "devpod-workspace"
+
+// IsManaged checks if the instance was created by the provider
+func IsManaged(instance *computepb.Instance) bool {
+	return instance.GetLabels()[ManagedByLabel] == ManagedByValue
+}
+
+// ErrAlreadyExists is returned by Create if an instance with the same name exists
+var ErrAlreadyExists = errors.New("instance already exists")
+
+// Create inserts the instance and waits for the insert to finish. The started callback is
+// called with the name of the insert operation before waiting, so the wait can be resumed
+// with WaitForOperation if the process is interrupted.
+func (c *Client) Create(ctx context.Context, instance *computepb.Instance, started func(operation string) error) error {
+	return c.insert(ctx, &computepb.InsertInstanceRequest{
 		InstanceResource: instance,
 		Project:          c.Project,
 		Zone:             c.Zone,
-	})
+	}, started)
+}
+
+// CreateFromTemplate creates the instance from the given instance template like Create, the
+// fields set in the instance override the ones of the template.
+func (c *Client) CreateFromTemplate(ctx context.Context, instance *computepb.Instance, template string, started func(operation string) error) error {
+	return c.insert(ctx, &computepb.InsertInstanceRequest{
+		InstanceResource:       instance,
+		Project:                c.Project,
+		SourceInstanceTemplate: ptr.Ptr(template),
+		Zone:                   c.Zone,
+	}, started)
+}
+
+func (c *Client) insert(ctx context.Context, request *computepb.InsertInstanceRequest, started func(operation string) error) error {
+	// inserts aren't retried, the retry of an insert that succeeded would fail as the instance exists
+	callCtx, cancel := c.callContext(ctx)
+	operation, err := c.InstanceClient.Insert(callCtx, request)
+	cancel()
 	if err != nil {
-		return err
+		if isAlreadyExists(err) {
+			return ErrAlreadyExists
+		}
+
+		return orgPolicyError(classifyError(err))
 	}
 
-	return operation.Wait(ctx)
+	if started != nil {
+		err = started(operation.Name())
+		if err != nil {
+			return err
+		}
+	}
+
+	// org policies are checked by the insert request as well as while the operation runs.
+	// The Wait of the operation only returns errors of polling it, not the errors the
+	// operation failed with, so the operation is waited for with WaitForOperation.
+	return orgPolicyError(classifyError(c.WaitForOperation(ctx, c.Zone, operation.Name())))
+}
+
+// orgPolicyGuidance maps the org policy constraints that commonly block creates to how to comply with them
+var orgPolicyGuidance = map[string]string{
+	"constraints/compute.vmExternalIpAccess":           "external IPs are disabled by org policy, set PUBLIC_IP_ENABLED=false",
+	"constraints/compute.requireShieldedVm":            "shielded VMs are required by org policy, use a DISK_IMAGE that supports shielded VM",
+	"constraints/compute.trustedImageProjects":         "the project of DISK_IMAGE isn't a trusted image project of the org policy, use an image from an allowed project",
+	"constraints/compute.requireOsLogin":               "OS Login is required by org policy, which replaces the ssh key in the instance metadata",
+	"constraints/gcp.resourceLocations":                "the ZONE isn't an allowed resource location of the org policy, use a zone in an allowed region",
+	"constraints/compute.restrictNonCmekServices":      "customer managed encryption keys are required by org policy for the disks",
+	"constraints/compute.restrictSharedVpcSubnetworks": "SUBNETWORK isn't an allowed shared VPC subnetwork of the org policy",
+}
+
+// orgPolicyError prefixes errors caused by a known org policy constraint with guidance on
+// how to comply with it and returns all other errors as they are
+func orgPolicyError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	message := err.Error()
+	for constraint, guidance := range orgPolicyGuidance {
+		if strings.Contains(message, constraint) {
+			return fmt.Errorf("%s: %w", guidance, err)
+		}
+	}
+
+	return err
+}
+
+// WaitForOperation waits until the given zone operation is done and returns its error if it failed
+func (c *Client) WaitForOperation(ctx context.Context, zone, name string) error {
+	for {
+		// wait returns after at most two minutes even if the operation isn't done yet
+		operation, err := c.OperationsClient.Wait(ctx, &computepb.WaitZoneOperationRequest{
+			Operation: name,
+			Project:   c.Project,
+			Zone:      zone,
+		})
+		if err != nil {
+			return err
+		}
+
+		if operation.GetStatus() != computepb.Operation_DONE {
+			continue
+		}
+
+		if operationErrors := operation.GetError().GetErrors(); len(operationErrors) > 0 {
+			messages := []string{}
+			for _, operationError := range operationErrors {
+				messages = append(messages, operationError.GetMessage())
+			}
+
+			return fmt.Errorf("operation %s failed: %s", name, strings.Join(messages, "; "))
+		}
+
+		return nil
+	}
 }
 
 func (c *Client) Start(ctx context.Context, name string) error {
-	operation, err := c.InstanceClient.Start(ctx, &computepb.StartInstanceRequest{
+	callCtx, cancel := c.callContext(ctx)
+	operation, err := c.InstanceClient.Start(callCtx, &computepb.StartInstanceRequest{
 		Instance: name,
 		Project:  c.Project,
 		Zone:     c.Zone,
-	})
+	}, c.callOptions...)
+	cancel()
 	if err != nil {
 		return err
 	}
@@ -154,99 +552,1046 @@ func (c *Client) Start(ctx context.Context, name string) error {
 	return operation.Wait(ctx)
 }
 
-func (c *Client) Stop(ctx context.Context, name string, async bool) error {
-	operation, err := c.InstanceClient.Stop(ctx, &computepb.StopInstanceRequest{
+// Resume resumes the given suspended instance
+func (c *Client) Resume(ctx context.Context, name string) error {
+	callCtx, cancel := c.callContext(ctx)
+	operation, err := c.InstanceClient.Resume(callCtx, &computepb.ResumeInstanceRequest{
 		Instance: name,
 		Project:  c.Project,
 		Zone:     c.Zone,
-	})
+	}, c.callOptions...)
+	cancel()
 	if err != nil {
 		return err
-	} else if async {
-		return nil
 	}
 
 	return operation.Wait(ctx)
 }
 
-func (c *Client) Delete(ctx context.Context, name string) error {
-	operation, err := c.InstanceClient.Delete(ctx, &computepb.DeleteInstanceRequest{
-		Instance: name,
-		Project:  c.Project,
-		Zone:     c.Zone,
-	})
+// Stop stops the given instance. Instances with local ssds can only be stopped if
+// discardLocalSSD is set, as their contents are lost.
+func (c *Client) Stop(ctx context.Context, name string, async, discardLocalSSD bool) error {
+	callCtx, cancel := c.callContext(ctx)
+	operation, err := c.InstanceClient.Stop(callCtx, &computepb.StopInstanceRequest{
+		Instance:        name,
+		Project:         c.Project,
+		Zone:            c.Zone,
+		DiscardLocalSsd: ptr.Ptr(discardLocalSSD),
+	}, c.callOptions...)
+	cancel()
 	if err != nil {
 		return err
+	} else if async {
+		return nil
 	}
 
 	return operation.Wait(ctx)
 }
 
-func (c *Client) Get(ctx context.Context, name string) (*computepb.Instance, error) {
-	instance, err := c.InstanceClient.Get(ctx, &computepb.GetInstanceRequest{
+// StartMany starts the given instances concurrently with at most concurrency
+// operations in flight and waits for all of them
+func (c *Client) StartMany(ctx context.Context, names []string, concurrency int) error {
+	return forEach(names, concurrency, func(name string) error {
+		return c.Start(ctx, name)
+	})
+}
+
+// StopMany stops the given instances concurrently with at most concurrency
+// operations in flight and waits for all of them
+func (c *Client) StopMany(ctx context.Context, names []string, concurrency int, discardLocalSSD bool) error {
+	return forEach(names, concurrency, func(name string) error {
+		return c.Stop(ctx, name, false, discardLocalSSD)
+	})
+}
+
+// forEach runs fn for all names using a bounded number of goroutines and aggregates the errors
+func forEach(names []string, concurrency int, fn func(name string) error) error {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	var (
+		wg        sync.WaitGroup
+		errsMutex sync.Mutex
+		errs      []string
+	)
+	semaphore := make(chan struct{}, concurrency)
+	for _, name := range names {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(name string) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			err := fn(name)
+			if err != nil {
+				errsMutex.Lock()
+				errs = append(errs, fmt.Sprintf("%s: %v", name, err))
+				errsMutex.Unlock()
+			}
+		}(name)
+	}
+	wg.Wait()
+
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return fmt.Errorf("%d of %d operations failed:\n%s", len(errs), len(names), strings.Join(errs, "\n"))
+	}
+
+	return nil
+}
+
+func (c *Client) Delete(ctx context.Context, name string) error {
+	callCtx, cancel := c.callContext(ctx)
+	operation, err := c.InstanceClient.Delete(callCtx, &computepb.DeleteInstanceRequest{
 		Instance: name,
 		Project:  c.Project,
 		Zone:     c.Zone,
-	})
+	}, c.callOptions...)
+	cancel()
 	if err != nil {
-		// check if api error
-		apiError, ok := err.(*apierror.APIError)
-		if ok {
-			googleAPIError, ok := apiError.Unwrap().(*googleapi.Error)
-			if ok && googleAPIError.Code == 404 {
-				return nil, nil
-			}
-		}
-
-		return nil, err
+		return classifyError(err)
 	}
 
-	return instance, nil
+	return classifyError(operation.Wait(ctx))
 }
 
-func (c *Client) Status(ctx context.Context, name string) (client.Status, error) {
+// ForceDelete deletes the instance like Delete, but first disables its deletion protection,
+// retries the delete up to attempts times while another operation on the instance conflicts
+// with it and only waits for the delete to finish until the wait timeout.
+func (c *Client) ForceDelete(ctx context.Context, name string, attempts int, wait time.Duration) error {
 	instance, err := c.Get(ctx, name)
 	if err != nil {
-		return client.StatusNotFound, err
+		return err
 	} else if instance == nil {
-		return client.StatusNotFound, nil
+		return fmt.Errorf("instance %s: %w", name, ErrNotFound)
+	}
+
+	if instance.GetDeletionProtection() {
+		operation, err := c.InstanceClient.SetDeletionProtection(ctx, &computepb.SetDeletionProtectionInstanceRequest{
+			DeletionProtection: ptr.Ptr(false),
+			Project:            c.Project,
+			Resource:           name,
+			Zone:               c.Zone,
+		})
+		if err != nil {
+			return fmt.Errorf("disable deletion protection: %w", classifyError(err))
+		}
+
+		err = operation.Wait(ctx)
+		if err != nil {
+			return fmt.Errorf("disable deletion protection: %w", classifyError(err))
+		}
+	}
+
+	var operation *compute.Operation
+	for attempt := 1; ; attempt++ {
+		callCtx, cancel := c.callContext(ctx)
+		operation, err = c.InstanceClient.Delete(callCtx, &computepb.DeleteInstanceRequest{
+			Instance: name,
+			Project:  c.Project,
+			Zone:     c.Zone,
+		}, c.callOptions...)
+		cancel()
+		if err == nil {
+			break
+		} else if !isConflict(err) || attempt >= attempts {
+			return classifyError(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(attempt) * 5 * time.Second):
+		}
 	}
 
-	status := strings.TrimSpace(strings.ToUpper(*instance.Status))
-	if status == "RUNNING" {
-		return client.StatusRunning, nil
-	} else if status == "STOPPING" || status == "SUSPENDING" || status == "REPAIRING" || status == "PROVISIONING" || status == "STAGING" {
-		return client.StatusBusy, nil
-	} else if status == "TERMINATED" {
-		return client.StatusStopped, nil
+	waitCtx, cancel := context.WithTimeout(ctx, wait)
+	defer cancel()
+	err = operation.Wait(waitCtx)
+	if err != nil && ctx.Err() == nil && waitCtx.Err() != nil {
+		return fmt.Errorf("delete operation %s didn't finish within %v", operation.Name(), wait)
 	}
 
-	return client.StatusNotFound, fmt.Errorf("unexpected status: %v", status)
+	return classifyError(err)
 }
 
-func (c *Client) Close() error {
-	err := c.InstanceClient.Close()
+// SetDiskAutoDelete sets whether the disk attached with the given device name is deleted with the instance
+func (c *Client) SetDiskAutoDelete(ctx context.Context, name, deviceName string, autoDelete bool) error {
+	operation, err := c.InstanceClient.SetDiskAutoDelete(ctx, &computepb.SetDiskAutoDeleteInstanceRequest{
+		Instance:   name,
+		DeviceName: deviceName,
+		AutoDelete: autoDelete,
+		Project:    c.Project,
+		Zone:       c.Zone,
+	})
 	if err != nil {
 		return err
 	}
 
-	err = c.RoutersClient.Close()
+	return operation.Wait(ctx)
+}
+
+// fingerprintAttempts is how often an update is attempted while the resource keeps changing concurrently
+const fingerprintAttempts = 3
+
+// GetDisk returns the disk in the zone of the client, or nil if it doesn't exist
+func (c *Client) GetDisk(ctx context.Context, name string) (*computepb.Disk, error) {
+	callCtx, cancel := c.callContext(ctx)
+	defer cancel()
+
+	disk, err := c.DisksClient.Get(callCtx, &computepb.GetDiskRequest{
+		Disk:    name,
+		Project: c.Project,
+		Zone:    c.Zone,
+	}, c.callOptions...)
 	if err != nil {
-		return err
+		if isNotFound(err) {
+			return nil, nil
+		}
+
+		return nil, classifyError(err)
 	}
 
-	return nil
+	return disk, nil
 }
 
-// CheckCloudNAT checks if Cloud NAT is configured for the given subnet in the region
-func (c *Client) CheckCloudNAT(ctx context.Context, region, subnetName string) (bool, error) {
-	// List all routers in the region
-	routersIterator := c.RoutersClient.List(ctx, &computepb.ListRoutersRequest{
+// ResizeDisk grows the disk to sizeGB and waits until it's resized. Disks can't be shrunk,
+// so a size below the current one is rejected before the request is sent.
+func (c *Client) ResizeDisk(ctx context.Context, name string, sizeGB int64) error {
+	disk, err := c.GetDisk(ctx, name)
+	if err != nil {
+		return err
+	} else if disk == nil {
+		return fmt.Errorf("disk %s: %w", name, ErrNotFound)
+	} else if sizeGB < disk.GetSizeGb() {
+		return fmt.Errorf("disk %s can't be shrunk from %dGB to %dGB", name, disk.GetSizeGb(), sizeGB)
+	} else if sizeGB == disk.GetSizeGb() {
+		return nil
+	}
+
+	callCtx, cancel := c.callContext(ctx)
+	operation, err := c.DisksClient.Resize(callCtx, &computepb.ResizeDiskRequest{
+		Disk: name,
+		DisksResizeRequestResource: &computepb.DisksResizeRequest{
+			SizeGb: ptr.Ptr(sizeGB),
+		},
 		Project: c.Project,
-		Region:  region,
+		Zone:    c.Zone,
+	}, c.callOptions...)
+	cancel()
+	if err != nil {
+		return classifyError(err)
+	}
+
+	return classifyError(operation.Wait(ctx))
+}
+
+// SetLabels updates the labels of the instance, update gets a copy of the current labels and
+// modifies it. The update is applied again to the new labels if they changed concurrently.
+func (c *Client) SetLabels(ctx context.Context, name string, update func(labels map[string]string)) error {
+	return retryOnFingerprintMismatch(func() error {
+		instance, err := c.Get(ctx, name)
+		if err != nil {
+			return err
+		} else if instance == nil {
+			return fmt.Errorf("instance %s: %w", name, ErrNotFound)
+		}
+
+		labels := map[string]string{}
+		for key, value := range instance.GetLabels() {
+			labels[key] = value
+		}
+		update(labels)
+
+		operation, err := c.InstanceClient.SetLabels(ctx, &computepb.SetLabelsInstanceRequest{
+			Instance: name,
+			InstancesSetLabelsRequestResource: &computepb.InstancesSetLabelsRequest{
+				LabelFingerprint: instance.LabelFingerprint,
+				Labels:           labels,
+			},
+			Project: c.Project,
+			Zone:    c.Zone,
+		})
+		if err != nil {
+			return err
+		}
+
+		return operation.Wait(ctx)
+	})
+}
+
+// SetTags updates the network tags of the instance like SetLabels
+func (c *Client) SetTags(ctx context.Context, name string, update func(tags []string) []string) error {
+	return retryOnFingerprintMismatch(func() error {
+		instance, err := c.Get(ctx, name)
+		if err != nil {
+			return err
+		} else if instance == nil {
+			return fmt.Errorf("instance %s: %w", name, ErrNotFound)
+		}
+
+		tags := append([]string{}, instance.GetTags().GetItems()...)
+		operation, err := c.InstanceClient.SetTags(ctx, &computepb.SetTagsInstanceRequest{
+			Instance: name,
+			TagsResource: &computepb.Tags{
+				Fingerprint: instance.GetTags().Fingerprint,
+				Items:       update(tags),
+			},
+			Project: c.Project,
+			Zone:    c.Zone,
+		})
+		if err != nil {
+			return err
+		}
+
+		return operation.Wait(ctx)
+	})
+}
+
+// retryOnFingerprintMismatch runs fn until it succeeds, fails with another error than a
+// fingerprint mismatch or ran fingerprintAttempts times
+func retryOnFingerprintMismatch(fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || !isPreconditionFailed(err) || attempt >= fingerprintAttempts {
+			return classifyError(err)
+		}
+	}
+}
+
+// SetMetadata replaces the metadata of the instance and waits until it's applied, the
+// fingerprint of the metadata must match the current one of the instance
+func (c *Client) SetMetadata(ctx context.Context, name string, metadata *computepb.Metadata) error {
+	operation, err := c.InstanceClient.SetMetadata(ctx, &computepb.SetMetadataInstanceRequest{
+		Instance:         name,
+		MetadataResource: metadata,
+		Project:          c.Project,
+		Zone:             c.Zone,
+	})
+	if err != nil {
+		return classifyError(err)
+	}
+
+	return classifyError(operation.Wait(ctx))
+}
+
+// Get returns the given instance or nil if it doesn't exist
+func (c *Client) Get(ctx context.Context, name string) (*computepb.Instance, error) {
+	callCtx, cancel := c.callContext(ctx)
+	instance, err := c.InstanceClient.Get(callCtx, &computepb.GetInstanceRequest{
+		Instance: name,
+		Project:  c.Project,
+		Zone:     c.Zone,
+	}, c.callOptions...)
+	cancel()
+	if err != nil {
+		if isNotFound(err) {
+			return nil, nil
+		}
+
+		return nil, classifyError(err)
+	}
+
+	// instances with the same name in another workspace are never operated on, instances
+	// without the label were created before it was set
+	if owner := instance.GetLabels()[WorkspaceLabel]; c.workspace != "" && owner != "" && owner != c.workspace {
+		return nil, fmt.Errorf("instance %s belongs to workspace %s and not to workspace %s, add {{.WorkspaceID}} to the NAME_TEMPLATE to give the instances of different workspaces unique names", name, owner, c.workspace)
+	}
+
+	return instance, nil
+}
+
+// GetSerialPortOutput returns the output of the first serial port from the given byte
+// offset and the offset to continue from
+func (c *Client) GetSerialPortOutput(ctx context.Context, name string, start int64) (string, int64, error) {
+	output, err := c.InstanceClient.GetSerialPortOutput(ctx, &computepb.GetSerialPortOutputInstanceRequest{
+		Instance: name,
+		Project:  c.Project,
+		Zone:     c.Zone,
+		Start:    ptr.Ptr(start),
 	})
+	if err != nil {
+		return "", start, err
+	}
+
+	return output.GetContents(), output.GetNext(), nil
+}
+
+// GetHostKeys returns the ssh host keys by key type that the guest environment published
+// to the guest attributes of the instance, or nil if they aren't available yet
+func (c *Client) GetHostKeys(ctx context.Context, name string) (map[string]string, error) {
+	guestAttributes, err := c.InstanceClient.GetGuestAttributes(ctx, &computepb.GetGuestAttributesInstanceRequest{
+		Instance:  name,
+		Project:   c.Project,
+		Zone:      c.Zone,
+		QueryPath: ptr.Ptr("hostkeys/"),
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	hostKeys := map[string]string{}
+	for _, item := range guestAttributes.GetQueryValue().GetItems() {
+		if item.GetNamespace() == "hostkeys" && item.GetValue() != "" {
+			hostKeys[item.GetKey()] = item.GetValue()
+		}
+	}
+	if len(hostKeys) == 0 {
+		return nil, nil
+	}
 
-	// Check each router for NAT configuration on the specified subnet
+	return hostKeys, nil
+}
+
+// WaitForGuestAttribute polls the guest attributes of the instance every interval until the
+// attribute with the given namespace and key is written and returns its value. It only returns
+// early if the guest attributes can't be read or the context is done.
+func (c *Client) WaitForGuestAttribute(ctx context.Context, name, namespace, key string, interval time.Duration) (string, error) {
+	for {
+		guestAttributes, err := c.InstanceClient.GetGuestAttributes(ctx, &computepb.GetGuestAttributesInstanceRequest{
+			Instance:  name,
+			Project:   c.Project,
+			Zone:      c.Zone,
+			QueryPath: ptr.Ptr(namespace + "/"),
+		})
+		if err != nil && !isNotFound(err) {
+			return "", err
+		}
+
+		// the namespace doesn't exist until the first attribute is written to it
+		for _, item := range guestAttributes.GetQueryValue().GetItems() {
+			if item.GetNamespace() == namespace && item.GetKey() == key {
+				return item.GetValue(), nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// ListInstancesWithTag returns the instances managed by the provider in all zones of the project
+// that have the given network tag
+func (c *Client) ListInstancesWithTag(ctx context.Context, tag string) ([]*computepb.Instance, error) {
+	instances := []*computepb.Instance{}
+
+	// network tags can't be filtered on, the label keeps the list to the provider's instances
+	it := c.InstanceClient.AggregatedList(ctx, &computepb.AggregatedListInstancesRequest{
+		Filter:  ptr.Ptr(fmt.Sprintf("labels.%s = %q", ManagedByLabel, ManagedByValue)),
+		Project: c.Project,
+	})
+	for {
+		pair, err := it.Next()
+		if err == iterator.Done {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("cannot list instances: %w", err)
+		}
+
+		for _, instance := range pair.Value.Instances {
+			for _, item := range instance.GetTags().GetItems() {
+				if item == tag {
+					instances = append(instances, instance)
+					break
+				}
+			}
+		}
+	}
+
+	return instances, nil
+}
+
+// GetFirewall returns the given firewall rule or nil if it doesn't exist
+func (c *Client) GetFirewall(ctx context.Context, name string) (*computepb.Firewall, error) {
+	firewall, err := c.FirewallsClient.Get(ctx, &computepb.GetFirewallRequest{
+		Firewall: name,
+		Project:  c.Project,
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	return firewall, nil
+}
+
+// DeleteFirewall deletes the given firewall rule
+func (c *Client) DeleteFirewall(ctx context.Context, name string) error {
+	operation, err := c.FirewallsClient.Delete(ctx, &computepb.DeleteFirewallRequest{
+		Firewall: name,
+		Project:  c.Project,
+	})
+	if err != nil {
+		return err
+	}
+
+	return operation.Wait(ctx)
+}
+
+// GetMachineType returns the given machine type in the client's zone or nil if it
+// doesn't exist. Lookups are cached, so repeated calls only hit the API once.
+func (c *Client) GetMachineType(ctx context.Context, name string) (*computepb.MachineType, error) {
+	c.machineTypesMutex.Lock()
+	defer c.machineTypesMutex.Unlock()
+
+	if machineType, ok := c.machineTypes[name]; ok {
+		return machineType, nil
+	}
+
+	machineType, err := c.MachineTypesClient.Get(ctx, &computepb.GetMachineTypeRequest{
+		MachineType: name,
+		Project:     c.Project,
+		Zone:        c.Zone,
+	})
+	if err != nil {
+		if !isNotFound(err) {
+			return nil, err
+		}
+
+		machineType = nil
+	}
+
+	c.machineTypes[name] = machineType
+	return machineType, nil
+}
+
+// GetRegionQuotas returns the quotas of the given region keyed by metric
+func (c *Client) GetRegionQuotas(ctx context.Context, region string) (map[string]*computepb.Quota, error) {
+	resolvedRegion, err := c.RegionsClient.Get(ctx, &computepb.GetRegionRequest{
+		Project: c.Project,
+		Region:  region,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	quotas := map[string]*computepb.Quota{}
+	for _, quota := range resolvedRegion.Quotas {
+		quotas[quota.GetMetric()] = quota
+	}
+
+	return quotas, nil
+}
+
+// HasZone checks if the given region has the zone
+func (c *Client) HasZone(ctx context.Context, region, zone string) (bool, error) {
+	resolvedRegion, err := c.RegionsClient.Get(ctx, &computepb.GetRegionRequest{
+		Project: c.Project,
+		Region:  region,
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	for _, zoneURL := range resolvedRegion.GetZones() {
+		if path.Base(zoneURL) == zone {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+var (
+	imageFamilyPattern = regexp.MustCompile(`^(?:projects/([^/]+)/)?global/images/family/([^/]+)$`)
+	imagePattern       = regexp.MustCompile(`^(?:projects/([^/]+)/)?global/images/([^/]+)$`)
+)
+
+// GetImage resolves an image reference as accepted by the instance source image, either
+// an image or an image family, and returns nil if it doesn't exist. An error matching
+// ErrPermissionDenied is returned if the caller is not allowed to access the image.
+func (c *Client) GetImage(ctx context.Context, image string) (*computepb.Image, error) {
+	var (
+		err           error
+		resolvedImage *computepb.Image
+	)
+
+	image = strings.TrimPrefix(image, "https://www.googleapis.com/compute/v1/")
+	image = strings.TrimPrefix(image, "https://compute.googleapis.com/compute/v1/")
+	if match := imageFamilyPattern.FindStringSubmatch(image); match != nil {
+		resolvedImage, err = c.ImagesClient.GetFromFamily(ctx, &computepb.GetFromFamilyImageRequest{
+			Family:  match[2],
+			Project: c.projectOrDefault(match[1]),
+		})
+	} else if match := imagePattern.FindStringSubmatch(image); match != nil {
+		resolvedImage, err = c.ImagesClient.Get(ctx, &computepb.GetImageRequest{
+			Image:   match[2],
+			Project: c.projectOrDefault(match[1]),
+		})
+	} else {
+		resolvedImage, err = c.ImagesClient.Get(ctx, &computepb.GetImageRequest{
+			Image:   image,
+			Project: c.Project,
+		})
+	}
+	if err != nil {
+		if isNotFound(err) {
+			return nil, nil
+		} else if isPermissionDenied(err) {
+			return nil, fmt.Errorf("permission denied accessing image %s: %w", image, classifyError(err))
+		}
+
+		return nil, err
+	}
+
+	return resolvedImage, nil
+}
+
+func (c *Client) projectOrDefault(project string) string {
+	if project == "" {
+		return c.Project
+	}
+
+	return project
+}
+
+// isNotFound checks if err is a 404 returned by the compute api
+func isNotFound(err error) bool {
+	apiError, ok := err.(*apierror.APIError)
+	if ok {
+		googleAPIError, ok := apiError.Unwrap().(*googleapi.Error)
+		if ok && googleAPIError.Code == 404 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isAlreadyExists checks if err is a 409 returned by the compute api
+func isAlreadyExists(err error) bool {
+	apiError, ok := err.(*apierror.APIError)
+	if ok {
+		googleAPIError, ok := apiError.Unwrap().(*googleapi.Error)
+		if ok && googleAPIError.Code == 409 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isPermissionDenied checks if err is a 403 returned by the compute api
+func isPermissionDenied(err error) bool {
+	apiError, ok := err.(*apierror.APIError)
+	if ok {
+		googleAPIError, ok := apiError.Unwrap().(*googleapi.Error)
+		if ok && googleAPIError.Code == 403 {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *Client) Status(ctx context.Context, name string) (client.Status, error) {
+	instance, err := c.Get(ctx, name)
+	if err != nil {
+		return client.StatusNotFound, err
+	} else if instance == nil {
+		return client.StatusNotFound, nil
+	}
+
+	return instanceStatus(instance), nil
+}
+
+// instanceStatuses maps the GCE instance statuses to the devpod statuses
+var instanceStatuses = map[string]client.Status{
+	"PROVISIONING": client.StatusBusy,
+	"STAGING":      client.StatusBusy,
+	"RUNNING":      client.StatusRunning,
+	"STOPPING":     client.StatusBusy,
+	"SUSPENDING":   client.StatusBusy,
+	"SUSPENDED":    client.StatusStopped,
+	"REPAIRING":    client.StatusBusy,
+	"TERMINATED":   client.StatusStopped,
+}
+
+// IsKnownInstanceStatus returns whether the given GCE instance status is one the
+// provider knows how to map to a devpod status.
+func IsKnownInstanceStatus(status string) bool {
+	_, ok := instanceStatuses[strings.TrimSpace(strings.ToUpper(status))]
+	return ok
+}
+
+// instanceStatus maps the status of the instance to the devpod status
+func instanceStatus(instance *computepb.Instance) client.Status {
+	status, ok := instanceStatuses[strings.TrimSpace(strings.ToUpper(instance.GetStatus()))]
+	if !ok {
+		// treat unknown statuses as transitional, so devpod waits instead of failing
+		return client.StatusBusy
+	}
+
+	return status
+}
+
+// InstanceDetails holds the most relevant information about an instance
+type InstanceDetails struct {
+	Name              string        `json:"name"`
+	Status            client.Status `json:"status"`
+	InstanceStatus    string        `json:"instanceStatus,omitempty"`
+	MachineType       string        `json:"machineType,omitempty"`
+	Zone              string        `json:"zone"`
+	InternalIP        string        `json:"internalIP,omitempty"`
+	ExternalIP        string        `json:"externalIP,omitempty"`
+	CreationTimestamp string        `json:"creationTimestamp,omitempty"`
+}
+
+// Details returns the details of the given instance. If the instance doesn't exist
+// the returned status is client.StatusNotFound.
+func (c *Client) Details(ctx context.Context, name string) (*InstanceDetails, error) {
+	details := &InstanceDetails{
+		Name:   name,
+		Status: client.StatusNotFound,
+		Zone:   c.Zone,
+	}
+
+	instance, err := c.Get(ctx, name)
+	if err != nil {
+		return nil, err
+	} else if instance == nil {
+		return details, nil
+	}
+
+	details.Status = instanceStatus(instance)
+
+	details.InstanceStatus = instance.GetStatus()
+	if instance.GetMachineType() != "" {
+		details.MachineType = path.Base(instance.GetMachineType())
+	}
+	details.CreationTimestamp = instance.GetCreationTimestamp()
+	if len(instance.NetworkInterfaces) > 0 {
+		networkInterface := instance.NetworkInterfaces[0]
+		details.InternalIP = networkInterface.GetNetworkIP()
+		if len(networkInterface.AccessConfigs) > 0 {
+			details.ExternalIP = networkInterface.AccessConfigs[0].GetNatIP()
+		}
+	}
+
+	return details, nil
+}
+
+func (c *Client) Close() error {
+	err := c.InstanceClient.Close()
+	if err != nil {
+		return err
+	}
+
+	err = c.RoutersClient.Close()
+	if err != nil {
+		return err
+	}
+
+	err = c.MachineTypesClient.Close()
+	if err != nil {
+		return err
+	}
+
+	err = c.ImagesClient.Close()
+	if err != nil {
+		return err
+	}
+
+	err = c.RegionsClient.Close()
+	if err != nil {
+		return err
+	}
+
+	err = c.FirewallsClient.Close()
+	if err != nil {
+		return err
+	}
+
+	err = c.OperationsClient.Close()
+	if err != nil {
+		return err
+	}
+
+	err = c.InstanceGroupsClient.Close()
+	if err != nil {
+		return err
+	}
+
+	err = c.AddressesClient.Close()
+	if err != nil {
+		return err
+	}
+
+	err = c.SubnetworksClient.Close()
+	if err != nil {
+		return err
+	}
+
+	err = c.DisksClient.Close()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// TestPermissions returns which of the given permissions the caller has on the project, which
+// defaults to the client project. The resource manager api isn't part of the vendored clients,
+// so it's called directly, through the HTTPS_PROXY and endpoint of the compute api.
+func (c *Client) TestPermissions(ctx context.Context, project string, permissions []string) ([]string, error) {
+	httpClient := c.httpClient
+	if httpClient == nil {
+		var err error
+		httpClient, err = google.DefaultClient(ctx, scopes...)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	endpoint, err := resourceManagerEndpoint()
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := json.Marshal(map[string][]string{"permissions": permissions})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("%s/v1/projects/%s:testIamPermissions", endpoint, c.projectOrDefault(project)), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	out, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	} else if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("test permissions of project %s: %s: %s", c.projectOrDefault(project), resp.Status, strings.TrimSpace(string(out)))
+	}
+
+	granted := struct {
+		Permissions []string `json:"permissions"`
+	}{}
+	err = json.Unmarshal(out, &granted)
+	if err != nil {
+		return nil, err
+	}
+
+	return granted.Permissions, nil
+}
+
+// HasInstanceGroup checks if the unmanaged instance group exists in the zone of the client
+func (c *Client) HasInstanceGroup(ctx context.Context, name string) (bool, error) {
+	_, err := c.InstanceGroupsClient.Get(ctx, &computepb.GetInstanceGroupRequest{
+		InstanceGroup: name,
+		Project:       c.Project,
+		Zone:          c.Zone,
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	return true, nil
+}
+
+// AddInstanceToGroup adds the instance to the unmanaged instance group and waits until it's added
+func (c *Client) AddInstanceToGroup(ctx context.Context, group, name string) error {
+	operation, err := c.InstanceGroupsClient.AddInstances(ctx, &computepb.AddInstancesInstanceGroupRequest{
+		InstanceGroup: group,
+		InstanceGroupsAddInstancesRequestResource: &computepb.InstanceGroupsAddInstancesRequest{
+			Instances: []*computepb.InstanceReference{
+				{Instance: ptr.Ptr(c.instanceURL(name))},
+			},
+		},
+		Project: c.Project,
+		Zone:    c.Zone,
+	})
+	if err != nil {
+		return classifyError(err)
+	}
+
+	return classifyError(operation.Wait(ctx))
+}
+
+// RemoveInstanceFromGroup removes the instance from the unmanaged instance group and waits until it's removed
+func (c *Client) RemoveInstanceFromGroup(ctx context.Context, group, name string) error {
+	operation, err := c.InstanceGroupsClient.RemoveInstances(ctx, &computepb.RemoveInstancesInstanceGroupRequest{
+		InstanceGroup: group,
+		InstanceGroupsRemoveInstancesRequestResource: &computepb.InstanceGroupsRemoveInstancesRequest{
+			Instances: []*computepb.InstanceReference{
+				{Instance: ptr.Ptr(c.instanceURL(name))},
+			},
+		},
+		Project: c.Project,
+		Zone:    c.Zone,
+	})
+	if err != nil {
+		return classifyError(err)
+	}
+
+	return classifyError(operation.Wait(ctx))
+}
+
+// instanceURL returns the resource path of the instance in the project and zone of the client
+func (c *Client) instanceURL(name string) string {
+	return fmt.Sprintf("projects/%s/zones/%s/instances/%s", c.Project, c.Zone, name)
+}
+
+// GetAddress returns the regional address with the given name, or nil if it doesn't exist
+func (c *Client) GetAddress(ctx context.Context, region, name string) (*computepb.Address, error) {
+	address, err := c.AddressesClient.Get(ctx, &computepb.GetAddressRequest{
+		Address: name,
+		Project: c.Project,
+		Region:  region,
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return nil, nil
+		}
+
+		return nil, classifyError(err)
+	}
+
+	return address, nil
+}
+
+// GetSubnetwork returns the subnetwork of the given project, which defaults to the client
+// project, or nil if it doesn't exist
+func (c *Client) GetSubnetwork(ctx context.Context, project, region, name string) (*computepb.Subnetwork, error) {
+	subnetwork, err := c.SubnetworksClient.Get(ctx, &computepb.GetSubnetworkRequest{
+		Project:    c.projectOrDefault(project),
+		Region:     region,
+		Subnetwork: name,
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return nil, nil
+		}
+
+		return nil, classifyError(err)
+	}
+
+	return subnetwork, nil
+}
+
+// ReserveAddress reserves the given ip as a static address with the given name. An ephemeral
+// ip that is in use by an instance is promoted, so the instance keeps it across stops.
+func (c *Client) ReserveAddress(ctx context.Context, region, name, ip, networkTier, description string) error {
+	address := &computepb.Address{
+		Address:     ptr.Ptr(ip),
+		Description: ptr.Ptr(description),
+		Name:        ptr.Ptr(name),
+	}
+	if networkTier != "" {
+		address.NetworkTier = ptr.Ptr(networkTier)
+	}
+
+	operation, err := c.AddressesClient.Insert(ctx, &computepb.InsertAddressRequest{
+		AddressResource: address,
+		Project:         c.Project,
+		Region:          region,
+	})
+	if err != nil {
+		return classifyError(err)
+	}
+
+	return classifyError(operation.Wait(ctx))
+}
+
+// DeleteAddress releases the regional address with the given name
+func (c *Client) DeleteAddress(ctx context.Context, region, name string) error {
+	operation, err := c.AddressesClient.Delete(ctx, &computepb.DeleteAddressRequest{
+		Address: name,
+		Project: c.Project,
+		Region:  region,
+	})
+	if err != nil {
+		return classifyError(err)
+	}
+
+	return classifyError(operation.Wait(ctx))
+}
+
+// SetExternalIP replaces the access config of the network interface, if any, by one that uses
+// the given ip
+func (c *Client) SetExternalIP(ctx context.Context, name string, networkInterface *computepb.NetworkInterface, ip, networkTier string) error {
+	accessConfigName := "External NAT"
+	for _, accessConfig := range networkInterface.GetAccessConfigs() {
+		operation, err := c.InstanceClient.DeleteAccessConfig(ctx, &computepb.DeleteAccessConfigInstanceRequest{
+			AccessConfig:     accessConfig.GetName(),
+			Instance:         name,
+			NetworkInterface: networkInterface.GetName(),
+			Project:          c.Project,
+			Zone:             c.Zone,
+		})
+		if err != nil {
+			return classifyError(err)
+		}
+
+		err = operation.Wait(ctx)
+		if err != nil {
+			return classifyError(err)
+		}
+		accessConfigName = accessConfig.GetName()
+	}
+
+	accessConfig := &computepb.AccessConfig{
+		Name:  ptr.Ptr(accessConfigName),
+		NatIP: ptr.Ptr(ip),
+	}
+	if networkTier != "" {
+		accessConfig.NetworkTier = ptr.Ptr(networkTier)
+	}
+
+	operation, err := c.InstanceClient.AddAccessConfig(ctx, &computepb.AddAccessConfigInstanceRequest{
+		AccessConfigResource: accessConfig,
+		Instance:             name,
+		NetworkInterface:     networkInterface.GetName(),
+		Project:              c.Project,
+		Zone:                 c.Zone,
+	})
+	if err != nil {
+		return classifyError(err)
+	}
+
+	return classifyError(operation.Wait(ctx))
+}
+
+// CheckCloudNAT checks if Cloud NAT is configured for the given subnet in the region.
+// The routers are looked up in the given project, which defaults to the client project.
+func (c *Client) CheckCloudNAT(ctx context.Context, project, region, subnetName string) (bool, error) {
+	// List all routers in the region, the largest page size keeps the number of requests
+	// low in regions with many routers
+	routersIterator := c.RoutersClient.List(ctx, &computepb.ListRoutersRequest{
+		MaxResults: ptr.Ptr(uint32(500)),
+		Project:    c.projectOrDefault(project),
+		Region:     region,
+	})
+
+	// Check each router for NAT configuration on the specified subnet, returning early is
+	// fine as the iterator only fetches the next page on demand and holds no resources
 	for {
 		router, err := routersIterator.Next()
 		if err == iterator.Done {
@@ -256,30 +1601,31 @@ func (c *Client) CheckCloudNAT(ctx context.Context, region, subnetName string) (
 			return false, fmt.Errorf("error listing routers: %w", err)
 		}
 
-		// Check if this router has NAT configured
-		if router.Nats != nil && len(router.Nats) > 0 {
-			for _, nat := range router.Nats {
-				// Check if this NAT applies to all subnets or specifically to our subnet
-				if nat.SourceSubnetworkIpRangesToNat != nil {
-					sourceType := *nat.SourceSubnetworkIpRangesToNat
-
-					// ALL_SUBNETWORKS_ALL_IP_RANGES means Cloud NAT is enabled for all subnets
-					if sourceType == "ALL_SUBNETWORKS_ALL_IP_RANGES" {
-						return true, nil
-					}
-
-					// LIST_OF_SUBNETWORKS means we need to check if our subnet is in the list
-					if sourceType == "LIST_OF_SUBNETWORKS" && nat.Subnetworks != nil {
-						for _, subnet := range nat.Subnetworks {
-							if subnet.Name != nil && strings.Contains(*subnet.Name, subnetName) {
-								return true, nil
-							}
-						}
-					}
+		if natCoversSubnetwork(router, subnetName) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// natCoversSubnetwork checks if one of the NATs of the router applies to the subnetwork
+func natCoversSubnetwork(router *computepb.Router, subnetName string) bool {
+	for _, nat := range router.Nats {
+		switch nat.GetSourceSubnetworkIpRangesToNat() {
+		case "ALL_SUBNETWORKS_ALL_IP_RANGES", "ALL_SUBNETWORKS_ALL_PRIMARY_IP_RANGES":
+			// the NAT is enabled for all subnets, the instance ip is from the primary range
+			return true
+		case "LIST_OF_SUBNETWORKS":
+			for _, subnet := range nat.Subnetworks {
+				// the subnetworks are full urls, which are compared by their name so
+				// that e.g. default doesn't match default-2
+				if path.Base(subnet.GetName()) == subnetName {
+					return true
 				}
 			}
 		}
 	}
 
-	return false, nil
+	return false
 }
@@ -0,0 +1,1154 @@
+package gcloud
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/compute/apiv1/computepb"
+	"github.com/badal-io/devpod-provider-gcloud/pkg/ptr"
+	"github.com/loft-sh/devpod/pkg/client"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/option"
+)
+
+func TestInstanceStatus(t *testing.T) {
+	tests := []struct {
+		status string
+		want   client.Status
+		known  bool
+	}{
+		{status: "PROVISIONING", want: client.StatusBusy, known: true},
+		{status: "STAGING", want: client.StatusBusy, known: true},
+		{status: "RUNNING", want: client.StatusRunning, known: true},
+		{status: "STOPPING", want: client.StatusBusy, known: true},
+		{status: "SUSPENDING", want: client.StatusBusy, known: true},
+		{status: "SUSPENDED", want: client.StatusStopped, known: true},
+		{status: "REPAIRING", want: client.StatusBusy, known: true},
+		{status: "TERMINATED", want: client.StatusStopped, known: true},
+		{status: " running ", want: client.StatusRunning, known: true},
+		{status: "SOMETHING_NEW", want: client.StatusBusy, known: false},
+		{status: "", want: client.StatusBusy, known: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.status, func(t *testing.T) {
+			status := tt.status
+			if got := instanceStatus(&computepb.Instance{Status: &status}); got != tt.want {
+				t.Errorf("instanceStatus(%q) = %s, want %s", tt.status, got, tt.want)
+			}
+			if got := IsKnownInstanceStatus(tt.status); got != tt.known {
+				t.Errorf("IsKnownInstanceStatus(%q) = %t, want %t", tt.status, got, tt.known)
+			}
+		})
+	}
+}
+
+func TestRetryOnFingerprintMismatch(t *testing.T) {
+	tests := []struct {
+		name         string
+		errs         []int
+		wantAttempts int
+		wantErr      bool
+	}{
+		{name: "success", errs: []int{0}, wantAttempts: 1},
+		{name: "success after a mismatch", errs: []int{412, 0}, wantAttempts: 2},
+		{name: "mismatch at every attempt", errs: []int{412, 412, 412, 412}, wantAttempts: fingerprintAttempts, wantErr: true},
+		{name: "other error", errs: []int{500, 0}, wantAttempts: 1, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			attempts := 0
+			err := retryOnFingerprintMismatch(func() error {
+				code := test.errs[attempts]
+				attempts++
+				if code == 0 {
+					return nil
+				}
+
+				reason := "conditionNotMet"
+				if code != 412 {
+					reason = "backendError"
+				}
+
+				return apiError(t, code, reason)
+			})
+			if attempts != test.wantAttempts {
+				t.Errorf("retryOnFingerprintMismatch() attempts = %d, want %d", attempts, test.wantAttempts)
+			}
+			if (err != nil) != test.wantErr {
+				t.Errorf("retryOnFingerprintMismatch() error = %v, want error %t", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestForEach(t *testing.T) {
+	tests := []struct {
+		name        string
+		names       []string
+		concurrency int
+		fail        map[string]bool
+		wantErr     string
+	}{
+		{name: "sequential", names: []string{"a", "b", "c"}, concurrency: 1},
+		{name: "concurrent", names: []string{"a", "b", "c", "d", "e"}, concurrency: 2},
+		{name: "invalid concurrency", names: []string{"a", "b"}, concurrency: 0},
+		{
+			name:        "failures",
+			names:       []string{"a", "b", "c", "d"},
+			concurrency: 4,
+			fail:        map[string]bool{"d": true, "b": true},
+			wantErr:     "2 of 4 operations failed:\nb: failed\nd: failed",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var (
+				mutex   sync.Mutex
+				running int
+				maximum int
+				called  = map[string]bool{}
+			)
+			err := forEach(test.names, test.concurrency, func(name string) error {
+				mutex.Lock()
+				called[name] = true
+				running++
+				if running > maximum {
+					maximum = running
+				}
+				mutex.Unlock()
+
+				// give the other operations the time to overlap
+				time.Sleep(10 * time.Millisecond)
+
+				mutex.Lock()
+				running--
+				mutex.Unlock()
+				if test.fail[name] {
+					return errors.New("failed")
+				}
+
+				return nil
+			})
+
+			if test.wantErr == "" && err != nil {
+				t.Errorf("forEach() error = %v", err)
+			} else if test.wantErr != "" && (err == nil || err.Error() != test.wantErr) {
+				t.Errorf("forEach() error = %v, want %q", err, test.wantErr)
+			}
+			if len(called) != len(test.names) {
+				t.Errorf("forEach() called %d of %d names", len(called), len(test.names))
+			}
+
+			wantMaximum := test.concurrency
+			if wantMaximum < 1 {
+				wantMaximum = 1
+			}
+			if maximum > wantMaximum {
+				t.Errorf("forEach() ran %d operations at once, want at most %d", maximum, wantMaximum)
+			} else if len(test.names) >= wantMaximum && maximum != wantMaximum {
+				t.Errorf("forEach() ran %d operations at once, want them to overlap up to %d", maximum, wantMaximum)
+			}
+		})
+	}
+}
+
+func TestSharedClient(t *testing.T) {
+	t.Cleanup(func() { _ = CloseAll() })
+
+	ctx := context.Background()
+	opts := []option.ClientOption{option.WithEndpoint("http://127.0.0.1:1"), option.WithoutAuthentication()}
+	client, err := SharedClient(ctx, "shared-project", "europe-west1-b", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	same, err := SharedClient(ctx, "shared-project", "europe-west1-b", opts...)
+	if err != nil {
+		t.Fatal(err)
+	} else if same != client {
+		t.Error("SharedClient() returned a new client for the same project and zone")
+	}
+
+	otherZone, err := SharedClient(ctx, "shared-project", "europe-west1-c", opts...)
+	if err != nil {
+		t.Fatal(err)
+	} else if otherZone == client {
+		t.Error("SharedClient() returned the same client for another zone")
+	}
+
+	otherProject, err := SharedClient(ctx, "other-project", "europe-west1-b", opts...)
+	if err != nil {
+		t.Fatal(err)
+	} else if otherProject == client {
+		t.Error("SharedClient() returned the same client for another project")
+	}
+
+	if err := CloseAll(); err != nil {
+		t.Fatal(err)
+	}
+	recreated, err := SharedClient(ctx, "shared-project", "europe-west1-b", opts...)
+	if err != nil {
+		t.Fatal(err)
+	} else if recreated == client {
+		t.Error("SharedClient() returned a closed client after CloseAll")
+	}
+}
+
+// newTestClient returns a client of the project test-project in zone europe-west1-b whose
+// requests are served by the handler
+func newTestClient(t *testing.T, handler http.HandlerFunc) *Client {
+	t.Helper()
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	client, err := NewClient(context.Background(), "test-project", "europe-west1-b", option.WithEndpoint(server.URL), option.WithoutAuthentication())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+
+	return client
+}
+
+func TestSetCallOptionsRetries(t *testing.T) {
+	tests := []struct {
+		name         string
+		maxRetries   int
+		call         func(client *Client) error
+		wantRequests int32
+	}{
+		{
+			name:         "get without retries",
+			call:         func(client *Client) error { _, err := client.Get(context.Background(), "devpod-test"); return err },
+			wantRequests: 1,
+		},
+		{
+			name:         "get is retried",
+			maxRetries:   1,
+			call:         func(client *Client) error { _, err := client.Get(context.Background(), "devpod-test"); return err },
+			wantRequests: 2,
+		},
+		{
+			name:         "insert isn't retried",
+			maxRetries:   1,
+			call:         func(client *Client) error { return client.Create(context.Background(), &computepb.Instance{}, nil) },
+			wantRequests: 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var requests int32
+			client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&requests, 1)
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusServiceUnavailable)
+				_, _ = w.Write([]byte(`{"error": {"code": 503, "message": "backendError"}}`))
+			})
+			client.SetCallOptions(test.maxRetries, 0)
+
+			if err := test.call(client); err == nil {
+				t.Error("call didn't fail")
+			}
+			if got := atomic.LoadInt32(&requests); got != test.wantRequests {
+				t.Errorf("sent %d requests, want %d", got, test.wantRequests)
+			}
+		})
+	}
+}
+
+func TestSetCallOptionsTimeout(t *testing.T) {
+	for _, name := range []string{"get", "insert"} {
+		t.Run(name, func(t *testing.T) {
+			client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				// the call gives up long before the response
+				time.Sleep(time.Second)
+			})
+			client.SetCallOptions(0, 50*time.Millisecond)
+
+			var err error
+			if name == "get" {
+				_, err = client.Get(context.Background(), "devpod-test")
+			} else {
+				err = client.Create(context.Background(), &computepb.Instance{}, nil)
+			}
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("call error = %v, want %v", err, context.DeadlineExceeded)
+			}
+		})
+	}
+}
+
+func TestResourceManagerEndpoint(t *testing.T) {
+	tests := []struct {
+		computeEndpoint string
+		want            string
+		wantErr         bool
+	}{
+		{want: "https://cloudresourcemanager.googleapis.com"},
+		{computeEndpoint: "https://compute-myendpoint.p.googleapis.com", want: "https://cloudresourcemanager-myendpoint.p.googleapis.com"},
+		{computeEndpoint: "https://compute-myendpoint.p.googleapis.com/", want: "https://cloudresourcemanager-myendpoint.p.googleapis.com"},
+		{computeEndpoint: "https://compute.example.com", want: "https://cloudresourcemanager.googleapis.com"},
+		{computeEndpoint: "compute-myendpoint", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run("endpoint "+test.computeEndpoint, func(t *testing.T) {
+			t.Setenv("COMPUTE_API_ENDPOINT", test.computeEndpoint)
+
+			got, err := resourceManagerEndpoint()
+			if (err != nil) != test.wantErr {
+				t.Fatalf("resourceManagerEndpoint() error = %v, want error %t", err, test.wantErr)
+			} else if got != test.want {
+				t.Errorf("resourceManagerEndpoint() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+// redirectTransport sends all requests to the server and records their original urls
+type redirectTransport struct {
+	server *httptest.Server
+	urls   []string
+}
+
+func (r *redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	r.urls = append(r.urls, req.URL.String())
+
+	redirected := req.Clone(req.Context())
+	redirected.URL.Scheme = "http"
+	redirected.URL.Host = strings.TrimPrefix(r.server.URL, "http://")
+	return http.DefaultTransport.RoundTrip(redirected)
+}
+
+func TestTestPermissions(t *testing.T) {
+	t.Setenv("COMPUTE_API_ENDPOINT", "https://compute-myendpoint.p.googleapis.com")
+
+	var requested map[string][]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&requested)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"permissions": ["compute.instances.create"]}`))
+	}))
+	t.Cleanup(server.Close)
+
+	// the http client of the HTTPS_PROXY is used for the call
+	transport := &redirectTransport{server: server}
+	c := &Client{Project: "test-project", httpClient: &http.Client{Transport: transport}}
+
+	granted, err := c.TestPermissions(context.Background(), "", []string{"compute.instances.create", "compute.instances.delete"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"compute.instances.create"}; !reflect.DeepEqual(granted, want) {
+		t.Errorf("TestPermissions() = %q, want %q", granted, want)
+	}
+	if want := []string{"compute.instances.create", "compute.instances.delete"}; !reflect.DeepEqual(requested["permissions"], want) {
+		t.Errorf("requested permissions = %q, want %q", requested["permissions"], want)
+	}
+	if want := []string{"https://cloudresourcemanager-myendpoint.p.googleapis.com/v1/projects/test-project:testIamPermissions"}; !reflect.DeepEqual(transport.urls, want) {
+		t.Errorf("requested urls = %q, want %q", transport.urls, want)
+	}
+}
+
+func TestNetworkClientOptionsProxy(t *testing.T) {
+	credentials := filepath.Join(t.TempDir(), "credentials.json")
+	err := os.WriteFile(credentials, []byte(`{"type": "service_account", "project_id": "test-project", "client_email": "devpod@test-project.iam.gserviceaccount.com", "private_key": "key"}`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", credentials)
+	t.Setenv("COMPUTE_API_ENDPOINT", "")
+
+	t.Setenv("HTTPS_PROXY", "")
+	t.Setenv("https_proxy", "")
+	opts, httpClient, err := networkClientOptions(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	} else if len(opts) != 0 || httpClient != nil {
+		t.Errorf("networkClientOptions() = %v, %v without a proxy, want no options", opts, httpClient)
+	}
+
+	t.Setenv("HTTPS_PROXY", "http://proxy.internal:3128")
+	opts, httpClient, err = networkClientOptions(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	} else if len(opts) != 1 || httpClient == nil {
+		t.Fatalf("networkClientOptions() = %v, %v with a proxy, want the http client of the proxy", opts, httpClient)
+	}
+
+	transport, ok := httpClient.Transport.(*oauth2.Transport)
+	if !ok {
+		t.Fatalf("http client transport = %T, want an oauth2 transport", httpClient.Transport)
+	}
+	if base, ok := transport.Base.(*http.Transport); !ok || base.Proxy == nil {
+		t.Errorf("http client base transport = %T, want a transport with the proxy", transport.Base)
+	}
+}
+
+func TestGetMachineType(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		wantName string
+		wantErr  bool
+	}{
+		{name: "found", status: http.StatusOK, wantName: "e2-standard-2"},
+		{name: "not found", status: http.StatusNotFound},
+		{name: "api error", status: http.StatusForbidden, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var requests int32
+			client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&requests, 1)
+				if r.URL.Path != "/compute/v1/projects/test-project/zones/europe-west1-b/machineTypes/e2-standard-2" {
+					t.Errorf("requested %s", r.URL.Path)
+				}
+
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(test.status)
+				if test.status == http.StatusOK {
+					_ = json.NewEncoder(w).Encode(map[string]interface{}{"name": "e2-standard-2", "guestCpus": 2})
+				} else {
+					_ = json.NewEncoder(w).Encode(map[string]interface{}{"error": map[string]interface{}{"code": test.status, "message": http.StatusText(test.status)}})
+				}
+			})
+
+			for i := 0; i < 2; i++ {
+				machineType, err := client.GetMachineType(context.Background(), "e2-standard-2")
+				if (err != nil) != test.wantErr {
+					t.Fatalf("GetMachineType() error = %v, want error %v", err, test.wantErr)
+				} else if machineType.GetName() != test.wantName {
+					t.Errorf("GetMachineType() = %v, want %q", machineType, test.wantName)
+				}
+			}
+
+			// found and missing machine types are cached, failed lookups are repeated
+			wantRequests := int32(1)
+			if test.wantErr {
+				wantRequests = 2
+			}
+			if requests != wantRequests {
+				t.Errorf("machine type requested %d times, want %d", requests, wantRequests)
+			}
+		})
+	}
+}
+
+func TestGetImage(t *testing.T) {
+	tests := []struct {
+		name     string
+		image    string
+		wantPath string
+	}{
+		{name: "image", image: "projects/cos-cloud/global/images/cos-101", wantPath: "/projects/cos-cloud/global/images/cos-101"},
+		{name: "image family", image: "projects/cos-cloud/global/images/family/cos-stable", wantPath: "/projects/cos-cloud/global/images/family/cos-stable"},
+		{name: "image url", image: "https://www.googleapis.com/compute/v1/projects/cos-cloud/global/images/cos-101", wantPath: "/projects/cos-cloud/global/images/cos-101"},
+		{name: "image of the project of the client", image: "global/images/family/golden", wantPath: "/projects/test-project/global/images/family/golden"},
+		{name: "image name", image: "golden-v2", wantPath: "/projects/test-project/global/images/golden-v2"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var requested string
+			client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				requested = strings.TrimPrefix(r.URL.Path, "/compute/v1")
+				w.Header().Set("Content-Type", "application/json")
+				_ = json.NewEncoder(w).Encode(map[string]interface{}{"name": "resolved", "diskSizeGb": "10"})
+			})
+
+			image, err := client.GetImage(context.Background(), test.image)
+			if err != nil {
+				t.Fatalf("GetImage() error = %v", err)
+			} else if image.GetName() != "resolved" {
+				t.Errorf("GetImage() = %v, want the resolved image", image)
+			}
+			if requested != test.wantPath {
+				t.Errorf("requested %s, want %s", requested, test.wantPath)
+			}
+		})
+	}
+
+	for _, test := range []struct {
+		status  int
+		wantErr error
+	}{
+		{status: http.StatusNotFound},
+		{status: http.StatusForbidden, wantErr: ErrPermissionDenied},
+	} {
+		t.Run(http.StatusText(test.status), func(t *testing.T) {
+			client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(test.status)
+				_ = json.NewEncoder(w).Encode(map[string]interface{}{"error": map[string]interface{}{"code": test.status, "message": http.StatusText(test.status)}})
+			})
+
+			image, err := client.GetImage(context.Background(), "projects/cos-cloud/global/images/family/cos-stable")
+			if image != nil {
+				t.Errorf("GetImage() = %v, want nil", image)
+			}
+			if test.wantErr == nil && err != nil {
+				t.Errorf("GetImage() error = %v", err)
+			} else if test.wantErr != nil && !errors.Is(err, test.wantErr) {
+				t.Errorf("GetImage() error = %v, want %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestComputeEndpoint(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		want     string
+		wantErr  bool
+	}{
+		{want: defaultComputeEndpoint},
+		{endpoint: "https://compute-myendpoint.p.googleapis.com", want: "https://compute-myendpoint.p.googleapis.com"},
+		{endpoint: "https://compute-myendpoint.p.googleapis.com/", want: "https://compute-myendpoint.p.googleapis.com"},
+		{endpoint: "http://localhost:8080", want: "http://localhost:8080"},
+		{endpoint: "compute-myendpoint.p.googleapis.com", wantErr: true},
+		{endpoint: "ftp://compute.example.com", wantErr: true},
+		{endpoint: "https://compute.example.com/compute/v1", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.endpoint, func(t *testing.T) {
+			t.Setenv("COMPUTE_API_ENDPOINT", test.endpoint)
+
+			endpoint, err := ComputeEndpoint()
+			if (err != nil) != test.wantErr {
+				t.Fatalf("ComputeEndpoint() error = %v, want error %v", err, test.wantErr)
+			} else if endpoint != test.want {
+				t.Errorf("ComputeEndpoint() = %q, want %q", endpoint, test.want)
+			}
+		})
+	}
+}
+
+func TestNewClientComputeEndpoint(t *testing.T) {
+	var requested string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = r.URL.Path
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"name": "devpod-test", "status": "RUNNING"})
+	}))
+	t.Cleanup(server.Close)
+	t.Setenv("COMPUTE_API_ENDPOINT", server.URL)
+	t.Setenv("HTTPS_PROXY", "")
+	t.Setenv("https_proxy", "")
+
+	client, err := NewClient(context.Background(), "test-project", "europe-west1-b", option.WithoutAuthentication())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+
+	if _, err := client.Get(context.Background(), "devpod-test"); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if want := "/compute/v1/projects/test-project/zones/europe-west1-b/instances/devpod-test"; requested != want {
+		t.Errorf("requested %q from COMPUTE_API_ENDPOINT, want %q", requested, want)
+	}
+}
+
+func TestDetails(t *testing.T) {
+	instance := map[string]interface{}{
+		"name":              "devpod-test",
+		"status":            "TERMINATED",
+		"machineType":       "https://www.googleapis.com/compute/v1/projects/test-project/zones/europe-west1-b/machineTypes/e2-standard-4",
+		"creationTimestamp": "2026-01-01T00:00:00.000-08:00",
+		"networkInterfaces": []map[string]interface{}{{
+			"networkIP":     "10.0.0.2",
+			"accessConfigs": []map[string]interface{}{{"natIP": "203.0.113.10"}},
+		}},
+	}
+	tests := []struct {
+		name     string
+		instance map[string]interface{}
+		want     InstanceDetails
+	}{
+		{
+			name:     "instance",
+			instance: instance,
+			want: InstanceDetails{
+				Name:              "devpod-test",
+				Status:            client.StatusStopped,
+				InstanceStatus:    "TERMINATED",
+				MachineType:       "e2-standard-4",
+				Zone:              "europe-west1-b",
+				InternalIP:        "10.0.0.2",
+				ExternalIP:        "203.0.113.10",
+				CreationTimestamp: "2026-01-01T00:00:00.000-08:00",
+			},
+		},
+		{
+			name:     "instance without network interfaces",
+			instance: map[string]interface{}{"name": "devpod-test", "status": "RUNNING"},
+			want:     InstanceDetails{Name: "devpod-test", Status: client.StatusRunning, InstanceStatus: "RUNNING", Zone: "europe-west1-b"},
+		},
+		{
+			name: "missing instance",
+			want: InstanceDetails{Name: "devpod-test", Status: client.StatusNotFound, Zone: "europe-west1-b"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				if test.instance == nil {
+					w.WriteHeader(http.StatusNotFound)
+					_ = json.NewEncoder(w).Encode(map[string]interface{}{"error": map[string]interface{}{"code": 404, "message": "not found"}})
+					return
+				}
+				_ = json.NewEncoder(w).Encode(test.instance)
+			})
+
+			details, err := client.Details(context.Background(), "devpod-test")
+			if err != nil {
+				t.Fatalf("Details() error = %v", err)
+			} else if !reflect.DeepEqual(*details, test.want) {
+				t.Errorf("Details() = %+v, want %+v", *details, test.want)
+			}
+		})
+	}
+}
+
+func TestInit(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		wantErr bool
+	}{
+		{name: "instances", status: http.StatusOK, body: `{"items": [{"name": "devpod-test"}], "nextPageToken": "next"}`},
+		{name: "no instances", status: http.StatusOK, body: `{}`},
+		{name: "no access", status: http.StatusForbidden, body: `{"error": {"code": 403, "message": "forbidden"}}`, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var requests []string
+			client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				requests = append(requests, r.Method+" "+r.URL.Path+"?"+r.URL.Query().Get("maxResults"))
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(test.status)
+				_, _ = w.Write([]byte(test.body))
+			})
+
+			err := client.Init(context.Background())
+			if test.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "cannot list instances") {
+					t.Errorf("Init() error = %v, want cannot list instances", err)
+				}
+			} else if err != nil {
+				t.Errorf("Init() error = %v", err)
+			}
+
+			// a single page of a single instance is requested
+			if want := []string{"GET /compute/v1/projects/test-project/zones/europe-west1-b/instances?1"}; !reflect.DeepEqual(requests, want) {
+				t.Errorf("requests = %q, want %q", requests, want)
+			}
+		})
+	}
+}
+
+func TestOrgPolicyError(t *testing.T) {
+	if err := orgPolicyError(nil); err != nil {
+		t.Errorf("orgPolicyError(nil) = %v", err)
+	}
+
+	other := errors.New("dial tcp: connection refused")
+	if err := orgPolicyError(other); err != other {
+		t.Errorf("orgPolicyError() = %v, want the error as it is", err)
+	}
+
+	violation := errors.New("Constraint constraints/compute.vmExternalIpAccess violated for project test-project")
+	err := orgPolicyError(violation)
+	if !errors.Is(err, violation) || !strings.HasPrefix(err.Error(), "external IPs are disabled by org policy, set PUBLIC_IP_ENABLED=false: Constraint") {
+		t.Errorf("orgPolicyError() = %v, want the guidance of the external ip constraint", err)
+	}
+}
+
+func TestCreateOrgPolicyViolation(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+	}{
+		{
+			name: "insert",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusPreconditionFailed)
+				_, _ = w.Write([]byte(`{"error": {"code": 412, "message": "Constraint constraints/compute.requireShieldedVm violated for project test-project."}}`))
+			},
+		},
+		{
+			name: "operation",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = w.Write([]byte(`{"name": "op-create", "status": "DONE", "error": {"errors": [{"code": "CONDITION_NOT_MET", "message": "Constraint constraints/compute.requireShieldedVm violated"}]}}`))
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := newTestClient(t, test.handler)
+
+			err := client.Create(context.Background(), &computepb.Instance{Name: ptr.Ptr("devpod-test")}, nil)
+			if err == nil || !strings.HasPrefix(err.Error(), "shielded VMs are required by org policy") {
+				t.Errorf("Create() error = %v, want the guidance of the shielded vm constraint", err)
+			}
+		})
+	}
+}
+
+func TestIsManaged(t *testing.T) {
+	tests := []struct {
+		name   string
+		labels map[string]string
+		want   bool
+	}{
+		{name: "managed", labels: map[string]string{ManagedByLabel: ManagedByValue}, want: true},
+		{name: "managed by someone else", labels: map[string]string{ManagedByLabel: "terraform"}},
+		{name: "without labels"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := IsManaged(&computepb.Instance{Labels: test.labels}); got != test.want {
+				t.Errorf("IsManaged() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestHasZone(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		want    bool
+		wantErr bool
+	}{
+		{name: "zone of the region", status: http.StatusOK, body: `{"zones": ["https://www.googleapis.com/compute/v1/projects/test-project/zones/europe-west1-b"]}`, want: true},
+		{name: "other zones", status: http.StatusOK, body: `{"zones": ["https://www.googleapis.com/compute/v1/projects/test-project/zones/europe-west1-c"]}`},
+		{name: "unknown region", status: http.StatusNotFound, body: `{"error": {"code": 404, "message": "not found"}}`},
+		{name: "no access", status: http.StatusForbidden, body: `{"error": {"code": 403, "message": "forbidden"}}`, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/compute/v1/projects/test-project/regions/europe-west1" {
+					t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+				}
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(test.status)
+				_, _ = w.Write([]byte(test.body))
+			})
+
+			got, err := client.HasZone(context.Background(), "europe-west1", "europe-west1-b")
+			if (err != nil) != test.wantErr {
+				t.Fatalf("HasZone() error = %v, wantErr %v", err, test.wantErr)
+			}
+			if got != test.want {
+				t.Errorf("HasZone() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSetupEnvJson(t *testing.T) {
+	tmpDir := t.TempDir()
+	t.Setenv("TMPDIR", tmpDir)
+	t.Setenv("GCLOUD_JSON_AUTH", `{"type": "service_account"}`)
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", "")
+
+	if err := SetupEnvJson(context.Background()); err != nil {
+		t.Fatalf("SetupEnvJson() error = %v", err)
+	}
+	t.Cleanup(func() { _ = CleanupEnvJson() })
+
+	credentials := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	if filepath.Dir(credentials) != tmpDir {
+		t.Fatalf("GOOGLE_APPLICATION_CREDENTIALS = %q, want a temporary file in %s", credentials, tmpDir)
+	}
+	out, err := os.ReadFile(credentials)
+	if err != nil {
+		t.Fatal(err)
+	} else if string(out) != `{"type": "service_account"}` {
+		t.Errorf("credentials = %q, want GCLOUD_JSON_AUTH", out)
+	}
+	if info, err := os.Stat(credentials); err != nil {
+		t.Fatal(err)
+	} else if info.Mode().Perm() != 0o600 {
+		t.Errorf("credentials mode = %v, want 0600", info.Mode().Perm())
+	}
+
+	// the file is written once per process
+	if err := SetupEnvJson(context.Background()); err != nil {
+		t.Fatalf("SetupEnvJson() error = %v", err)
+	} else if os.Getenv("GOOGLE_APPLICATION_CREDENTIALS") != credentials {
+		t.Errorf("GOOGLE_APPLICATION_CREDENTIALS = %q, want %q", os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"), credentials)
+	}
+
+	if err := CleanupEnvJson(); err != nil {
+		t.Fatalf("CleanupEnvJson() error = %v", err)
+	}
+	if _, err := os.Stat(credentials); !os.IsNotExist(err) {
+		t.Errorf("credentials file still exists: %v", err)
+	}
+	if err := CleanupEnvJson(); err != nil {
+		t.Errorf("second CleanupEnvJson() error = %v", err)
+	}
+}
+
+func TestForceDelete(t *testing.T) {
+	const instancePath = "/compute/v1/projects/test-project/zones/europe-west1-b/instances/devpod-test"
+	tests := []struct {
+		name               string
+		instance           string
+		deleteStatus       int
+		operationStatus    string
+		wantRequests       []string
+		wantErr            string
+		wantErrNotFound    bool
+		wantErrNotFinished bool
+	}{
+		{
+			name:            "delete",
+			instance:        `{"name": "devpod-test"}`,
+			operationStatus: "DONE",
+			wantRequests:    []string{"GET " + instancePath, "DELETE " + instancePath},
+		},
+		{
+			name:            "deletion protection",
+			instance:        `{"name": "devpod-test", "deletionProtection": true}`,
+			operationStatus: "DONE",
+			wantRequests:    []string{"GET " + instancePath, "POST " + instancePath + "/setDeletionProtection", "DELETE " + instancePath},
+		},
+		{
+			name:            "conflict without retries left",
+			instance:        `{"name": "devpod-test"}`,
+			deleteStatus:    http.StatusConflict,
+			operationStatus: "DONE",
+			wantRequests:    []string{"GET " + instancePath, "DELETE " + instancePath},
+			wantErr:         "conflict",
+		},
+		{
+			name:               "stuck delete",
+			instance:           `{"name": "devpod-test"}`,
+			operationStatus:    "RUNNING",
+			wantRequests:       []string{"GET " + instancePath, "DELETE " + instancePath},
+			wantErrNotFinished: true,
+		},
+		{
+			name:            "missing instance",
+			wantRequests:    []string{"GET " + instancePath},
+			wantErrNotFound: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mutex := sync.Mutex{}
+			var requests []string
+			client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				if strings.Contains(r.URL.Path, "/operations/") {
+					_, _ = w.Write([]byte(`{"name": "op-delete", "status": "` + test.operationStatus + `"}`))
+					return
+				}
+
+				mutex.Lock()
+				requests = append(requests, r.Method+" "+r.URL.Path)
+				mutex.Unlock()
+				switch {
+				case r.Method == http.MethodGet && test.instance == "":
+					w.WriteHeader(http.StatusNotFound)
+					_, _ = w.Write([]byte(`{"error": {"code": 404, "message": "not found"}}`))
+				case r.Method == http.MethodGet:
+					_, _ = w.Write([]byte(test.instance))
+				case test.deleteStatus != 0:
+					w.WriteHeader(test.deleteStatus)
+					_, _ = w.Write([]byte(`{"error": {"code": 409, "message": "conflict"}}`))
+				default:
+					_, _ = w.Write([]byte(`{"name": "op-delete", "status": "RUNNING"}`))
+				}
+			})
+
+			err := client.ForceDelete(context.Background(), "devpod-test", 1, 100*time.Millisecond)
+			switch {
+			case test.wantErrNotFound:
+				if !errors.Is(err, ErrNotFound) {
+					t.Errorf("ForceDelete() error = %v, want ErrNotFound", err)
+				}
+			case test.wantErrNotFinished:
+				if err == nil || !strings.Contains(err.Error(), "delete operation op-delete didn't finish within 100ms") {
+					t.Errorf("ForceDelete() error = %v, want the delete didn't finish", err)
+				}
+			case test.wantErr != "":
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Errorf("ForceDelete() error = %v, want %q", err, test.wantErr)
+				}
+			case err != nil:
+				t.Errorf("ForceDelete() error = %v", err)
+			}
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			if !reflect.DeepEqual(requests, test.wantRequests) {
+				t.Errorf("requests = %q, want %q", requests, test.wantRequests)
+			}
+		})
+	}
+}
+
+func TestNatCoversSubnetwork(t *testing.T) {
+	subnetworks := func(names ...string) []*computepb.RouterNatSubnetworkToNat {
+		var subnets []*computepb.RouterNatSubnetworkToNat
+		for _, name := range names {
+			subnets = append(subnets, &computepb.RouterNatSubnetworkToNat{Name: ptr.Ptr("https://www.googleapis.com/compute/v1/projects/test-project/regions/europe-west1/subnetworks/" + name)})
+		}
+		return subnets
+	}
+
+	tests := []struct {
+		name string
+		nats []*computepb.RouterNat
+		want bool
+	}{
+		{name: "no nat"},
+		{name: "all ip ranges", nats: []*computepb.RouterNat{{SourceSubnetworkIpRangesToNat: ptr.Ptr("ALL_SUBNETWORKS_ALL_IP_RANGES")}}, want: true},
+		{name: "all primary ip ranges", nats: []*computepb.RouterNat{{SourceSubnetworkIpRangesToNat: ptr.Ptr("ALL_SUBNETWORKS_ALL_PRIMARY_IP_RANGES")}}, want: true},
+		{name: "listed subnetwork", nats: []*computepb.RouterNat{{SourceSubnetworkIpRangesToNat: ptr.Ptr("LIST_OF_SUBNETWORKS"), Subnetworks: subnetworks("other", "default")}}, want: true},
+		{name: "subnetwork with a longer name", nats: []*computepb.RouterNat{{SourceSubnetworkIpRangesToNat: ptr.Ptr("LIST_OF_SUBNETWORKS"), Subnetworks: subnetworks("default-2")}}},
+		{name: "second nat", nats: []*computepb.RouterNat{
+			{SourceSubnetworkIpRangesToNat: ptr.Ptr("LIST_OF_SUBNETWORKS"), Subnetworks: subnetworks("other")},
+			{SourceSubnetworkIpRangesToNat: ptr.Ptr("LIST_OF_SUBNETWORKS"), Subnetworks: subnetworks("default")},
+		}, want: true},
+	}
+
+	for _, test := range tests {
+		if got := natCoversSubnetwork(&computepb.Router{Nats: test.nats}, "default"); got != test.want {
+			t.Errorf("natCoversSubnetwork() with %s = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestCheckCloudNAT(t *testing.T) {
+	var requests []string
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.Path+"?"+r.URL.Query().Get("maxResults")+","+r.URL.Query().Get("pageToken"))
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Query().Get("pageToken") == "" {
+			_, _ = w.Write([]byte(`{"items": [{"name": "router-1"}], "nextPageToken": "next"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"items": [{"name": "router-2", "nats": [{"sourceSubnetworkIpRangesToNat": "LIST_OF_SUBNETWORKS", "subnetworks": [{"name": "projects/host-project/regions/europe-west1/subnetworks/shared"}]}]}]}`))
+	})
+
+	hasCloudNAT, err := client.CheckCloudNAT(context.Background(), "host-project", "europe-west1", "shared")
+	if err != nil {
+		t.Fatalf("CheckCloudNAT() error = %v", err)
+	} else if !hasCloudNAT {
+		t.Error("CheckCloudNAT() = false, want the NAT of the second page")
+	}
+
+	// the routers of the given project are listed in pages of 500
+	want := []string{
+		"/compute/v1/projects/host-project/regions/europe-west1/routers?500,",
+		"/compute/v1/projects/host-project/regions/europe-west1/routers?500,next",
+	}
+	if !reflect.DeepEqual(requests, want) {
+		t.Errorf("requests = %q, want %q", requests, want)
+	}
+}
+
+func TestWaitForGuestAttribute(t *testing.T) {
+	tests := []struct {
+		name         string
+		responses    []string
+		want         string
+		wantErr      bool
+		wantRequests int
+	}{
+		{
+			name: "written after a while",
+			responses: []string{
+				"404",
+				`{}`,
+				`{"queryValue": {"items": [{"namespace": "devpod", "key": "other", "value": "x"}]}}`,
+				`{"queryValue": {"items": [{"namespace": "devpod", "key": "bootstrap", "value": "done"}]}}`,
+			},
+			want:         "done",
+			wantRequests: 4,
+		},
+		{name: "no access", responses: []string{"403"}, wantErr: true, wantRequests: 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			requests := 0
+			client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Query().Get("queryPath") != "devpod/" {
+					t.Errorf("queryPath = %q, want devpod/", r.URL.Query().Get("queryPath"))
+				}
+				response := test.responses[len(test.responses)-1]
+				if requests < len(test.responses) {
+					response = test.responses[requests]
+				}
+				requests++
+
+				w.Header().Set("Content-Type", "application/json")
+				switch response {
+				case "404":
+					w.WriteHeader(http.StatusNotFound)
+					_, _ = w.Write([]byte(`{"error": {"code": 404, "message": "not found"}}`))
+				case "403":
+					w.WriteHeader(http.StatusForbidden)
+					_, _ = w.Write([]byte(`{"error": {"code": 403, "message": "forbidden"}}`))
+				default:
+					_, _ = w.Write([]byte(response))
+				}
+			})
+
+			got, err := client.WaitForGuestAttribute(context.Background(), "devpod-test", "devpod", "bootstrap", time.Millisecond)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("WaitForGuestAttribute() error = %v, wantErr %v", err, test.wantErr)
+			}
+			if got != test.want || requests != test.wantRequests {
+				t.Errorf("WaitForGuestAttribute() = %q after %d requests, want %q after %d", got, requests, test.want, test.wantRequests)
+			}
+		})
+	}
+}
+
+func TestGetSubnetwork(t *testing.T) {
+	tests := []struct {
+		name    string
+		project string
+		status  int
+		body    string
+		path    string
+		want    bool
+		wantErr bool
+	}{
+		{name: "client project", status: http.StatusOK, body: `{"name": "dev", "ipCidrRange": "10.128.0.0/20"}`, path: "/compute/v1/projects/test-project/regions/europe-west1/subnetworks/dev", want: true},
+		{name: "host project", project: "host-project", status: http.StatusOK, body: `{"name": "dev", "ipCidrRange": "10.128.0.0/20"}`, path: "/compute/v1/projects/host-project/regions/europe-west1/subnetworks/dev", want: true},
+		{name: "missing", status: http.StatusNotFound, body: `{"error": {"code": 404, "message": "not found"}}`, path: "/compute/v1/projects/test-project/regions/europe-west1/subnetworks/dev"},
+		{name: "no access", status: http.StatusForbidden, body: `{"error": {"code": 403, "message": "forbidden"}}`, path: "/compute/v1/projects/test-project/regions/europe-west1/subnetworks/dev", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != test.path {
+					t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+				}
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(test.status)
+				_, _ = w.Write([]byte(test.body))
+			})
+
+			got, err := client.GetSubnetwork(context.Background(), test.project, "europe-west1", "dev")
+			if (err != nil) != test.wantErr {
+				t.Fatalf("GetSubnetwork() error = %v, wantErr %v", err, test.wantErr)
+			} else if test.wantErr && !errors.Is(err, ErrPermissionDenied) {
+				t.Errorf("GetSubnetwork() error = %v, want ErrPermissionDenied", err)
+			}
+			if (got != nil) != test.want || (got != nil && got.GetIpCidrRange() != "10.128.0.0/20") {
+				t.Errorf("GetSubnetwork() = %v, want found %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestResizeDisk(t *testing.T) {
+	const diskPath = "/compute/v1/projects/test-project/zones/europe-west1-b/disks/devpod-test"
+	tests := []struct {
+		name            string
+		sizeGB          int64
+		missing         bool
+		wantRequests    []string
+		wantErr         string
+		wantErrNotFound bool
+	}{
+		{name: "grow", sizeGB: 60, wantRequests: []string{"GET " + diskPath, "POST " + diskPath + "/resize"}},
+		{name: "same size", sizeGB: 40, wantRequests: []string{"GET " + diskPath}},
+		{name: "shrink", sizeGB: 20, wantRequests: []string{"GET " + diskPath}, wantErr: "disk devpod-test can't be shrunk from 40GB to 20GB"},
+		{name: "missing disk", sizeGB: 60, missing: true, wantRequests: []string{"GET " + diskPath}, wantErrNotFound: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mutex := sync.Mutex{}
+			var requests []string
+			client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				if strings.Contains(r.URL.Path, "/operations/") {
+					_, _ = w.Write([]byte(`{"name": "op-resize", "status": "DONE"}`))
+					return
+				}
+
+				mutex.Lock()
+				requests = append(requests, r.Method+" "+r.URL.Path)
+				mutex.Unlock()
+				switch {
+				case r.Method == http.MethodGet && test.missing:
+					w.WriteHeader(http.StatusNotFound)
+					_, _ = w.Write([]byte(`{"error": {"code": 404, "message": "not found"}}`))
+				case r.Method == http.MethodGet:
+					_, _ = w.Write([]byte(`{"name": "devpod-test", "sizeGb": "40"}`))
+				default:
+					body, _ := io.ReadAll(r.Body)
+					if !strings.Contains(string(body), `"sizeGb":"60"`) {
+						t.Errorf("resize body = %s, want sizeGb 60", body)
+					}
+					_, _ = w.Write([]byte(`{"name": "op-resize", "status": "RUNNING"}`))
+				}
+			})
+
+			err := client.ResizeDisk(context.Background(), "devpod-test", test.sizeGB)
+			switch {
+			case test.wantErrNotFound:
+				if !errors.Is(err, ErrNotFound) {
+					t.Errorf("ResizeDisk() error = %v, want ErrNotFound", err)
+				}
+			case test.wantErr != "":
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Errorf("ResizeDisk() error = %v, want %q", err, test.wantErr)
+				}
+			case err != nil:
+				t.Errorf("ResizeDisk() error = %v", err)
+			}
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			if !reflect.DeepEqual(requests, test.wantRequests) {
+				t.Errorf("requests = %q, want %q", requests, test.wantRequests)
+			}
+		})
+	}
+}
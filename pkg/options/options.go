@@ -1,37 +1,134 @@
 package options
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"net"
+	"net/url"
 	"os"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"golang.org/x/oauth2/google"
+	"gopkg.in/yaml.v2"
 )
 
 type Options struct {
 	MachineID     string
 	MachineFolder string
+	NameTemplate  string
+	WorkspaceID   string
+
+	Project         string
+	HostProject     string
+	Zone            string
+	Region          string
+	Hostname        string
+	Network         string
+	Subnetwork      string
+	InternalIP      string
+	Tag             string
+	AliasIPRanges   string
+	DiskSize        string
+	DiskImage       string
+	LocalSSDCount   int
+	KeepBootDisk    bool
+	DiscardLocalSSD bool
+	MachineType     string
+	ServiceAccount  string
+	Scopes          []string
+	PublicIP        bool
+	SSHKeyType      string
+	SSHPort         int
+	SSHConfigPath   string
+	SSHProxy        string
+	NetworkTier     string
+	CheckQuota      bool
+	ShowCost        bool
+	CheckEgress     bool
+	CleanupOnDelete bool
+	ForceDelete     bool
+	EgressCheckURL  string
+
+	ReservationAffinity string
+	Reservation         string
+	NodeAffinity        string
+	AutomaticRestart    bool
+
+	NestedVirtualization bool
+	ThreadsPerCore       int
+	VisibleCoreCount     int
+
+	DiskImageProject   string
+	DiskResourcePolicy string
+	DiskInterface      string
+	DiskLabels         string
+	DiskType           string
+	DiskIOPS           int
+	BootDiskDeviceName string
+	BootDiskName       string
+
+	IAPSSHKeepalive   int
+	IAPConnectTimeout int
+	IAPVerbosity      string
+	IAPSourceRanges   []string
+
+	StrictHostKeyChecking bool
+	SSHControlMaster      bool
+	BlockProjectSSHKeys   bool
+	BastionHost           string
+	InstanceGroup         string
+	UseInternalIP         bool
+	SkipNATCheck          bool
+	SkipUserCreation      bool
 
-	Project        string
-	Zone           string
-	Network        string
-	Subnetwork     string
-	Tag            string
-	DiskSize       string
-	DiskImage      string
-	MachineType    string
-	ServiceAccount string
-	PublicIP       bool
+	ConfigureArtifactRegistry bool
+	InstallGPUDriver          bool
+	CreateIfNotExists         bool
+	CheckPermissions          bool
+	CloudInit                 string
+	InstanceTemplate          string
+	PostCreateCommand         string
+	ReadyCheckCommand         string
+	ReadyCheckAttempts        int
+	WaitForBootstrap          bool
+
+	MaxAPIRetries  int
+	APICallTimeout time.Duration
+
+	OperationTimeout   time.Duration
+	IdleTimeout        time.Duration
+	StatusPollInterval time.Duration
+
+	SelfManagementServiceAccount string
 }
 
 func FromEnv(withMachine, withFolder bool) (*Options, error) {
-	retOptions := &Options{}
+	err := applyConfigFile()
+	if err != nil {
+		return nil, err
+	}
+
+	retOptions := &Options{
+		NameTemplate: os.Getenv("NAME_TEMPLATE"),
+		WorkspaceID:  os.Getenv("WORKSPACE_ID"),
+	}
 
-	var err error
 	if withMachine {
 		retOptions.MachineID, err = fromEnvOrError("MACHINE_ID")
 		if err != nil {
 			return nil, err
 		}
-		// prefix with devpod-
-		retOptions.MachineID = "devpod-" + retOptions.MachineID
+		retOptions.MachineID, err = renderInstanceName(retOptions.NameTemplate, retOptions.MachineID, retOptions.WorkspaceID, os.Getenv("ZONE"))
+		if err != nil {
+			return nil, err
+		}
 	}
 	if withFolder {
 		retOptions.MachineFolder, err = fromEnvOrError("MACHINE_FOLDER")
@@ -40,7 +137,7 @@ func FromEnv(withMachine, withFolder bool) (*Options, error) {
 		}
 	}
 
-	retOptions.Project, err = fromEnvOrError("PROJECT")
+	retOptions.Project, err = projectFromEnv()
 	if err != nil {
 		return nil, err
 	}
@@ -51,10 +148,24 @@ func FromEnv(withMachine, withFolder bool) (*Options, error) {
 	retOptions.DiskSize, err = fromEnvOrError("DISK_SIZE")
 	if err != nil {
 		return nil, err
+	} else if diskSize, err := strconv.Atoi(retOptions.DiskSize); err != nil || diskSize <= 0 {
+		return nil, fmt.Errorf("DISK_SIZE must be a positive number of GB, got %s", retOptions.DiskSize)
 	}
-	retOptions.DiskImage, err = fromEnvOrError("DISK_IMAGE")
-	if err != nil {
-		return nil, err
+	// an existing boot disk replaces the disk image
+	retOptions.BootDiskName = os.Getenv("BOOT_DISK_NAME")
+	retOptions.DiskImage = os.Getenv("DISK_IMAGE")
+	if retOptions.BootDiskName != "" && retOptions.DiskImage != "" {
+		return nil, fmt.Errorf("BOOT_DISK_NAME and DISK_IMAGE can't be combined, unset DISK_IMAGE to boot from the existing disk")
+	} else if retOptions.BootDiskName == "" {
+		retOptions.DiskImage, err = fromEnvOrError("DISK_IMAGE")
+		if err != nil {
+			return nil, err
+		}
+	}
+	if strings.HasPrefix(retOptions.DiskImage, "family/") {
+		if _, _, ok := retOptions.ImageFamily(); !ok {
+			return nil, fmt.Errorf("DISK_IMAGE %s must be of the form family/<project>/<family>", retOptions.DiskImage)
+		}
 	}
 	retOptions.MachineType, err = fromEnvOrError("MACHINE_TYPE")
 	if err != nil {
@@ -67,15 +178,578 @@ func FromEnv(withMachine, withFolder bool) (*Options, error) {
 	}
 
 	retOptions.PublicIP = publicIp == "true"
+	retOptions.KeepBootDisk = os.Getenv("KEEP_BOOT_DISK") == "true"
+	retOptions.DiscardLocalSSD = os.Getenv("DISCARD_LOCAL_SSD") == "true"
+	retOptions.AutomaticRestart = os.Getenv("AUTOMATIC_RESTART") != "false"
+	retOptions.DiskImageProject = os.Getenv("DISK_IMAGE_PROJECT")
+	retOptions.DiskResourcePolicy = os.Getenv("DISK_RESOURCE_POLICY")
+	retOptions.DiskLabels = os.Getenv("DISK_LABELS")
+	retOptions.DiskType = os.Getenv("DISK_TYPE")
+	if retOptions.DiskType == "" {
+		retOptions.DiskType = "pd-balanced"
+	}
+	if os.Getenv("DISK_IOPS") != "" {
+		retOptions.DiskIOPS, err = positiveIntFromEnv("DISK_IOPS", 0)
+		if err != nil {
+			return nil, err
+		} else if retOptions.DiskType != "pd-extreme" {
+			return nil, fmt.Errorf("DISK_IOPS is only supported with DISK_TYPE=pd-extreme, got %s", retOptions.DiskType)
+		} else if retOptions.BootDiskName != "" {
+			return nil, fmt.Errorf("DISK_IOPS can't be combined with BOOT_DISK_NAME, the iops are only set on new disks")
+		}
+	}
+	if retOptions.DiskLabels != "" && retOptions.BootDiskName != "" {
+		return nil, fmt.Errorf("DISK_LABELS can't be combined with BOOT_DISK_NAME, the labels are only set on new disks")
+	}
+	retOptions.DiskInterface = strings.ToUpper(os.Getenv("DISK_INTERFACE"))
+	if retOptions.DiskInterface != "" && retOptions.DiskInterface != "SCSI" && retOptions.DiskInterface != "NVME" {
+		return nil, fmt.Errorf("invalid DISK_INTERFACE %s, must be SCSI or NVME", os.Getenv("DISK_INTERFACE"))
+	}
+	retOptions.BootDiskDeviceName = os.Getenv("BOOT_DISK_DEVICE_NAME")
+	if retOptions.BootDiskDeviceName != "" && !instanceNamePattern.MatchString(retOptions.BootDiskDeviceName) {
+		return nil, fmt.Errorf("invalid BOOT_DISK_DEVICE_NAME %s, it must start with a lowercase letter, only contain lowercase letters, digits and dashes and be at most 63 characters long", retOptions.BootDiskDeviceName)
+	}
+
+	if localSSDCount := os.Getenv("LOCAL_SSD_COUNT"); localSSDCount != "" {
+		retOptions.LocalSSDCount, err = strconv.Atoi(localSSDCount)
+		if err != nil {
+			return nil, fmt.Errorf("parse LOCAL_SSD_COUNT: %w", err)
+		} else if retOptions.LocalSSDCount < 0 || retOptions.LocalSSDCount > 24 {
+			return nil, fmt.Errorf("LOCAL_SSD_COUNT must be between 0 and 24, got %d", retOptions.LocalSSDCount)
+		}
+	}
+	retOptions.CheckQuota = os.Getenv("CHECK_QUOTA") == "true"
+	retOptions.CheckPermissions = os.Getenv("CHECK_PERMISSIONS") == "true"
+
+	retOptions.SSHConfigPath, err = expandHome(os.Getenv("SSH_CONFIG_PATH"))
+	if err != nil {
+		return nil, err
+	}
+	retOptions.SSHPort, err = positiveIntFromEnv("SSH_PORT", 22)
+	if err != nil {
+		return nil, err
+	} else if retOptions.SSHPort > 65535 {
+		return nil, fmt.Errorf("SSH_PORT must be between 1 and 65535, got %d", retOptions.SSHPort)
+	}
+
+	retOptions.SSHKeyType = os.Getenv("SSH_KEY_TYPE")
+	if retOptions.SSHKeyType == "" {
+		retOptions.SSHKeyType = "rsa"
+	} else if retOptions.SSHKeyType != "rsa" && retOptions.SSHKeyType != "ed25519" {
+		return nil, fmt.Errorf("invalid SSH_KEY_TYPE %s, must be rsa or ed25519", retOptions.SSHKeyType)
+	}
+	retOptions.CheckEgress = os.Getenv("CHECK_EGRESS") == "true"
+	retOptions.ShowCost = os.Getenv("SHOW_COST") == "true"
+	retOptions.NestedVirtualization = os.Getenv("NESTED_VIRTUALIZATION") == "true"
+
+	if threadsPerCore := os.Getenv("THREADS_PER_CORE"); threadsPerCore != "" {
+		retOptions.ThreadsPerCore, err = strconv.Atoi(threadsPerCore)
+		if err != nil {
+			return nil, fmt.Errorf("parse THREADS_PER_CORE: %w", err)
+		} else if retOptions.ThreadsPerCore != 1 && retOptions.ThreadsPerCore != 2 {
+			return nil, fmt.Errorf("THREADS_PER_CORE must be 1 or 2, got %d", retOptions.ThreadsPerCore)
+		}
+	}
+	if visibleCoreCount := os.Getenv("VISIBLE_CORE_COUNT"); visibleCoreCount != "" {
+		retOptions.VisibleCoreCount, err = strconv.Atoi(visibleCoreCount)
+		if err != nil {
+			return nil, fmt.Errorf("parse VISIBLE_CORE_COUNT: %w", err)
+		} else if retOptions.VisibleCoreCount < 1 {
+			return nil, fmt.Errorf("VISIBLE_CORE_COUNT must be at least 1, got %d", retOptions.VisibleCoreCount)
+		}
+	}
+	retOptions.CleanupOnDelete = os.Getenv("CLEANUP_ON_DELETE") == "true"
+	retOptions.ForceDelete = os.Getenv("FORCE_DELETE") == "true"
+	retOptions.CreateIfNotExists = os.Getenv("CREATE_IF_NOT_EXISTS") == "true"
+	retOptions.InstallGPUDriver = os.Getenv("INSTALL_GPU_DRIVER") == "true"
+	retOptions.CloudInit = os.Getenv("CLOUD_INIT")
+	retOptions.InstanceTemplate = os.Getenv("INSTANCE_TEMPLATE")
+	retOptions.PostCreateCommand = os.Getenv("POST_CREATE_COMMAND")
+	retOptions.ReadyCheckCommand = os.Getenv("READY_CHECK_COMMAND")
+	retOptions.ReadyCheckAttempts, err = positiveIntFromEnv("READY_CHECK_ATTEMPTS", 12)
+	if err != nil {
+		return nil, err
+	}
+	retOptions.WaitForBootstrap = os.Getenv("WAIT_FOR_BOOTSTRAP") == "true"
+	retOptions.ConfigureArtifactRegistry = os.Getenv("CONFIGURE_ARTIFACT_REGISTRY") == "true"
+	retOptions.EgressCheckURL = os.Getenv("EGRESS_CHECK_URL")
+	if retOptions.EgressCheckURL == "" {
+		retOptions.EgressCheckURL = "https://github.com"
+	}
+
+	// the subnetwork of an instance has to be in the region of its zone
+	retOptions.Region = os.Getenv("REGION")
+	if zoneRegion := retOptions.Zone[:strings.LastIndex(retOptions.Zone, "-")+1]; retOptions.Region != "" && retOptions.Region+"-" != zoneRegion {
+		return nil, fmt.Errorf("REGION %s doesn't match the region of ZONE %s, the subnetwork must be in the region of the zone", retOptions.Region, retOptions.Zone)
+	}
+	// HOSTNAME itself is exported by many shells and containers with the local hostname
+	retOptions.Hostname = os.Getenv("INSTANCE_HOSTNAME")
+	if retOptions.Hostname != "" && (len(retOptions.Hostname) > 253 || !hostnamePattern.MatchString(retOptions.Hostname)) {
+		return nil, fmt.Errorf("invalid INSTANCE_HOSTNAME %s, must be a fully qualified domain name of lowercase letters, digits and dashes, e.g. devpod.example.internal", retOptions.Hostname)
+	}
+	retOptions.HostProject = os.Getenv("HOST_PROJECT")
+
+	retOptions.NodeAffinity = os.Getenv("NODE_AFFINITY")
+	retOptions.Reservation = os.Getenv("RESERVATION")
+	retOptions.ReservationAffinity = os.Getenv("RESERVATION_AFFINITY")
+	if retOptions.ReservationAffinity == "" && retOptions.Reservation != "" {
+		retOptions.ReservationAffinity = "SPECIFIC_RESERVATION"
+	}
+	switch retOptions.ReservationAffinity {
+	case "", "ANY_RESERVATION", "NO_RESERVATION":
+		if retOptions.Reservation != "" {
+			return nil, fmt.Errorf("RESERVATION can only be used with RESERVATION_AFFINITY=SPECIFIC_RESERVATION")
+		}
+	case "SPECIFIC_RESERVATION":
+		if retOptions.Reservation == "" {
+			return nil, fmt.Errorf("RESERVATION_AFFINITY=SPECIFIC_RESERVATION requires RESERVATION to be set")
+		}
+	default:
+		return nil, fmt.Errorf("invalid RESERVATION_AFFINITY %s, must be one of ANY_RESERVATION, SPECIFIC_RESERVATION, NO_RESERVATION", retOptions.ReservationAffinity)
+	}
+
+	retOptions.NetworkTier = os.Getenv("NETWORK_TIER")
+	if retOptions.NetworkTier == "" {
+		retOptions.NetworkTier = "STANDARD"
+	} else if retOptions.NetworkTier != "STANDARD" && retOptions.NetworkTier != "PREMIUM" {
+		return nil, fmt.Errorf("invalid NETWORK_TIER %s, must be STANDARD or PREMIUM", retOptions.NetworkTier)
+	}
 
 	retOptions.ServiceAccount = os.Getenv("SERVICE_ACCOUNT")
+	retOptions.Scopes, err = parseScopes(os.Getenv("SCOPES"))
+	if err != nil {
+		return nil, err
+	} else if os.Getenv("SCOPES") != "" && retOptions.ServiceAccount == "" {
+		return nil, fmt.Errorf("SCOPES requires SERVICE_ACCOUNT to be set, the scopes apply to the service account of the instance")
+	}
 	retOptions.Network = os.Getenv("NETWORK")
 	retOptions.Subnetwork = os.Getenv("SUBNETWORK")
+	if retOptions.HostProject != "" && retOptions.Subnetwork == "" {
+		return nil, fmt.Errorf("HOST_PROJECT requires SUBNETWORK to be set to a shared subnetwork of the host project")
+	}
+	retOptions.InternalIP = os.Getenv("INTERNAL_IP")
+	if retOptions.InternalIP != "" {
+		if ip := net.ParseIP(retOptions.InternalIP); ip == nil && !instanceNamePattern.MatchString(retOptions.InternalIP) {
+			return nil, fmt.Errorf("invalid INTERNAL_IP %s, must be an ipv4 address or the name of a reserved internal address", retOptions.InternalIP)
+		} else if ip != nil && ip.To4() == nil {
+			return nil, fmt.Errorf("invalid INTERNAL_IP %s, only ipv4 addresses are supported", retOptions.InternalIP)
+		} else if retOptions.Subnetwork == "" {
+			return nil, fmt.Errorf("INTERNAL_IP requires SUBNETWORK to be set, the address must be within the range of the subnetwork")
+		}
+	}
 	retOptions.Tag = os.Getenv("TAG")
+	retOptions.AliasIPRanges = os.Getenv("ALIAS_IP_RANGES")
+
+	retOptions.OperationTimeout = 10 * time.Minute
+	retOptions.IAPSSHKeepalive, err = positiveIntFromEnv("IAP_SSH_KEEPALIVE", 30)
+	if err != nil {
+		return nil, err
+	}
+	retOptions.IAPConnectTimeout, err = positiveIntFromEnv("IAP_CONNECT_TIMEOUT", 300)
+	if err != nil {
+		return nil, err
+	}
+	retOptions.StrictHostKeyChecking = os.Getenv("STRICT_HOST_KEY_CHECKING") == "true"
+	retOptions.BlockProjectSSHKeys = os.Getenv("BLOCK_PROJECT_SSH_KEYS") == "true"
+	retOptions.SSHControlMaster = os.Getenv("SSH_CONTROL_MASTER") == "true"
+	retOptions.SkipNATCheck = os.Getenv("SKIP_NAT_CHECK") == "true"
+	retOptions.SkipUserCreation = os.Getenv("SKIP_USER_CREATION") == "true"
+	retOptions.UseInternalIP = os.Getenv("USE_INTERNAL_IP") == "true"
+	retOptions.BastionHost = os.Getenv("BASTION_HOST")
+	retOptions.InstanceGroup = os.Getenv("INSTANCE_GROUP")
+	if retOptions.BastionHost != "" && !bastionHostPattern.MatchString(retOptions.BastionHost) {
+		return nil, fmt.Errorf("invalid BASTION_HOST %s, must be of the form [user@]host[:port]", retOptions.BastionHost)
+	}
+	retOptions.SSHProxy = os.Getenv("SSH_PROXY")
+	err = validateSSHProxy(retOptions)
+	if err != nil {
+		return nil, err
+	}
+	retOptions.IAPVerbosity = os.Getenv("IAP_VERBOSITY")
+	switch retOptions.IAPVerbosity {
+	case "":
+		retOptions.IAPVerbosity = "warning"
+	case "debug", "info", "warning", "error", "critical", "none":
+	default:
+		return nil, fmt.Errorf("invalid IAP_VERBOSITY %s, must be one of debug, info, warning, error, critical, none", retOptions.IAPVerbosity)
+	}
+
+	retOptions.IAPSourceRanges, err = parseSourceRanges(os.Getenv("IAP_SOURCE_RANGES"))
+	if err != nil {
+		return nil, err
+	}
+
+	if operationTimeout := os.Getenv("OPERATION_TIMEOUT"); operationTimeout != "" {
+		retOptions.OperationTimeout, err = time.ParseDuration(operationTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("parse OPERATION_TIMEOUT: %w", err)
+		} else if retOptions.OperationTimeout <= 0 {
+			return nil, fmt.Errorf("OPERATION_TIMEOUT must be positive, got %s", operationTimeout)
+		}
+	}
+
+	if maxAPIRetries := os.Getenv("MAX_API_RETRIES"); maxAPIRetries != "" {
+		retOptions.MaxAPIRetries, err = strconv.Atoi(maxAPIRetries)
+		if err != nil {
+			return nil, fmt.Errorf("parse MAX_API_RETRIES: %w", err)
+		} else if retOptions.MaxAPIRetries < 0 {
+			return nil, fmt.Errorf("MAX_API_RETRIES must not be negative, got %s", maxAPIRetries)
+		}
+	}
+
+	if apiCallTimeout := os.Getenv("API_CALL_TIMEOUT"); apiCallTimeout != "" {
+		retOptions.APICallTimeout, err = time.ParseDuration(apiCallTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("parse API_CALL_TIMEOUT: %w", err)
+		} else if retOptions.APICallTimeout <= 0 {
+			return nil, fmt.Errorf("API_CALL_TIMEOUT must be positive, got %s", apiCallTimeout)
+		}
+	}
+
+	retOptions.StatusPollInterval = 5 * time.Second
+	if statusPollInterval := os.Getenv("STATUS_POLL_INTERVAL"); statusPollInterval != "" {
+		retOptions.StatusPollInterval, err = time.ParseDuration(statusPollInterval)
+		if err != nil {
+			return nil, fmt.Errorf("parse STATUS_POLL_INTERVAL: %w", err)
+		} else if retOptions.StatusPollInterval < time.Second {
+			return nil, fmt.Errorf("STATUS_POLL_INTERVAL must be at least 1s, got %s", statusPollInterval)
+		}
+	}
+
+	if idleTimeout := os.Getenv("IDLE_TIMEOUT"); idleTimeout != "" {
+		retOptions.IdleTimeout, err = time.ParseDuration(idleTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("parse IDLE_TIMEOUT: %w", err)
+		} else if retOptions.IdleTimeout < time.Minute {
+			return nil, fmt.Errorf("IDLE_TIMEOUT must be at least 1m, got %s", idleTimeout)
+		}
+	}
+
+	// the instance only manages itself to stop when idle
+	retOptions.SelfManagementServiceAccount = os.Getenv("SELF_MANAGEMENT_SERVICE_ACCOUNT")
+	if retOptions.SelfManagementServiceAccount != "" && retOptions.IdleTimeout == 0 {
+		return nil, fmt.Errorf("SELF_MANAGEMENT_SERVICE_ACCOUNT is only used to stop idle instances, set IDLE_TIMEOUT as well")
+	}
+
+	err = validateInstanceTemplate(retOptions)
+	if err != nil {
+		return nil, err
+	}
 
 	return retOptions, nil
 }
 
+// parseScopes returns the comma separated oauth scopes of the service account, scopes without
+// a prefix are relative to https://www.googleapis.com/auth/. An empty value defaults to the
+// cloud-platform scope and none attaches the service account without any scope.
+func parseScopes(value string) ([]string, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return []string{"https://www.googleapis.com/auth/cloud-platform"}, nil
+	} else if value == "none" {
+		return []string{}, nil
+	}
+
+	scopes := []string{}
+	for _, scope := range strings.Split(value, ",") {
+		scope = strings.TrimSpace(scope)
+		if scope == "" {
+			continue
+		} else if scope == "none" {
+			return nil, fmt.Errorf("invalid SCOPES %s, none can't be combined with other scopes", value)
+		} else if !strings.HasPrefix(scope, "https://") {
+			scope = "https://www.googleapis.com/auth/" + scope
+		}
+
+		scopes = append(scopes, scope)
+	}
+
+	return scopes, nil
+}
+
+// validateInstanceTemplate checks that none of the options replaced by the INSTANCE_TEMPLATE
+// are set. MACHINE_TYPE, DISK_SIZE and DISK_IMAGE are always set because they are required,
+// so they are ignored instead.
+func validateInstanceTemplate(o *Options) error {
+	if o.InstanceTemplate == "" {
+		return nil
+	}
+
+	conflicts := []struct {
+		name string
+		set  bool
+	}{
+		{"NETWORK", o.Network != ""},
+		{"SUBNETWORK", o.Subnetwork != ""},
+		{"INTERNAL_IP", o.InternalIP != ""},
+		{"HOST_PROJECT", o.HostProject != ""},
+		{"INSTANCE_HOSTNAME", o.Hostname != ""},
+		{"SERVICE_ACCOUNT", o.ServiceAccount != ""},
+		{"SCOPES", os.Getenv("SCOPES") != ""},
+		{"TAG", o.Tag != ""},
+		{"ALIAS_IP_RANGES", o.AliasIPRanges != ""},
+		{"LOCAL_SSD_COUNT", o.LocalSSDCount > 0},
+		{"RESERVATION", o.Reservation != ""},
+		{"NODE_AFFINITY", o.NodeAffinity != ""},
+		{"NESTED_VIRTUALIZATION", o.NestedVirtualization},
+		{"THREADS_PER_CORE", o.ThreadsPerCore > 0},
+		{"VISIBLE_CORE_COUNT", o.VisibleCoreCount > 0},
+		{"DISK_IMAGE_PROJECT", o.DiskImageProject != ""},
+		{"DISK_RESOURCE_POLICY", o.DiskResourcePolicy != ""},
+		{"DISK_INTERFACE", o.DiskInterface != ""},
+		{"DISK_LABELS", o.DiskLabels != ""},
+		{"DISK_TYPE", os.Getenv("DISK_TYPE") != ""},
+		{"DISK_IOPS", o.DiskIOPS > 0},
+		{"BOOT_DISK_DEVICE_NAME", o.BootDiskDeviceName != ""},
+		{"CLOUD_INIT", o.CloudInit != ""},
+		{"IDLE_TIMEOUT", o.IdleTimeout > 0},
+		{"CONFIGURE_ARTIFACT_REGISTRY", o.ConfigureArtifactRegistry},
+		{"INSTALL_GPU_DRIVER", o.InstallGPUDriver},
+		{"WAIT_FOR_BOOTSTRAP", o.WaitForBootstrap},
+	}
+	for _, conflict := range conflicts {
+		if conflict.set {
+			return fmt.Errorf("%s can't be combined with INSTANCE_TEMPLATE, configure it in the instance template instead", conflict.name)
+		}
+	}
+
+	return nil
+}
+
+// hostnamePattern matches the fully qualified domain names accepted as custom hostname of an
+// instance, which need at least two labels
+var hostnamePattern = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]{0,61}[a-z0-9])?(\.[a-z0-9]([-a-z0-9]{0,61}[a-z0-9])?)+$`)
+
+// bastionHostPattern matches the [user@]host[:port] destinations supported by ssh ProxyJump
+var bastionHostPattern = regexp.MustCompile(`^([a-zA-Z0-9._-]+@)?[a-zA-Z0-9.-]+(:[0-9]{1,5})?$`)
+
+// positiveIntFromEnv parses the given env variable as a positive number or returns the default if it's not set
+func positiveIntFromEnv(name string, defaultValue int) (int, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("parse %s: %w", name, err)
+	} else if number < 1 {
+		return 0, fmt.Errorf("%s must be positive, got %d", name, number)
+	}
+
+	return number, nil
+}
+
+// NetworkProject returns the project the network belongs to, which is the host project for a shared VPC
+func (o *Options) NetworkProject() string {
+	if o.HostProject != "" {
+		return o.HostProject
+	}
+
+	return o.Project
+}
+
+// validateSSHProxy checks that the SSH_PROXY is a socks5 or http proxy url with a port
+func validateSSHProxy(o *Options) error {
+	if o.SSHProxy == "" {
+		return nil
+	} else if o.BastionHost != "" {
+		return fmt.Errorf("SSH_PROXY can't be combined with BASTION_HOST, configure the proxy for the bastion host in your ssh config instead")
+	}
+
+	u, err := url.Parse(o.SSHProxy)
+	if err != nil || (u.Scheme != "socks5" && u.Scheme != "socks5h" && u.Scheme != "http") || u.Hostname() == "" || u.Port() == "" {
+		return fmt.Errorf("invalid SSH_PROXY %s, expected an url like socks5://host:port or http://host:port", o.SSHProxy)
+	}
+
+	return nil
+}
+
+// defaultIAPSourceRange is the range Google's IAP forwards the tcp connections from
+const defaultIAPSourceRange = "35.235.240.0/20"
+
+// parseSourceRanges parses the comma separated IAP_SOURCE_RANGES CIDRs, which
+// default to the range of Google's IAP
+func parseSourceRanges(value string) ([]string, error) {
+	ranges := []string{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IAP_SOURCE_RANGES entry %s, must be a CIDR like %s", entry, defaultIAPSourceRange)
+		} else if ipNet.String() != entry {
+			return nil, fmt.Errorf("invalid IAP_SOURCE_RANGES entry %s, the host bits must be zero, e.g. %s", entry, ipNet.String())
+		}
+
+		ranges = append(ranges, entry)
+	}
+
+	if len(ranges) == 0 {
+		return []string{defaultIAPSourceRange}, nil
+	}
+
+	return ranges, nil
+}
+
+// ImageFamily returns the project and the image family of a DISK_IMAGE given as
+// family/<project>/<family>
+func (o *Options) ImageFamily() (string, string, bool) {
+	parts := strings.Split(o.DiskImage, "/")
+	if len(parts) != 3 || parts[0] != "family" || parts[1] == "" || parts[2] == "" {
+		return "", "", false
+	}
+
+	return parts[1], parts[2], true
+}
+
+// SSHConfigFile returns the path of the generated ssh config, which is written to the machine
+// folder unless SSH_CONFIG_PATH sets a directory for the configs of all machines
+func (o *Options) SSHConfigFile() string {
+	if o.SSHConfigPath != "" {
+		return filepath.Join(o.SSHConfigPath, o.MachineID+".conf")
+	}
+
+	return filepath.Join(o.MachineFolder, "ssh_config")
+}
+
+// expandHome replaces a leading ~ of the path with the home directory of the user
+func expandHome(value string) (string, error) {
+	if value != "~" && !strings.HasPrefix(value, "~/") {
+		return value, nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("expand %s: %w", value, err)
+	}
+
+	return filepath.Join(home, strings.TrimPrefix(value, "~")), nil
+}
+
+// InstanceName returns the instance name of the given devpod machine id
+func (o *Options) InstanceName(machineID string) (string, error) {
+	return renderInstanceName(o.NameTemplate, machineID, o.WorkspaceID, o.Zone)
+}
+
+var (
+	instanceNamePattern = regexp.MustCompile(`^[a-z]([-a-z0-9]{0,61}[a-z0-9])?$`)
+	invalidUserPattern  = regexp.MustCompile(`[^a-z0-9-]+`)
+)
+
+// renderInstanceName renders the instance name from the NAME_TEMPLATE, which supports
+// the {{.MachineID}}, {{.WorkspaceID}}, {{.User}} and {{.Zone}} placeholders. Without a
+// template the machine id is prefixed with devpod-, the name existing instances have.
+func renderInstanceName(nameTemplate, machineID, workspaceID, zone string) (string, error) {
+	if nameTemplate == "" {
+		return "devpod-" + machineID, nil
+	}
+
+	tmpl, err := template.New("name").Option("missingkey=error").Parse(nameTemplate)
+	if err != nil {
+		return "", fmt.Errorf("parse NAME_TEMPLATE: %w", err)
+	}
+
+	// the local user is sanitized as it commonly contains dots or uppercase letters
+	username := ""
+	if currentUser, err := user.Current(); err == nil {
+		username = strings.Trim(invalidUserPattern.ReplaceAllString(strings.ToLower(currentUser.Username), "-"), "-")
+	}
+
+	buf := &bytes.Buffer{}
+	err = tmpl.Execute(buf, map[string]string{
+		"MachineID":   machineID,
+		"WorkspaceID": sanitizeNamePart(workspaceID),
+		"User":        username,
+		"Zone":        zone,
+	})
+	if err != nil {
+		return "", fmt.Errorf("render NAME_TEMPLATE: %w", err)
+	}
+
+	name := buf.String()
+	if !instanceNamePattern.MatchString(name) {
+		return "", fmt.Errorf("instance name %q rendered from NAME_TEMPLATE is invalid, it must start with a lowercase letter, only contain lowercase letters, digits and dashes, not end with a dash and be at most 63 characters long", name)
+	}
+
+	return name, nil
+}
+
+// sanitizeNamePart lowercases value and replaces the characters that aren't allowed in
+// instance names with dashes
+func sanitizeNamePart(value string) string {
+	return strings.Trim(invalidUserPattern.ReplaceAllString(strings.ToLower(value), "-"), "-")
+}
+
+// projectFromEnv returns the PROJECT, falling back to GOOGLE_CLOUD_PROJECT and
+// then to the project of the application default credentials like gcloud
+func projectFromEnv() (string, error) {
+	if project := os.Getenv("PROJECT"); project != "" {
+		return project, nil
+	} else if project := os.Getenv("GOOGLE_CLOUD_PROJECT"); project != "" {
+		return project, nil
+	}
+
+	var (
+		credentials *google.Credentials
+		err         error
+	)
+	if jsonAuth := os.Getenv("GCLOUD_JSON_AUTH"); jsonAuth != "" {
+		credentials, err = google.CredentialsFromJSON(context.Background(), []byte(jsonAuth))
+	} else {
+		credentials, err = google.FindDefaultCredentials(context.Background())
+	}
+	if err == nil && credentials.ProjectID != "" {
+		return credentials.ProjectID, nil
+	}
+
+	return "", fmt.Errorf("couldn't find option PROJECT in environment, please make sure PROJECT or GOOGLE_CLOUD_PROJECT is defined or the application default credentials have a project")
+}
+
+// applyConfigFile sets the options of the yaml or json file at PROVIDER_CONFIG that aren't
+// set in the environment, so the environment always takes precedence over the file
+func applyConfigFile() error {
+	configPath := os.Getenv("PROVIDER_CONFIG")
+	if configPath == "" {
+		return nil
+	}
+
+	out, err := os.ReadFile(configPath)
+	if err != nil {
+		return fmt.Errorf("read PROVIDER_CONFIG: %w", err)
+	}
+
+	values := map[string]interface{}{}
+	err = yaml.Unmarshal(out, &values)
+	if err != nil {
+		return fmt.Errorf("parse PROVIDER_CONFIG %s: %w", configPath, err)
+	}
+
+	for name, value := range values {
+		switch value.(type) {
+		case nil:
+			continue
+		case string, int, float64, bool:
+		default:
+			return fmt.Errorf("invalid option %s in PROVIDER_CONFIG %s, values must be strings, numbers or booleans", name, configPath)
+		}
+
+		if os.Getenv(name) != "" {
+			continue
+		}
+
+		err = os.Setenv(name, fmt.Sprint(value))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func fromEnvOrError(name string) (string, error) {
 	val := os.Getenv(name)
 	if val == "" {
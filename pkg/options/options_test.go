@@ -0,0 +1,1051 @@
+package options
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// setRequiredEnv sets the options FromEnv can't do without
+func setRequiredEnv(t *testing.T) {
+	t.Helper()
+
+	for name, value := range map[string]string{
+		"PROJECT":           "my-project",
+		"ZONE":              "europe-west1-b",
+		"DISK_SIZE":         "40",
+		"DISK_IMAGE":        "projects/cos-cloud/global/images/cos-101-17162-127-5",
+		"MACHINE_TYPE":      "c2-standard-4",
+		"PUBLIC_IP_ENABLED": "true",
+	} {
+		t.Setenv(name, value)
+	}
+}
+
+func TestFromEnvHostProject(t *testing.T) {
+	tests := []struct {
+		name        string
+		hostProject string
+		subnetwork  string
+		wantErr     string
+	}{
+		{
+			name:        "host project with subnetwork",
+			hostProject: "host-project",
+			subnetwork:  "europe-west1/shared",
+		},
+		{
+			name:        "host project without subnetwork",
+			hostProject: "host-project",
+			wantErr:     "HOST_PROJECT requires SUBNETWORK",
+		},
+		{
+			name:       "subnetwork without host project",
+			subnetwork: "europe-west1/shared",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setRequiredEnv(t)
+			t.Setenv("HOST_PROJECT", test.hostProject)
+			t.Setenv("SUBNETWORK", test.subnetwork)
+
+			options, err := FromEnv(false, false)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("FromEnv() error = %v, want %q", err, test.wantErr)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("FromEnv() error = %v", err)
+			}
+
+			if options.HostProject != test.hostProject || options.Subnetwork != test.subnetwork {
+				t.Errorf("FromEnv() HostProject = %q, Subnetwork = %q, want %q, %q", options.HostProject, options.Subnetwork, test.hostProject, test.subnetwork)
+			}
+			if want := test.hostProject; want != "" && options.NetworkProject() != want {
+				t.Errorf("NetworkProject() = %q, want %q", options.NetworkProject(), want)
+			}
+		})
+	}
+}
+
+func TestRenderInstanceName(t *testing.T) {
+	tests := []struct {
+		name         string
+		nameTemplate string
+		machineID    string
+		workspaceID  string
+		want         string
+	}{
+		{
+			name:      "without workspace",
+			machineID: "abc123",
+			want:      "devpod-abc123",
+		},
+		{
+			name:        "workspace isn't part of the default name",
+			machineID:   "abc123",
+			workspaceID: "my-app",
+			want:        "devpod-abc123",
+		},
+		{
+			name:        "machine id equal to the workspace id",
+			machineID:   "my-app",
+			workspaceID: "my-app",
+			want:        "devpod-my-app",
+		},
+		{
+			name:         "template with workspace",
+			nameTemplate: "ws-{{.WorkspaceID}}-{{.MachineID}}",
+			machineID:    "abc123",
+			workspaceID:  "my-app",
+			want:         "ws-my-app-abc123",
+		},
+		{
+			name:         "template with sanitized workspace",
+			nameTemplate: "ws-{{.WorkspaceID}}-{{.MachineID}}",
+			machineID:    "abc123",
+			workspaceID:  "My_App.v2",
+			want:         "ws-my-app-v2-abc123",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := renderInstanceName(test.nameTemplate, test.machineID, test.workspaceID, "europe-west1-b")
+			if err != nil {
+				t.Fatalf("renderInstanceName() error = %v", err)
+			} else if got != test.want {
+				t.Errorf("renderInstanceName() = %q, want %q", got, test.want)
+			} else if !instanceNamePattern.MatchString(got) {
+				t.Errorf("renderInstanceName() = %q is no valid instance name", got)
+			}
+		})
+	}
+}
+
+func TestRenderInstanceNameUniquePerWorkspace(t *testing.T) {
+	names := map[string]string{}
+	for _, workspaceID := range []string{"frontend", "backend", "backend-v2"} {
+		name, err := renderInstanceName("devpod-{{.WorkspaceID}}-{{.MachineID}}", "abc123", workspaceID, "europe-west1-b")
+		if err != nil {
+			t.Fatalf("renderInstanceName() error = %v", err)
+		}
+
+		if other, ok := names[name]; ok {
+			t.Errorf("workspaces %s and %s share the instance name %s", other, workspaceID, name)
+		}
+		names[name] = workspaceID
+	}
+}
+
+func TestFromEnvRegion(t *testing.T) {
+	tests := []struct {
+		region  string
+		wantErr bool
+	}{
+		{region: ""},
+		{region: "europe-west1"},
+		{region: "europe-west", wantErr: true},
+		{region: "us-central1", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.region, func(t *testing.T) {
+			setRequiredEnv(t)
+			t.Setenv("REGION", test.region)
+
+			_, err := FromEnv(false, false)
+			if (err != nil) != test.wantErr {
+				t.Errorf("FromEnv() with REGION %q error = %v, want error %v", test.region, err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestProjectFromEnv(t *testing.T) {
+	credentials := func(project string) string {
+		return `{"type": "service_account", "project_id": "` + project + `", "client_email": "devpod@` + project + `.iam.gserviceaccount.com", "private_key": "key"}`
+	}
+	tests := []struct {
+		name               string
+		project            string
+		googleCloudProject string
+		jsonAuth           string
+		adcProject         string
+		want               string
+		wantErr            bool
+	}{
+		{name: "PROJECT", project: "project", googleCloudProject: "cloud-project", jsonAuth: credentials("json-project"), adcProject: "adc-project", want: "project"},
+		{name: "GOOGLE_CLOUD_PROJECT", googleCloudProject: "cloud-project", jsonAuth: credentials("json-project"), adcProject: "adc-project", want: "cloud-project"},
+		{name: "GCLOUD_JSON_AUTH", jsonAuth: credentials("json-project"), adcProject: "adc-project", want: "json-project"},
+		{name: "application default credentials", adcProject: "adc-project", want: "adc-project"},
+		{name: "no project", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// the gcloud configuration of the user running the tests is never used
+			home := t.TempDir()
+			t.Setenv("HOME", home)
+			t.Setenv("CLOUDSDK_CONFIG", home)
+			t.Setenv("PROJECT", test.project)
+			t.Setenv("GOOGLE_CLOUD_PROJECT", test.googleCloudProject)
+			t.Setenv("GCLOUD_JSON_AUTH", test.jsonAuth)
+			adcPath := filepath.Join(home, "adc.json")
+			if test.adcProject != "" {
+				if err := os.WriteFile(adcPath, []byte(credentials(test.adcProject)), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", adcPath)
+
+			got, err := projectFromEnv()
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("projectFromEnv() = %q, want an error", got)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("projectFromEnv() error = %v", err)
+			}
+
+			if got != test.want {
+				t.Errorf("projectFromEnv() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestFromEnvDiskSize(t *testing.T) {
+	tests := []struct {
+		name     string
+		diskSize string
+		wantErr  bool
+	}{
+		{name: "size in GB", diskSize: "40"},
+		{name: "zero", diskSize: "0", wantErr: true},
+		{name: "negative", diskSize: "-10", wantErr: true},
+		{name: "with unit", diskSize: "40GB", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setRequiredEnv(t)
+			t.Setenv("DISK_SIZE", test.diskSize)
+
+			options, err := FromEnv(false, false)
+			if test.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "DISK_SIZE must be a positive number of GB, got "+test.diskSize) {
+					t.Errorf("FromEnv() error = %v, want the invalid DISK_SIZE", err)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("FromEnv() error = %v", err)
+			}
+
+			if options.DiskSize != test.diskSize {
+				t.Errorf("FromEnv() DiskSize = %q, want %q", options.DiskSize, test.diskSize)
+			}
+		})
+	}
+}
+
+func TestImageFamily(t *testing.T) {
+	tests := []struct {
+		diskImage   string
+		wantProject string
+		wantFamily  string
+		wantOK      bool
+	}{
+		{diskImage: "family/cos-cloud/cos-stable", wantProject: "cos-cloud", wantFamily: "cos-stable", wantOK: true},
+		{diskImage: "family/cos-stable"},
+		{diskImage: "family//cos-stable"},
+		{diskImage: "family/cos-cloud/"},
+		{diskImage: "projects/cos-cloud/global/images/family/cos-stable"},
+		{diskImage: "cos-101"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.diskImage, func(t *testing.T) {
+			project, family, ok := (&Options{DiskImage: test.diskImage}).ImageFamily()
+			if project != test.wantProject || family != test.wantFamily || ok != test.wantOK {
+				t.Errorf("ImageFamily() = %q, %q, %v, want %q, %q, %v", project, family, ok, test.wantProject, test.wantFamily, test.wantOK)
+			}
+		})
+	}
+}
+
+func TestFromEnvDiskLabelsWithBootDiskName(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("DISK_LABELS", "team=platform")
+	t.Setenv("BOOT_DISK_NAME", "my-disk")
+	t.Setenv("DISK_IMAGE", "")
+
+	_, err := FromEnv(false, false)
+	if err == nil || !strings.Contains(err.Error(), "DISK_LABELS can't be combined with BOOT_DISK_NAME") {
+		t.Errorf("FromEnv() error = %v, want DISK_LABELS to be rejected with BOOT_DISK_NAME", err)
+	}
+}
+
+func TestFromEnvSelfManagementServiceAccount(t *testing.T) {
+	tests := []struct {
+		name        string
+		idleTimeout string
+		wantErr     string
+	}{
+		{name: "with IDLE_TIMEOUT", idleTimeout: "2h"},
+		{name: "without IDLE_TIMEOUT", wantErr: "SELF_MANAGEMENT_SERVICE_ACCOUNT is only used to stop idle instances, set IDLE_TIMEOUT as well"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setRequiredEnv(t)
+			t.Setenv("SELF_MANAGEMENT_SERVICE_ACCOUNT", "stopper@my-project.iam.gserviceaccount.com")
+			t.Setenv("IDLE_TIMEOUT", test.idleTimeout)
+
+			options, err := FromEnv(false, false)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Errorf("FromEnv() error = %v, want %q", err, test.wantErr)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("FromEnv() error = %v", err)
+			}
+
+			if options.SelfManagementServiceAccount != "stopper@my-project.iam.gserviceaccount.com" {
+				t.Errorf("FromEnv() SelfManagementServiceAccount = %q", options.SelfManagementServiceAccount)
+			}
+		})
+	}
+}
+
+func TestFromEnvNetworkTier(t *testing.T) {
+	tests := []struct {
+		networkTier string
+		want        string
+		wantErr     bool
+	}{
+		{want: "STANDARD"},
+		{networkTier: "STANDARD", want: "STANDARD"},
+		{networkTier: "PREMIUM", want: "PREMIUM"},
+		{networkTier: "premium", wantErr: true},
+		{networkTier: "FIXED_STANDARD", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.networkTier, func(t *testing.T) {
+			setRequiredEnv(t)
+			t.Setenv("NETWORK_TIER", test.networkTier)
+
+			options, err := FromEnv(false, false)
+			if test.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "invalid NETWORK_TIER "+test.networkTier+", must be STANDARD or PREMIUM") {
+					t.Errorf("FromEnv() error = %v, want the invalid NETWORK_TIER", err)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("FromEnv() error = %v", err)
+			}
+
+			if options.NetworkTier != test.want {
+				t.Errorf("FromEnv() NetworkTier = %q, want %q", options.NetworkTier, test.want)
+			}
+		})
+	}
+}
+
+func TestFromEnvEgressCheck(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("CHECK_EGRESS", "true")
+	t.Setenv("EGRESS_CHECK_URL", "")
+
+	options, err := FromEnv(false, false)
+	if err != nil {
+		t.Fatal(err)
+	} else if !options.CheckEgress || options.EgressCheckURL != "https://github.com" {
+		t.Errorf("FromEnv() CheckEgress = %v, EgressCheckURL = %q, want the check of https://github.com", options.CheckEgress, options.EgressCheckURL)
+	}
+
+	t.Setenv("EGRESS_CHECK_URL", "https://proxy.golang.org")
+	options, err = FromEnv(false, false)
+	if err != nil {
+		t.Fatal(err)
+	} else if options.EgressCheckURL != "https://proxy.golang.org" {
+		t.Errorf("FromEnv() EgressCheckURL = %q, want https://proxy.golang.org", options.EgressCheckURL)
+	}
+}
+
+func TestFromEnvSSHKeyType(t *testing.T) {
+	tests := []struct {
+		sshKeyType string
+		want       string
+		wantErr    bool
+	}{
+		{want: "rsa"},
+		{sshKeyType: "rsa", want: "rsa"},
+		{sshKeyType: "ed25519", want: "ed25519"},
+		{sshKeyType: "ecdsa", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.sshKeyType, func(t *testing.T) {
+			setRequiredEnv(t)
+			t.Setenv("SSH_KEY_TYPE", test.sshKeyType)
+
+			options, err := FromEnv(false, false)
+			if test.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "invalid SSH_KEY_TYPE "+test.sshKeyType+", must be rsa or ed25519") {
+					t.Errorf("FromEnv() error = %v, want the invalid SSH_KEY_TYPE", err)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("FromEnv() error = %v", err)
+			}
+
+			if options.SSHKeyType != test.want {
+				t.Errorf("FromEnv() SSHKeyType = %q, want %q", options.SSHKeyType, test.want)
+			}
+		})
+	}
+}
+
+func TestRenderInstanceNameTemplate(t *testing.T) {
+	currentUser, err := user.Current()
+	if err != nil {
+		t.Skipf("current user: %v", err)
+	}
+	username := sanitizeNamePart(currentUser.Username)
+
+	tests := []struct {
+		name         string
+		nameTemplate string
+		want         string
+		wantErr      string
+	}{
+		{name: "zone", nameTemplate: "{{.Zone}}-{{.MachineID}}", want: "europe-west1-b-abc123"},
+		{name: "user", nameTemplate: "dev-{{.User}}-{{.MachineID}}", want: "dev-" + username + "-abc123"},
+		{name: "invalid template", nameTemplate: "dev-{{.MachineID", wantErr: "parse NAME_TEMPLATE"},
+		{name: "unknown placeholder", nameTemplate: "dev-{{.Project}}", wantErr: "render NAME_TEMPLATE"},
+		{name: "starting with a digit", nameTemplate: "1-{{.MachineID}}", wantErr: `instance name "1-abc123" rendered from NAME_TEMPLATE is invalid`},
+		{name: "uppercase", nameTemplate: "Dev-{{.MachineID}}", wantErr: "is invalid"},
+		{name: "ending with a dash", nameTemplate: "dev-{{.MachineID}}-", wantErr: "is invalid"},
+		{name: "too long", nameTemplate: "devpod-" + strings.Repeat("a", 50) + "-{{.MachineID}}", wantErr: "is invalid"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := renderInstanceName(test.nameTemplate, "abc123", "", "europe-west1-b")
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Errorf("renderInstanceName() error = %v, want %q", err, test.wantErr)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("renderInstanceName() error = %v", err)
+			}
+
+			if got != test.want {
+				t.Errorf("renderInstanceName() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestFromEnvLocalSSDCount(t *testing.T) {
+	tests := []struct {
+		localSSDCount string
+		want          int
+		wantErr       string
+	}{
+		{want: 0},
+		{localSSDCount: "2", want: 2},
+		{localSSDCount: "24", want: 24},
+		{localSSDCount: "25", wantErr: "LOCAL_SSD_COUNT must be between 0 and 24, got 25"},
+		{localSSDCount: "-1", wantErr: "LOCAL_SSD_COUNT must be between 0 and 24, got -1"},
+		{localSSDCount: "two", wantErr: "parse LOCAL_SSD_COUNT"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.localSSDCount, func(t *testing.T) {
+			setRequiredEnv(t)
+			t.Setenv("LOCAL_SSD_COUNT", test.localSSDCount)
+
+			options, err := FromEnv(false, false)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Errorf("FromEnv() error = %v, want %q", err, test.wantErr)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("FromEnv() error = %v", err)
+			}
+
+			if options.LocalSSDCount != test.want {
+				t.Errorf("FromEnv() LocalSSDCount = %d, want %d", options.LocalSSDCount, test.want)
+			}
+		})
+	}
+}
+
+func TestFromEnvReservationAffinity(t *testing.T) {
+	tests := []struct {
+		name                string
+		reservationAffinity string
+		reservation         string
+		want                string
+		wantErr             string
+	}{
+		{name: "default"},
+		{name: "any reservation", reservationAffinity: "ANY_RESERVATION", want: "ANY_RESERVATION"},
+		{name: "no reservation", reservationAffinity: "NO_RESERVATION", want: "NO_RESERVATION"},
+		{name: "specific reservation", reservationAffinity: "SPECIFIC_RESERVATION", reservation: "my-reservation", want: "SPECIFIC_RESERVATION"},
+		{name: "reservation implies specific reservation", reservation: "my-reservation", want: "SPECIFIC_RESERVATION"},
+		{name: "specific reservation without reservation", reservationAffinity: "SPECIFIC_RESERVATION", wantErr: "requires RESERVATION to be set"},
+		{name: "reservation with any reservation", reservationAffinity: "ANY_RESERVATION", reservation: "my-reservation", wantErr: "RESERVATION can only be used with RESERVATION_AFFINITY=SPECIFIC_RESERVATION"},
+		{name: "invalid", reservationAffinity: "SOME_RESERVATION", wantErr: "invalid RESERVATION_AFFINITY SOME_RESERVATION"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setRequiredEnv(t)
+			t.Setenv("RESERVATION_AFFINITY", test.reservationAffinity)
+			t.Setenv("RESERVATION", test.reservation)
+
+			options, err := FromEnv(false, false)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Errorf("FromEnv() error = %v, want %q", err, test.wantErr)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("FromEnv() error = %v", err)
+			}
+
+			if options.ReservationAffinity != test.want || options.Reservation != test.reservation {
+				t.Errorf("FromEnv() reservation affinity = %q %q, want %q %q", options.ReservationAffinity, options.Reservation, test.want, test.reservation)
+			}
+		})
+	}
+}
+
+func TestFromEnvIAPSSH(t *testing.T) {
+	tests := []struct {
+		name               string
+		env                map[string]string
+		wantKeepalive      int
+		wantConnectTimeout int
+		wantVerbosity      string
+		wantErr            string
+	}{
+		{name: "defaults", wantKeepalive: 30, wantConnectTimeout: 300, wantVerbosity: "warning"},
+		{
+			name:               "configured",
+			env:                map[string]string{"IAP_SSH_KEEPALIVE": "10", "IAP_CONNECT_TIMEOUT": "60", "IAP_VERBOSITY": "debug"},
+			wantKeepalive:      10,
+			wantConnectTimeout: 60,
+			wantVerbosity:      "debug",
+		},
+		{name: "zero keepalive", env: map[string]string{"IAP_SSH_KEEPALIVE": "0"}, wantErr: "IAP_SSH_KEEPALIVE must be positive, got 0"},
+		{name: "invalid connect timeout", env: map[string]string{"IAP_CONNECT_TIMEOUT": "5m"}, wantErr: "parse IAP_CONNECT_TIMEOUT"},
+		{name: "invalid verbosity", env: map[string]string{"IAP_VERBOSITY": "trace"}, wantErr: "invalid IAP_VERBOSITY trace"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setRequiredEnv(t)
+			for name, value := range test.env {
+				t.Setenv(name, value)
+			}
+
+			options, err := FromEnv(false, false)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Errorf("FromEnv() error = %v, want %q", err, test.wantErr)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("FromEnv() error = %v", err)
+			}
+
+			if options.IAPSSHKeepalive != test.wantKeepalive || options.IAPConnectTimeout != test.wantConnectTimeout || options.IAPVerbosity != test.wantVerbosity {
+				t.Errorf("FromEnv() IAP ssh = %d %d %s, want %d %d %s", options.IAPSSHKeepalive, options.IAPConnectTimeout, options.IAPVerbosity,
+					test.wantKeepalive, test.wantConnectTimeout, test.wantVerbosity)
+			}
+		})
+	}
+}
+
+func TestFromEnvSSHPort(t *testing.T) {
+	tests := []struct {
+		sshPort string
+		want    int
+		wantErr string
+	}{
+		{want: 22},
+		{sshPort: "2222", want: 2222},
+		{sshPort: "65536", wantErr: "SSH_PORT must be between 1 and 65535, got 65536"},
+		{sshPort: "0", wantErr: "SSH_PORT must be positive, got 0"},
+		{sshPort: "ssh", wantErr: "parse SSH_PORT"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.sshPort, func(t *testing.T) {
+			setRequiredEnv(t)
+			t.Setenv("SSH_PORT", test.sshPort)
+
+			options, err := FromEnv(false, false)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Errorf("FromEnv() error = %v, want %q", err, test.wantErr)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("FromEnv() error = %v", err)
+			}
+
+			if options.SSHPort != test.want {
+				t.Errorf("FromEnv() SSHPort = %d, want %d", options.SSHPort, test.want)
+			}
+		})
+	}
+}
+
+func TestFromEnvBootDiskDeviceName(t *testing.T) {
+	for bootDiskDeviceName, wantErr := range map[string]bool{
+		"":          false,
+		"workspace": false,
+		"disk-0":    false,
+		"Workspace": true,
+		"0-disk":    true,
+		"disk_0":    true,
+	} {
+		setRequiredEnv(t)
+		t.Setenv("BOOT_DISK_DEVICE_NAME", bootDiskDeviceName)
+
+		options, err := FromEnv(false, false)
+		if wantErr {
+			if err == nil || !strings.Contains(err.Error(), "invalid BOOT_DISK_DEVICE_NAME "+bootDiskDeviceName) {
+				t.Errorf("FromEnv() error = %v, want the invalid BOOT_DISK_DEVICE_NAME %s", err, bootDiskDeviceName)
+			}
+		} else if err != nil {
+			t.Errorf("FromEnv() error = %v", err)
+		} else if options.BootDiskDeviceName != bootDiskDeviceName {
+			t.Errorf("FromEnv() BootDiskDeviceName = %q, want %q", options.BootDiskDeviceName, bootDiskDeviceName)
+		}
+	}
+}
+
+func TestFromEnvBastionHost(t *testing.T) {
+	for bastionHost, wantErr := range map[string]bool{
+		"":                              false,
+		"bastion.example.com":           false,
+		"jump@bastion.example.com:2200": false,
+		"10.0.0.2":                      false,
+		"bastion.example.com:ssh":       true,
+		"jump@bastion -p 22":            true,
+		"ssh://bastion.example.com":     true,
+	} {
+		setRequiredEnv(t)
+		t.Setenv("BASTION_HOST", bastionHost)
+
+		options, err := FromEnv(false, false)
+		if wantErr {
+			if err == nil || !strings.Contains(err.Error(), "invalid BASTION_HOST") {
+				t.Errorf("FromEnv() error = %v, want the invalid BASTION_HOST %s", err, bastionHost)
+			}
+		} else if err != nil {
+			t.Errorf("FromEnv() error = %v", err)
+		} else if options.BastionHost != bastionHost {
+			t.Errorf("FromEnv() BastionHost = %q, want %q", options.BastionHost, bastionHost)
+		}
+	}
+}
+
+func TestFromEnvProviderConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		missing bool
+		wantErr string
+	}{
+		{
+			name:   "yaml",
+			config: "DISK_SIZE: 80\nMACHINE_TYPE: e2-small\nSSH_PORT: 2222\nPUBLIC_IP_ENABLED: true\nHOST_PROJECT:\n",
+		},
+		{
+			name:   "json",
+			config: `{"DISK_SIZE": 80, "MACHINE_TYPE": "e2-small", "SSH_PORT": 2222}`,
+		},
+		{
+			name:    "list value",
+			config:  "DISK_SIZE: 80\nNETWORK_TAGS: [a, b]\n",
+			wantErr: "invalid option NETWORK_TAGS in PROVIDER_CONFIG",
+		},
+		{
+			name:    "invalid yaml",
+			config:  "DISK_SIZE: [80\n",
+			wantErr: "parse PROVIDER_CONFIG",
+		},
+		{
+			name:    "missing file",
+			missing: true,
+			wantErr: "read PROVIDER_CONFIG",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setRequiredEnv(t)
+			// unset options the config file provides, t.Setenv restores them after the test
+			for _, name := range []string{"DISK_SIZE", "SSH_PORT", "NETWORK_TAGS", "HOST_PROJECT"} {
+				t.Setenv(name, "")
+			}
+
+			configPath := filepath.Join(t.TempDir(), "config.yaml")
+			if !test.missing {
+				err := os.WriteFile(configPath, []byte(test.config), 0o600)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			t.Setenv("PROVIDER_CONFIG", configPath)
+
+			options, err := FromEnv(false, false)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("FromEnv() error = %v, want %q", err, test.wantErr)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("FromEnv() error = %v", err)
+			}
+
+			// the environment takes precedence over the config file
+			if options.MachineType != "c2-standard-4" {
+				t.Errorf("MachineType = %q, want the environment c2-standard-4", options.MachineType)
+			}
+			if options.DiskSize != "80" || options.SSHPort != 2222 {
+				t.Errorf("DiskSize, SSHPort = %q, %d, want 80, 2222 from the config file", options.DiskSize, options.SSHPort)
+			}
+			if options.HostProject != "" {
+				t.Errorf("HostProject = %q, want an empty value to be skipped", options.HostProject)
+			}
+		})
+	}
+}
+
+func TestFromEnvInstanceTemplate(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		wantErr string
+	}{
+		{name: "template", env: map[string]string{"INSTANCE_TEMPLATE": "dev-template"}},
+		{name: "no template", env: map[string]string{"NETWORK": "dev"}},
+		{
+			name:    "template with network",
+			env:     map[string]string{"INSTANCE_TEMPLATE": "dev-template", "NETWORK": "dev"},
+			wantErr: "NETWORK can't be combined with INSTANCE_TEMPLATE",
+		},
+		{
+			name:    "template with cloud init",
+			env:     map[string]string{"INSTANCE_TEMPLATE": "dev-template", "CLOUD_INIT": "#cloud-config\n"},
+			wantErr: "CLOUD_INIT can't be combined with INSTANCE_TEMPLATE",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setRequiredEnv(t)
+			for name, value := range test.env {
+				t.Setenv(name, value)
+			}
+
+			options, err := FromEnv(false, false)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("FromEnv() error = %v, want %q", err, test.wantErr)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("FromEnv() error = %v", err)
+			}
+
+			if options.InstanceTemplate != test.env["INSTANCE_TEMPLATE"] {
+				t.Errorf("InstanceTemplate = %q, want %q", options.InstanceTemplate, test.env["INSTANCE_TEMPLATE"])
+			}
+		})
+	}
+}
+
+func TestFromEnvBootDiskName(t *testing.T) {
+	tests := []struct {
+		name         string
+		bootDiskName string
+		diskImage    string
+		wantErr      string
+	}{
+		{name: "disk image", diskImage: "projects/cos-cloud/global/images/cos-101-17162-127-5"},
+		{name: "boot disk", bootDiskName: "workspace"},
+		{name: "both", bootDiskName: "workspace", diskImage: "projects/cos-cloud/global/images/cos-101-17162-127-5", wantErr: "BOOT_DISK_NAME and DISK_IMAGE can't be combined"},
+		{name: "neither", wantErr: "couldn't find option DISK_IMAGE"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setRequiredEnv(t)
+			t.Setenv("BOOT_DISK_NAME", test.bootDiskName)
+			t.Setenv("DISK_IMAGE", test.diskImage)
+
+			options, err := FromEnv(false, false)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("FromEnv() error = %v, want %q", err, test.wantErr)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("FromEnv() error = %v", err)
+			}
+
+			if options.BootDiskName != test.bootDiskName || options.DiskImage != test.diskImage {
+				t.Errorf("BootDiskName, DiskImage = %q, %q, want %q, %q", options.BootDiskName, options.DiskImage, test.bootDiskName, test.diskImage)
+			}
+		})
+	}
+}
+
+func TestFromEnvInstanceHostname(t *testing.T) {
+	for hostname, wantErr := range map[string]bool{
+		"":                         false,
+		"devpod.example.internal":  false,
+		"dev-1.example":            false,
+		"devpod":                   true,
+		"DevPod.example.internal":  true,
+		"-devpod.example.internal": true,
+		"devpod..example":          true,
+		"devpod_1.example":         true,
+		strings.Repeat("a", 63) + "." + strings.Repeat("b", 63) + "." + strings.Repeat("c", 63) + "." + strings.Repeat("d", 63): true,
+	} {
+		setRequiredEnv(t)
+		t.Setenv("INSTANCE_HOSTNAME", hostname)
+
+		options, err := FromEnv(false, false)
+		if wantErr {
+			if err == nil || !strings.Contains(err.Error(), "invalid INSTANCE_HOSTNAME") {
+				t.Errorf("FromEnv() error = %v, want the invalid INSTANCE_HOSTNAME %s", err, hostname)
+			}
+		} else if err != nil {
+			t.Errorf("FromEnv() error = %v", err)
+		} else if options.Hostname != hostname {
+			t.Errorf("FromEnv() Hostname = %q, want %q", options.Hostname, hostname)
+		}
+	}
+}
+
+func TestFromEnvScopes(t *testing.T) {
+	tests := []struct {
+		name           string
+		scopes         string
+		serviceAccount string
+		want           []string
+		wantErr        string
+	}{
+		{name: "default", want: []string{"https://www.googleapis.com/auth/cloud-platform"}},
+		{
+			name:           "short and full scopes",
+			scopes:         "compute, https://www.googleapis.com/auth/devstorage.read_only,",
+			serviceAccount: "devpod@my-project.iam.gserviceaccount.com",
+			want:           []string{"https://www.googleapis.com/auth/compute", "https://www.googleapis.com/auth/devstorage.read_only"},
+		},
+		{name: "none", scopes: "none", serviceAccount: "devpod@my-project.iam.gserviceaccount.com", want: []string{}},
+		{name: "none with other scopes", scopes: "none,compute", serviceAccount: "devpod@my-project.iam.gserviceaccount.com", wantErr: "none can't be combined with other scopes"},
+		{name: "without service account", scopes: "compute", wantErr: "SCOPES requires SERVICE_ACCOUNT"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setRequiredEnv(t)
+			t.Setenv("SCOPES", test.scopes)
+			t.Setenv("SERVICE_ACCOUNT", test.serviceAccount)
+
+			options, err := FromEnv(false, false)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("FromEnv() error = %v, want %q", err, test.wantErr)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("FromEnv() error = %v", err)
+			}
+
+			if !reflect.DeepEqual(options.Scopes, test.want) {
+				t.Errorf("Scopes = %q, want %q", options.Scopes, test.want)
+			}
+		})
+	}
+}
+
+func TestFromEnvDiskInterface(t *testing.T) {
+	for diskInterface, want := range map[string]string{
+		"":     "",
+		"SCSI": "SCSI",
+		"nvme": "NVME",
+		"ide":  "",
+	} {
+		setRequiredEnv(t)
+		t.Setenv("DISK_INTERFACE", diskInterface)
+
+		options, err := FromEnv(false, false)
+		if diskInterface != "" && want == "" {
+			if err == nil || !strings.Contains(err.Error(), "invalid DISK_INTERFACE "+diskInterface) {
+				t.Errorf("FromEnv() error = %v, want the invalid DISK_INTERFACE %s", err, diskInterface)
+			}
+		} else if err != nil {
+			t.Errorf("FromEnv() error = %v", err)
+		} else if options.DiskInterface != want {
+			t.Errorf("FromEnv() DiskInterface = %q, want %q", options.DiskInterface, want)
+		}
+	}
+}
+
+func TestFromEnvSSHConfigPath(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	for sshConfigPath, wantFile := range map[string]string{
+		"":                  filepath.Join("machine", "ssh_config"),
+		"/etc/ssh/devpod":   "/etc/ssh/devpod/devpod-test.conf",
+		"~/.ssh/devpod":     filepath.Join(home, ".ssh", "devpod", "devpod-test.conf"),
+		"~":                 filepath.Join(home, "devpod-test.conf"),
+		"~other/.ssh":       filepath.Join("~other", ".ssh", "devpod-test.conf"),
+		"relative/ssh/conf": filepath.Join("relative", "ssh", "conf", "devpod-test.conf"),
+	} {
+		setRequiredEnv(t)
+		t.Setenv("SSH_CONFIG_PATH", sshConfigPath)
+
+		options, err := FromEnv(false, false)
+		if err != nil {
+			t.Fatalf("FromEnv() error = %v", err)
+		}
+
+		options.MachineID = "devpod-test"
+		options.MachineFolder = "machine"
+		if got := options.SSHConfigFile(); got != wantFile {
+			t.Errorf("SSHConfigFile() = %q with SSH_CONFIG_PATH=%q, want %q", got, sshConfigPath, wantFile)
+		}
+	}
+}
+
+func TestFromEnvIAPSourceRanges(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    []string
+		wantErr string
+	}{
+		{value: "", want: []string{"35.235.240.0/20"}},
+		{value: " 35.235.240.0/20, 10.10.0.0/16 ,", want: []string{"35.235.240.0/20", "10.10.0.0/16"}},
+		{value: "10.10.0.1/16", wantErr: "invalid IAP_SOURCE_RANGES entry 10.10.0.1/16, the host bits must be zero, e.g. 10.10.0.0/16"},
+		{value: "10.10.0.0", wantErr: "invalid IAP_SOURCE_RANGES entry 10.10.0.0, must be a CIDR"},
+	}
+
+	for _, test := range tests {
+		setRequiredEnv(t)
+		t.Setenv("IAP_SOURCE_RANGES", test.value)
+
+		options, err := FromEnv(false, false)
+		if test.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("FromEnv() error = %v with IAP_SOURCE_RANGES=%q, want %q", err, test.value, test.wantErr)
+			}
+		} else if err != nil {
+			t.Errorf("FromEnv() error = %v", err)
+		} else if !reflect.DeepEqual(options.IAPSourceRanges, test.want) {
+			t.Errorf("IAPSourceRanges = %q, want %q", options.IAPSourceRanges, test.want)
+		}
+	}
+}
+
+func TestFromEnvDiskTypeIOPS(t *testing.T) {
+	tests := []struct {
+		name         string
+		env          map[string]string
+		wantDiskType string
+		wantIOPS     int
+		wantErr      string
+	}{
+		{name: "default", wantDiskType: "pd-balanced"},
+		{name: "disk type", env: map[string]string{"DISK_TYPE": "pd-ssd"}, wantDiskType: "pd-ssd"},
+		{name: "iops", env: map[string]string{"DISK_TYPE": "pd-extreme", "DISK_IOPS": "12000"}, wantDiskType: "pd-extreme", wantIOPS: 12000},
+		{name: "iops without pd-extreme", env: map[string]string{"DISK_IOPS": "12000"}, wantErr: "DISK_IOPS is only supported with DISK_TYPE=pd-extreme, got pd-balanced"},
+		{name: "invalid iops", env: map[string]string{"DISK_TYPE": "pd-extreme", "DISK_IOPS": "-1"}, wantErr: "DISK_IOPS"},
+		{
+			name:    "iops with boot disk",
+			env:     map[string]string{"DISK_TYPE": "pd-extreme", "DISK_IOPS": "12000", "BOOT_DISK_NAME": "workspace", "DISK_IMAGE": ""},
+			wantErr: "DISK_IOPS can't be combined with BOOT_DISK_NAME",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setRequiredEnv(t)
+			for _, name := range []string{"DISK_TYPE", "DISK_IOPS", "BOOT_DISK_NAME"} {
+				t.Setenv(name, "")
+			}
+			for name, value := range test.env {
+				t.Setenv(name, value)
+			}
+
+			options, err := FromEnv(false, false)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("FromEnv() error = %v, want %q", err, test.wantErr)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("FromEnv() error = %v", err)
+			}
+
+			if options.DiskType != test.wantDiskType || options.DiskIOPS != test.wantIOPS {
+				t.Errorf("DiskType, DiskIOPS = %q, %d, want %q, %d", options.DiskType, options.DiskIOPS, test.wantDiskType, test.wantIOPS)
+			}
+		})
+	}
+}
+
+func TestFromEnvInternalIP(t *testing.T) {
+	tests := []struct {
+		value      string
+		subnetwork string
+		wantErr    string
+	}{
+		{value: ""},
+		{value: "10.128.0.10", subnetwork: "dev"},
+		{value: "devpod-ip", subnetwork: "dev"},
+		{value: "10.128.0.10", wantErr: "INTERNAL_IP requires SUBNETWORK to be set"},
+		{value: "fd20::10", subnetwork: "dev", wantErr: "only ipv4 addresses are supported"},
+		{value: "Devpod_IP", subnetwork: "dev", wantErr: "invalid INTERNAL_IP Devpod_IP"},
+	}
+
+	for _, test := range tests {
+		setRequiredEnv(t)
+		t.Setenv("INTERNAL_IP", test.value)
+		t.Setenv("SUBNETWORK", test.subnetwork)
+
+		options, err := FromEnv(false, false)
+		if test.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("FromEnv() error = %v with INTERNAL_IP=%q, want %q", err, test.value, test.wantErr)
+			}
+		} else if err != nil {
+			t.Errorf("FromEnv() error = %v", err)
+		} else if options.InternalIP != test.value {
+			t.Errorf("InternalIP = %q, want %q", options.InternalIP, test.value)
+		}
+	}
+}